@@ -32,6 +32,8 @@ func Run(version string) error {
 	// Create a new root command
 	subcommands := []*ff.Command{
 		newServerCmd(cfg),
+		newKickCmd(cfg),
+		newPolicyCmd(cfg),
 		{
 			Name:      "version",
 			Usage:     "version",
@@ -92,6 +94,13 @@ func newRootCmd(version string, cfg *config.Config, subcommands []*ff.Command) *
 
 	for _, cmd := range subcommands {
 		cmd.Flags = ff.NewFlagSet(cmd.Name).SetParent(fs)
+
+		// Nested commands define their own flags and inherit the root's
+		for _, sub := range cmd.Subcommands {
+			if subFlags, ok := sub.Flags.(*ff.FlagSet); ok {
+				subFlags.SetParent(fs)
+			}
+		}
 	}
 
 	cmd := &ff.Command{
@@ -109,8 +118,60 @@ func newRootCmd(version string, cfg *config.Config, subcommands []*ff.Command) *
 	fs.StringVar(&cfg.Port, 'p', "port", config.DefaultPort, "Port to listen on")
 	fs.StringVar(&cfg.DatabaseFilePath, 'f', "database-file", config.DefaultDatabaseFilePath, "Path to the database file")
 	fs.StringVar(&cfg.RadiusSecret, 's', "radius-secret", "", "RADIUS secret")
+	fs.BoolVar(&cfg.ReplyMessage, 0, "reply-message", "Send the reason for the access decision in a Reply-Message attribute")
 	fs.StringVar(&cfg.TelegramBotToken, 't', "telegram-token", "", "Telegram bot token")
-	fs.StringListVar(&cfg.TelegramChatIDs, 'i', "telegram-chat-ids", "Telegram chat IDs")
+	fs.StringListVar(&cfg.TelegramChatIDs, 'i', "telegram-chat-ids", "Telegram chat or user IDs with the admin role")
+	fs.StringListVar(&cfg.TelegramOperatorIDs, 0, "telegram-operator-ids", "Telegram chat or user IDs with the operator role, who can approve devices to non-privileged VLANs")
+	fs.StringListVar(&cfg.TelegramViewerIDs, 0, "telegram-viewer-ids", "Telegram chat or user IDs with the viewer role, who receive alerts and can list devices")
+	fs.StringVar(&cfg.TelegramWebhookListen, 0, "telegram-webhook-listen", "", "Address the Telegram webhook server listens on. Leave empty to use long polling")
+	fs.StringVar(&cfg.TelegramWebhookURL, 0, "telegram-webhook-url", "", "Public HTTPS URL Telegram sends updates to")
+	fs.StringVar(&cfg.TelegramWebhookSecret, 0, "telegram-webhook-secret", "", "Secret token Telegram sends with every update. Required with --telegram-webhook-listen")
+	fs.StringVar(&cfg.TelegramWebhookTLSCert, 0, "telegram-webhook-tls-cert", "", "Certificate file used to serve the Telegram webhook over TLS")
+	fs.StringVar(&cfg.TelegramWebhookTLSKey, 0, "telegram-webhook-tls-key", "", "Key file used to serve the Telegram webhook over TLS")
+	fs.StringVar(&cfg.EventLogFilePath, 0, "event-log-file", config.DefaultEventLogFilePath, "Path to the authentication event log. Leave empty to keep events in memory only")
+	fs.IntVar(&cfg.EventLogSize, 0, "event-log-size", config.DefaultEventLogSize, "Number of authentication events kept in memory")
+	fs.IntVar(&cfg.EventLogMaxFileSize, 0, "event-log-max-size", config.DefaultEventLogMaxFileSize, "Size in megabytes after which the event log is rotated")
+	fs.StringListVar(&cfg.WebhookURLs, 0, "webhook-url", "Webhook URL that receives events. Declare it multiple times for multiple webhooks")
+	fs.StringListVar(&cfg.WebhookTemplates, 0, "webhook-template", "\"<url> <template file> [content type]\" with a Go template for the body sent to a webhook URL")
+	fs.StringVar(&cfg.WebhookSecret, 0, "webhook-secret", "", "Secret used to sign webhook bodies with HMAC-SHA256")
+	fs.IntVar(&cfg.WebhookRetries, 0, "webhook-retries", config.DefaultWebhookRetries, "Number of times a failed webhook is retried")
+	fs.DurationVar(&cfg.WebhookRetryBackoff, 0, "webhook-retry-backoff", config.DefaultWebhookRetryBackoff, "Wait before the first webhook retry. It doubles on every retry")
+	fs.StringVar(&cfg.ApprovalListenAddr, 0, "approval-listen", "", "Address the approval HTTP server listens on. Leave empty to disable approval links")
+	fs.StringVar(&cfg.ApprovalPublicURL, 0, "approval-url", "", "Public base URL of the approval HTTP server used in approval links")
+	fs.StringVar(&cfg.ApprovalSecret, 0, "approval-secret", "", "Secret used to sign approval links")
+	fs.DurationVar(&cfg.ApprovalTTL, 0, "approval-ttl", config.DefaultApprovalTTL, "How long approval links are valid for")
+	fs.StringVar(&cfg.NtfyURL, 0, "ntfy-url", config.DefaultNtfyURL, "Base URL of the ntfy server")
+	fs.StringVar(&cfg.NtfyTopic, 0, "ntfy-topic", "", "ntfy topic alerts are published to. Leave empty to disable ntfy")
+	fs.StringVar(&cfg.NtfyToken, 0, "ntfy-token", "", "ntfy access token")
+	fs.IntVar(&cfg.NtfyPriority, 0, "ntfy-priority", config.DefaultNtfyPriority, "ntfy priority of new device alerts, from 1 to 5")
+	fs.StringListVar(&cfg.NtfyTags, 0, "ntfy-tag", "ntfy tag of new device alerts. Declare it multiple times for multiple tags")
+	fs.StringVar(&cfg.GotifyURL, 0, "gotify-url", "", "Base URL of the Gotify server. Leave empty to disable Gotify")
+	fs.StringVar(&cfg.GotifyToken, 0, "gotify-token", "", "Gotify application token")
+	fs.IntVar(&cfg.GotifyPriority, 0, "gotify-priority", config.DefaultGotifyPriority, "Gotify priority of new device alerts, from 0 to 10")
+	fs.StringVar(&cfg.SMTPHost, 0, "smtp-host", "", "Host of the SMTP server. Leave empty to disable email")
+	fs.IntVar(&cfg.SMTPPort, 0, "smtp-port", config.DefaultSMTPPort, "Port of the SMTP server")
+	fs.StringVar(&cfg.SMTPUsername, 0, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.SMTPPassword, 0, "smtp-password", "", "SMTP password")
+	fs.BoolVarDefault(&cfg.SMTPStartTLS, 0, "smtp-starttls", config.DefaultSMTPStartTLS, "Require the SMTP connection to be upgraded with STARTTLS")
+	fs.StringVar(&cfg.EmailFrom, 0, "email-from", "", "Sender address of the emails")
+	fs.StringListVar(&cfg.EmailTo, 0, "email-to", "Recipient address of the emails. Declare it multiple times for multiple recipients")
+	fs.StringVar(&cfg.EmailAlertTemplate, 0, "email-alert-template", "", "Go template file for the immediate email alerts")
+	fs.StringVar(&cfg.EmailDigestTemplate, 0, "email-digest-template", "", "Go template file for the daily email digest")
+	fs.StringVar(&cfg.EmailDigestTime, 0, "email-digest-time", "", "Local time of day the email digest is sent at, e.g. 08:00. Leave empty to disable the digest")
+	fs.StringVar(&cfg.MatrixHomeserverURL, 0, "matrix-homeserver", "", "Base URL of the Matrix homeserver. Leave empty to disable Matrix")
+	fs.StringVar(&cfg.MatrixAccessToken, 0, "matrix-token", "", "Access token of the Matrix bot user")
+	fs.StringVar(&cfg.MatrixRoomID, 0, "matrix-room", "", "ID of the Matrix room alerts are sent to")
+	fs.StringVar(&cfg.DiscordWebhookURL, 0, "discord-webhook", "", "Discord webhook URL alerts are posted to. Leave empty to disable Discord")
+	fs.StringVar(&cfg.SlackWebhookURL, 0, "slack-webhook", "", "Slack incoming webhook URL alerts are posted to. Leave empty to disable Slack")
+	fs.DurationVar(&cfg.NotifyDedupWindow, 0, "notify-dedup-window", config.DefaultNotifyDedupWindow, "How long repeated attempts from the same device are not notified again. Set to 0 to disable")
+	fs.IntVar(&cfg.NotifyRateLimit, 0, "notify-rate-limit", config.DefaultNotifyRateLimit, "Maximum number of notifications sent per rate period. Set to 0 to disable")
+	fs.DurationVar(&cfg.NotifyRatePeriod, 0, "notify-rate-period", config.DefaultNotifyRatePeriod, "Period of the notification rate limit")
+	fs.DurationVar(&cfg.ExpiryCheckInterval, 0, "expiry-check-interval", config.DefaultExpiryCheckInterval, "How often expired guests and temporary VLANs are cleaned up")
+	fs.BoolVar(&cfg.CoAEnabled, 0, "coa", "Disconnect or reauthorize devices when they are approved, blocked, moved, or deleted (RFC 5176)")
+	fs.IntVar(&cfg.CoAPort, 0, "coa-port", config.DefaultCoAPort, "Port the NAS devices listen to Dynamic Authorization requests on")
+	fs.StringVar(&cfg.CoASecret, 0, "coa-secret", "", "Secret of the Dynamic Authorization requests. Defaults to the RADIUS secret")
+	fs.DurationVar(&cfg.CoATimeout, 0, "coa-timeout", config.DefaultCoATimeout, "How long to wait for a NAS to answer a Dynamic Authorization request")
+	fs.StringListVar(&cfg.CoANAS, 0, "coa-nas", "\"<address> <port> [secret]\" entry that overrides the CoA port and secret of a NAS")
 	// Optional config flag
 	fs.String('c', "config", "", "config file")
 
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/maronato/authifi/internal/coa"
+	"github.com/maronato/authifi/internal/config"
+	yamldatabase "github.com/maronato/authifi/internal/database/yaml"
+	"github.com/maronato/authifi/internal/eventlog"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/peterbourgon/ff/v4"
+)
+
+// errMissingDevice is returned when the kick command is run without a device.
+var errMissingDevice = errors.New("missing device name or username")
+
+func newKickCmd(cfg *config.Config) *ff.Command {
+	return &ff.Command{
+		Name:      "kick",
+		Usage:     "kick [flags] <device>",
+		ShortHelp: "Disconnect a device from its NAS, so it authenticates again",
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errMissingDevice
+			}
+
+			// Validate config
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("error validating config: %w", err)
+			}
+
+			// Create a logger and add it to the context
+			l := logging.NewLogger(os.Stderr, cfg)
+			ctx = logging.WithLogger(ctx, l)
+
+			username := args[0]
+
+			// Maybe it's the description. The database is only read, so a running server's changes are kept
+			dbFilePath, err := absPath(cfg.DatabaseFilePath)
+			if err != nil {
+				return err
+			}
+
+			db, err := yamldatabase.Load(dbFilePath)
+			if err != nil {
+				return fmt.Errorf("error loading database: %w", err)
+			}
+
+			defer db.Close(ctx)
+
+			if user, err := db.GetUserByDescription(username); err == nil {
+				username = user.Username
+			}
+
+			// The sessions are read from the event log the server writes
+			eventLogFilePath := cfg.EventLogFilePath
+			if eventLogFilePath != "" {
+				if eventLogFilePath, err = absPath(eventLogFilePath); err != nil {
+					return err
+				}
+			}
+
+			events := eventlog.NewLog(eventLogFilePath, cfg.EventLogSize, int64(cfg.EventLogMaxFileSize)*bytesPerMegabyte)
+			if err := events.Load(); err != nil {
+				return fmt.Errorf("error loading event log: %w", err)
+			}
+
+			if err := coa.NewKicker(coa.NewClient(cfg), events).Kick(ctx, username); err != nil {
+				return fmt.Errorf("error kicking device: %w", err)
+			}
+
+			fmt.Printf("Disconnected %s\n", username) //nolint:forbidigo // We want to print to stdout
+
+			return nil
+		},
+	}
+}
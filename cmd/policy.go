@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	yamldatabase "github.com/maronato/authifi/internal/database/yaml"
+	"github.com/maronato/authifi/internal/dictionary"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/policy"
+	"github.com/maronato/authifi/internal/radiusserver"
+	"github.com/peterbourgon/ff/v4"
+)
+
+// errMissingPolicyDevice is returned when the policy test command is run without a device.
+var errMissingPolicyDevice = errors.New("missing device name or username")
+
+// policyTestFlags are the flags of the policy test command, which describe the request.
+type policyTestFlags struct {
+	password        string
+	macAddress      string
+	calledStationID string
+	nasIdentifier   string
+	nasAddress      string
+	at              string
+}
+
+func newPolicyCmd(cfg *config.Config) *ff.Command {
+	flags := policyTestFlags{}
+
+	fs := ff.NewFlagSet("test")
+	fs.StringVar(&flags.password, 0, "password", "", "User-Password of the request. Defaults to the device's password")
+	fs.StringVar(&flags.macAddress, 0, "mac", "", "Calling-Station-Id of the request. Defaults to the username")
+	fs.StringVar(&flags.calledStationID, 0, "called-station-id", "", "Called-Station-Id of the request, like \"AA-BB-CC-DD-EE-FF:IoT\"")
+	fs.StringVar(&flags.nasIdentifier, 0, "nas-identifier", "", "NAS-Identifier of the request")
+	fs.StringVar(&flags.nasAddress, 0, "nas-address", "", "IP address of the NAS")
+	fs.StringVar(&flags.at, 0, "at", "", "Time of the request, in RFC 3339 format. Defaults to now")
+
+	test := &ff.Command{
+		Name:      "test",
+		Usage:     "policy test [flags] <device>",
+		ShortHelp: "Show the access decision for a request without sending it, and which policy rules matched",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errMissingPolicyDevice
+			}
+
+			// Create a logger and add it to the context
+			l := logging.NewLogger(os.Stderr, cfg)
+			ctx = logging.WithLogger(ctx, l)
+
+			dbFilePath, err := absPath(cfg.DatabaseFilePath)
+			if err != nil {
+				return err
+			}
+
+			// The database is only read, so a running server's changes are kept
+			db, err := yamldatabase.Load(dbFilePath)
+			if err != nil {
+				return fmt.Errorf("error loading database: %w", err)
+			}
+
+			defer db.Close(ctx)
+
+			req, err := flags.request(db, args[0])
+			if err != nil {
+				return err
+			}
+
+			printExplanation(os.Stdout, req, radiusserver.Explain(db, req))
+
+			return nil
+		},
+	}
+
+	return &ff.Command{
+		Name:        "policy",
+		Usage:       "policy <command> [flags]",
+		ShortHelp:   "Inspect the access policy",
+		Subcommands: []*ff.Command{test},
+	}
+}
+
+// request builds the request of a device from the flags.
+func (f policyTestFlags) request(db database.Database, device string) (radiusserver.Request, error) {
+	req := radiusserver.Request{
+		Username:        device,
+		Password:        f.password,
+		MACAddress:      f.macAddress,
+		NASAddress:      f.nasAddress,
+		CalledStationID: f.calledStationID,
+		SSID:            radiusserver.ParseSSID(f.calledStationID),
+		NASIdentifier:   f.nasIdentifier,
+		Time:            time.Now(),
+	}
+
+	// Maybe it's the description
+	user, err := db.GetUserByDescription(device)
+	if err == nil {
+		req.Username = user.Username
+	} else {
+		user, err = db.GetUser(device)
+	}
+
+	if req.Password == "" && err == nil {
+		req.Password = user.Password
+	}
+
+	if req.MACAddress == "" {
+		req.MACAddress = req.Username
+	}
+
+	if f.at != "" {
+		if req.Time, err = time.Parse(time.RFC3339, f.at); err != nil {
+			return radiusserver.Request{}, fmt.Errorf("error parsing time: %w", err)
+		}
+	}
+
+	return req, nil
+}
+
+// printExplanation prints how the access decision for a request was made.
+func printExplanation(w io.Writer, req radiusserver.Request, e radiusserver.Explanation) {
+	fmt.Fprintf(w, "Request: username %s, MAC %s, SSID %q, NAS %q (%s), at %s\n",
+		req.Username, req.MACAddress, req.SSID, req.NASIdentifier, req.NASAddress, req.Time.Format(time.RFC1123))
+	fmt.Fprintf(w, "Usual decision: %s\n", describeDecision(e.Usual))
+
+	if len(e.Steps) == 0 {
+		fmt.Fprintln(w, "Policy: no rules")
+	} else {
+		fmt.Fprintln(w, "Policy:")
+	}
+
+	for i, step := range e.Steps {
+		result := "matched"
+		if !step.Matched {
+			result = "no match: " + step.Why
+		}
+
+		fmt.Fprintf(w, "  %d. %s\n     %s\n", i+1, policy.Describe(step.Rule), result)
+	}
+
+	fmt.Fprintf(w, "Decision: %s\n", describeDecision(e.Decision))
+}
+
+// describeDecision describes a decision, like "accept on VLAN 10 (user_vlan), Session-Timeout=3600".
+func describeDecision(d radiusserver.Decision) string {
+	if !d.Accepted() {
+		return "reject (" + d.Reason.String() + ")"
+	}
+
+	desc := fmt.Sprintf("accept on VLAN %s (%s)", d.VLAN.ID, d.Reason)
+
+	for _, avp := range d.Attributes {
+		// The tunnel attributes are shown as the VLAN
+		if radiusserver.IsTunnelAttribute(avp.Type) {
+			continue
+		}
+
+		if a, ok := dictionary.Decode(avp); ok {
+			desc += ", " + a.Name + "=" + a.Value
+		} else {
+			desc += fmt.Sprintf(", attribute %d", avp.Type)
+		}
+	}
+
+	return desc
+}
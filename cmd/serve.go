@@ -3,18 +3,54 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path"
+	"time"
 
+	"github.com/maronato/authifi/internal/approval"
+	"github.com/maronato/authifi/internal/coa"
 	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
 	yamldatabase "github.com/maronato/authifi/internal/database/yaml"
+	"github.com/maronato/authifi/internal/eventlog"
+	"github.com/maronato/authifi/internal/expiry"
 	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/discord"
+	"github.com/maronato/authifi/internal/notify/email"
+	"github.com/maronato/authifi/internal/notify/gotify"
+	"github.com/maronato/authifi/internal/notify/matrix"
+	"github.com/maronato/authifi/internal/notify/ntfy"
+	"github.com/maronato/authifi/internal/notify/slack"
+	"github.com/maronato/authifi/internal/notify/webhook"
 	"github.com/maronato/authifi/internal/radiusserver"
 	"github.com/maronato/authifi/internal/telegram"
 	"github.com/peterbourgon/ff/v4"
 	"golang.org/x/sync/errgroup"
 )
 
+const (
+	// bytesPerMegabyte is used to convert file size flags to bytes.
+	bytesPerMegabyte = 1024 * 1024
+	// webhookShutdownTimeout is how long the server waits for the webhook retries when it stops.
+	webhookShutdownTimeout = 30 * time.Second
+)
+
+// absPath makes a relative path absolute based on the working directory.
+func absPath(p string) (string, error) {
+	if path.IsAbs(p) {
+		return p, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("error getting working directory: %w", err)
+	}
+
+	return path.Join(wd, p), nil
+}
+
 func newServerCmd(cfg *config.Config) *ff.Command {
 	return &ff.Command{
 		Name:      "serve",
@@ -31,14 +67,9 @@ func newServerCmd(cfg *config.Config) *ff.Command {
 			ctx = logging.WithLogger(ctx, l)
 
 			// If the database file path is relative, make it absolute
-			dbFilePath := cfg.DatabaseFilePath
-			if !path.IsAbs(dbFilePath) {
-				wd, err := os.Getwd()
-				if err != nil {
-					return fmt.Errorf("error getting working directory: %w", err)
-				}
-
-				dbFilePath = path.Join(wd, dbFilePath)
+			dbFilePath, err := absPath(cfg.DatabaseFilePath)
+			if err != nil {
+				return err
 			}
 
 			// Initialize the database
@@ -48,25 +79,151 @@ func newServerCmd(cfg *config.Config) *ff.Command {
 			}
 			defer db.Close(ctx)
 
-			botServer, err := telegram.NewBotServer(ctx, cfg, db)
-			if err != nil {
-				return fmt.Errorf("error creating bot server: %w", err)
+			// Initialize the authentication event log
+			eventLogFilePath := cfg.EventLogFilePath
+			if eventLogFilePath != "" {
+				if eventLogFilePath, err = absPath(eventLogFilePath); err != nil {
+					return err
+				}
+			}
+
+			events := eventlog.NewLog(eventLogFilePath, cfg.EventLogSize, int64(cfg.EventLogMaxFileSize)*bytesPerMegabyte)
+			if err := events.Open(ctx); err != nil {
+				return fmt.Errorf("error initializing event log: %w", err)
+			}
+			defer events.Close(ctx)
+
+			// Setup the notifiers and notify them of changes made by the admins
+			notifiers := notify.NewMulti()
+			adminDB := notify.NewDatabase(ctx, db, notifiers)
+
+			// Apply the changes made by the admins to the connected devices right away
+			kicker := coa.NewKicker(coa.NewClient(cfg), events)
+
+			var (
+				approvalDB  database.Database = adminDB
+				expiryStore expiry.Store      = adminDB
+			)
+
+			if cfg.CoAEnabled {
+				coaDB := coa.NewDatabase(ctx, adminDB, kicker)
+				approvalDB, expiryStore = coaDB, coaDB
 			}
 
 			// Create an errgroup to run the server
 			eg, egCtx := errgroup.WithContext(ctx)
 
-			eg.Go(func() error {
-				if err := radiusserver.StartServer(egCtx, cfg, db, botServer); err != nil {
-					return fmt.Errorf("server error: %w", err)
+			if cfg.TelegramBotToken != "" {
+				botServer, err := telegram.NewBotServer(ctx, cfg, approvalDB, events, kicker)
+				if err != nil {
+					return fmt.Errorf("error creating bot server: %w", err)
 				}
 
-				return nil
+				notifiers.Add(botServer)
+
+				eg.Go(func() error {
+					if err := botServer.StartBot(egCtx); err != nil {
+						return fmt.Errorf("bot error: %w", err)
+					}
+
+					return nil
+				})
+			}
+
+			// Setup the approval links for notifiers without inline buttons
+			var linker notify.Linker
+
+			if cfg.ApprovalListenAddr != "" {
+				approvalServer := approval.NewServer(ctx, cfg, approvalDB)
+				linker = approvalServer
+
+				eg.Go(func() error {
+					if err := approvalServer.StartServer(egCtx); err != nil {
+						return fmt.Errorf("approval server error: %w", err)
+					}
+
+					return nil
+				})
+			}
+
+			if len(cfg.WebhookURLs) > 0 {
+				// Retries keep going after the server stops, so the webhooks still in flight are delivered
+				webhookNotifier, err := webhook.NewNotifier(context.WithoutCancel(ctx), cfg, linker)
+				if err != nil {
+					return fmt.Errorf("error creating webhook notifier: %w", err)
+				}
+
+				defer func() {
+					closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookShutdownTimeout)
+					defer cancel()
+
+					if err := webhookNotifier.Close(closeCtx); err != nil {
+						l.Error("Error stopping webhook notifier", slog.Any("error", err))
+					}
+				}()
+
+				notifiers.Add(webhookNotifier)
+			}
+
+			if cfg.NtfyTopic != "" {
+				notifiers.Add(ntfy.NewNotifier(ctx, cfg, linker))
+			}
+
+			if cfg.GotifyURL != "" {
+				notifiers.Add(gotify.NewNotifier(ctx, cfg, linker))
+			}
+
+			if cfg.MatrixHomeserverURL != "" {
+				notifiers.Add(matrix.NewNotifier(ctx, cfg, linker))
+			}
+
+			if cfg.DiscordWebhookURL != "" {
+				notifiers.Add(discord.NewNotifier(ctx, cfg, linker))
+			}
+
+			if cfg.SlackWebhookURL != "" {
+				notifiers.Add(slack.NewNotifier(ctx, cfg, linker))
+			}
+
+			if cfg.SMTPHost != "" {
+				emailNotifier, err := email.NewNotifier(ctx, cfg, linker, events)
+				if err != nil {
+					return fmt.Errorf("error creating email notifier: %w", err)
+				}
+
+				notifiers.Add(emailNotifier)
+
+				eg.Go(func() error {
+					if err := emailNotifier.StartDigest(egCtx); err != nil {
+						return fmt.Errorf("email digest error: %w", err)
+					}
+
+					return nil
+				})
+			}
+
+			if notifiers.Len() == 0 {
+				l.Info("No notifiers configured, new devices will only be logged")
+			}
+
+			// Suppress repeated attempts and cap the notifications sent for RADIUS requests
+			deduplicator := notify.NewDeduplicator(ctx, notifiers, notify.DedupOptions{
+				Window:     cfg.NotifyDedupWindow,
+				RateLimit:  cfg.NotifyRateLimit,
+				RatePeriod: cfg.NotifyRatePeriod,
+			})
+
+			// Delete expired guests and revert expired temporary VLANs, notifying about each of them and
+			// disconnecting or moving their devices
+			janitor := expiry.NewJanitor(ctx, cfg, expiryStore)
+
+			eg.Go(func() error {
+				return janitor.Start(egCtx)
 			})
 
 			eg.Go(func() error {
-				if err := botServer.StartBot(egCtx); err != nil {
-					return fmt.Errorf("bot error: %w", err)
+				if err := radiusserver.StartServer(egCtx, cfg, db, deduplicator, events); err != nil {
+					return fmt.Errorf("server error: %w", err)
 				}
 
 				return nil
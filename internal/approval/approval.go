@@ -0,0 +1,165 @@
+package approval
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature doesn't match.
+	ErrInvalidToken = errors.New("invalid approval token")
+	// ErrExpiredToken is returned when a token has expired.
+	ErrExpiredToken = errors.New("approval token expired")
+	// ErrUnknownAction is returned when an approval has an unknown action.
+	ErrUnknownAction = errors.New("unknown approval action")
+	// ErrPrivilegedVLAN is returned when a link tries to add a device to a privileged VLAN.
+	ErrPrivilegedVLAN = errors.New("privileged VLANs can't be approved by link")
+)
+
+// Action is what to do with a new device.
+type Action string
+
+const (
+	// ActionAdd adds the device to a VLAN.
+	ActionAdd Action = "add"
+	// ActionIgnore ignores the request.
+	ActionIgnore Action = "ignore"
+	// ActionBlock blocks the device.
+	ActionBlock Action = "block"
+)
+
+// Approval is a signed decision about a pending device. It only references the device by the ID of
+// its pending request, so tokens don't reveal its credentials and stop working once it's handled.
+type Approval struct {
+	Action    Action    `json:"a"`
+	PendingID string    `json:"i"`
+	VlanID    string    `json:"v,omitempty"`
+	Expires   time.Time `json:"e"`
+	// UserExpiresAt is when the added user expires. The user never expires if it's nil.
+	UserExpiresAt *time.Time `json:"x,omitempty"`
+}
+
+// Signer creates and verifies approval tokens.
+type Signer struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewSigner creates a new Signer.
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret), now: time.Now}
+}
+
+// mac returns the signature of a payload.
+func (s *Signer) mac(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// Sign encodes and signs an approval as a URL safe token.
+func (s *Signer) Sign(a Approval) (string, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return "", fmt.Errorf("error encoding approval: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), nil
+}
+
+// Verify decodes a token and checks its signature and expiration.
+func (s *Signer) Verify(token string) (Approval, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Approval{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Approval{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return Approval{}, ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, s.mac(payload)) {
+		return Approval{}, ErrInvalidToken
+	}
+
+	var a Approval
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return Approval{}, ErrInvalidToken
+	}
+
+	if s.now().After(a.Expires) {
+		return Approval{}, ErrExpiredToken
+	}
+
+	return a, nil
+}
+
+// Apply performs the database mutations of an approval and returns the handled device. The device
+// is taken from the pending queue first, so concurrent approvals of the same device are applied only
+// once. The others return ErrPendingDeviceNotFound, like approvals of devices that are no longer pending.
+func Apply(db database.Database, a Approval) (database.PendingDevice, error) {
+	var vlan database.VLAN
+
+	// Check the approval before taking the device so a bad one leaves it pending
+	switch a.Action {
+	case ActionAdd:
+		// Make sure the VLAN exists
+		var err error
+		if vlan, err = db.GetVLAN(a.VlanID); err != nil {
+			return database.PendingDevice{}, fmt.Errorf("error getting VLAN: %w", err)
+		}
+	case ActionBlock, ActionIgnore:
+	default:
+		return database.PendingDevice{}, fmt.Errorf("%w: %s", ErrUnknownAction, a.Action)
+	}
+
+	pending, err := db.TakePendingDevice(a.PendingID)
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error getting pending device: %w", err)
+	}
+
+	switch a.Action {
+	case ActionAdd:
+		err = db.CreateUser(database.User{
+			Username:  pending.Username,
+			Password:  pending.Password,
+			VlanID:    vlan.ID,
+			ExpiresAt: a.UserExpiresAt,
+		})
+		if err != nil {
+			err = fmt.Errorf("error creating user: %w", err)
+		}
+	case ActionBlock:
+		if err = db.BlockUser(pending.Username); err != nil {
+			err = fmt.Errorf("error blocking user: %w", err)
+		}
+	case ActionIgnore:
+		// Taking the device already removed it from the queue
+	}
+
+	if err != nil {
+		// Put the device back so the request can be handled again
+		if _, recordErr := db.RecordPendingDevice(pending); recordErr != nil {
+			err = errors.Join(err, fmt.Errorf("error restoring pending device: %w", recordErr))
+		}
+
+		return database.PendingDevice{}, err
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,178 @@
+package approval_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/approval"
+	"github.com/maronato/authifi/internal/database"
+	memorydatabase "github.com/maronato/authifi/internal/database/memory"
+)
+
+// newDatabase creates a database with a VLAN and a pending device.
+func newDatabase(t *testing.T) (*memorydatabase.MemoryDatabase, database.PendingDevice) {
+	t.Helper()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main", Default: true}, {ID: "40", Name: "Admin", Privileged: true}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	pending, err := db.RecordPendingDevice(database.PendingDevice{Username: "aa:bb:cc:dd:ee:ff", Password: "secret", LastSeen: time.Now()})
+	if err != nil {
+		t.Fatalf("error recording pending device: %v", err)
+	}
+
+	return db, pending
+}
+
+func TestSignVerify(t *testing.T) {
+	t.Parallel()
+
+	signer := approval.NewSigner("secret")
+	a := approval.Approval{Action: approval.ActionAdd, PendingID: "abc", VlanID: "10", Expires: time.Now().Add(time.Hour).Truncate(time.Second)}
+
+	token, err := signer.Sign(a)
+	if err != nil {
+		t.Fatalf("error signing approval: %v", err)
+	}
+
+	got, err := signer.Verify(token)
+	if err != nil || got.PendingID != a.PendingID || got.Action != a.Action || got.VlanID != a.VlanID || !got.Expires.Equal(a.Expires) {
+		t.Errorf("got %+v, %v, want %+v", got, err, a)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	other, _ := signer.Sign(approval.Approval{Action: approval.ActionBlock, PendingID: "abc", Expires: a.Expires})
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	expired, _ := signer.Sign(approval.Approval{Action: approval.ActionBlock, PendingID: "abc", Expires: time.Now().Add(-time.Minute)})
+
+	tests := []struct {
+		name    string
+		signer  *approval.Signer
+		token   string
+		wantErr error
+	}{
+		{"other secret", approval.NewSigner("other"), token, approval.ErrInvalidToken},
+		{"swapped payload", signer, otherPayload + "." + signature, approval.ErrInvalidToken},
+		{"tampered signature", signer, payload + "." + strings.Repeat("A", len(signature)), approval.ErrInvalidToken},
+		{"no signature", signer, payload, approval.ErrInvalidToken},
+		{"not base64", signer, "!!." + signature, approval.ErrInvalidToken},
+		{"expired", signer, expired, approval.ErrExpiredToken},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.signer.Verify(tt.token); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		action  approval.Approval
+		check   func(t *testing.T, db database.Database)
+		wantErr error
+	}{
+		{
+			name:   "add",
+			action: approval.Approval{Action: approval.ActionAdd, VlanID: "10", UserExpiresAt: &expiresAt},
+			check: func(t *testing.T, db database.Database) {
+				t.Helper()
+
+				user, err := db.GetUser("aa:bb:cc:dd:ee:ff")
+				if err != nil || user.Password != "secret" || user.VlanID != "10" || user.ExpiresAt == nil || !user.ExpiresAt.Equal(expiresAt) {
+					t.Errorf("got user %+v, %v", user, err)
+				}
+			},
+		},
+		{
+			name:   "block",
+			action: approval.Approval{Action: approval.ActionBlock},
+			check: func(t *testing.T, db database.Database) {
+				t.Helper()
+
+				if blocked, _ := db.IsUserBlocked("aa:bb:cc:dd:ee:ff"); !blocked {
+					t.Error("user was not blocked")
+				}
+			},
+		},
+		{name: "ignore", action: approval.Approval{Action: approval.ActionIgnore}},
+		{name: "missing VLAN", action: approval.Approval{Action: approval.ActionAdd, VlanID: "99"}, wantErr: database.ErrVLANNotFound},
+		{name: "unknown action", action: approval.Approval{Action: "promote"}, wantErr: approval.ErrUnknownAction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, pending := newDatabase(t)
+
+			a := tt.action
+			a.PendingID = pending.ID
+
+			if _, err := approval.Apply(db, a); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// Failed approvals leave the request pending
+			if tt.wantErr != nil {
+				if _, err := db.GetPendingDevice(pending.ID); err != nil {
+					t.Errorf("got %v getting the device after a failed approval, want it pending", err)
+				}
+
+				return
+			}
+
+			if tt.check != nil {
+				tt.check(t, db)
+			}
+
+			// The request is handled, so the same approval can't be applied again
+			if _, err := db.GetPendingDevice(pending.ID); !errors.Is(err, database.ErrPendingDeviceNotFound) {
+				t.Errorf("got %v getting the handled device, want %v", err, database.ErrPendingDeviceNotFound)
+			}
+
+			if _, err := approval.Apply(db, a); !errors.Is(err, database.ErrPendingDeviceNotFound) {
+				t.Errorf("got %v applying the approval again, want %v", err, database.ErrPendingDeviceNotFound)
+			}
+		})
+	}
+}
+
+func TestApplyRestoresFailedDevices(t *testing.T) {
+	t.Parallel()
+
+	db, pending := newDatabase(t)
+
+	// The device was blocked, and then retried before the approval was used
+	if err := db.BlockUser(pending.Username); err != nil {
+		t.Fatalf("error blocking user: %v", err)
+	}
+
+	pending, err := db.RecordPendingDevice(database.PendingDevice{Username: pending.Username, Password: "secret", LastSeen: time.Now()})
+	if err != nil {
+		t.Fatalf("error recording pending device: %v", err)
+	}
+
+	a := approval.Approval{Action: approval.ActionBlock, PendingID: pending.ID}
+	if _, err := approval.Apply(db, a); !errors.Is(err, database.ErrUserAlreadyBlocked) {
+		t.Fatalf("got error %v, want %v", err, database.ErrUserAlreadyBlocked)
+	}
+
+	// The request goes back to the queue with its ID, so it can still be handled
+	if _, err := db.GetPendingDevice(pending.ID); err != nil {
+		t.Errorf("got %v getting the device after a failed approval, want it pending", err)
+	}
+}
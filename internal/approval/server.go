@@ -0,0 +1,321 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// ApprovePath is the path of the approval endpoint.
+	ApprovePath = "/approve"
+	// ReadHeaderTimeout is the timeout to read the request headers.
+	ReadHeaderTimeout = 10 * time.Second
+)
+
+const pageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authifi</title>
+</head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 2em auto; padding: 0 1em;">
+<h1>{{ .Title }}</h1>
+<p>{{ .Message }}</p>
+{{ if .Token }}
+<form method="post" action="{{ .Action }}">
+<input type="hidden" name="token" value="{{ .Token }}">
+<button type="submit" style="font-size: 1.2em; padding: 0.5em 1em;">Confirm</button>
+</form>
+{{ end }}
+</body>
+</html>`
+
+// page is the data rendered by the page template.
+type page struct {
+	Title   string
+	Message string
+	Action  string
+	Token   string
+}
+
+// Server serves the approval endpoint and creates signed approval links.
+type Server struct {
+	// db is the database.
+	db database.Database
+	// signer creates and verifies the tokens.
+	signer *Signer
+	// listenAddr is the address the HTTP server listens on.
+	listenAddr string
+	// publicURL is the base URL used in the links.
+	publicURL string
+	// ttl is how long the links are valid for.
+	ttl time.Duration
+	// page is the HTML page template.
+	page *template.Template
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewServer creates a new Server.
+func NewServer(ctx context.Context, cfg *config.Config, db database.Database) *Server {
+	l := logging.FromCtx(ctx)
+
+	l.Debug("Approval server setup complete", slog.String("publicURL", cfg.ApprovalPublicURL), slog.Duration("ttl", cfg.ApprovalTTL))
+
+	return &Server{
+		db:         db,
+		signer:     NewSigner(cfg.ApprovalSecret),
+		listenAddr: cfg.ApprovalListenAddr,
+		publicURL:  strings.TrimSuffix(cfg.ApprovalPublicURL, "/"),
+		ttl:        cfg.ApprovalTTL,
+		page:       template.Must(template.New("page").Parse(pageTemplate)),
+		l:          l,
+	}
+}
+
+// link creates a signed link for an approval.
+func (s *Server) link(a Approval) (string, error) {
+	a.Expires = s.signer.now().Add(s.ttl)
+
+	token, err := s.signer.Sign(a)
+	if err != nil {
+		return "", err
+	}
+
+	return s.publicURL + ApprovePath + "?token=" + url.QueryEscape(token), nil
+}
+
+// Links returns signed links to add the device to each VLAN that isn't privileged, ignore it, or
+// block it. The links reference the pending request of the device, so there are none if it's not pending.
+func (s *Server) Links(d notify.Device) []notify.Action {
+	pending, err := s.pendingDevice(d.Username)
+	if err != nil {
+		s.l.Error("Error getting pending device for approval links", slog.Any("error", err), slog.String("username", d.Username))
+
+		return nil
+	}
+
+	vlans, err := s.db.GetVLANs()
+	if err != nil {
+		s.l.Error("Error getting VLANs for approval links", slog.Any("error", err))
+	}
+
+	approvals := make([]Approval, 0, len(vlans)+2) //nolint:gomnd // ignore and block
+	labels := make([]string, 0, cap(approvals))
+
+	for _, vlan := range vlans {
+		// Only admins can add devices to privileged VLANs, and anyone who gets a notification can open the links
+		if vlan.Privileged {
+			continue
+		}
+
+		approvals = append(approvals, Approval{Action: ActionAdd, PendingID: pending.ID, VlanID: vlan.ID})
+		labels = append(labels, "Add to "+vlan.Name)
+	}
+
+	approvals = append(approvals, Approval{Action: ActionIgnore, PendingID: pending.ID}, Approval{Action: ActionBlock, PendingID: pending.ID})
+	labels = append(labels, "Ignore", "Block")
+
+	actions := make([]notify.Action, 0, len(approvals))
+
+	for i, a := range approvals {
+		link, err := s.link(a)
+		if err != nil {
+			s.l.Error("Error creating approval link", slog.Any("error", err))
+
+			continue
+		}
+
+		actions = append(actions, notify.Action{Label: labels[i], URL: link})
+	}
+
+	return actions
+}
+
+// pendingDevice returns the pending request of a device by its username.
+func (s *Server) pendingDevice(username string) (database.PendingDevice, error) {
+	devices, err := s.db.GetPendingDevices()
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error getting pending devices: %w", err)
+	}
+
+	for _, d := range devices {
+		if d.Username == username {
+			return d, nil
+		}
+	}
+
+	return database.PendingDevice{}, fmt.Errorf("error getting pending device %s: %w", username, database.ErrPendingDeviceNotFound)
+}
+
+// apply applies an approval from a link. Links can't add devices to privileged VLANs.
+func (s *Server) apply(a Approval) error {
+	if a.Action == ActionAdd {
+		if vlan, err := s.db.GetVLAN(a.VlanID); err == nil && vlan.Privileged {
+			return fmt.Errorf("error adding device to VLAN %s: %w", vlan.ID, ErrPrivilegedVLAN)
+		}
+	}
+
+	if _, err := Apply(s.db, a); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// describe returns a human readable description of an approval for a pending device.
+func (s *Server) describe(a Approval, pending database.PendingDevice) string {
+	switch a.Action {
+	case ActionAdd:
+		name := a.VlanID
+		if vlan, err := s.db.GetVLAN(a.VlanID); err == nil {
+			name = vlan.Name
+		}
+
+		return fmt.Sprintf("Add %s to the %s network", pending.Username, name)
+	case ActionIgnore:
+		return fmt.Sprintf("Ignore the request from %s", pending.Username)
+	case ActionBlock:
+		return fmt.Sprintf("Block %s", pending.Username)
+	default:
+		return string(a.Action)
+	}
+}
+
+// render writes a page with the given status code.
+func (s *Server) render(w http.ResponseWriter, status int, p page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := s.page.Execute(w, p); err != nil {
+		s.l.Error("Error rendering approval page", slog.Any("error", err))
+	}
+}
+
+// ServeHTTP shows a confirmation page on GET and performs the approval on POST.
+// GET requests never mutate the database so link previews can't trigger actions.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != ApprovePath {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	// The token may come from the query or from the confirmation form
+	token := r.FormValue("token")
+
+	a, err := s.signer.Verify(token)
+	if errors.Is(err, ErrExpiredToken) {
+		s.render(w, http.StatusGone, page{Title: "⌛ Link Expired", Message: "This link has expired."})
+
+		return
+	} else if err != nil {
+		s.render(w, http.StatusBadRequest, page{Title: "🚫 Invalid Link", Message: "This link is not valid."})
+
+		return
+	}
+
+	// Links stop working once the device was handled, here or anywhere else
+	pending, err := s.db.GetPendingDevice(a.PendingID)
+	if errors.Is(err, database.ErrPendingDeviceNotFound) {
+		s.render(w, http.StatusGone, page{Title: "✔️ Already Handled", Message: "This request was already handled."})
+
+		return
+	} else if err != nil {
+		s.l.Error("Error getting pending device", slog.Any("error", err), slog.String("id", a.PendingID))
+		s.render(w, http.StatusInternalServerError, page{Title: "⚠️ Error", Message: "Could not load the request."})
+
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.render(w, http.StatusOK, page{Title: "🚨 Confirm Action", Message: s.describe(a, pending) + "?", Action: ApprovePath, Token: token})
+
+		return
+	}
+
+	if err := s.apply(a); err != nil {
+		s.l.Error("Error applying approval", slog.Any("error", err), slog.String("action", string(a.Action)), slog.String("username", pending.Username))
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrUserAlreadyExists) || errors.Is(err, database.ErrUserAlreadyBlocked) || errors.Is(err, database.ErrVLANNotFound) ||
+			errors.Is(err, database.ErrPendingDeviceNotFound) {
+			status = http.StatusConflict
+		} else if errors.Is(err, ErrPrivilegedVLAN) {
+			status = http.StatusForbidden
+		}
+
+		s.render(w, status, page{Title: "⚠️ Error", Message: "Could not " + strings.ToLower(s.describe(a, pending)) + ": " + err.Error()})
+
+		return
+	}
+
+	s.l.Info("Approval applied", slog.String("action", string(a.Action)), slog.String("username", pending.Username), slog.String("vlan", a.VlanID))
+
+	s.render(w, http.StatusOK, page{Title: "✅ Done", Message: s.describe(a, pending) + "."})
+}
+
+// StartServer starts the approval HTTP server.
+func (s *Server) StartServer(ctx context.Context) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	l := logging.FromCtx(ctx)
+
+	server := &http.Server{
+		Addr:              s.listenAddr,
+		Handler:           s,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+	}
+
+	eg.Go(func() error {
+		l.Info("Starting approval server on " + s.listenAddr)
+
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("error running approval server: %w", err)
+		}
+
+		return nil
+	})
+
+	eg.Go(func() error {
+		<-egCtx.Done()
+		l.Debug("Shutting down approval server")
+
+		// Disable cancel so we can shutdown gracefully
+		noCancelCtx := context.WithoutCancel(egCtx)
+		if err := server.Shutdown(noCancelCtx); err != nil {
+			return fmt.Errorf("error shutting down approval server: %w", err)
+		}
+
+		return nil
+	})
+
+	// Wait for the server to exit and check for errors that
+	// are not caused by the context being canceled.
+	if err := eg.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("approval server exited with error: %w", err)
+	}
+
+	return nil
+}
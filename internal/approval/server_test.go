@@ -0,0 +1,161 @@
+package approval_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/approval"
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+func newTestConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.ApprovalSecret = "approval-secret"
+	cfg.ApprovalPublicURL = "https://authifi.example.com/"
+	cfg.ApprovalTTL = time.Hour
+
+	return cfg
+}
+
+// tokenOf returns the token of an approval link.
+func tokenOf(t *testing.T, link string) string {
+	t.Helper()
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("error parsing link: %v", err)
+	}
+
+	return u.Query().Get("token")
+}
+
+// open sends a request for a token to the server and returns the status code.
+func open(s *approval.Server, method, token string) int {
+	var r *http.Request
+	if method == http.MethodGet {
+		r = httptest.NewRequest(method, approval.ApprovePath+"?token="+url.QueryEscape(token), nil)
+	} else {
+		r = httptest.NewRequest(method, approval.ApprovePath, strings.NewReader(url.Values{"token": {token}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestLinks(t *testing.T) {
+	t.Parallel()
+
+	db, pending := newDatabase(t)
+	s := approval.NewServer(context.Background(), newTestConfig(), db)
+
+	actions := s.Links(notify.Device{Username: pending.Username, Password: pending.Password})
+
+	labels := make([]string, 0, len(actions))
+	for _, a := range actions {
+		labels = append(labels, a.Label)
+
+		if !strings.HasPrefix(a.URL, "https://authifi.example.com"+approval.ApprovePath+"?token=") {
+			t.Errorf("got link %s", a.URL)
+		}
+
+		// Tokens are readable, so they must not carry the device's credentials
+		payload, _, _ := strings.Cut(tokenOf(t, a.URL), ".")
+		if decoded, _ := base64.RawURLEncoding.DecodeString(payload); strings.Contains(string(decoded), pending.Password) || strings.Contains(string(decoded), pending.Username) {
+			t.Errorf("got token payload %s with the device's credentials", decoded)
+		}
+	}
+
+	// Privileged VLANs are left out
+	if got, want := strings.Join(labels, ","), "Add to Main,Ignore,Block"; got != want {
+		t.Errorf("got labels %s, want %s", got, want)
+	}
+
+	if actions := s.Links(notify.Device{Username: "not-pending"}); len(actions) != 0 {
+		t.Errorf("got %d links for a device that isn't pending, want none", len(actions))
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig()
+	db, pending := newDatabase(t)
+	s := approval.NewServer(context.Background(), cfg, db)
+
+	actions := s.Links(notify.Device{Username: pending.Username})
+	add, block := tokenOf(t, actions[0].URL), tokenOf(t, actions[2].URL)
+
+	// Opening the link only shows a confirmation page
+	if status := open(s, http.MethodGet, add); status != http.StatusOK {
+		t.Errorf("got status %d opening the link, want %d", status, http.StatusOK)
+	}
+
+	if _, err := db.GetUser(pending.Username); err == nil {
+		t.Error("opening the link added the device")
+	}
+
+	// Confirming applies it
+	if status := open(s, http.MethodPost, add); status != http.StatusOK {
+		t.Errorf("got status %d confirming the link, want %d", status, http.StatusOK)
+	}
+
+	if user, err := db.GetUser(pending.Username); err != nil || user.VlanID != "10" {
+		t.Errorf("got user %+v, %v after confirming", user, err)
+	}
+
+	// The other links of the request stop working once it's handled
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if status := open(s, method, block); status != http.StatusGone {
+			t.Errorf("got status %d for %s on a handled request, want %d", status, method, http.StatusGone)
+		}
+	}
+
+	if blocked, _ := db.IsUserBlocked(pending.Username); blocked {
+		t.Error("the block link worked after the device was approved")
+	}
+
+	// Links can't add devices to privileged VLANs, even if they are signed
+	other, err := db.RecordPendingDevice(database.PendingDevice{Username: "11:22:33:44:55:66", LastSeen: time.Now()})
+	if err != nil {
+		t.Fatalf("error recording pending device: %v", err)
+	}
+
+	privileged, _ := approval.NewSigner(cfg.ApprovalSecret).Sign(approval.Approval{Action: approval.ActionAdd, PendingID: other.ID, VlanID: "40", Expires: time.Now().Add(time.Hour)})
+	if status := open(s, http.MethodPost, privileged); status != http.StatusForbidden {
+		t.Errorf("got status %d adding a device to a privileged VLAN, want %d", status, http.StatusForbidden)
+	}
+
+	expired, _ := approval.NewSigner(cfg.ApprovalSecret).Sign(approval.Approval{Action: approval.ActionBlock, PendingID: other.ID, Expires: time.Now().Add(-time.Minute)})
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"invalid token", http.MethodPost, "invalid", http.StatusBadRequest},
+		{"expired token", http.MethodPost, expired, http.StatusGone},
+		{"other method", http.MethodDelete, block, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		if status := open(s, tt.method, tt.token); status != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	if _, err := db.GetPendingDevice(other.ID); err != nil {
+		t.Errorf("error getting pending device that should be left alone: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Package coa sends RFC 5176 Dynamic Authorization requests to the NAS devices, so
+// devices re-authenticate and pick up changes right away.
+package coa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc3576"
+)
+
+// ErrNAK is returned when the NAS refuses a request.
+var ErrNAK = errors.New("request refused by the NAS")
+
+// Target identifies the session of a device on a NAS.
+type Target struct {
+	// NASAddress is the IP address of the NAS the device is connected to.
+	NASAddress string
+	// NASIdentifier is the NAS-Identifier of the NAS, if known.
+	NASIdentifier string
+	// Username is the User-Name of the session.
+	Username string
+	// MACAddress is the Calling-Station-Id of the session, if known.
+	MACAddress string
+	// SessionID is the Acct-Session-Id of the session, if known.
+	SessionID string
+}
+
+// nasSettings are the port and secret of the requests sent to a NAS.
+type nasSettings struct {
+	port   int
+	secret []byte
+}
+
+// Client sends Dynamic Authorization requests.
+type Client struct {
+	// defaults are the settings of the NAS devices without their own.
+	defaults nasSettings
+	// nas are the settings of each NAS address.
+	nas map[string]nasSettings
+	// timeout is how long to wait for an answer.
+	timeout time.Duration
+	// client is the RADIUS client that retransmits the requests.
+	client *radius.Client
+}
+
+// NewClient creates a new Client.
+func NewClient(cfg *config.Config) *Client {
+	secret := cfg.CoASecret
+	if secret == "" {
+		secret = cfg.RadiusSecret
+	}
+
+	c := &Client{
+		defaults: nasSettings{port: cfg.CoAPort, secret: []byte(secret)},
+		nas:      make(map[string]nasSettings, len(cfg.CoANAS)),
+		timeout:  cfg.CoATimeout,
+		client:   &radius.Client{Retry: time.Second},
+	}
+
+	// The entries are checked when the config is validated
+	for _, entry := range cfg.CoANAS {
+		address, port, nasSecret, ok := config.ParseCoANAS(entry)
+		if !ok {
+			continue
+		}
+
+		settings := nasSettings{port: port, secret: c.defaults.secret}
+		if nasSecret != "" {
+			settings.secret = []byte(nasSecret)
+		}
+
+		c.nas[address] = settings
+	}
+
+	return c
+}
+
+// settings returns the settings of a NAS.
+func (c *Client) settings(nasAddress string) nasSettings {
+	if settings, ok := c.nas[nasAddress]; ok {
+		return settings
+	}
+
+	return c.defaults
+}
+
+// Disconnect asks the NAS to end the session of a device with a Disconnect-Request.
+func (c *Client) Disconnect(ctx context.Context, t Target) error {
+	return c.send(ctx, radius.CodeDisconnectRequest, t, nil)
+}
+
+// CoA asks the NAS to apply new authorization attributes to the session of a device with a CoA-Request.
+func (c *Client) CoA(ctx context.Context, t Target, attributes radius.Attributes) error {
+	return c.send(ctx, radius.CodeCoARequest, t, attributes)
+}
+
+// send sends a request for the session of a device and checks that it was acknowledged.
+func (c *Client) send(ctx context.Context, code radius.Code, t Target, attributes radius.Attributes) error {
+	settings := c.settings(t.NASAddress)
+
+	packet := radius.New(code, settings.secret)
+	if err := setTarget(packet, t); err != nil {
+		return err
+	}
+
+	for _, avp := range attributes {
+		packet.Add(avp.Type, avp.Attribute)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(t.NASAddress, strconv.Itoa(settings.port))
+
+	response, err := c.client.Exchange(ctx, packet, addr)
+	if err != nil {
+		return fmt.Errorf("error sending %s to %s: %w", code, addr, err)
+	}
+
+	ack := radius.CodeDisconnectACK
+	if code == radius.CodeCoARequest {
+		ack = radius.CodeCoAACK
+	}
+
+	if response.Code != ack {
+		if cause, err := rfc3576.ErrorCause_Lookup(response); err == nil {
+			return fmt.Errorf("%w: %s from %s: %s", ErrNAK, response.Code, addr, cause)
+		}
+
+		return fmt.Errorf("%w: %s from %s", ErrNAK, response.Code, addr)
+	}
+
+	return nil
+}
+
+// setTarget adds the attributes that identify the session of a device to a request.
+func setTarget(packet *radius.Packet, t Target) error {
+	if err := rfc2865.UserName_SetString(packet, t.Username); err != nil {
+		return fmt.Errorf("error setting User-Name: %w", err)
+	}
+
+	if t.MACAddress != "" {
+		if err := rfc2865.CallingStationID_SetString(packet, t.MACAddress); err != nil {
+			return fmt.Errorf("error setting Calling-Station-Id: %w", err)
+		}
+	}
+
+	if t.SessionID != "" {
+		if err := rfc2866.AcctSessionID_SetString(packet, t.SessionID); err != nil {
+			return fmt.Errorf("error setting Acct-Session-Id: %w", err)
+		}
+	}
+
+	// Some NAS devices check that the request is meant for them
+	if ip := net.ParseIP(t.NASAddress).To4(); ip != nil {
+		if err := rfc2865.NASIPAddress_Set(packet, ip); err != nil {
+			return fmt.Errorf("error setting NAS-IP-Address: %w", err)
+		}
+	}
+
+	if t.NASIdentifier != "" {
+		if err := rfc2865.NASIdentifier_SetString(packet, t.NASIdentifier); err != nil {
+			return fmt.Errorf("error setting NAS-Identifier: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package coa_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/coa"
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/eventlog"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+	"layeh.com/radius/rfc3576"
+)
+
+const testSecret = "test-secret"
+
+// startNAS starts a fake NAS that answers requests signed with secret with the given code and
+// sends the requests it receives to a channel. It returns the port it listens on.
+func startNAS(t *testing.T, secret string, code radius.Code) (int, <-chan *radius.Packet) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+
+	requests := make(chan *radius.Packet, 4)
+	server := radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			requests <- r.Packet
+
+			response := r.Response(code)
+			if code == radius.CodeDisconnectNAK || code == radius.CodeCoANAK {
+				rfc3576.ErrorCause_Set(response, rfc3576.ErrorCause_Value_SessionContextNotFound) //nolint:errcheck // test
+			}
+
+			w.Write(response) //nolint:errcheck // test
+		}),
+	}
+
+	go server.Serve(conn) //nolint:errcheck // stopped by the cleanup
+
+	t.Cleanup(func() { server.Shutdown(context.Background()) }) //nolint:errcheck // test
+
+	return conn.LocalAddr().(*net.UDPAddr).Port, requests //nolint:forcetypeassert // it's UDP
+}
+
+// newClient creates a client that sends requests to a port.
+func newClient(port int) *coa.Client {
+	cfg := config.NewConfig()
+	cfg.RadiusSecret = testSecret
+	cfg.CoAPort = port
+	cfg.CoATimeout = 2 * time.Second
+
+	return coa.NewClient(cfg)
+}
+
+func TestDisconnect(t *testing.T) {
+	t.Parallel()
+
+	port, requests := startNAS(t, testSecret, radius.CodeDisconnectACK)
+	target := coa.Target{
+		NASAddress:    "127.0.0.1",
+		NASIdentifier: "ap-1",
+		Username:      "aabbccddeeff",
+		MACAddress:    "AA-BB-CC-DD-EE-FF",
+		SessionID:     "5F2A0001",
+	}
+
+	if err := newClient(port).Disconnect(context.Background(), target); err != nil {
+		t.Fatalf("error disconnecting: %v", err)
+	}
+
+	request := <-requests
+	if request.Code != radius.CodeDisconnectRequest {
+		t.Errorf("got code %s, want %s", request.Code, radius.CodeDisconnectRequest)
+	}
+
+	if got := rfc2865.UserName_GetString(request); got != target.Username {
+		t.Errorf("got User-Name %q, want %q", got, target.Username)
+	}
+
+	if got := rfc2865.CallingStationID_GetString(request); got != target.MACAddress {
+		t.Errorf("got Calling-Station-Id %q, want %q", got, target.MACAddress)
+	}
+
+	if got := rfc2865.NASIPAddress_Get(request); !got.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("got NAS-IP-Address %s, want 127.0.0.1", got)
+	}
+
+	if got := rfc2865.NASIdentifier_GetString(request); got != target.NASIdentifier {
+		t.Errorf("got NAS-Identifier %q, want %q", got, target.NASIdentifier)
+	}
+
+	if got := rfc2866.AcctSessionID_GetString(request); got != target.SessionID {
+		t.Errorf("got Acct-Session-Id %q, want %q", got, target.SessionID)
+	}
+}
+
+func TestDisconnectNAK(t *testing.T) {
+	t.Parallel()
+
+	port, _ := startNAS(t, testSecret, radius.CodeDisconnectNAK)
+
+	err := newClient(port).Disconnect(context.Background(), coa.Target{NASAddress: "127.0.0.1", Username: "phone"})
+	if !errors.Is(err, coa.ErrNAK) {
+		t.Fatalf("got error %v, want %v", err, coa.ErrNAK)
+	}
+
+	if !strings.Contains(err.Error(), "Session-Context-Not-Found") {
+		t.Errorf("got error %q without the error cause", err)
+	}
+}
+
+func TestDisconnectPerNAS(t *testing.T) {
+	t.Parallel()
+
+	port, requests := startNAS(t, "nas-secret", radius.CodeDisconnectACK)
+
+	// The default port and secret are wrong, so the request only succeeds with the NAS entry
+	cfg := config.NewConfig()
+	cfg.RadiusSecret = testSecret
+	cfg.CoAPort = 1
+	cfg.CoATimeout = 2 * time.Second
+	cfg.CoANAS = []string{"127.0.0.1 " + strconv.Itoa(port) + " nas-secret"}
+
+	if err := coa.NewClient(cfg).Disconnect(context.Background(), coa.Target{NASAddress: "127.0.0.1", Username: "phone"}); err != nil {
+		t.Fatalf("error disconnecting: %v", err)
+	}
+
+	if request := <-requests; rfc2865.UserName_GetString(request) != "phone" {
+		t.Errorf("got User-Name %q, want phone", rfc2865.UserName_GetString(request))
+	}
+}
+
+func TestCoA(t *testing.T) {
+	t.Parallel()
+
+	port, requests := startNAS(t, testSecret, radius.CodeCoAACK)
+
+	attributes := radius.Attributes{}
+	attributes.Add(rfc2868.TunnelPrivateGroupID_Type, radius.Attribute("\x0020"))
+
+	if err := newClient(port).CoA(context.Background(), coa.Target{NASAddress: "127.0.0.1", Username: "phone"}, attributes); err != nil {
+		t.Fatalf("error sending CoA-Request: %v", err)
+	}
+
+	request := <-requests
+	if request.Code != radius.CodeCoARequest {
+		t.Errorf("got code %s, want %s", request.Code, radius.CodeCoARequest)
+	}
+
+	if got := request.Get(rfc2868.TunnelPrivateGroupID_Type); string(got) != "\x0020" {
+		t.Errorf("got Tunnel-Private-Group-ID %q, want the new VLAN", got)
+	}
+}
+
+func TestReauthorizeFallsBackToDisconnect(t *testing.T) {
+	t.Parallel()
+
+	port, requests := startNAS(t, testSecret, radius.CodeCoANAK)
+
+	events := eventlog.NewLog("", 10, 0)
+	if err := events.Record(eventlog.Event{Time: time.Now(), Username: "phone", NASAddress: "127.0.0.1", SessionID: "5F2A0001"}); err != nil {
+		t.Fatalf("error recording event: %v", err)
+	}
+
+	// The NAS refuses both requests, but the disconnect must still be attempted
+	err := coa.NewKicker(newClient(port), events).Reauthorize(context.Background(), "phone", nil)
+	if !errors.Is(err, coa.ErrNAK) {
+		t.Fatalf("got error %v, want %v", err, coa.ErrNAK)
+	}
+
+	codes := []radius.Code{(<-requests).Code, (<-requests).Code}
+	if codes[0] != radius.CodeCoARequest || codes[1] != radius.CodeDisconnectRequest {
+		t.Errorf("got requests %v, want a CoA-Request and then a Disconnect-Request", codes)
+	}
+}
+
+func TestLastSession(t *testing.T) {
+	t.Parallel()
+
+	events := eventlog.NewLog("", 10, 0)
+
+	if _, err := coa.LastSession(events, "phone"); !errors.Is(err, coa.ErrNoSession) {
+		t.Fatalf("got error %v, want %v", err, coa.ErrNoSession)
+	}
+
+	event := eventlog.Event{
+		Time:          time.Now(),
+		Username:      "phone",
+		MACAddress:    "AA-BB-CC-DD-EE-FF",
+		NASAddress:    "10.0.0.2",
+		NASIdentifier: "ap-1",
+		SessionID:     "5F2A0001",
+	}
+	if err := events.Record(event); err != nil {
+		t.Fatalf("error recording event: %v", err)
+	}
+
+	want := coa.Target{NASAddress: "10.0.0.2", NASIdentifier: "ap-1", Username: "phone", MACAddress: "AA-BB-CC-DD-EE-FF", SessionID: "5F2A0001"}
+	if got, err := coa.LastSession(events, "phone"); err != nil || got != want {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestParseCoANAS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		entry   string
+		address string
+		port    int
+		secret  string
+		ok      bool
+	}{
+		{"10.0.0.2 3799", "10.0.0.2", 3799, "", true},
+		{"10.0.0.2 1700 s3cret", "10.0.0.2", 1700, "s3cret", true},
+		{"10.0.0.2", "", 0, "", false},
+		{"10.0.0.2 port", "", 0, "", false},
+		{"10.0.0.2 70000", "", 0, "", false},
+		{"10.0.0.2 3799 s3cret extra", "", 0, "", false},
+	}
+
+	for _, tt := range tests {
+		address, port, secret, ok := config.ParseCoANAS(tt.entry)
+		if address != tt.address || port != tt.port || secret != tt.secret || ok != tt.ok {
+			t.Errorf("ParseCoANAS(%q) = %q, %d, %q, %v", tt.entry, address, port, secret, ok)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package coa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/radiusserver"
+	"layeh.com/radius"
+)
+
+// Database wraps a database.Database and sends Dynamic Authorization requests when devices are
+// approved, blocked, moved to another VLAN, deleted, or expire, so the changes apply right away instead
+// of the next time the devices happen to re-authenticate.
+type Database struct {
+	database.Database
+
+	//nolint:containedctx // Changes happen outside of a request, so the server context is used
+	ctx    context.Context
+	kicker *Kicker
+	l      *slog.Logger
+}
+
+// NewDatabase creates a new Database that sends Dynamic Authorization requests for the changes in db.
+func NewDatabase(ctx context.Context, db database.Database, kicker *Kicker) *Database {
+	return &Database{Database: db, ctx: ctx, kicker: kicker, l: logging.FromCtx(ctx)}
+}
+
+// CreateUser creates a new user and disconnects its device in the background, so it leaves the
+// quarantine or default VLAN and reconnects on its new VLAN.
+func (d *Database) CreateUser(u database.User) error {
+	if err := d.Database.CreateUser(u); err != nil {
+		return fmt.Errorf("error creating user: %w", err)
+	}
+
+	go d.kick(u.Username, "approved")
+
+	return nil
+}
+
+// UpdateUser updates a user and reauthorizes its device in the background if its access changed.
+func (d *Database) UpdateUser(u database.User) error {
+	before := d.decide(u.Username)
+
+	if err := d.Database.UpdateUser(u); err != nil {
+		return fmt.Errorf("error updating user: %w", err)
+	}
+
+	d.reauthorizeIfChanged(u.Username, before)
+
+	return nil
+}
+
+// UpdateGroup updates a group and reauthorizes the devices of its members whose access changed.
+func (d *Database) UpdateGroup(g database.Group) error {
+	members, err := d.members(g.Name)
+	if err != nil {
+		return err
+	}
+
+	before := make(map[string]radiusserver.Decision, len(members))
+	for _, username := range members {
+		before[username] = d.decide(username)
+	}
+
+	if err := d.Database.UpdateGroup(g); err != nil {
+		return fmt.Errorf("error updating group: %w", err)
+	}
+
+	for _, username := range members {
+		d.reauthorizeIfChanged(username, before[username])
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user and disconnects its device in the background.
+func (d *Database) DeleteUser(username string) error {
+	if err := d.Database.DeleteUser(username); err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	go d.kick(username, "deleted")
+
+	return nil
+}
+
+// BlockUser blocks a user and disconnects its device in the background.
+func (d *Database) BlockUser(username string) error {
+	if err := d.Database.BlockUser(username); err != nil {
+		return fmt.Errorf("error blocking user: %w", err)
+	}
+
+	go d.kick(username, "blocked")
+
+	return nil
+}
+
+// expirer is a database that announces the changes made when users and temporary VLANs expire.
+type expirer interface {
+	ExpireUser(u database.User) error
+	RevertTempVLAN(u database.User) error
+}
+
+// ExpireUser deletes an expired guest user and disconnects its device in the background. The
+// wrapped database's ExpireUser is used if it has one.
+func (d *Database) ExpireUser(u database.User) error {
+	var err error
+	if e, ok := d.Database.(expirer); ok {
+		err = e.ExpireUser(u)
+	} else {
+		err = d.Database.DeleteUser(u.Username)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error expiring user: %w", err)
+	}
+
+	go d.kick(u.Username, "expired")
+
+	return nil
+}
+
+// RevertTempVLAN removes the expired temporary VLAN of a user and moves its device back in the
+// background. The wrapped database's RevertTempVLAN is used if it has one.
+func (d *Database) RevertTempVLAN(u database.User) error {
+	// The device is still on the temporary VLAN, even though it already expired
+	var tempVLANID string
+	if u.TempVLAN != nil {
+		tempVLANID = u.TempVLAN.VlanID
+	}
+
+	var err error
+	if e, ok := d.Database.(expirer); ok {
+		err = e.RevertTempVLAN(u)
+	} else {
+		u.TempVLAN = nil
+		err = d.Database.UpdateUser(u)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reverting temporary VLAN: %w", err)
+	}
+
+	after := d.decide(u.Username)
+
+	switch {
+	case !after.Accepted():
+		go d.kick(u.Username, "rejected")
+	case vlanID(after) != tempVLANID:
+		go d.reauthorize(u.Username, after.Attributes)
+	}
+
+	return nil
+}
+
+// ReplaceVLAN moves the users and groups that use a VLAN to another VLAN, deletes it, and
+// reauthorizes the devices whose access changed.
+func (d *Database) ReplaceVLAN(id, replacementID string) (database.VLANUsage, error) {
+	usage, err := database.GetVLANUsage(d.Database, id)
+	if err != nil {
+		return database.VLANUsage{}, fmt.Errorf("error getting VLAN usage: %w", err)
+	}
+
+	// The devices of the users and of the members of the groups may move
+	before := make(map[string]radiusserver.Decision, len(usage.Users))
+	for _, u := range usage.Users {
+		before[u.Username] = d.decide(u.Username)
+	}
+
+	for _, g := range usage.Groups {
+		members, err := d.members(g.Name)
+		if err != nil {
+			return database.VLANUsage{}, err
+		}
+
+		for _, username := range members {
+			before[username] = d.decide(username)
+		}
+	}
+
+	moved, err := d.Database.ReplaceVLAN(id, replacementID)
+	if err != nil {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN: %w", err)
+	}
+
+	for username, decision := range before {
+		d.reauthorizeIfChanged(username, decision)
+	}
+
+	return moved, nil
+}
+
+// members returns the usernames of the members of a group.
+func (d *Database) members(group string) ([]string, error) {
+	users, err := d.Database.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("error getting users: %w", err)
+	}
+
+	members := []string{}
+
+	for _, u := range users {
+		if u.Group == group {
+			members = append(members, u.Username)
+		}
+	}
+
+	return members, nil
+}
+
+// decide returns the decision a device would get if it authenticated now on the network it last connected to.
+func (d *Database) decide(username string) radiusserver.Decision {
+	req := radiusserver.Request{Username: username, Time: time.Now()}
+	if u, err := d.Database.GetUser(username); err == nil {
+		req.Password = u.Password
+	}
+
+	if stats, ok := d.kicker.events.Stats(username); ok {
+		req.NASAddress = stats.LastNAS
+		req.NASIdentifier = stats.LastNASIdentifier
+		req.CalledStationID = stats.LastCalledStationID
+		req.SSID = radiusserver.ParseSSID(stats.LastCalledStationID)
+	}
+
+	return radiusserver.Decide(d.Database, req)
+}
+
+// reauthorizeIfChanged reauthorizes a device in the background if its decision changed. Devices
+// that are now rejected are disconnected instead.
+func (d *Database) reauthorizeIfChanged(username string, before radiusserver.Decision) {
+	after := d.decide(username)
+
+	switch {
+	case !after.Accepted() && before.Accepted():
+		go d.kick(username, "rejected")
+	case after.Accepted() && vlanID(after) != vlanID(before):
+		go d.reauthorize(username, after.Attributes)
+	}
+}
+
+// kick disconnects a device, logging the outcome.
+func (d *Database) kick(username, why string) {
+	err := d.kicker.Kick(d.ctx, username)
+	if errors.Is(err, ErrNoSession) {
+		d.l.Debug("No known session to disconnect the device from", slog.String("username", username))
+
+		return
+	}
+
+	if err != nil {
+		d.l.Error("Error disconnecting device", slog.Any("error", err), slog.String("username", username), slog.String("change", why))
+
+		return
+	}
+
+	d.l.Info("Disconnected device", slog.String("username", username), slog.String("change", why))
+}
+
+// reauthorize sends the new attributes of a device to its NAS, logging the outcome.
+func (d *Database) reauthorize(username string, attributes radius.Attributes) {
+	err := d.kicker.Reauthorize(d.ctx, username, attributes)
+	if errors.Is(err, ErrNoSession) {
+		d.l.Debug("No known session to reauthorize the device in", slog.String("username", username))
+
+		return
+	}
+
+	if err != nil {
+		d.l.Error("Error reauthorizing device", slog.Any("error", err), slog.String("username", username))
+
+		return
+	}
+
+	d.l.Info("Reauthorized device on its new VLAN", slog.String("username", username))
+}
+
+// vlanID returns the ID of the VLAN of a decision, or an empty string if there's none.
+func vlanID(d radiusserver.Decision) string {
+	if d.VLAN == nil {
+		return ""
+	}
+
+	return d.VLAN.ID
+}
@@ -0,0 +1,136 @@
+package coa_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/coa"
+	"github.com/maronato/authifi/internal/database"
+	memorydatabase "github.com/maronato/authifi/internal/database/memory"
+	"github.com/maronato/authifi/internal/eventlog"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2868"
+)
+
+// nextRequest returns the next request received by a fake NAS.
+func nextRequest(t *testing.T, requests <-chan *radius.Packet) *radius.Packet {
+	t.Helper()
+
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no request")
+
+		return nil
+	}
+}
+
+func TestDatabaseTriggers(t *testing.T) {
+	t.Parallel()
+
+	port, requests := startNAS(t, testSecret, radius.CodeCoAACK)
+
+	mem := memorydatabase.NewMemoryDatabase()
+	for _, v := range []database.VLAN{{ID: "10", Name: "Home"}, {ID: "20", Name: "IoT"}} {
+		if err := mem.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	if err := mem.CreateUser(database.User{Username: "phone", Password: "phone", VlanID: "10"}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	events := eventlog.NewLog("", 10, 0)
+	if err := events.Record(eventlog.Event{Time: time.Now(), Username: "phone", NASAddress: "127.0.0.1"}); err != nil {
+		t.Fatalf("error recording event: %v", err)
+	}
+
+	db := coa.NewDatabase(context.Background(), mem, coa.NewKicker(newClient(port), events))
+
+	// Moving the device to another VLAN sends its new VLAN in a CoA-Request
+	if err := db.UpdateUser(database.User{Username: "phone", Password: "phone", VlanID: "20"}); err != nil {
+		t.Fatalf("error updating user: %v", err)
+	}
+
+	request := nextRequest(t, requests)
+	if request.Code != radius.CodeCoARequest {
+		t.Errorf("got code %s, want %s", request.Code, radius.CodeCoARequest)
+	}
+
+	if _, vlan := rfc2868.TunnelPrivateGroupID_GetString(request); vlan != "20" {
+		t.Errorf("got Tunnel-Private-Group-ID %q, want 20", vlan)
+	}
+
+	// Renaming the device doesn't change its access
+	if err := db.UpdateUser(database.User{Username: "phone", Password: "phone", VlanID: "20", Description: "Phone"}); err != nil {
+		t.Fatalf("error updating user: %v", err)
+	}
+
+	// Blocking the device disconnects it
+	if err := db.BlockUser("phone"); err != nil {
+		t.Fatalf("error blocking user: %v", err)
+	}
+
+	if request := nextRequest(t, requests); request.Code != radius.CodeDisconnectRequest {
+		t.Errorf("got code %s, want %s", request.Code, radius.CodeDisconnectRequest)
+	}
+}
+
+func TestDatabaseExpiry(t *testing.T) {
+	t.Parallel()
+
+	port, requests := startNAS(t, testSecret, radius.CodeCoAACK)
+
+	mem := memorydatabase.NewMemoryDatabase()
+	for _, v := range []database.VLAN{{ID: "10", Name: "Home"}, {ID: "20", Name: "Guest"}} {
+		if err := mem.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	phone := database.User{Username: "phone", Password: "phone", VlanID: "10", TempVLAN: &database.VLANOverride{VlanID: "20", ExpiresAt: time.Now().Add(-time.Minute)}}
+	if err := mem.CreateUser(phone); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	events := eventlog.NewLog("", 10, 0)
+	if err := events.Record(eventlog.Event{Time: time.Now(), Username: "phone", NASAddress: "127.0.0.1"}); err != nil {
+		t.Fatalf("error recording event: %v", err)
+	}
+
+	db := coa.NewDatabase(context.Background(), mem, coa.NewKicker(newClient(port), events))
+
+	// Reverting the temporary VLAN moves the device back to its own VLAN
+	if err := db.RevertTempVLAN(phone); err != nil {
+		t.Fatalf("error reverting temporary VLAN: %v", err)
+	}
+
+	request := nextRequest(t, requests)
+	if request.Code != radius.CodeCoARequest {
+		t.Errorf("got code %s, want %s", request.Code, radius.CodeCoARequest)
+	}
+
+	if _, vlan := rfc2868.TunnelPrivateGroupID_GetString(request); vlan != "10" {
+		t.Errorf("got Tunnel-Private-Group-ID %q, want 10", vlan)
+	}
+
+	if u, _ := mem.GetUser("phone"); u.TempVLAN != nil {
+		t.Errorf("got temporary VLAN %+v after reverting it", u.TempVLAN)
+	}
+
+	// Expiring the device disconnects it
+	if err := db.ExpireUser(phone); err != nil {
+		t.Fatalf("error expiring user: %v", err)
+	}
+
+	if request := nextRequest(t, requests); request.Code != radius.CodeDisconnectRequest {
+		t.Errorf("got code %s, want %s", request.Code, radius.CodeDisconnectRequest)
+	}
+
+	if _, err := mem.GetUser("phone"); err == nil {
+		t.Error("got the user after expiring it")
+	}
+}
@@ -0,0 +1,73 @@
+package coa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/maronato/authifi/internal/eventlog"
+	"layeh.com/radius"
+)
+
+// ErrNoSession is returned when there's no known session for a device.
+var ErrNoSession = errors.New("no known session for the device")
+
+// LastSession returns the session a device last authenticated in, from the event log.
+func LastSession(events *eventlog.Log, username string) (Target, error) {
+	stats, ok := events.Stats(username)
+	if !ok || stats.LastNAS == "" {
+		return Target{}, fmt.Errorf("%w: %s", ErrNoSession, username)
+	}
+
+	return Target{
+		NASAddress:    stats.LastNAS,
+		NASIdentifier: stats.LastNASIdentifier,
+		Username:      username,
+		MACAddress:    stats.LastMAC,
+		SessionID:     stats.LastSessionID,
+	}, nil
+}
+
+// Kicker sends Dynamic Authorization requests to the last known session of the devices.
+type Kicker struct {
+	client *Client
+	events *eventlog.Log
+}
+
+// NewKicker creates a new Kicker.
+func NewKicker(client *Client, events *eventlog.Log) *Kicker {
+	return &Kicker{client: client, events: events}
+}
+
+// Kick disconnects a device, so it authenticates again.
+func (k *Kicker) Kick(ctx context.Context, username string) error {
+	target, err := LastSession(k.events, username)
+	if err != nil {
+		return err
+	}
+
+	if err := k.client.Disconnect(ctx, target); err != nil {
+		return fmt.Errorf("error disconnecting %s: %w", username, err)
+	}
+
+	return nil
+}
+
+// Reauthorize sends new authorization attributes to a device with a CoA-Request. Devices are
+// disconnected instead if their NAS refuses it, so they get the attributes when they reconnect.
+func (k *Kicker) Reauthorize(ctx context.Context, username string, attributes radius.Attributes) error {
+	target, err := LastSession(k.events, username)
+	if err != nil {
+		return err
+	}
+
+	if err := k.client.CoA(ctx, target, attributes); err != nil {
+		if errors.Is(err, ErrNAK) {
+			return k.Kick(ctx, username)
+		}
+
+		return fmt.Errorf("error reauthorizing %s: %w", username, err)
+	}
+
+	return nil
+}
@@ -3,9 +3,15 @@ package config
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net"
+	"net/mail"
 	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type VerboseLevel int
@@ -30,11 +36,48 @@ const (
 	DefaultVerbose = VerboseLevelInfo
 	// DefaultQuiet is the default quiet mode.
 	DefaultQuiet = false
+	// DefaultEventLogFilePath is the default file path to the authentication event log.
+	DefaultEventLogFilePath = "events.jsonl"
+	// DefaultEventLogSize is the default number of authentication events kept in memory.
+	DefaultEventLogSize = 1000
+	// DefaultEventLogMaxFileSize is the default size in megabytes after which the event log is rotated.
+	DefaultEventLogMaxFileSize = 10
+	// DefaultWebhookRetries is the default number of times a failed webhook is retried.
+	DefaultWebhookRetries = 3
+	// DefaultWebhookRetryBackoff is the default wait before the first webhook retry.
+	DefaultWebhookRetryBackoff = time.Second
+	// DefaultApprovalTTL is the default time approval links are valid for.
+	DefaultApprovalTTL = 24 * time.Hour
+	// DefaultNtfyURL is the default ntfy server.
+	DefaultNtfyURL = "https://ntfy.sh"
+	// DefaultNtfyPriority is the default ntfy priority of new device alerts.
+	DefaultNtfyPriority = 4
+	// DefaultGotifyPriority is the default Gotify priority of new device alerts.
+	DefaultGotifyPriority = 8
+	// DefaultSMTPPort is the default SMTP submission port.
+	DefaultSMTPPort = 587
+	// DefaultSMTPStartTLS is whether STARTTLS is required by default.
+	DefaultSMTPStartTLS = true
+	// DefaultNotifyDedupWindow is the default time repeated attempts from the same device are not notified.
+	DefaultNotifyDedupWindow = 10 * time.Minute
+	// DefaultNotifyRateLimit is the default maximum number of notifications per rate period.
+	DefaultNotifyRateLimit = 30
+	// DefaultNotifyRatePeriod is the default period of the notification rate limit.
+	DefaultNotifyRatePeriod = time.Minute
+	// DefaultExpiryCheckInterval is the default interval between checks for expired guests and temporary VLANs.
+	DefaultExpiryCheckInterval = time.Minute
+	// DefaultCoAPort is the default port NAS devices listen to Dynamic Authorization requests on (RFC 5176).
+	DefaultCoAPort = 3799
+	// DefaultCoATimeout is the default time to wait for a NAS to answer a Dynamic Authorization request.
+	DefaultCoATimeout = 5 * time.Second
 )
 
 // ErrInvalidConfig is returned when the config is invalid.
 var ErrInvalidConfig = errors.New("invalid config")
 
+// telegramSecretTokenRegex matches the secret tokens accepted by Telegram.
+var telegramSecretTokenRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
 type Config struct {
 	// Prod is a flag that indicates if the server is running in production mode.
 	Prod bool
@@ -52,20 +95,171 @@ type Config struct {
 	Verbose VerboseLevel `json:"verbose"`
 	// Quiet defines whether or not the server should be quiet.
 	Quiet bool
+	// ReplyMessage defines whether or not the reason for the access decision is sent in a Reply-Message attribute.
+	ReplyMessage bool
 	// TelegramBotToken is the token used to authenticate with the Telegram bot API.
 	TelegramBotToken string
-	// TelegramChatIDs is a list of chat IDs that are allowed to interact with the bot.
+	// TelegramChatIDs is a list of chat or user IDs with the admin role. Admins can do everything.
 	TelegramChatIDs []string
+	// TelegramOperatorIDs is a list of chat or user IDs with the operator role.
+	// Operators can approve devices to non-privileged VLANs.
+	TelegramOperatorIDs []string
+	// TelegramViewerIDs is a list of chat or user IDs with the viewer role. Viewers receive alerts and can list devices.
+	TelegramViewerIDs []string
+	// TelegramWebhookListen is the address the Telegram webhook server listens on. Long polling is used if empty.
+	TelegramWebhookListen string
+	// TelegramWebhookURL is the public HTTPS URL Telegram sends updates to.
+	TelegramWebhookURL string
+	// TelegramWebhookSecret is the secret token Telegram sends with every update.
+	TelegramWebhookSecret string
+	// TelegramWebhookTLSCert is the optional certificate file the webhook server uses for TLS.
+	TelegramWebhookTLSCert string
+	// TelegramWebhookTLSKey is the optional key file the webhook server uses for TLS.
+	TelegramWebhookTLSKey string
+	// EventLogFilePath is the path to the authentication event log. Events are only kept in memory if empty.
+	EventLogFilePath string
+	// EventLogSize is the number of authentication events kept in memory.
+	EventLogSize int
+	// EventLogMaxFileSize is the size in megabytes after which the event log is rotated.
+	EventLogMaxFileSize int
+	// WebhookURLs is a list of URLs that receive events as JSON.
+	WebhookURLs []string
+	// WebhookTemplates is a list of "<url> <template file> [content type]" values that customize the body sent to a webhook URL.
+	WebhookTemplates []string
+	// WebhookSecret is used to sign the webhook bodies with HMAC-SHA256.
+	WebhookSecret string
+	// WebhookRetries is the number of times a failed webhook is retried.
+	WebhookRetries int
+	// WebhookRetryBackoff is the wait before the first webhook retry. It doubles on every retry.
+	WebhookRetryBackoff time.Duration
+	// ApprovalListenAddr is the address the approval HTTP server listens on. It's disabled if empty.
+	ApprovalListenAddr string
+	// ApprovalPublicURL is the base URL used in approval links.
+	ApprovalPublicURL string
+	// ApprovalSecret is used to sign approval links.
+	ApprovalSecret string
+	// ApprovalTTL is how long approval links are valid for.
+	ApprovalTTL time.Duration
+	// NtfyURL is the base URL of the ntfy server.
+	NtfyURL string
+	// NtfyTopic is the ntfy topic alerts are published to. ntfy is disabled if empty.
+	NtfyTopic string
+	// NtfyToken is the optional ntfy access token.
+	NtfyToken string
+	// NtfyPriority is the ntfy priority of new device alerts, from 1 to 5.
+	NtfyPriority int
+	// NtfyTags are the ntfy tags of new device alerts. A siren emoji is used if empty.
+	NtfyTags []string
+	// GotifyURL is the base URL of the Gotify server. Gotify is disabled if empty.
+	GotifyURL string
+	// GotifyToken is the Gotify application token.
+	GotifyToken string
+	// GotifyPriority is the Gotify priority of new device alerts, from 0 to 10.
+	GotifyPriority int
+	// SMTPHost is the host of the SMTP server. Email is disabled if empty.
+	SMTPHost string
+	// SMTPPort is the port of the SMTP server.
+	SMTPPort int
+	// SMTPUsername is the optional SMTP username.
+	SMTPUsername string
+	// SMTPPassword is the optional SMTP password.
+	SMTPPassword string
+	// SMTPStartTLS requires the SMTP connection to be upgraded with STARTTLS.
+	SMTPStartTLS bool
+	// EmailFrom is the sender address of the emails.
+	EmailFrom string
+	// EmailTo is a list of recipient addresses.
+	EmailTo []string
+	// EmailAlertTemplate is an optional template file for the immediate alerts.
+	EmailAlertTemplate string
+	// EmailDigestTemplate is an optional template file for the daily digest.
+	EmailDigestTemplate string
+	// EmailDigestTime is the local time of day ("15:04") the digest is sent at. It's disabled if empty.
+	EmailDigestTime string
+	// MatrixHomeserverURL is the base URL of the Matrix homeserver. Matrix is disabled if empty.
+	MatrixHomeserverURL string
+	// MatrixAccessToken is the access token of the Matrix bot user.
+	MatrixAccessToken string
+	// MatrixRoomID is the Matrix room alerts are sent to.
+	MatrixRoomID string
+	// DiscordWebhookURL is the Discord webhook alerts are posted to. Discord is disabled if empty.
+	DiscordWebhookURL string
+	// SlackWebhookURL is the Slack incoming webhook alerts are posted to. Slack is disabled if empty.
+	SlackWebhookURL string
+	// NotifyDedupWindow is how long repeated attempts from the same device are not notified again. It's disabled if zero.
+	NotifyDedupWindow time.Duration
+	// NotifyRateLimit is the maximum number of notifications sent per NotifyRatePeriod. It's disabled if zero.
+	NotifyRateLimit int
+	// NotifyRatePeriod is the period of the notification rate limit.
+	NotifyRatePeriod time.Duration
+	// ExpiryCheckInterval is how often expired guests and temporary VLANs are cleaned up.
+	ExpiryCheckInterval time.Duration
+	// CoAEnabled defines whether devices are disconnected or reauthorized after they are approved, blocked,
+	// moved to another VLAN, or deleted, so the change applies right away.
+	CoAEnabled bool
+	// CoAPort is the default port Dynamic Authorization requests are sent to.
+	CoAPort int
+	// CoASecret is the default secret of the Dynamic Authorization requests. The RADIUS secret is used if empty.
+	CoASecret string
+	// CoATimeout is how long to wait for a NAS to answer a Dynamic Authorization request.
+	CoATimeout time.Duration
+	// CoANAS is a list of "<address> <port> [secret]" entries that override the CoA port and secret of a NAS.
+	CoANAS []string
+}
+
+// ParseCoANAS splits a "<address> <port> [secret]" entry. The secret is empty if it's not set.
+func ParseCoANAS(s string) (address string, port int, secret string, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 { //nolint:gomnd // address, port and secret
+		return "", 0, "", false
+	}
+
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, "", false
+	}
+
+	if len(fields) == 3 { //nolint:gomnd // the secret is set
+		secret = fields[2]
+	}
+
+	return fields[0], port, secret, true
+}
+
+// ParseWebhookTemplate splits a "<url> <template file> [content type]" value. The content type is
+// empty if it's not set.
+func ParseWebhookTemplate(s string) (webhookURL, templatePath, contentType string, ok bool) {
+	webhookURL, rest, ok := strings.Cut(strings.TrimSpace(s), " ")
+	templatePath, contentType, _ = strings.Cut(strings.TrimSpace(rest), " ")
+
+	return webhookURL, templatePath, strings.TrimSpace(contentType), ok && templatePath != ""
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Prod:             DefaultProd,
-		Host:             DefaultHost,
-		Port:             DefaultPort,
-		DatabaseFilePath: DefaultDatabaseFilePath,
-		Verbose:          DefaultVerbose,
-		Quiet:            DefaultQuiet,
+		Prod:                DefaultProd,
+		Host:                DefaultHost,
+		Port:                DefaultPort,
+		DatabaseFilePath:    DefaultDatabaseFilePath,
+		Verbose:             DefaultVerbose,
+		Quiet:               DefaultQuiet,
+		EventLogFilePath:    DefaultEventLogFilePath,
+		EventLogSize:        DefaultEventLogSize,
+		EventLogMaxFileSize: DefaultEventLogMaxFileSize,
+		WebhookRetries:      DefaultWebhookRetries,
+		WebhookRetryBackoff: DefaultWebhookRetryBackoff,
+		ApprovalTTL:         DefaultApprovalTTL,
+		NtfyURL:             DefaultNtfyURL,
+		NtfyPriority:        DefaultNtfyPriority,
+		GotifyPriority:      DefaultGotifyPriority,
+		SMTPPort:            DefaultSMTPPort,
+		SMTPStartTLS:        DefaultSMTPStartTLS,
+		NotifyDedupWindow:   DefaultNotifyDedupWindow,
+		NotifyRateLimit:     DefaultNotifyRateLimit,
+		NotifyRatePeriod:    DefaultNotifyRatePeriod,
+		ExpiryCheckInterval: DefaultExpiryCheckInterval,
+		CoAPort:             DefaultCoAPort,
+		CoATimeout:          DefaultCoATimeout,
 	}
 }
 
@@ -102,12 +296,164 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("%w: database file path is empty", ErrInvalidConfig)
 	}
 
+	if c.EventLogSize <= 0 {
+		return fmt.Errorf("%w: event log size must be positive", ErrInvalidConfig)
+	}
+
+	if c.EventLogMaxFileSize <= 0 {
+		return fmt.Errorf("%w: event log max file size must be positive", ErrInvalidConfig)
+	}
+
 	if c.RadiusSecret == "" {
 		return fmt.Errorf("%w: RADIUS secret is empty", ErrInvalidConfig)
 	}
 
+	if c.TelegramBotToken == "" && len(c.TelegramChatIDs)+len(c.TelegramOperatorIDs)+len(c.TelegramViewerIDs) > 0 {
+		return fmt.Errorf("%w: Telegram chat IDs require a Telegram bot token", ErrInvalidConfig)
+	}
+
+	if err := c.validateTelegramWebhook(); err != nil {
+		return err
+	}
+
+	// Make sure all webhook URLs are valid and all templates point to one of them.
+	webhookURLs := make(map[string]bool, len(c.WebhookURLs))
+
+	for _, u := range c.WebhookURLs {
+		if parsed, err := url.ParseRequestURI(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("%w: invalid webhook URL: %s", ErrInvalidConfig, u)
+		}
+
+		webhookURLs[u] = true
+	}
+
+	for _, t := range c.WebhookTemplates {
+		u, _, contentType, ok := ParseWebhookTemplate(t)
+		if !ok {
+			return fmt.Errorf("%w: webhook template must be \"<url> <template file> [content type]\": %s", ErrInvalidConfig, t)
+		}
+
+		if contentType != "" {
+			if _, _, err := mime.ParseMediaType(contentType); err != nil {
+				return fmt.Errorf("%w: invalid webhook template content type: %s", ErrInvalidConfig, contentType)
+			}
+		}
+
+		if !webhookURLs[u] {
+			return fmt.Errorf("%w: webhook template for unknown URL: %s", ErrInvalidConfig, u)
+		}
+	}
+
+	if c.WebhookRetries < 0 {
+		return fmt.Errorf("%w: webhook retries cannot be negative", ErrInvalidConfig)
+	}
+
+	if c.ApprovalListenAddr != "" {
+		if parsed, err := url.ParseRequestURI(c.ApprovalPublicURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("%w: invalid approval public URL: %s", ErrInvalidConfig, c.ApprovalPublicURL)
+		}
+
+		if c.ApprovalSecret == "" {
+			return fmt.Errorf("%w: approval secret is empty", ErrInvalidConfig)
+		}
+
+		if c.ApprovalTTL <= 0 {
+			return fmt.Errorf("%w: approval TTL must be positive", ErrInvalidConfig)
+		}
+	}
+
+	if c.NtfyTopic != "" {
+		if parsed, err := url.ParseRequestURI(c.NtfyURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("%w: invalid ntfy URL: %s", ErrInvalidConfig, c.NtfyURL)
+		}
+
+		if c.NtfyPriority < 1 || c.NtfyPriority > 5 {
+			return fmt.Errorf("%w: ntfy priority must be between 1 and 5", ErrInvalidConfig)
+		}
+	}
+
+	if c.GotifyURL != "" {
+		if parsed, err := url.ParseRequestURI(c.GotifyURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("%w: invalid Gotify URL: %s", ErrInvalidConfig, c.GotifyURL)
+		}
+
+		if c.GotifyToken == "" {
+			return fmt.Errorf("%w: Gotify token is empty", ErrInvalidConfig)
+		}
+
+		if c.GotifyPriority < 0 || c.GotifyPriority > 10 {
+			return fmt.Errorf("%w: Gotify priority must be between 0 and 10", ErrInvalidConfig)
+		}
+	}
+
+	if c.SMTPHost != "" {
+		if _, err := mail.ParseAddress(c.EmailFrom); err != nil {
+			return fmt.Errorf("%w: invalid email sender: %s", ErrInvalidConfig, c.EmailFrom)
+		}
+
+		if len(c.EmailTo) == 0 {
+			return fmt.Errorf("%w: email requires at least one recipient", ErrInvalidConfig)
+		}
+
+		for _, to := range c.EmailTo {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("%w: invalid email recipient: %s", ErrInvalidConfig, to)
+			}
+		}
+
+		if c.EmailDigestTime != "" {
+			if _, err := time.Parse("15:04", c.EmailDigestTime); err != nil {
+				return fmt.Errorf("%w: email digest time must be formatted as HH:MM: %s", ErrInvalidConfig, c.EmailDigestTime)
+			}
+		}
+	}
+
+	if c.NotifyDedupWindow < 0 || c.NotifyRateLimit < 0 {
+		return fmt.Errorf("%w: notification dedup window and rate limit cannot be negative", ErrInvalidConfig)
+	}
+
+	if c.NotifyRateLimit > 0 && c.NotifyRatePeriod <= 0 {
+		return fmt.Errorf("%w: notification rate period must be positive", ErrInvalidConfig)
+	}
+
+	if c.CoAPort <= 0 || c.CoAPort > 65535 || c.CoATimeout <= 0 {
+		return fmt.Errorf("%w: CoA port must be between 1 and 65535 and its timeout must be positive", ErrInvalidConfig)
+	}
+
+	for _, nas := range c.CoANAS {
+		if _, _, _, ok := ParseCoANAS(nas); !ok {
+			return fmt.Errorf("%w: CoA NAS must be \"<address> <port> [secret]\": %s", ErrInvalidConfig, nas)
+		}
+	}
+
+	if c.ExpiryCheckInterval <= 0 {
+		return fmt.Errorf("%w: expiry check interval must be positive", ErrInvalidConfig)
+	}
+
+	if c.MatrixHomeserverURL != "" {
+		if parsed, err := url.ParseRequestURI(c.MatrixHomeserverURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("%w: invalid Matrix homeserver URL: %s", ErrInvalidConfig, c.MatrixHomeserverURL)
+		}
+
+		if c.MatrixAccessToken == "" || c.MatrixRoomID == "" {
+			return fmt.Errorf("%w: Matrix requires an access token and a room ID", ErrInvalidConfig)
+		}
+	}
+
+	if c.DiscordWebhookURL != "" {
+		if parsed, err := url.ParseRequestURI(c.DiscordWebhookURL); err != nil || parsed.Scheme != "https" {
+			return fmt.Errorf("%w: invalid Discord webhook URL: %s", ErrInvalidConfig, c.DiscordWebhookURL)
+		}
+	}
+
+	if c.SlackWebhookURL != "" {
+		if parsed, err := url.ParseRequestURI(c.SlackWebhookURL); err != nil || parsed.Scheme != "https" {
+			return fmt.Errorf("%w: invalid Slack webhook URL: %s", ErrInvalidConfig, c.SlackWebhookURL)
+		}
+	}
+
 	// Make sure all chat IDs are integers.
-	for _, chatID := range c.TelegramChatIDs {
+	for _, chatID := range slices.Concat(c.TelegramChatIDs, c.TelegramOperatorIDs, c.TelegramViewerIDs) {
 		if _, err := strconv.Atoi(chatID); err != nil {
 			return fmt.Errorf("%w: invalid chat ID: %s", ErrInvalidConfig, chatID)
 		}
@@ -115,3 +461,38 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// validateTelegramWebhook validates the Telegram webhook settings.
+func (c *Config) validateTelegramWebhook() error {
+	if c.TelegramWebhookListen == "" {
+		if c.TelegramWebhookURL != "" {
+			return fmt.Errorf("%w: Telegram webhook URL requires a webhook listen address", ErrInvalidConfig)
+		}
+
+		return nil
+	}
+
+	if c.TelegramBotToken == "" {
+		return fmt.Errorf("%w: Telegram webhook requires a Telegram bot token", ErrInvalidConfig)
+	}
+
+	// Telegram only sends updates to HTTPS URLs
+	if parsed, err := url.ParseRequestURI(c.TelegramWebhookURL); err != nil || parsed.Scheme != "https" {
+		return fmt.Errorf("%w: invalid Telegram webhook URL: %s", ErrInvalidConfig, c.TelegramWebhookURL)
+	}
+
+	// Anyone who can reach the webhook server could send updates as an admin without the secret
+	if c.TelegramWebhookSecret == "" {
+		return fmt.Errorf("%w: Telegram webhook requires a webhook secret", ErrInvalidConfig)
+	}
+
+	if !telegramSecretTokenRegex.MatchString(c.TelegramWebhookSecret) {
+		return fmt.Errorf("%w: Telegram webhook secret must be 1-256 letters, numbers, underscores, or hyphens", ErrInvalidConfig)
+	}
+
+	if (c.TelegramWebhookTLSCert == "") != (c.TelegramWebhookTLSKey == "") {
+		return fmt.Errorf("%w: Telegram webhook TLS requires both a certificate and a key", ErrInvalidConfig)
+	}
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package database
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
 
 type VLAN struct {
 	ID               string `json:"id"                         yaml:"id"`
@@ -8,19 +13,266 @@ type VLAN struct {
 	Default          bool   `json:"default,omitempty"          yaml:"default,omitempty"`
 	TunnelType       uint32 `json:"tunnelType,omitempty"       yaml:"tunnelType,omitempty"`
 	TunnelMediumType uint32 `json:"tunnelMediumType,omitempty" yaml:"tunnelMediumType,omitempty"`
+	Privileged       bool   `json:"privileged,omitempty"       yaml:"privileged,omitempty"`
+	// Quarantine marks the VLAN unknown devices are isolated in until they are approved.
+	Quarantine bool `json:"quarantine,omitempty" yaml:"quarantine,omitempty"`
+	// DefaultFor are the networks whose unknown devices default to the VLAN instead of the
+	// quarantine or default VLAN.
+	DefaultFor []Network `json:"defaultFor,omitempty" yaml:"defaultFor,omitempty"`
+	// Attributes are sent to the devices on the VLAN. The group's and user's take precedence.
+	Attributes []Attribute `json:"attributes,omitempty" yaml:"attributes,omitempty"`
 }
 
 type User struct {
-	Username    string `json:"username"              yaml:"username"`
-	Password    string `json:"password"              yaml:"password"`
+	Username string `json:"username"              yaml:"username"`
+	Password string `json:"password"              yaml:"password"`
+	// VlanID is the VLAN of the user. Users in a group may leave it empty to use the group's VLAN.
+	VlanID      string `json:"vlan,omitempty"        yaml:"vlan,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Group is the name of the group of the user, if any.
+	Group string `json:"group,omitempty"       yaml:"group,omitempty"`
+	// Schedules are the time-based rules of the user. They are checked before the group's.
+	Schedules []Schedule `json:"schedules,omitempty" yaml:"schedules,omitempty"`
+	// Networks are the VLANs of the user on specific networks. They are checked before the group's.
+	Networks []NetworkVLAN `json:"networks,omitempty" yaml:"networks,omitempty"`
+	// ExpiresAt is when the user is deleted, for guests. Users never expire if it's nil.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+	// TempVLAN temporarily moves the user to another VLAN, if set.
+	TempVLAN *VLANOverride `json:"tempVlan,omitempty" yaml:"tempVlan,omitempty"`
+	// Attributes are sent to the user. They take precedence over the group's and VLAN's.
+	Attributes []Attribute `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// VLANOverride is a temporary VLAN assignment that is reverted when it expires.
+type VLANOverride struct {
+	// VlanID is the VLAN the user is moved to.
+	VlanID string `json:"vlan"      yaml:"vlan"`
+	// ExpiresAt is when the user goes back to its own VLAN.
+	ExpiresAt time.Time `json:"expiresAt" yaml:"expiresAt"`
+}
+
+// Expired reports whether the user has expired at t.
+func (u User) Expired(t time.Time) bool {
+	return u.ExpiresAt != nil && !t.Before(*u.ExpiresAt)
+}
+
+// ActiveTempVLAN returns the temporary VLAN override of the user that is active at t, if any.
+func (u User) ActiveTempVLAN(t time.Time) *VLANOverride {
+	if u.TempVLAN == nil || !t.Before(u.TempVLAN.ExpiresAt) {
+		return nil
+	}
+
+	return u.TempVLAN
+}
+
+// UsesVLAN reports whether the user is assigned to a VLAN directly, temporarily, or through its schedules or networks.
+func (u User) UsesVLAN(id string) bool {
+	return u.VlanID == id || (u.TempVLAN != nil && u.TempVLAN.VlanID == id) ||
+		schedulesUseVLAN(u.Schedules, id) || networksUseVLAN(u.Networks, id)
+}
+
+// MoveVLAN returns the user with every assignment to a VLAN moved to another one.
+func (u User) MoveVLAN(from, to string) User {
+	if u.VlanID == from {
+		u.VlanID = to
+	}
+
+	if u.TempVLAN != nil && u.TempVLAN.VlanID == from {
+		u.TempVLAN = &VLANOverride{VlanID: to, ExpiresAt: u.TempVLAN.ExpiresAt}
+	}
+
+	u.Schedules = moveSchedules(u.Schedules, from, to)
+	u.Networks = moveNetworks(u.Networks, from, to)
+
+	return u
+}
+
+// Group is a set of users that share a VLAN.
+type Group struct {
+	Name        string `json:"name"                  yaml:"name"`
 	VlanID      string `json:"vlan"                  yaml:"vlan"`
 	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Schedules are the time-based rules of the users in the group.
+	Schedules []Schedule `json:"schedules,omitempty" yaml:"schedules,omitempty"`
+	// Networks are the VLANs of the users in the group on specific networks.
+	Networks []NetworkVLAN `json:"networks,omitempty" yaml:"networks,omitempty"`
+	// Attributes are sent to the users in the group. They take precedence over the VLAN's.
+	Attributes []Attribute `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// UsesVLAN reports whether the group is assigned to a VLAN directly or through its schedules or networks.
+func (g Group) UsesVLAN(id string) bool {
+	return g.VlanID == id || schedulesUseVLAN(g.Schedules, id) || networksUseVLAN(g.Networks, id)
+}
+
+// MoveVLAN returns the group with every assignment to a VLAN moved to another one.
+func (g Group) MoveVLAN(from, to string) Group {
+	if g.VlanID == from {
+		g.VlanID = to
+	}
+
+	g.Schedules = moveSchedules(g.Schedules, from, to)
+	g.Networks = moveNetworks(g.Networks, from, to)
+
+	return g
+}
+
+// Network identifies the requests from an SSID, a NAS, or both. Empty fields match any request.
+type Network struct {
+	// SSID is the name of the wireless network, taken from the Called-Station-Id.
+	SSID string `json:"ssid,omitempty" yaml:"ssid,omitempty"`
+	// NAS is the NAS-Identifier or the IP address of the NAS.
+	NAS string `json:"nas,omitempty"  yaml:"nas,omitempty"`
+}
+
+// Validate checks that the network has an SSID or a NAS, so it doesn't match every request.
+func (n Network) Validate() error {
+	if n.SSID == "" && n.NAS == "" {
+		return ErrInvalidNetwork
+	}
+
+	return nil
+}
+
+// Matches reports whether a request from an SSID and a NAS belongs to the network.
+func (n Network) Matches(ssid, nasIdentifier, nasAddress string) bool {
+	if n.SSID != "" && n.SSID != ssid {
+		return false
+	}
+
+	return n.NAS == "" || n.NAS == nasIdentifier || n.NAS == nasAddress
+}
+
+// Specificity ranks how narrow the network is: networks with both an SSID and a NAS are the most
+// specific, followed by networks with only an SSID, and then networks with only a NAS.
+func (n Network) Specificity() int {
+	switch {
+	case n.SSID != "" && n.NAS != "":
+		return 2 //nolint:gomnd // SSID and NAS
+	case n.SSID != "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// String describes the network, like "SSID IoT on NAS ap-1".
+func (n Network) String() string {
+	switch {
+	case n.SSID != "" && n.NAS != "":
+		return "SSID " + n.SSID + " on NAS " + n.NAS
+	case n.SSID != "":
+		return "SSID " + n.SSID
+	default:
+		return "NAS " + n.NAS
+	}
+}
+
+// NetworkVLAN assigns a VLAN to the requests from a network.
+type NetworkVLAN struct {
+	Network `yaml:",inline"`
+	// VlanID is the VLAN of the requests from the network.
+	VlanID string `json:"vlan" yaml:"vlan"`
+}
+
+// ScheduleAction is what happens to a device while a schedule rule is active.
+type ScheduleAction string
+
+const (
+	// ScheduleActionReject rejects the device.
+	ScheduleActionReject ScheduleAction = "reject"
+	// ScheduleActionVLAN moves the device to another VLAN.
+	ScheduleActionVLAN ScheduleAction = "vlan"
+)
+
+// Schedule is a time window during which a device is rejected or moved to another VLAN.
+type Schedule struct {
+	// Days are the weekdays the window starts on, like "mon" or "friday". Every day if empty.
+	Days []string `json:"days,omitempty"     yaml:"days,omitempty"`
+	// From is when the window starts, as HH:MM.
+	From string `json:"from"               yaml:"from"`
+	// To is when the window ends, as HH:MM. Windows that end before they start end on the next day.
+	To string `json:"to"                 yaml:"to"`
+	// Timezone is the IANA time zone of the times. The system's time zone is used if empty.
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// Action is what happens during the window.
+	Action ScheduleAction `json:"action"             yaml:"action"`
+	// VlanID is the VLAN the device is moved to by vlan rules.
+	VlanID string `json:"vlan,omitempty"     yaml:"vlan,omitempty"`
+}
+
+// PolicyAction is what a policy rule does with the requests it matches.
+type PolicyAction string
+
+const (
+	// PolicyActionAccept keeps the usual decision and stops evaluating the policy.
+	PolicyActionAccept PolicyAction = "accept"
+	// PolicyActionReject rejects the request.
+	PolicyActionReject PolicyAction = "reject"
+	// PolicyActionVLAN moves accepted requests to another VLAN and stops evaluating the policy.
+	PolicyActionVLAN PolicyAction = "vlan"
+	// PolicyActionAttributes adds reply attributes to accepted requests and keeps evaluating the policy.
+	PolicyActionAttributes PolicyAction = "attributes"
+)
+
+// PolicyRule is a rule of the access policy. Rules are evaluated in order after the usual
+// access decision, and the first accept, reject, or vlan rule that matches ends the evaluation.
+type PolicyRule struct {
+	// Name identifies the rule in logs and dry runs.
+	Name string `json:"name"                 yaml:"name"`
+	// Match are the conditions of the rule. A rule without conditions matches every request.
+	Match PolicyMatch `json:"match"                yaml:"match,omitempty"`
+	// Action is what the rule does with the requests it matches.
+	Action PolicyAction `json:"action"               yaml:"action"`
+	// VlanID is the VLAN requests are moved to by vlan rules.
+	VlanID string `json:"vlan,omitempty"       yaml:"vlan,omitempty"`
+	// Attributes are the reply attributes added by vlan and attributes rules.
+	Attributes []Attribute `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// PolicyMatch are the conditions of a policy rule. A request must match every condition that is set.
+type PolicyMatch struct {
+	// Username is a glob pattern the User-Name must match, like "guest-*".
+	Username string `json:"username,omitempty"  yaml:"username,omitempty"`
+	// MACPrefix is the prefix or OUI of the MAC address, in any format, like "B8:27:EB".
+	MACPrefix string `json:"macPrefix,omitempty" yaml:"macPrefix,omitempty"`
+	// SSID is the SSID the request comes from.
+	SSID string `json:"ssid,omitempty"      yaml:"ssid,omitempty"`
+	// NAS is the NAS-Identifier or the IP address of the NAS the request comes from.
+	NAS string `json:"nas,omitempty"       yaml:"nas,omitempty"`
+	// Group is the group of the user. Unknown devices never match it.
+	Group string `json:"group,omitempty"     yaml:"group,omitempty"`
+	// Days are the weekdays the time window starts on. Every day if empty.
+	Days []string `json:"days,omitempty"      yaml:"days,omitempty"`
+	// From and To are the time window the request must be in, as HH:MM. No window if empty.
+	From string `json:"from,omitempty"      yaml:"from,omitempty"`
+	To   string `json:"to,omitempty"        yaml:"to,omitempty"`
+	// Timezone is the IANA time zone of the window. The system's time zone is used if empty.
+	Timezone string `json:"timezone,omitempty"  yaml:"timezone,omitempty"`
+}
+
+// Attribute is a RADIUS reply attribute, by its name in the built-in dictionary.
+type Attribute struct {
+	Name  string `json:"name"  yaml:"name"`
+	Value string `json:"value" yaml:"value"`
 }
 
 type BlockedUser struct {
 	Username string `json:"username" yaml:"username"`
 }
 
+// PendingDevice is an unknown device waiting for an admin to approve, ignore or block it.
+type PendingDevice struct {
+	ID         string    `json:"id"                   yaml:"id"`
+	Username   string    `json:"username"             yaml:"username"`
+	Password   string    `json:"password"             yaml:"password"`
+	MACAddress string    `json:"macAddress"           yaml:"macAddress"`
+	NASAddress string    `json:"nasAddress,omitempty" yaml:"nasAddress,omitempty"`
+	FirstSeen  time.Time `json:"firstSeen"            yaml:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"             yaml:"lastSeen"`
+	Attempts   int       `json:"attempts"             yaml:"attempts"`
+}
+
 // Database is the interface that wraps the basic database operations.
 type Database interface {
 	// GetVLANs returns all the VLANs.
@@ -29,12 +281,31 @@ type Database interface {
 	GetVLAN(id string) (VLAN, error)
 	// CreateVLAN creates a new VLAN.
 	CreateVLAN(v VLAN) error
-	// UpdateVLAN updates a VLAN.
+	// UpdateVLAN updates a VLAN. It returns ErrDefaultVLANAlreadyExists when setting Default
+	// while another VLAN is the default, and ErrQuarantineVLANAlreadyExists for Quarantine.
 	UpdateVLAN(v VLAN) error
-	// DeleteVLAN deletes a VLAN by its ID.
+	// DeleteVLAN deletes a VLAN by its ID. It returns ErrDefaultVLANInUse for the default VLAN
+	// and ErrVLANInUse while GetVLANUsage finds anything that uses it.
 	DeleteVLAN(id string) error
+	// ReplaceVLAN moves the users and groups that use a VLAN to another VLAN and deletes it in one
+	// operation. It returns what was moved, and the same errors as DeleteVLAN for anything that
+	// can't be moved, like policy rules.
+	ReplaceVLAN(id, replacementID string) (VLANUsage, error)
 	// GetDefaultVLAN returns the default VLAN.
 	GetDefaultVLAN() (VLAN, error)
+	// GetQuarantineVLAN returns the quarantine VLAN. It returns ErrQuarantineVLANNotFound if there's none.
+	GetQuarantineVLAN() (VLAN, error)
+
+	// GetGroups returns all the groups.
+	GetGroups() ([]Group, error)
+	// GetGroup returns a group by its name.
+	GetGroup(name string) (Group, error)
+	// CreateGroup creates a new group.
+	CreateGroup(g Group) error
+	// UpdateGroup updates a group.
+	UpdateGroup(g Group) error
+	// DeleteGroup deletes a group by its name. It returns ErrGroupInUse while users belong to it.
+	DeleteGroup(name string) error
 
 	// GetUsers returns all the users.
 	GetUsers() ([]User, error)
@@ -44,7 +315,7 @@ type Database interface {
 	GetUserByDescription(description string) (User, error)
 	// CreateUser creates a new user.
 	CreateUser(u User) error
-	// UpdateUser updates a user.
+	// UpdateUser updates a user. It returns ErrVLANNotFound or ErrGroupNotFound if its VLAN or group don't exist.
 	UpdateUser(u User) error
 	// DeleteUser deletes a user by its username.
 	DeleteUser(username string) error
@@ -58,8 +329,196 @@ type Database interface {
 	// UnblockUser unblocks a user by its username.
 	UnblockUser(username string) error
 
+	// GetPendingDevices returns all the pending devices, oldest first.
+	GetPendingDevices() ([]PendingDevice, error)
+	// GetPendingDevice returns a pending device by its ID.
+	GetPendingDevice(id string) (PendingDevice, error)
+	// RecordPendingDevice records an attempt from an unknown device. It creates the pending
+	// device if its username is new, or updates its last seen time and attempts otherwise.
+	// Pending devices are removed when a user with the same username is created or blocked,
+	// and the device seen the longest ago is dropped once there are too many of them.
+	RecordPendingDevice(d PendingDevice) (PendingDevice, error)
+	// DeletePendingDevice deletes a pending device by its ID.
+	DeletePendingDevice(id string) error
+	// TakePendingDevice deletes a pending device by its ID and returns it. Only one caller can
+	// take a device, the others get ErrPendingDeviceNotFound.
+	TakePendingDevice(id string) (PendingDevice, error)
+
+	// GetPolicy returns the rules of the access policy, in order.
+	GetPolicy() ([]PolicyRule, error)
+
 	// Init initializes the database.
 	Open(ctx context.Context) error
 	// Close closes the database.
 	Close(ctx context.Context) error
 }
+
+// UserVLANID returns the ID of the VLAN of a user: its own VLAN, or its group's VLAN if it has none.
+func UserVLANID(db Database, u User) (string, error) {
+	if u.VlanID != "" {
+		return u.VlanID, nil
+	}
+
+	if u.Group == "" {
+		return "", fmt.Errorf("error getting VLAN of user %s: %w", u.Username, ErrVLANNotFound)
+	}
+
+	group, err := db.GetGroup(u.Group)
+	if err != nil {
+		return "", fmt.Errorf("error getting group of user %s: %w", u.Username, err)
+	}
+
+	return group.VlanID, nil
+}
+
+// UserSchedules returns the schedule rules of a user followed by the rules of its group.
+func UserSchedules(db Database, u User) ([]Schedule, error) {
+	if u.Group == "" {
+		return u.Schedules, nil
+	}
+
+	group, err := db.GetGroup(u.Group)
+	if err != nil {
+		return nil, fmt.Errorf("error getting group of user %s: %w", u.Username, err)
+	}
+
+	rules := make([]Schedule, 0, len(u.Schedules)+len(group.Schedules))
+
+	return append(append(rules, u.Schedules...), group.Schedules...), nil
+}
+
+// UserNetworks returns the network VLANs of a user followed by the ones of its group.
+func UserNetworks(db Database, u User) ([]NetworkVLAN, error) {
+	if u.Group == "" {
+		return u.Networks, nil
+	}
+
+	group, err := db.GetGroup(u.Group)
+	if err != nil {
+		return nil, fmt.Errorf("error getting group of user %s: %w", u.Username, err)
+	}
+
+	networks := make([]NetworkVLAN, 0, len(u.Networks)+len(group.Networks))
+
+	return append(append(networks, u.Networks...), group.Networks...), nil
+}
+
+// NetworkDefaultVLAN returns the VLAN unknown devices on a network default to. When the networks of
+// several VLANs match, the most specific network wins, and then the first VLAN in ID order.
+// It returns ErrVLANNotFound if there's none.
+func NetworkDefaultVLAN(db Database, ssid, nasIdentifier, nasAddress string) (VLAN, error) {
+	vlans, err := db.GetVLANs()
+	if err != nil {
+		return VLAN{}, fmt.Errorf("error getting VLANs: %w", err)
+	}
+
+	var (
+		best        *VLAN
+		specificity int
+	)
+
+	for i, vlan := range vlans {
+		for _, n := range vlan.DefaultFor {
+			if n.Matches(ssid, nasIdentifier, nasAddress) && (best == nil || n.Specificity() > specificity) {
+				best, specificity = &vlans[i], n.Specificity()
+			}
+		}
+	}
+
+	if best == nil {
+		return VLAN{}, fmt.Errorf("error getting default VLAN of the network: %w", ErrVLANNotFound)
+	}
+
+	return *best, nil
+}
+
+// VLANUsage is everything that uses a VLAN and keeps it from being deleted.
+type VLANUsage struct {
+	// Users are the users assigned to the VLAN directly, temporarily, or through their schedules or networks.
+	Users []User
+	// Groups are the groups assigned to the VLAN directly or through their schedules or networks.
+	Groups []Group
+	// Policy are the policy rules that move requests to the VLAN.
+	Policy []PolicyRule
+}
+
+// InUse reports whether anything uses the VLAN.
+func (u VLANUsage) InUse() bool {
+	return len(u.Users) > 0 || len(u.Groups) > 0 || len(u.Policy) > 0
+}
+
+// GetVLANUsage returns the users, groups, and policy rules that use a VLAN.
+func GetVLANUsage(db Database, id string) (VLANUsage, error) {
+	var usage VLANUsage
+
+	users, err := db.GetUsers()
+	if err != nil {
+		return VLANUsage{}, fmt.Errorf("error getting users: %w", err)
+	}
+
+	for _, u := range users {
+		if u.UsesVLAN(id) {
+			usage.Users = append(usage.Users, u)
+		}
+	}
+
+	groups, err := db.GetGroups()
+	if err != nil {
+		return VLANUsage{}, fmt.Errorf("error getting groups: %w", err)
+	}
+
+	for _, g := range groups {
+		if g.UsesVLAN(id) {
+			usage.Groups = append(usage.Groups, g)
+		}
+	}
+
+	policy, err := db.GetPolicy()
+	if err != nil {
+		return VLANUsage{}, fmt.Errorf("error getting policy: %w", err)
+	}
+
+	for _, r := range policy {
+		if r.VlanID == id {
+			usage.Policy = append(usage.Policy, r)
+		}
+	}
+
+	return usage, nil
+}
+
+// schedulesUseVLAN reports whether any of the schedule rules moves devices to a VLAN.
+func schedulesUseVLAN(rules []Schedule, id string) bool {
+	return slices.ContainsFunc(rules, func(s Schedule) bool { return s.VlanID == id })
+}
+
+// networksUseVLAN reports whether any of the network VLANs is a VLAN.
+func networksUseVLAN(networks []NetworkVLAN, id string) bool {
+	return slices.ContainsFunc(networks, func(n NetworkVLAN) bool { return n.VlanID == id })
+}
+
+// moveSchedules returns a copy of the schedule rules with the ones that use a VLAN moved to another one.
+func moveSchedules(rules []Schedule, from, to string) []Schedule {
+	rules = slices.Clone(rules)
+
+	for i := range rules {
+		if rules[i].VlanID == from {
+			rules[i].VlanID = to
+		}
+	}
+
+	return rules
+}
+
+// moveNetworks returns a copy of the network VLANs with the ones that use a VLAN moved to another one.
+func moveNetworks(networks []NetworkVLAN, from, to string) []NetworkVLAN {
+	networks = slices.Clone(networks)
+
+	for i := range networks {
+		if networks[i].VlanID == from {
+			networks[i].VlanID = to
+		}
+	}
+
+	return networks
+}
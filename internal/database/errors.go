@@ -15,8 +15,30 @@ var (
 	ErrDefaultVLANNotFound = errors.New("default vlan not found")
 	// ErrDefaultVLANAlreadyExists is returned when the default VLAN already exists.
 	ErrDefaultVLANAlreadyExists = errors.New("default vlan already exists")
+	// ErrQuarantineVLANNotFound is returned when there's no quarantine VLAN.
+	ErrQuarantineVLANNotFound = errors.New("quarantine vlan not found")
+	// ErrQuarantineVLANAlreadyExists is returned when the quarantine VLAN already exists.
+	ErrQuarantineVLANAlreadyExists = errors.New("quarantine vlan already exists")
+	// ErrNetworkDefaultVLANAlreadyExists is returned when another VLAN is already the default of a network.
+	ErrNetworkDefaultVLANAlreadyExists = errors.New("network default vlan already exists")
+	// ErrInvalidNetwork is returned when a network has no SSID nor NAS.
+	ErrInvalidNetwork = errors.New("network must have an ssid or a nas")
+	// ErrVLANInUse is returned when deleting a VLAN that users are still assigned to.
+	ErrVLANInUse = errors.New("vlan is in use")
+	// ErrDefaultVLANInUse is returned when deleting the default VLAN.
+	ErrDefaultVLANInUse = errors.New("vlan is the default vlan")
 	// ErrBlockedUserNotFound is returned when a blocked user is not found.
 	ErrBlockedUserNotFound = errors.New("blocked user not found")
 	// ErrUserAlreadyBlocked is returned when a user is already blocked.
 	ErrUserAlreadyBlocked = errors.New("user already blocked")
+	// ErrGroupNotFound is returned when a group is not found.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupAlreadyExists is returned when a group already exists.
+	ErrGroupAlreadyExists = errors.New("group already exists")
+	// ErrGroupInUse is returned when deleting a group that users still belong to.
+	ErrGroupInUse = errors.New("group is in use")
+	// ErrPolicyRuleAlreadyExists is returned when two policy rules have the same name.
+	ErrPolicyRuleAlreadyExists = errors.New("policy rule already exists")
+	// ErrPendingDeviceNotFound is returned when a pending device is not found.
+	ErrPendingDeviceNotFound = errors.New("pending device not found")
 )
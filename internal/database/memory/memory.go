@@ -3,12 +3,18 @@ package memorydatabase
 import (
 	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/dictionary"
+	"github.com/maronato/authifi/internal/policy"
+	"github.com/maronato/authifi/internal/schedule"
 )
 
 // MemoryDatabase implements the Database interface using an in-memory map.
@@ -21,15 +27,33 @@ type MemoryDatabase struct {
 	blockedUsers map[string]*database.BlockedUser
 	// defaultVLAN is the default VLAN.
 	defaultVLAN *database.VLAN
+	// groups is a map of group names to groups.
+	groups map[string]*database.Group
+	// pendingDevices is a map of IDs to pending devices.
+	pendingDevices map[string]*database.PendingDevice
+	// pendingMu guards pendingDevices, which is written to by concurrent RADIUS requests.
+	pendingMu sync.Mutex
+	// policy are the rules of the access policy, in order.
+	policy []database.PolicyRule
 }
 
+const (
+	// pendingIDLength is the length in bytes of the random pending device IDs.
+	pendingIDLength = 8
+	// MaxPendingDevices is the number of pending devices kept. The device seen the longest ago
+	// is dropped to make room for a new one.
+	MaxPendingDevices = 100
+)
+
 // NewMemoryDatabase creates a new MemoryDatabase.
 func NewMemoryDatabase() *MemoryDatabase {
 	return &MemoryDatabase{
-		users:        make(map[string]*database.User),
-		vlans:        make(map[string]*database.VLAN),
-		blockedUsers: make(map[string]*database.BlockedUser),
-		defaultVLAN:  nil,
+		users:          make(map[string]*database.User),
+		vlans:          make(map[string]*database.VLAN),
+		blockedUsers:   make(map[string]*database.BlockedUser),
+		defaultVLAN:    nil,
+		groups:         make(map[string]*database.Group),
+		pendingDevices: make(map[string]*database.PendingDevice),
 	}
 }
 
@@ -40,13 +64,21 @@ func (d *MemoryDatabase) GetVLANs() ([]database.VLAN, error) {
 		vlans = append(vlans, *vlan)
 	}
 
-	// Sort VLANs by their ID
+	// Sort VLANs by their ID, numeric IDs first so "9" comes before "10"
 	slices.SortFunc(vlans, func(a, b database.VLAN) int {
-		// Convert IDs to integers for comparison
-		idA, _ := strconv.Atoi(a.ID)
-		idB, _ := strconv.Atoi(b.ID)
-
-		return cmp.Compare(idA, idB)
+		idA, errA := strconv.Atoi(a.ID)
+		idB, errB := strconv.Atoi(b.ID)
+
+		switch {
+		case errA == nil && errB == nil:
+			return cmp.Or(cmp.Compare(idA, idB), cmp.Compare(a.ID, b.ID))
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			return cmp.Compare(a.ID, b.ID)
+		}
 	})
 
 	return vlans, nil
@@ -68,6 +100,19 @@ func (d *MemoryDatabase) CreateVLAN(v database.VLAN) error {
 		return fmt.Errorf("error creating VLAN %s: %w", v.ID, database.ErrVLANAlreadyExists)
 	}
 
+	// There can only be one quarantine VLAN
+	if q, err := d.GetQuarantineVLAN(); v.Quarantine && err == nil {
+		return fmt.Errorf("error creating VLAN %s, %s is the quarantine VLAN: %w", v.ID, q.ID, database.ErrQuarantineVLANAlreadyExists)
+	}
+
+	if err := d.validateDefaultFor(v); err != nil {
+		return fmt.Errorf("error creating VLAN %s: %w", v.ID, err)
+	}
+
+	if err := validateAttributes(v.Attributes); err != nil {
+		return fmt.Errorf("error creating VLAN %s: %w", v.ID, err)
+	}
+
 	// If the VLAN is the default VLAN, set it
 	if v.Default {
 		if d.defaultVLAN != nil {
@@ -88,22 +133,320 @@ func (d *MemoryDatabase) UpdateVLAN(v database.VLAN) error {
 		return fmt.Errorf("error updating VLAN %s: %w", v.ID, database.ErrVLANNotFound)
 	}
 
+	// There can only be one default VLAN
+	if v.Default && d.defaultVLAN != nil && d.defaultVLAN.ID != v.ID {
+		return fmt.Errorf("error updating VLAN %s: %w", v.ID, database.ErrDefaultVLANAlreadyExists)
+	}
+
+	if q, err := d.GetQuarantineVLAN(); v.Quarantine && err == nil && q.ID != v.ID {
+		return fmt.Errorf("error updating VLAN %s, %s is the quarantine VLAN: %w", v.ID, q.ID, database.ErrQuarantineVLANAlreadyExists)
+	}
+
+	if err := d.validateDefaultFor(v); err != nil {
+		return fmt.Errorf("error updating VLAN %s: %w", v.ID, err)
+	}
+
+	if err := validateAttributes(v.Attributes); err != nil {
+		return fmt.Errorf("error updating VLAN %s: %w", v.ID, err)
+	}
+
+	// Keep the default VLAN pointing to the current copy
+	switch {
+	case v.Default:
+		d.defaultVLAN = &v
+	case d.defaultVLAN != nil && d.defaultVLAN.ID == v.ID:
+		d.defaultVLAN = nil
+	}
+
 	d.vlans[v.ID] = &v
 
 	return nil
 }
 
-// DeleteVLAN deletes a VLAN by its ID.
+// DeleteVLAN deletes a VLAN by its ID. VLANs that are the default or that users, groups,
+// or policy rules use can't be deleted.
 func (d *MemoryDatabase) DeleteVLAN(id string) error {
 	if _, ok := d.vlans[id]; !ok {
 		return fmt.Errorf("error deleting VLAN %s: %w", id, database.ErrVLANNotFound)
 	}
 
+	if d.defaultVLAN != nil && d.defaultVLAN.ID == id {
+		return fmt.Errorf("error deleting VLAN %s: %w", id, database.ErrDefaultVLANInUse)
+	}
+
+	usage, err := database.GetVLANUsage(d, id)
+	if err != nil {
+		return fmt.Errorf("error deleting VLAN %s: %w", id, err)
+	}
+
+	if usage.InUse() {
+		return fmt.Errorf("error deleting VLAN %s used by %d users, %d groups, and %d policy rules: %w", id, len(usage.Users), len(usage.Groups), len(usage.Policy), database.ErrVLANInUse)
+	}
+
 	delete(d.vlans, id)
 
 	return nil
 }
 
+// ReplaceVLAN moves the users and groups that use a VLAN to another VLAN and deletes it. Nothing
+// is changed if any of them can't be moved.
+func (d *MemoryDatabase) ReplaceVLAN(id, replacementID string) (database.VLANUsage, error) {
+	if _, ok := d.vlans[id]; !ok {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN %s: %w", id, database.ErrVLANNotFound)
+	}
+
+	if _, ok := d.vlans[replacementID]; !ok {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN %s with %s: %w", id, replacementID, database.ErrVLANNotFound)
+	}
+
+	if id == replacementID {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN %s with itself: %w", id, database.ErrVLANInUse)
+	}
+
+	if d.defaultVLAN != nil && d.defaultVLAN.ID == id {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN %s: %w", id, database.ErrDefaultVLANInUse)
+	}
+
+	usage, err := database.GetVLANUsage(d, id)
+	if err != nil {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN %s: %w", id, err)
+	}
+
+	// Policy rules are only changed in the database file
+	if len(usage.Policy) > 0 {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN %s used by %d policy rules: %w", id, len(usage.Policy), database.ErrVLANInUse)
+	}
+
+	// Validate every move before changing anything
+	users := make([]database.User, 0, len(usage.Users))
+
+	for _, u := range usage.Users {
+		u = u.MoveVLAN(id, replacementID)
+		if err := d.validateUser(u); err != nil {
+			return database.VLANUsage{}, fmt.Errorf("error moving user %s: %w", u.Username, err)
+		}
+
+		users = append(users, u)
+	}
+
+	groups := make([]database.Group, 0, len(usage.Groups))
+
+	for _, g := range usage.Groups {
+		g = g.MoveVLAN(id, replacementID)
+		if err := d.validateGroup(g); err != nil {
+			return database.VLANUsage{}, fmt.Errorf("error moving group %s: %w", g.Name, err)
+		}
+
+		groups = append(groups, g)
+	}
+
+	for _, u := range users {
+		d.users[u.Username] = &u
+	}
+
+	for _, g := range groups {
+		d.groups[g.Name] = &g
+	}
+
+	delete(d.vlans, id)
+
+	return usage, nil
+}
+
+// validateDefaultFor checks that the networks a VLAN is the default of are valid and that no other
+// VLAN is the default of the same network.
+func (d *MemoryDatabase) validateDefaultFor(v database.VLAN) error {
+	for _, n := range v.DefaultFor {
+		if err := n.Validate(); err != nil {
+			return err
+		}
+
+		for _, other := range d.vlans {
+			if other.ID != v.ID && slices.Contains(other.DefaultFor, n) {
+				return fmt.Errorf("%s is the default VLAN of %s: %w", other.ID, n, database.ErrNetworkDefaultVLANAlreadyExists)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateNetworks checks that the network VLANs are valid and that their VLANs exist.
+func (d *MemoryDatabase) validateNetworks(networks []database.NetworkVLAN) error {
+	for _, n := range networks {
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("error validating network: %w", err)
+		}
+
+		if _, err := d.GetVLAN(n.VlanID); err != nil {
+			return fmt.Errorf("error validating network %s: %w", n.Network, err)
+		}
+	}
+
+	return nil
+}
+
+// validateSchedules checks that the schedule rules are valid and that their VLANs exist.
+func (d *MemoryDatabase) validateSchedules(rules []database.Schedule) error {
+	for _, s := range rules {
+		if err := schedule.Validate(s); err != nil {
+			return fmt.Errorf("error validating schedule: %w", err)
+		}
+
+		if s.VlanID != "" {
+			if _, err := d.GetVLAN(s.VlanID); err != nil {
+				return fmt.Errorf("error validating schedule: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetGroups returns all the groups.
+func (d *MemoryDatabase) GetGroups() ([]database.Group, error) {
+	groups := make([]database.Group, 0, len(d.groups))
+	for _, group := range d.groups {
+		groups = append(groups, *group)
+	}
+
+	// Sort groups by their name
+	slices.SortFunc(groups, func(a, b database.Group) int {
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	return groups, nil
+}
+
+// GetGroup returns a group by its name.
+func (d *MemoryDatabase) GetGroup(name string) (database.Group, error) {
+	group, ok := d.groups[name]
+	if !ok {
+		return database.Group{}, fmt.Errorf("error getting group %s: %w", name, database.ErrGroupNotFound)
+	}
+
+	return *group, nil
+}
+
+// CreateGroup creates a new group.
+func (d *MemoryDatabase) CreateGroup(g database.Group) error {
+	if _, ok := d.groups[g.Name]; ok {
+		return fmt.Errorf("error creating group %s: %w", g.Name, database.ErrGroupAlreadyExists)
+	}
+
+	if err := d.validateGroup(g); err != nil {
+		return fmt.Errorf("error creating group %s: %w", g.Name, err)
+	}
+
+	d.groups[g.Name] = &g
+
+	return nil
+}
+
+// UpdateGroup updates a group.
+func (d *MemoryDatabase) UpdateGroup(g database.Group) error {
+	if _, ok := d.groups[g.Name]; !ok {
+		return fmt.Errorf("error updating group %s: %w", g.Name, database.ErrGroupNotFound)
+	}
+
+	if err := d.validateGroup(g); err != nil {
+		return fmt.Errorf("error updating group %s: %w", g.Name, err)
+	}
+
+	d.groups[g.Name] = &g
+
+	return nil
+}
+
+// validateGroup checks that the VLAN of a group exists and that its schedules, networks, and
+// attributes are valid.
+func (d *MemoryDatabase) validateGroup(g database.Group) error {
+	if _, err := d.GetVLAN(g.VlanID); err != nil {
+		return err
+	}
+
+	if err := d.validateSchedules(g.Schedules); err != nil {
+		return err
+	}
+
+	if err := d.validateNetworks(g.Networks); err != nil {
+		return err
+	}
+
+	return validateAttributes(g.Attributes)
+}
+
+// DeleteGroup deletes a group by its name. Groups that users belong to can't be deleted.
+func (d *MemoryDatabase) DeleteGroup(name string) error {
+	if _, ok := d.groups[name]; !ok {
+		return fmt.Errorf("error deleting group %s: %w", name, database.ErrGroupNotFound)
+	}
+
+	count := 0
+
+	for _, user := range d.users {
+		if user.Group == name {
+			count++
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("error deleting group %s with %d users: %w", name, count, database.ErrGroupInUse)
+	}
+
+	if i := slices.IndexFunc(d.policy, func(r database.PolicyRule) bool { return r.Match.Group == name }); i >= 0 {
+		return fmt.Errorf("error deleting group %s used by policy rule %s: %w", name, d.policy[i].Name, database.ErrGroupInUse)
+	}
+
+	delete(d.groups, name)
+
+	return nil
+}
+
+// validateUser checks that the VLANs and group of a user exist and that its schedules, networks, and
+// attributes are valid.
+// Users must have a VLAN, a group, or both.
+func (d *MemoryDatabase) validateUser(u database.User) error {
+	if u.Group != "" {
+		if _, err := d.GetGroup(u.Group); err != nil {
+			return err
+		}
+	}
+
+	if u.VlanID != "" || u.Group == "" {
+		if _, err := d.GetVLAN(u.VlanID); err != nil {
+			return err
+		}
+	}
+
+	if u.TempVLAN != nil {
+		if _, err := d.GetVLAN(u.TempVLAN.VlanID); err != nil {
+			return fmt.Errorf("error validating temporary VLAN: %w", err)
+		}
+	}
+
+	if err := d.validateNetworks(u.Networks); err != nil {
+		return err
+	}
+
+	if err := validateAttributes(u.Attributes); err != nil {
+		return err
+	}
+
+	return d.validateSchedules(u.Schedules)
+}
+
+// validateAttributes checks that reply attributes are in the dictionary and that their values are valid.
+func validateAttributes(attributes []database.Attribute) error {
+	for _, a := range attributes {
+		if err := dictionary.Validate(a); err != nil {
+			return fmt.Errorf("error validating attribute: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // GetDefaultVLAN returns the default VLAN.
 func (d *MemoryDatabase) GetDefaultVLAN() (database.VLAN, error) {
 	if d.defaultVLAN == nil {
@@ -113,6 +456,17 @@ func (d *MemoryDatabase) GetDefaultVLAN() (database.VLAN, error) {
 	return *d.defaultVLAN, nil
 }
 
+// GetQuarantineVLAN returns the quarantine VLAN.
+func (d *MemoryDatabase) GetQuarantineVLAN() (database.VLAN, error) {
+	for _, vlan := range d.vlans {
+		if vlan.Quarantine {
+			return *vlan, nil
+		}
+	}
+
+	return database.VLAN{}, fmt.Errorf("error getting quarantine VLAN: %w", database.ErrQuarantineVLANNotFound)
+}
+
 // GetUsers returns all the users.
 func (d *MemoryDatabase) GetUsers() ([]database.User, error) {
 	users := make([]database.User, 0, len(d.users))
@@ -155,13 +509,16 @@ func (d *MemoryDatabase) CreateUser(u database.User) error {
 		return fmt.Errorf("error creating user %s: %w", u.Username, database.ErrUserAlreadyExists)
 	}
 
-	// Validate the VLAN
-	if _, err := d.GetVLAN(u.VlanID); err != nil {
-		return fmt.Errorf("error creating user: %w", err)
+	// Validate the VLAN and group
+	if err := d.validateUser(u); err != nil {
+		return fmt.Errorf("error creating user %s: %w", u.Username, err)
 	}
 
 	d.users[u.Username] = &u
 
+	// The device is no longer pending
+	d.deletePendingByUsername(u.Username)
+
 	return nil
 }
 
@@ -171,6 +528,11 @@ func (d *MemoryDatabase) UpdateUser(u database.User) error {
 		return fmt.Errorf("error updating user %s: %w", u.Username, database.ErrUserNotFound)
 	}
 
+	// Validate the VLAN and group
+	if err := d.validateUser(u); err != nil {
+		return fmt.Errorf("error updating user %s: %w", u.Username, err)
+	}
+
 	d.users[u.Username] = &u
 
 	return nil
@@ -213,6 +575,9 @@ func (d *MemoryDatabase) BlockUser(username string) error {
 
 	d.blockedUsers[username] = &database.BlockedUser{Username: username}
 
+	// The device is no longer pending
+	d.deletePendingByUsername(username)
+
 	return nil
 }
 
@@ -255,6 +620,144 @@ func (d *MemoryDatabase) IsUserBlocked(username string) (bool, error) {
 	return ok, nil
 }
 
+// GetPendingDevices returns all the pending devices, oldest first.
+func (d *MemoryDatabase) GetPendingDevices() ([]database.PendingDevice, error) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	devices := make([]database.PendingDevice, 0, len(d.pendingDevices))
+	for _, device := range d.pendingDevices {
+		devices = append(devices, *device)
+	}
+
+	// Sort pending devices by when they were first seen
+	slices.SortFunc(devices, func(a, b database.PendingDevice) int {
+		return a.FirstSeen.Compare(b.FirstSeen)
+	})
+
+	return devices, nil
+}
+
+// GetPendingDevice returns a pending device by its ID.
+func (d *MemoryDatabase) GetPendingDevice(id string) (database.PendingDevice, error) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	device, ok := d.pendingDevices[id]
+	if !ok {
+		return database.PendingDevice{}, fmt.Errorf("error getting pending device %s: %w", id, database.ErrPendingDeviceNotFound)
+	}
+
+	return *device, nil
+}
+
+// RecordPendingDevice records an attempt from an unknown device.
+func (d *MemoryDatabase) RecordPendingDevice(p database.PendingDevice) (database.PendingDevice, error) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	for _, device := range d.pendingDevices {
+		if device.Username == p.Username {
+			device.Password = p.Password
+			device.MACAddress = p.MACAddress
+			device.NASAddress = p.NASAddress
+			device.LastSeen = p.LastSeen
+			device.Attempts++
+
+			return *device, nil
+		}
+	}
+
+	if p.ID == "" {
+		id, err := newPendingID()
+		if err != nil {
+			return database.PendingDevice{}, fmt.Errorf("error recording pending device %s: %w", p.Username, err)
+		}
+
+		p.ID = id
+	}
+
+	if p.FirstSeen.IsZero() {
+		p.FirstSeen = p.LastSeen
+	}
+
+	p.Attempts = max(p.Attempts, 1)
+
+	if len(d.pendingDevices) >= MaxPendingDevices {
+		d.evictPending()
+	}
+
+	d.pendingDevices[p.ID] = &p
+
+	return p, nil
+}
+
+// DeletePendingDevice deletes a pending device by its ID.
+func (d *MemoryDatabase) DeletePendingDevice(id string) error {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	if _, ok := d.pendingDevices[id]; !ok {
+		return fmt.Errorf("error deleting pending device %s: %w", id, database.ErrPendingDeviceNotFound)
+	}
+
+	delete(d.pendingDevices, id)
+
+	return nil
+}
+
+// TakePendingDevice deletes a pending device by its ID and returns it.
+func (d *MemoryDatabase) TakePendingDevice(id string) (database.PendingDevice, error) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	device, ok := d.pendingDevices[id]
+	if !ok {
+		return database.PendingDevice{}, fmt.Errorf("error taking pending device %s: %w", id, database.ErrPendingDeviceNotFound)
+	}
+
+	delete(d.pendingDevices, id)
+
+	return *device, nil
+}
+
+// evictPending deletes the pending device that was seen the longest ago.
+func (d *MemoryDatabase) evictPending() {
+	var oldest *database.PendingDevice
+
+	for _, device := range d.pendingDevices {
+		if oldest == nil || device.LastSeen.Before(oldest.LastSeen) {
+			oldest = device
+		}
+	}
+
+	if oldest != nil {
+		delete(d.pendingDevices, oldest.ID)
+	}
+}
+
+// deletePendingByUsername deletes the pending device with the given username, if any.
+func (d *MemoryDatabase) deletePendingByUsername(username string) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	for id, device := range d.pendingDevices {
+		if device.Username == username {
+			delete(d.pendingDevices, id)
+		}
+	}
+}
+
+// newPendingID creates a random ID for a pending device. It's short enough to fit in Telegram callbacks.
+func newPendingID() (string, error) {
+	b := make([]byte, pendingIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating ID: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 // Open initializes the database.
 func (d *MemoryDatabase) Open(_ context.Context) error {
 	return nil
@@ -264,3 +767,42 @@ func (d *MemoryDatabase) Open(_ context.Context) error {
 func (d *MemoryDatabase) Close(_ context.Context) error {
 	return nil
 }
+
+// GetPolicy returns the rules of the access policy, in order.
+func (d *MemoryDatabase) GetPolicy() ([]database.PolicyRule, error) {
+	return slices.Clone(d.policy), nil
+}
+
+// SetPolicy replaces the rules of the access policy after checking that they are valid and that
+// their VLANs and groups exist.
+func (d *MemoryDatabase) SetPolicy(rules []database.PolicyRule) error {
+	names := make(map[string]bool, len(rules))
+
+	for _, r := range rules {
+		if err := policy.Validate(r); err != nil {
+			return fmt.Errorf("error validating policy rule %s: %w", r.Name, err)
+		}
+
+		if names[r.Name] {
+			return fmt.Errorf("error validating policy rule %s: %w", r.Name, database.ErrPolicyRuleAlreadyExists)
+		}
+
+		names[r.Name] = true
+
+		if r.VlanID != "" {
+			if _, err := d.GetVLAN(r.VlanID); err != nil {
+				return fmt.Errorf("error validating policy rule %s: %w", r.Name, err)
+			}
+		}
+
+		if r.Match.Group != "" {
+			if _, err := d.GetGroup(r.Match.Group); err != nil {
+				return fmt.Errorf("error validating policy rule %s: %w", r.Name, err)
+			}
+		}
+	}
+
+	d.policy = slices.Clone(rules)
+
+	return nil
+}
@@ -0,0 +1,587 @@
+package memorydatabase_test
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	memorydatabase "github.com/maronato/authifi/internal/database/memory"
+	"github.com/maronato/authifi/internal/dictionary"
+)
+
+// newDatabase creates a database with a default VLAN, another VLAN, and a user in each.
+func newDatabase(t *testing.T) *memorydatabase.MemoryDatabase {
+	t.Helper()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Home", Default: true}, {ID: "20", Name: "IoT"}, {ID: "30", Name: "Empty"}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	for _, u := range []database.User{{Username: "phone", VlanID: "10"}, {Username: "camera", VlanID: "20"}} {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("error creating user: %v", err)
+		}
+	}
+
+	return db
+}
+
+func TestDeleteVLAN(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if err := db.DeleteVLAN("10"); !errors.Is(err, database.ErrDefaultVLANInUse) {
+		t.Errorf("got %v deleting the default VLAN, want %v", err, database.ErrDefaultVLANInUse)
+	}
+
+	if err := db.DeleteVLAN("20"); !errors.Is(err, database.ErrVLANInUse) {
+		t.Errorf("got %v deleting a VLAN in use, want %v", err, database.ErrVLANInUse)
+	}
+
+	if err := db.DeleteVLAN("30"); err != nil {
+		t.Errorf("error deleting an unused VLAN: %v", err)
+	}
+
+	// VLANs can be deleted once their users are moved
+	if err := db.UpdateUser(database.User{Username: "camera", VlanID: "10"}); err != nil {
+		t.Fatalf("error moving user: %v", err)
+	}
+
+	if err := db.DeleteVLAN("20"); err != nil {
+		t.Errorf("error deleting a VLAN after moving its users: %v", err)
+	}
+}
+
+func TestUpdateVLANDefault(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if err := db.UpdateVLAN(database.VLAN{ID: "20", Name: "IoT", Default: true}); !errors.Is(err, database.ErrDefaultVLANAlreadyExists) {
+		t.Errorf("got %v setting a second default VLAN, want %v", err, database.ErrDefaultVLANAlreadyExists)
+	}
+
+	// Renaming the default VLAN keeps it the default
+	if err := db.UpdateVLAN(database.VLAN{ID: "10", Name: "Main", Default: true}); err != nil {
+		t.Fatalf("error updating VLAN: %v", err)
+	}
+
+	if vlan, err := db.GetDefaultVLAN(); err != nil || vlan.Name != "Main" {
+		t.Errorf("got default VLAN %+v (%v), want the renamed VLAN", vlan, err)
+	}
+
+	// Unsetting the default clears it, so another VLAN can be the default
+	if err := db.UpdateVLAN(database.VLAN{ID: "10", Name: "Main"}); err != nil {
+		t.Fatalf("error updating VLAN: %v", err)
+	}
+
+	if _, err := db.GetDefaultVLAN(); !errors.Is(err, database.ErrDefaultVLANNotFound) {
+		t.Errorf("got %v after unsetting the default, want %v", err, database.ErrDefaultVLANNotFound)
+	}
+
+	if err := db.UpdateVLAN(database.VLAN{ID: "20", Name: "IoT", Default: true}); err != nil {
+		t.Fatalf("error setting the new default: %v", err)
+	}
+
+	if vlan, err := db.GetDefaultVLAN(); err != nil || vlan.ID != "20" {
+		t.Errorf("got default VLAN %+v (%v), want 20", vlan, err)
+	}
+}
+
+func TestUpdateUserValidatesVLAN(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if err := db.UpdateUser(database.User{Username: "phone", VlanID: "99"}); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v, want %v", err, database.ErrVLANNotFound)
+	}
+
+	if user, _ := db.GetUser("phone"); user.VlanID != "10" {
+		t.Errorf("got VLAN %s after a failed update, want 10", user.VlanID)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if err := db.CreateGroup(database.Group{Name: "cameras", VlanID: "99"}); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v creating a group on a missing VLAN, want %v", err, database.ErrVLANNotFound)
+	}
+
+	if err := db.CreateGroup(database.Group{Name: "cameras", VlanID: "30"}); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	// Users in a group inherit its VLAN unless they have their own
+	if err := db.CreateUser(database.User{Username: "doorbell", Group: "cameras"}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	if err := db.UpdateUser(database.User{Username: "camera", VlanID: "20", Group: "cameras"}); err != nil {
+		t.Fatalf("error updating user: %v", err)
+	}
+
+	for username, want := range map[string]string{"doorbell": "30", "camera": "20"} {
+		user, _ := db.GetUser(username)
+		if got, err := database.UserVLANID(db, user); err != nil || got != want {
+			t.Errorf("got VLAN %q (%v) for %s, want %s", got, err, username, want)
+		}
+	}
+
+	// Moving the group moves the users that inherit its VLAN
+	if err := db.UpdateGroup(database.Group{Name: "cameras", VlanID: "10"}); err != nil {
+		t.Fatalf("error updating group: %v", err)
+	}
+
+	if user, _ := db.GetUser("doorbell"); user.VlanID != "" {
+		t.Errorf("got VLAN %q, want the user to keep inheriting", user.VlanID)
+	} else if got, _ := database.UserVLANID(db, user); got != "10" {
+		t.Errorf("got VLAN %q after moving the group, want 10", got)
+	}
+
+	// Groups and VLANs in use can't be deleted
+	if err := db.DeleteGroup("cameras"); !errors.Is(err, database.ErrGroupInUse) {
+		t.Errorf("got %v deleting a group in use, want %v", err, database.ErrGroupInUse)
+	}
+
+	if err := db.UpdateGroup(database.Group{Name: "cameras", VlanID: "30"}); err != nil {
+		t.Fatalf("error updating group: %v", err)
+	}
+
+	if err := db.DeleteVLAN("30"); !errors.Is(err, database.ErrVLANInUse) {
+		t.Errorf("got %v deleting a VLAN used by a group, want %v", err, database.ErrVLANInUse)
+	}
+
+	// Users need a VLAN, a group, or both
+	if err := db.CreateUser(database.User{Username: "nothing"}); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v creating a user without VLAN or group, want %v", err, database.ErrVLANNotFound)
+	}
+
+	if err := db.CreateUser(database.User{Username: "lost", Group: "missing"}); !errors.Is(err, database.ErrGroupNotFound) {
+		t.Errorf("got %v creating a user in a missing group, want %v", err, database.ErrGroupNotFound)
+	}
+}
+
+func TestSchedulesAreValidated(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	bad := database.User{Username: "phone", VlanID: "10", Schedules: []database.Schedule{{From: "25:00", To: "07:00", Action: database.ScheduleActionReject}}}
+	if err := db.UpdateUser(bad); err == nil {
+		t.Error("got no error saving an invalid schedule")
+	}
+
+	missing := database.User{Username: "phone", VlanID: "10", Schedules: []database.Schedule{{From: "21:00", To: "07:00", Action: database.ScheduleActionVLAN, VlanID: "99"}}}
+	if err := db.UpdateUser(missing); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v saving a schedule with a missing VLAN, want %v", err, database.ErrVLANNotFound)
+	}
+
+	// VLANs used by schedules can't be deleted
+	valid := database.User{Username: "phone", VlanID: "10", Schedules: []database.Schedule{{From: "21:00", To: "07:00", Action: database.ScheduleActionVLAN, VlanID: "30"}}}
+	if err := db.UpdateUser(valid); err != nil {
+		t.Fatalf("error saving schedule: %v", err)
+	}
+
+	if err := db.DeleteVLAN("30"); !errors.Is(err, database.ErrVLANInUse) {
+		t.Errorf("got %v deleting a VLAN used by a schedule, want %v", err, database.ErrVLANInUse)
+	}
+}
+
+func TestTempVLANIsValidated(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	missing := database.User{Username: "phone", VlanID: "10", TempVLAN: &database.VLANOverride{VlanID: "99", ExpiresAt: expiresAt}}
+	if err := db.UpdateUser(missing); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v saving a temporary VLAN that doesn't exist, want %v", err, database.ErrVLANNotFound)
+	}
+
+	// Temporary VLANs can't be deleted until they are reverted
+	valid := database.User{Username: "phone", VlanID: "10", TempVLAN: &database.VLANOverride{VlanID: "30", ExpiresAt: expiresAt}}
+	if err := db.UpdateUser(valid); err != nil {
+		t.Fatalf("error saving temporary VLAN: %v", err)
+	}
+
+	if err := db.DeleteVLAN("30"); !errors.Is(err, database.ErrVLANInUse) {
+		t.Errorf("got %v deleting a temporary VLAN, want %v", err, database.ErrVLANInUse)
+	}
+}
+
+func TestQuarantineVLAN(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if _, err := db.GetQuarantineVLAN(); !errors.Is(err, database.ErrQuarantineVLANNotFound) {
+		t.Errorf("got %v without a quarantine VLAN, want %v", err, database.ErrQuarantineVLANNotFound)
+	}
+
+	if err := db.CreateVLAN(database.VLAN{ID: "99", Name: "Quarantine", Quarantine: true}); err != nil {
+		t.Fatalf("error creating quarantine VLAN: %v", err)
+	}
+
+	if got, err := db.GetQuarantineVLAN(); err != nil || got.ID != "99" {
+		t.Errorf("got %+v, %v, want VLAN 99", got, err)
+	}
+
+	// There can only be one quarantine VLAN
+	if err := db.UpdateVLAN(database.VLAN{ID: "30", Name: "Empty", Quarantine: true}); !errors.Is(err, database.ErrQuarantineVLANAlreadyExists) {
+		t.Errorf("got %v making a second quarantine VLAN, want %v", err, database.ErrQuarantineVLANAlreadyExists)
+	}
+
+	if err := db.CreateVLAN(database.VLAN{ID: "98", Name: "Other", Quarantine: true}); !errors.Is(err, database.ErrQuarantineVLANAlreadyExists) {
+		t.Errorf("got %v creating a second quarantine VLAN, want %v", err, database.ErrQuarantineVLANAlreadyExists)
+	}
+}
+
+func TestNetworksAreValidated(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+	iot := database.Network{SSID: "IoT"}
+
+	if err := db.UpdateVLAN(database.VLAN{ID: "20", Name: "IoT", DefaultFor: []database.Network{{}}}); !errors.Is(err, database.ErrInvalidNetwork) {
+		t.Errorf("got %v for a network without an SSID nor a NAS, want %v", err, database.ErrInvalidNetwork)
+	}
+
+	if err := db.UpdateVLAN(database.VLAN{ID: "20", Name: "IoT", DefaultFor: []database.Network{iot}}); err != nil {
+		t.Fatalf("error setting the default VLAN of a network: %v", err)
+	}
+
+	// A network can only have one default VLAN
+	if err := db.CreateVLAN(database.VLAN{ID: "40", Name: "Other", DefaultFor: []database.Network{iot}}); !errors.Is(err, database.ErrNetworkDefaultVLANAlreadyExists) {
+		t.Errorf("got %v creating a second default VLAN of a network, want %v", err, database.ErrNetworkDefaultVLANAlreadyExists)
+	}
+
+	missing := database.User{Username: "phone", VlanID: "10", Networks: []database.NetworkVLAN{{Network: iot, VlanID: "99"}}}
+	if err := db.UpdateUser(missing); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v saving a network VLAN that doesn't exist, want %v", err, database.ErrVLANNotFound)
+	}
+
+	// Network VLANs can't be deleted while users use them
+	valid := database.User{Username: "phone", VlanID: "10", Networks: []database.NetworkVLAN{{Network: iot, VlanID: "30"}}}
+	if err := db.UpdateUser(valid); err != nil {
+		t.Fatalf("error saving network VLAN: %v", err)
+	}
+
+	if err := db.DeleteVLAN("30"); !errors.Is(err, database.ErrVLANInUse) {
+		t.Errorf("got %v deleting a network VLAN, want %v", err, database.ErrVLANInUse)
+	}
+}
+
+func TestPolicyIsValidated(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if err := db.CreateGroup(database.Group{Name: "kids", VlanID: "10"}); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	missingVLAN := []database.PolicyRule{{Name: "iot", Action: database.PolicyActionVLAN, VlanID: "99"}}
+	if err := db.SetPolicy(missingVLAN); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v for a rule with a VLAN that doesn't exist, want %v", err, database.ErrVLANNotFound)
+	}
+
+	missingGroup := []database.PolicyRule{{Name: "teens", Match: database.PolicyMatch{Group: "teens"}, Action: database.PolicyActionReject}}
+	if err := db.SetPolicy(missingGroup); !errors.Is(err, database.ErrGroupNotFound) {
+		t.Errorf("got %v for a rule with a group that doesn't exist, want %v", err, database.ErrGroupNotFound)
+	}
+
+	duplicate := []database.PolicyRule{{Name: "a", Action: database.PolicyActionAccept}, {Name: "a", Action: database.PolicyActionReject}}
+	if err := db.SetPolicy(duplicate); !errors.Is(err, database.ErrPolicyRuleAlreadyExists) {
+		t.Errorf("got %v for rules with the same name, want %v", err, database.ErrPolicyRuleAlreadyExists)
+	}
+
+	// The VLANs and groups of the rules can't be deleted
+	valid := []database.PolicyRule{
+		{Name: "iot", Action: database.PolicyActionVLAN, VlanID: "30"},
+		{Name: "kids", Match: database.PolicyMatch{Group: "kids"}, Action: database.PolicyActionReject},
+	}
+	if err := db.SetPolicy(valid); err != nil {
+		t.Fatalf("error setting policy: %v", err)
+	}
+
+	if err := db.DeleteVLAN("30"); !errors.Is(err, database.ErrVLANInUse) {
+		t.Errorf("got %v deleting a VLAN of a rule, want %v", err, database.ErrVLANInUse)
+	}
+
+	if err := db.DeleteGroup("kids"); !errors.Is(err, database.ErrGroupInUse) {
+		t.Errorf("got %v deleting a group of a rule, want %v", err, database.ErrGroupInUse)
+	}
+
+	if got, _ := db.GetPolicy(); len(got) != len(valid) || got[0].Name != "iot" {
+		t.Errorf("got policy %+v, want %+v", got, valid)
+	}
+}
+
+func TestAttributesAreValidated(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	unknown := []database.Attribute{{Name: "Foo", Value: "bar"}}
+	invalid := []database.Attribute{{Name: "Session-Timeout", Value: "1h"}}
+
+	if err := db.CreateVLAN(database.VLAN{ID: "40", Name: "Guest", Attributes: unknown}); !errors.Is(err, dictionary.ErrUnknownAttribute) {
+		t.Errorf("got %v creating a VLAN with an unknown attribute, want %v", err, dictionary.ErrUnknownAttribute)
+	}
+
+	if err := db.UpdateVLAN(database.VLAN{ID: "20", Name: "IoT", Attributes: invalid}); !errors.Is(err, dictionary.ErrInvalidValue) {
+		t.Errorf("got %v updating a VLAN with an invalid attribute, want %v", err, dictionary.ErrInvalidValue)
+	}
+
+	if err := db.CreateGroup(database.Group{Name: "kids", VlanID: "10", Attributes: invalid}); !errors.Is(err, dictionary.ErrInvalidValue) {
+		t.Errorf("got %v creating a group with an invalid attribute, want %v", err, dictionary.ErrInvalidValue)
+	}
+
+	if err := db.UpdateUser(database.User{Username: "phone", VlanID: "10", Attributes: unknown}); !errors.Is(err, dictionary.ErrUnknownAttribute) {
+		t.Errorf("got %v updating a user with an unknown attribute, want %v", err, dictionary.ErrUnknownAttribute)
+	}
+
+	valid := []database.Attribute{{Name: "Filter-Id", Value: "kids"}, {Name: "WISPr-Bandwidth-Max-Down", Value: "5000000"}}
+	if err := db.CreateGroup(database.Group{Name: "kids", VlanID: "10", Attributes: valid}); err != nil {
+		t.Errorf("error creating a group with valid attributes: %v", err)
+	}
+}
+
+func TestPendingDevicesAreCapped(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := range memorydatabase.MaxPendingDevices + 1 {
+		if _, err := db.RecordPendingDevice(database.PendingDevice{Username: strconv.Itoa(i), LastSeen: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("error recording pending device: %v", err)
+		}
+
+		// The first device keeps retrying, so it's never the one seen the longest ago
+		if _, err := db.RecordPendingDevice(database.PendingDevice{Username: "0", LastSeen: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("error recording pending device: %v", err)
+		}
+	}
+
+	devices, _ := db.GetPendingDevices()
+	if len(devices) != memorydatabase.MaxPendingDevices {
+		t.Fatalf("got %d pending devices, want %d", len(devices), memorydatabase.MaxPendingDevices)
+	}
+
+	usernames := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		usernames[device.Username] = true
+	}
+
+	if !usernames["0"] || usernames["1"] || !usernames[strconv.Itoa(memorydatabase.MaxPendingDevices)] {
+		t.Errorf("got pending devices %v, want the device seen the longest ago dropped", usernames)
+	}
+}
+
+func TestTakePendingDevice(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	pending, err := db.RecordPendingDevice(database.PendingDevice{Username: "tablet", LastSeen: time.Now()})
+	if err != nil {
+		t.Fatalf("error recording pending device: %v", err)
+	}
+
+	// Only one of the concurrent callers gets the device
+	var (
+		wg    sync.WaitGroup
+		taken atomic.Int32
+	)
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			device, err := db.TakePendingDevice(pending.ID)
+			if err == nil && device.Username == "tablet" {
+				taken.Add(1)
+			} else if !errors.Is(err, database.ErrPendingDeviceNotFound) {
+				t.Errorf("got error %v, want %v", err, database.ErrPendingDeviceNotFound)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got := taken.Load(); got != 1 {
+		t.Errorf("got the device taken %d times, want 1", got)
+	}
+
+	if devices, _ := db.GetPendingDevices(); len(devices) != 0 {
+		t.Errorf("got pending devices %+v, want none", devices)
+	}
+}
+
+func TestMoveVLANUsage(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+	schedules := []database.Schedule{{From: "21:00", To: "07:00", Action: database.ScheduleActionVLAN, VlanID: "30"}}
+	networks := []database.NetworkVLAN{{Network: database.Network{SSID: "IoT"}, VlanID: "30"}}
+
+	users := []database.User{
+		{Username: "phone", VlanID: "10", Schedules: schedules},
+		{Username: "tablet", VlanID: "10", TempVLAN: &database.VLANOverride{VlanID: "30", ExpiresAt: time.Now().Add(time.Hour)}},
+		{Username: "laptop", VlanID: "10", Networks: networks},
+	}
+
+	for _, u := range users {
+		if err := db.CreateUser(u); err != nil && !errors.Is(err, database.ErrUserAlreadyExists) {
+			t.Fatalf("error creating user: %v", err)
+		}
+
+		if err := db.UpdateUser(u); err != nil {
+			t.Fatalf("error updating user: %v", err)
+		}
+	}
+
+	if err := db.CreateGroup(database.Group{Name: "kids", VlanID: "30"}); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	usage, err := database.GetVLANUsage(db, "30")
+	if err != nil {
+		t.Fatalf("error getting VLAN usage: %v", err)
+	}
+
+	if len(usage.Users) != len(users) || len(usage.Groups) != 1 || !usage.InUse() {
+		t.Fatalf("got %d users and %d groups using the VLAN, want %d and 1", len(usage.Users), len(usage.Groups), len(users))
+	}
+
+	// Moving every user and group frees the VLAN
+	for _, u := range usage.Users {
+		if err := db.UpdateUser(u.MoveVLAN("30", "20")); err != nil {
+			t.Fatalf("error moving user: %v", err)
+		}
+	}
+
+	for _, g := range usage.Groups {
+		if err := db.UpdateGroup(g.MoveVLAN("30", "20")); err != nil {
+			t.Fatalf("error moving group: %v", err)
+		}
+	}
+
+	if err := db.DeleteVLAN("30"); err != nil {
+		t.Errorf("error deleting a VLAN after moving its users: %v", err)
+	}
+
+	// The rules keep their other fields
+	if phone, _ := db.GetUser("phone"); phone.VlanID != "10" || len(phone.Schedules) != 1 || phone.Schedules[0].VlanID != "20" || phone.Schedules[0].From != "21:00" {
+		t.Errorf("got user %+v, want its schedule moved to VLAN 20", phone)
+	}
+
+	// Moving doesn't change the original user
+	if schedules[0].VlanID != "30" {
+		t.Error("moving a user changed the schedules it was created with")
+	}
+}
+
+func TestReplaceVLAN(t *testing.T) {
+	t.Parallel()
+
+	db := newDatabase(t)
+
+	if err := db.CreateGroup(database.Group{Name: "sensors", VlanID: "20"}); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	if err := db.CreateUser(database.User{Username: "doorbell", Group: "sensors", TempVLAN: &database.VLANOverride{VlanID: "20", ExpiresAt: time.Now().Add(time.Hour)}}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	// Nothing is moved when the VLAN can't be replaced
+	policy := []database.PolicyRule{{Name: "iot", Action: database.PolicyActionVLAN, VlanID: "20"}}
+	if err := db.SetPolicy(policy); err != nil {
+		t.Fatalf("error setting policy: %v", err)
+	}
+
+	for _, tt := range []struct {
+		id, replacementID string
+		wantErr           error
+	}{
+		{"20", "99", database.ErrVLANNotFound},
+		{"20", "20", database.ErrVLANInUse},
+		{"10", "20", database.ErrDefaultVLANInUse},
+		{"20", "30", database.ErrVLANInUse},
+	} {
+		if _, err := db.ReplaceVLAN(tt.id, tt.replacementID); !errors.Is(err, tt.wantErr) {
+			t.Errorf("got %v replacing %s with %s, want %v", err, tt.id, tt.replacementID, tt.wantErr)
+		}
+	}
+
+	if camera, _ := db.GetUser("camera"); camera.VlanID != "20" {
+		t.Errorf("got VLAN %s for a user after a failed replacement, want 20", camera.VlanID)
+	}
+
+	if err := db.SetPolicy(nil); err != nil {
+		t.Fatalf("error clearing policy: %v", err)
+	}
+
+	usage, err := db.ReplaceVLAN("20", "30")
+	if err != nil {
+		t.Fatalf("error replacing VLAN: %v", err)
+	}
+
+	if len(usage.Users) != 2 || len(usage.Groups) != 1 {
+		t.Errorf("got %d users and %d groups moved, want 2 and 1", len(usage.Users), len(usage.Groups))
+	}
+
+	if _, err := db.GetVLAN("20"); !errors.Is(err, database.ErrVLANNotFound) {
+		t.Errorf("got %v getting the replaced VLAN, want %v", err, database.ErrVLANNotFound)
+	}
+
+	camera, _ := db.GetUser("camera")
+	doorbell, _ := db.GetUser("doorbell")
+	sensors, _ := db.GetGroup("sensors")
+
+	if camera.VlanID != "30" || doorbell.TempVLAN.VlanID != "30" || sensors.VlanID != "30" {
+		t.Errorf("got users %+v and %+v and group %+v, want them moved to VLAN 30", camera, doorbell, sensors)
+	}
+}
+
+func TestGetVLANsOrder(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, id := range []string{"guest", "10", "iot", "9", "010"} {
+		if err := db.CreateVLAN(database.VLAN{ID: id, Name: id}); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	vlans, _ := db.GetVLANs()
+
+	ids := make([]string, 0, len(vlans))
+	for _, v := range vlans {
+		ids = append(ids, v.ID)
+	}
+
+	// Numeric IDs come first in numeric order, and the others follow in alphabetical order
+	if want := []string{"9", "010", "10", "guest", "iot"}; !slices.Equal(ids, want) {
+		t.Errorf("got VLANs %v, want %v", ids, want)
+	}
+}
@@ -1,9 +1,11 @@
 package yamldatabase
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 
 	memorydatabase "github.com/maronato/authifi/internal/database/memory"
 	"gopkg.in/yaml.v3"
@@ -11,21 +13,15 @@ import (
 
 var ErrRelativeFile = fmt.Errorf("database file path must be absolute")
 
-// loadFile loads the YAML file into the in-memory database.
-func loadFile(filePath string) (*memorydatabase.MemoryDatabase, error) {
+// loadFile loads the content of the YAML file into the in-memory database.
+func loadFile(filePath string, data []byte) (*memorydatabase.MemoryDatabase, error) {
 	// If filePath is a relative path, return an error
 	if !path.IsAbs(filePath) {
 		return nil, fmt.Errorf("bad database file path (%s): %w", filePath, ErrRelativeFile)
 	}
 
-	f, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
-	}
-	defer f.Close()
-
 	var yf yamlFile
-	if err := yaml.NewDecoder(f).Decode(&yf); err != nil {
+	if err := yaml.Unmarshal(data, &yf); err != nil {
 		return nil, fmt.Errorf("error decoding file: %w", err)
 	}
 
@@ -37,6 +33,12 @@ func loadFile(filePath string) (*memorydatabase.MemoryDatabase, error) {
 		}
 	}
 
+	for _, g := range yf.Groups {
+		if err := db.CreateGroup(g); err != nil {
+			return nil, fmt.Errorf("error creating group: %w", err)
+		}
+	}
+
 	for _, u := range yf.Users {
 		if err := db.CreateUser(u); err != nil {
 			return nil, fmt.Errorf("error creating user: %w", err)
@@ -49,48 +51,126 @@ func loadFile(filePath string) (*memorydatabase.MemoryDatabase, error) {
 		}
 	}
 
+	if err := db.SetPolicy(yf.Policy); err != nil {
+		return nil, fmt.Errorf("error setting policy: %w", err)
+	}
+
+	for _, pd := range yf.PendingDevices {
+		// Skip devices that were handled while the file was being edited
+		if _, err := db.GetUser(pd.Username); err == nil {
+			continue
+		}
+
+		if blocked, _ := db.IsUserBlocked(pd.Username); blocked {
+			continue
+		}
+
+		if _, err := db.RecordPendingDevice(pd); err != nil {
+			return nil, fmt.Errorf("error restoring pending device: %w", err)
+		}
+	}
+
 	return db, nil
 }
 
-// dumpFile dumps the in-memory database into the YAML file.
-func dumpFile(filePath string, db *memorydatabase.MemoryDatabase) error {
+// dumpFile dumps the in-memory database into the YAML file and returns what was written.
+// The file is written to a temporary file first and then renamed, so it's never left half written.
+func dumpFile(filePath string, db *memorydatabase.MemoryDatabase) ([]byte, error) {
 	// If filePath is a relative path, return an error
 	if !path.IsAbs(filePath) {
-		return fmt.Errorf("bad database file path (%s): %w", filePath, ErrRelativeFile)
-	}
-
-	// Create or overwrite the file
-	f, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("error creating file: %w", err)
+		return nil, fmt.Errorf("bad database file path (%s): %w", filePath, ErrRelativeFile)
 	}
-	defer f.Close()
 
 	// Create the YAML file
 	users, err := db.GetUsers()
 	if err != nil {
-		return fmt.Errorf("error getting users: %w", err)
+		return nil, fmt.Errorf("error getting users: %w", err)
 	}
 
 	vlans, err := db.GetVLANs()
 	if err != nil {
-		return fmt.Errorf("error getting VLANs: %w", err)
+		return nil, fmt.Errorf("error getting VLANs: %w", err)
+	}
+
+	groups, err := db.GetGroups()
+	if err != nil {
+		return nil, fmt.Errorf("error getting groups: %w", err)
 	}
 
 	blockedUsers, err := db.GetBlockedUsers()
 	if err != nil {
-		return fmt.Errorf("error getting blocked users: %w", err)
+		return nil, fmt.Errorf("error getting blocked users: %w", err)
+	}
+
+	pendingDevices, err := db.GetPendingDevices()
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending devices: %w", err)
+	}
+
+	policy, err := db.GetPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("error getting policy: %w", err)
 	}
 
 	yf := yamlFile{
-		Users:        users,
-		VLANs:        vlans,
-		BlockedUsers: blockedUsers,
+		Users:          users,
+		VLANs:          vlans,
+		Groups:         groups,
+		BlockedUsers:   blockedUsers,
+		PendingDevices: pendingDevices,
+		Policy:         policy,
 	}
 
 	// Encode the YAML file
-	if err := yaml.NewEncoder(f).Encode(yf); err != nil {
-		return fmt.Errorf("error encoding file: %w", err)
+	var data bytes.Buffer
+	if err := yaml.NewEncoder(&data).Encode(yf); err != nil {
+		return nil, fmt.Errorf("error encoding file: %w", err)
+	}
+
+	if err := writeFile(filePath, data.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return data.Bytes(), nil
+}
+
+// writeFile replaces the file with data through a temporary file in the same directory.
+// The file keeps its permissions if it already exists.
+func writeFile(filePath string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+
+	// Remove the temporary file if it couldn't be renamed
+	defer os.Remove(f.Name())
+
+	if info, err := os.Stat(filePath); err == nil {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			f.Close()
+
+			return fmt.Errorf("error setting file permissions: %w", err)
+		}
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), filePath); err != nil {
+		return fmt.Errorf("error replacing file: %w", err)
 	}
 
 	return nil
@@ -1,9 +1,13 @@
 package yamldatabase
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -15,6 +19,9 @@ import (
 const (
 	// ReloadTimeout is the default timeout to reload the database after a change.
 	ReloadTimeout = 100 * time.Millisecond
+	// PendingSaveInterval is the minimum time between two saves caused by repeated attempts
+	// from an already pending device.
+	PendingSaveInterval = time.Minute
 )
 
 // YAMLDatabase implements the Database interface using a YAML file.
@@ -24,14 +31,24 @@ type YAMLDatabase struct {
 	// watcher is the file watcher.
 	watcher *fsnotify.Watcher
 
-	// memory is the in-memory database.
+	// memory is the in-memory database. It's replaced when the file is reloaded.
 	memory *memorydatabase.MemoryDatabase
+	// mu guards memory. Changes are saved while holding it, so a reload never drops a change
+	// that wasn't saved yet and saves never interleave.
+	mu sync.RWMutex
+	// saved is the content of the last save. Reloads are skipped while the file still has it.
+	saved []byte
+	// lastPendingSave is when the file was last saved because of a pending device.
+	lastPendingSave time.Time
 }
 
 type yamlFile struct {
-	Users        []database.User        `yaml:"users"`
-	VLANs        []database.VLAN        `yaml:"vlans"`
-	BlockedUsers []database.BlockedUser `yaml:"blocked"`
+	Users          []database.User          `yaml:"users"`
+	VLANs          []database.VLAN          `yaml:"vlans"`
+	Groups         []database.Group         `yaml:"groups,omitempty"`
+	BlockedUsers   []database.BlockedUser   `yaml:"blocked"`
+	PendingDevices []database.PendingDevice `yaml:"pending,omitempty"`
+	Policy         []database.PolicyRule    `yaml:"policy,omitempty"`
 }
 
 // NewYAMLDatabase creates a new YAMLDatabase.
@@ -42,41 +59,63 @@ func NewYAMLDatabase(filePath string) *YAMLDatabase {
 	}
 }
 
-func (d *YAMLDatabase) load() error {
-	db, err := loadFile(d.filePath)
+// Load reads the database in a file once, for commands that run next to a server. The file is not
+// watched, and changes to the returned database are never saved, so they can't overwrite the server's.
+func Load(filePath string) (*memorydatabase.MemoryDatabase, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return fmt.Errorf("error loading database file: %w", err)
+		return nil, fmt.Errorf("error reading database file: %w", err)
+	}
+
+	db, err := loadFile(filePath, data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading database file: %w", err)
+	}
+
+	return db, nil
+}
+
+// load replaces the in-memory database with the content of the file, unless the file
+// was last written by save.
+func (d *YAMLDatabase) load() (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := os.ReadFile(d.filePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading database file: %w", err)
+	}
+
+	if d.saved != nil && bytes.Equal(data, d.saved) {
+		return false, nil
+	}
+
+	db, err := loadFile(d.filePath, data)
+	if err != nil {
+		return false, fmt.Errorf("error loading database file: %w", err)
 	}
 
 	d.memory = db
 
-	return nil
+	return true, nil
 }
 
+// save writes the in-memory database to the file. d.mu must be held.
 func (d *YAMLDatabase) save() error {
-	if err := dumpFile(d.filePath, d.memory); err != nil {
+	data, err := dumpFile(d.filePath, d.memory)
+	if err != nil {
 		return fmt.Errorf("error saving database file: %w", err)
 	}
 
+	d.saved = data
+
 	return nil
 }
 
 func (d *YAMLDatabase) watch(ctx context.Context) {
 	l := logging.FromCtx(ctx)
 
-	// Create the watcher
-	w, err := fsnotify.NewWatcher()
-	if err != nil {
-		l.Error("error creating watcher: %v", err)
-	}
-	defer w.Close()
-
-	d.watcher = w
-
-	// Add the file to the watcher
-	if err := d.watcher.Add(d.filePath); err != nil {
-		l.Error("error watching database file: %v", err)
-	}
+	defer d.watcher.Close()
 
 	l.Debug("started yaml database watcher", slog.String("file", d.filePath))
 
@@ -88,7 +127,7 @@ func (d *YAMLDatabase) watch(ctx context.Context) {
 		case <-ctx.Done():
 			err := d.watcher.Close()
 			if err != nil {
-				l.Error("error closing watcher: %v", err)
+				l.Error("error closing watcher", slog.Any("error", err))
 			} else {
 				l.Debug("stopped watching database file")
 			}
@@ -101,7 +140,7 @@ func (d *YAMLDatabase) watch(ctx context.Context) {
 				return
 			}
 
-			if event.Has(fsnotify.Write) {
+			if filepath.Clean(event.Name) == filepath.Clean(d.filePath) && (event.Has(fsnotify.Write) || event.Has(fsnotify.Create)) {
 				// If there's a timer running, stop it
 				if debounceTimer != nil {
 					debounceTimer.Stop()
@@ -109,11 +148,13 @@ func (d *YAMLDatabase) watch(ctx context.Context) {
 
 				// Reload the database after 100ms of inactivity
 				debounceTimer = time.AfterFunc(ReloadTimeout, func() {
-					debounceTimer = nil
+					reloaded, err := d.load()
 
-					if err := d.load(); err != nil {
-						l.Error("error loading database file: %v", err)
-					} else {
+					switch {
+					case err != nil:
+						// Keep serving the previous version until the file is fixed
+						l.Error("error loading database file, keeping the previous version", slog.Any("error", err))
+					case reloaded:
 						l.Info("database file reloaded")
 					}
 				})
@@ -125,13 +166,16 @@ func (d *YAMLDatabase) watch(ctx context.Context) {
 				return
 			}
 
-			l.Error("error watching database file: %v", err)
+			l.Error("error watching database file", slog.Any("error", err))
 		}
 	}
 }
 
 // GetVLANs returns all the VLANs.
 func (d *YAMLDatabase) GetVLANs() ([]database.VLAN, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	vlans, err := d.memory.GetVLANs()
 	if err != nil {
 		return nil, fmt.Errorf("error getting VLANs from memory database: %w", err)
@@ -142,6 +186,9 @@ func (d *YAMLDatabase) GetVLANs() ([]database.VLAN, error) {
 
 // GetVLAN returns a VLAN by its ID.
 func (d *YAMLDatabase) GetVLAN(id string) (database.VLAN, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	vlan, err := d.memory.GetVLAN(id)
 	if err != nil {
 		return database.VLAN{}, fmt.Errorf("error getting VLAN from memory database: %w", err)
@@ -152,6 +199,9 @@ func (d *YAMLDatabase) GetVLAN(id string) (database.VLAN, error) {
 
 // CreateVLAN creates a new VLAN.
 func (d *YAMLDatabase) CreateVLAN(v database.VLAN) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.CreateVLAN(v); err != nil {
 		return fmt.Errorf("error creating VLAN: %w", err)
 	}
@@ -165,6 +215,9 @@ func (d *YAMLDatabase) CreateVLAN(v database.VLAN) error {
 
 // UpdateVLAN updates a VLAN.
 func (d *YAMLDatabase) UpdateVLAN(v database.VLAN) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.UpdateVLAN(v); err != nil {
 		return fmt.Errorf("error updating VLAN: %w", err)
 	}
@@ -178,6 +231,9 @@ func (d *YAMLDatabase) UpdateVLAN(v database.VLAN) error {
 
 // DeleteVLAN deletes a VLAN by its ID.
 func (d *YAMLDatabase) DeleteVLAN(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.DeleteVLAN(id); err != nil {
 		return fmt.Errorf("error deleting VLAN: %w", err)
 	}
@@ -189,8 +245,102 @@ func (d *YAMLDatabase) DeleteVLAN(id string) error {
 	return nil
 }
 
+// ReplaceVLAN moves the users and groups that use a VLAN to another VLAN and deletes it.
+func (d *YAMLDatabase) ReplaceVLAN(id, replacementID string) (database.VLANUsage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	usage, err := d.memory.ReplaceVLAN(id, replacementID)
+	if err != nil {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN: %w", err)
+	}
+
+	if err := d.save(); err != nil {
+		return database.VLANUsage{}, fmt.Errorf("error replacing VLAN: %w", err)
+	}
+
+	return usage, nil
+}
+
+// GetGroups returns all the groups.
+func (d *YAMLDatabase) GetGroups() ([]database.Group, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	groups, err := d.memory.GetGroups()
+	if err != nil {
+		return nil, fmt.Errorf("error getting groups from memory database: %w", err)
+	}
+
+	return groups, nil
+}
+
+// GetGroup returns a group by its name.
+func (d *YAMLDatabase) GetGroup(name string) (database.Group, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	group, err := d.memory.GetGroup(name)
+	if err != nil {
+		return database.Group{}, fmt.Errorf("error getting group from memory database: %w", err)
+	}
+
+	return group, nil
+}
+
+// CreateGroup creates a new group.
+func (d *YAMLDatabase) CreateGroup(g database.Group) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.memory.CreateGroup(g); err != nil {
+		return fmt.Errorf("error creating group: %w", err)
+	}
+
+	if err := d.save(); err != nil {
+		return fmt.Errorf("error creating group: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateGroup updates a group.
+func (d *YAMLDatabase) UpdateGroup(g database.Group) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.memory.UpdateGroup(g); err != nil {
+		return fmt.Errorf("error updating group: %w", err)
+	}
+
+	if err := d.save(); err != nil {
+		return fmt.Errorf("error updating group: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteGroup deletes a group by its name.
+func (d *YAMLDatabase) DeleteGroup(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.memory.DeleteGroup(name); err != nil {
+		return fmt.Errorf("error deleting group: %w", err)
+	}
+
+	if err := d.save(); err != nil {
+		return fmt.Errorf("error deleting group: %w", err)
+	}
+
+	return nil
+}
+
 // GetUsers returns all the users.
 func (d *YAMLDatabase) GetUsers() ([]database.User, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	users, err := d.memory.GetUsers()
 	if err != nil {
 		return nil, fmt.Errorf("error getting users from memory database: %w", err)
@@ -201,6 +351,9 @@ func (d *YAMLDatabase) GetUsers() ([]database.User, error) {
 
 // GetUser returns a user by its username.
 func (d *YAMLDatabase) GetUser(username string) (database.User, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	user, err := d.memory.GetUser(username)
 	if err != nil {
 		return database.User{}, fmt.Errorf("error getting user from memory database: %w", err)
@@ -211,6 +364,9 @@ func (d *YAMLDatabase) GetUser(username string) (database.User, error) {
 
 // GetUserByDescription returns a user by its description.
 func (d *YAMLDatabase) GetUserByDescription(description string) (database.User, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	user, err := d.memory.GetUserByDescription(description)
 	if err != nil {
 		return database.User{}, fmt.Errorf("error getting user by description from memory database: %w", err)
@@ -221,6 +377,9 @@ func (d *YAMLDatabase) GetUserByDescription(description string) (database.User,
 
 // CreateUser creates a new user.
 func (d *YAMLDatabase) CreateUser(u database.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.CreateUser(u); err != nil {
 		return fmt.Errorf("error creating user: %w", err)
 	}
@@ -234,6 +393,9 @@ func (d *YAMLDatabase) CreateUser(u database.User) error {
 
 // UpdateUser updates a user.
 func (d *YAMLDatabase) UpdateUser(u database.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.UpdateUser(u); err != nil {
 		return fmt.Errorf("error updating user: %w", err)
 	}
@@ -247,6 +409,9 @@ func (d *YAMLDatabase) UpdateUser(u database.User) error {
 
 // DeleteUser deletes a user by its username.
 func (d *YAMLDatabase) DeleteUser(username string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.DeleteUser(username); err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
@@ -260,6 +425,9 @@ func (d *YAMLDatabase) DeleteUser(username string) error {
 
 // GetBlockedUsers returns all the blocked users.
 func (d *YAMLDatabase) GetBlockedUsers() ([]database.BlockedUser, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	blockedUsers, err := d.memory.GetBlockedUsers()
 	if err != nil {
 		return nil, fmt.Errorf("error getting blocked users from memory database: %w", err)
@@ -270,6 +438,9 @@ func (d *YAMLDatabase) GetBlockedUsers() ([]database.BlockedUser, error) {
 
 // IsUserBlocked checks if a user is blocked by its username.
 func (d *YAMLDatabase) IsUserBlocked(username string) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	blocked, err := d.memory.IsUserBlocked(username)
 	if err != nil {
 		return false, fmt.Errorf("error checking if user is blocked: %w", err)
@@ -280,6 +451,9 @@ func (d *YAMLDatabase) IsUserBlocked(username string) (bool, error) {
 
 // BlockUser blocks a user by its username.
 func (d *YAMLDatabase) BlockUser(username string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.BlockUser(username); err != nil {
 		return fmt.Errorf("error blocking user: %w", err)
 	}
@@ -293,6 +467,9 @@ func (d *YAMLDatabase) BlockUser(username string) error {
 
 // UnblockUser unblocks a user by its username.
 func (d *YAMLDatabase) UnblockUser(username string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if err := d.memory.UnblockUser(username); err != nil {
 		return fmt.Errorf("error unblocking user: %w", err)
 	}
@@ -304,14 +481,113 @@ func (d *YAMLDatabase) UnblockUser(username string) error {
 	return nil
 }
 
+// GetPendingDevices returns all the pending devices, oldest first.
+func (d *YAMLDatabase) GetPendingDevices() ([]database.PendingDevice, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	devices, err := d.memory.GetPendingDevices()
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending devices from memory database: %w", err)
+	}
+
+	return devices, nil
+}
+
+// GetPendingDevice returns a pending device by its ID.
+func (d *YAMLDatabase) GetPendingDevice(id string) (database.PendingDevice, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	device, err := d.memory.GetPendingDevice(id)
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error getting pending device from memory database: %w", err)
+	}
+
+	return device, nil
+}
+
+// RecordPendingDevice records an attempt from an unknown device.
+// New devices are saved right away, but repeated attempts are saved at most once every
+// PendingSaveInterval to avoid rewriting the file every time a device retries.
+func (d *YAMLDatabase) RecordPendingDevice(p database.PendingDevice) (database.PendingDevice, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	device, err := d.memory.RecordPendingDevice(p)
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error recording pending device: %w", err)
+	}
+
+	if device.Attempts > 1 && time.Since(d.lastPendingSave) < PendingSaveInterval {
+		return device, nil
+	}
+
+	d.lastPendingSave = time.Now()
+
+	if err := d.save(); err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error recording pending device: %w", err)
+	}
+
+	return device, nil
+}
+
+// DeletePendingDevice deletes a pending device by its ID.
+func (d *YAMLDatabase) DeletePendingDevice(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.memory.DeletePendingDevice(id); err != nil {
+		return fmt.Errorf("error deleting pending device: %w", err)
+	}
+
+	if err := d.save(); err != nil {
+		return fmt.Errorf("error deleting pending device: %w", err)
+	}
+
+	return nil
+}
+
+// TakePendingDevice deletes a pending device by its ID and returns it.
+func (d *YAMLDatabase) TakePendingDevice(id string) (database.PendingDevice, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	device, err := d.memory.TakePendingDevice(id)
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error taking pending device: %w", err)
+	}
+
+	if err := d.save(); err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error taking pending device: %w", err)
+	}
+
+	return device, nil
+}
+
 // Open initializes the database.
 func (d *YAMLDatabase) Open(ctx context.Context) error {
 	l := logging.FromCtx(ctx)
 
-	if err := d.load(); err != nil {
+	if _, err := d.load(); err != nil {
 		return fmt.Errorf("error initializing database: %w", err)
 	}
 
+	// Create the watcher before returning so no change is missed
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating watcher: %w", err)
+	}
+
+	// Watch the directory, since saves replace the file with a new one
+	if err := w.Add(filepath.Dir(d.filePath)); err != nil {
+		w.Close()
+
+		return fmt.Errorf("error watching database file: %w", err)
+	}
+
+	d.watcher = w
+
 	// Start the watcher
 	go d.watch(ctx)
 
@@ -325,7 +601,11 @@ func (d *YAMLDatabase) Close(ctx context.Context) error {
 	l := logging.FromCtx(ctx)
 
 	// Make sure the database is up to date before closing
-	if err := d.save(); err != nil {
+	d.mu.Lock()
+	err := d.save()
+	d.mu.Unlock()
+
+	if err != nil {
 		return fmt.Errorf("error closing database: %w", err)
 	}
 
@@ -341,6 +621,9 @@ func (d *YAMLDatabase) Close(ctx context.Context) error {
 
 // GetDefaultVLAN returns the default VLAN.
 func (d *YAMLDatabase) GetDefaultVLAN() (database.VLAN, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	vlan, err := d.memory.GetDefaultVLAN()
 	if err != nil {
 		return database.VLAN{}, fmt.Errorf("error getting default VLAN from memory database: %w", err)
@@ -348,3 +631,29 @@ func (d *YAMLDatabase) GetDefaultVLAN() (database.VLAN, error) {
 
 	return vlan, nil
 }
+
+// GetQuarantineVLAN returns the quarantine VLAN.
+func (d *YAMLDatabase) GetQuarantineVLAN() (database.VLAN, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	vlan, err := d.memory.GetQuarantineVLAN()
+	if err != nil {
+		return database.VLAN{}, fmt.Errorf("error getting quarantine VLAN from memory database: %w", err)
+	}
+
+	return vlan, nil
+}
+
+// GetPolicy returns the rules of the access policy, in order.
+func (d *YAMLDatabase) GetPolicy() ([]database.PolicyRule, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rules, err := d.memory.GetPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("error getting policy from memory database: %w", err)
+	}
+
+	return rules, nil
+}
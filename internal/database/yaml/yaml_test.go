@@ -0,0 +1,176 @@
+package yamldatabase_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	yamldatabase "github.com/maronato/authifi/internal/database/yaml"
+)
+
+const initialFile = `vlans:
+  - id: "10"
+    name: Home
+    default: true
+users: []
+blocked: []
+`
+
+const editedFile = `vlans:
+  - id: "10"
+    name: Home
+    default: true
+users:
+  - username: laptop
+    password: secret
+    vlan: "10"
+blocked: []
+`
+
+// openDatabase writes the initial file and opens a database on it.
+func openDatabase(t *testing.T) (*yamldatabase.YAMLDatabase, string) {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "database.yaml")
+	if err := os.WriteFile(filePath, []byte(initialFile), 0o600); err != nil {
+		t.Fatalf("error writing database file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	db := yamldatabase.NewYAMLDatabase(filePath)
+	if err := db.Open(ctx); err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+
+	return db, filePath
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	t.Parallel()
+
+	db, filePath := openDatabase(t)
+
+	const count = 20
+
+	var wg sync.WaitGroup
+
+	for i := range count {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+
+			if err := db.CreateUser(database.User{Username: "user-" + strconv.Itoa(i), VlanID: "10"}); err != nil {
+				t.Errorf("error creating user: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := db.RecordPendingDevice(database.PendingDevice{Username: "pending-" + strconv.Itoa(i), LastSeen: time.Now()}); err != nil {
+				t.Errorf("error recording pending device: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := db.GetUsers(); err != nil {
+				t.Errorf("error getting users: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := db.Close(context.Background()); err != nil {
+		t.Fatalf("error closing database: %v", err)
+	}
+
+	// Every change made it to the file
+	reopened := yamldatabase.NewYAMLDatabase(filePath)
+	if err := reopened.Open(context.Background()); err != nil {
+		t.Fatalf("error reopening database: %v", err)
+	}
+
+	if users, _ := reopened.GetUsers(); len(users) != count {
+		t.Errorf("got %d users, want %d", len(users), count)
+	}
+
+	if devices, _ := reopened.GetPendingDevices(); len(devices) != count {
+		t.Errorf("got %d pending devices, want %d", len(devices), count)
+	}
+}
+
+func TestReloadAfterSave(t *testing.T) {
+	t.Parallel()
+
+	db, filePath := openDatabase(t)
+
+	// Saving replaces the file, which must not stop external edits from being picked up
+	if err := db.CreateUser(database.User{Username: "phone", VlanID: "10"}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	if err := os.WriteFile(filePath, []byte(editedFile), 0o600); err != nil {
+		t.Fatalf("error editing database file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if _, err := db.GetUser("laptop"); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the edited database file was not reloaded")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "database.yaml")
+	if err := os.WriteFile(filePath, []byte(editedFile), 0o600); err != nil {
+		t.Fatalf("error writing database file: %v", err)
+	}
+
+	db, err := yamldatabase.Load(filePath)
+	if err != nil {
+		t.Fatalf("error loading database: %v", err)
+	}
+
+	if _, err := db.GetUser("laptop"); err != nil {
+		t.Errorf("error getting user: %v", err)
+	}
+
+	// Changes are never saved to the file
+	if err := db.DeleteUser("laptop"); err != nil {
+		t.Fatalf("error deleting user: %v", err)
+	}
+
+	if err := db.Close(context.Background()); err != nil {
+		t.Fatalf("error closing database: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("error reading database file: %v", err)
+	}
+
+	if string(data) != editedFile {
+		t.Errorf("got file %q, want it unchanged", data)
+	}
+}
@@ -0,0 +1,211 @@
+// Package dictionary encodes the RADIUS reply attributes that can be set in the database by their name,
+// including the vendor-specific attributes of common access points and routers.
+package dictionary
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/maronato/authifi/internal/database"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+var (
+	// ErrUnknownAttribute is returned when an attribute is not in the dictionary.
+	ErrUnknownAttribute = errors.New("unknown attribute")
+	// ErrInvalidValue is returned when the value of an attribute can't be encoded.
+	ErrInvalidValue = errors.New("invalid attribute value")
+)
+
+// kind is how the value of an attribute is encoded.
+type kind int
+
+const (
+	// kindString values are sent as they are.
+	kindString kind = iota
+	// kindInteger values are unsigned 32-bit integers.
+	kindInteger
+)
+
+// Vendor IDs of the vendor-specific attributes in the dictionary.
+const (
+	vendorCisco    uint32 = 9
+	vendorWISPr    uint32 = 14122
+	vendorAruba    uint32 = 14823
+	vendorMikrotik uint32 = 14988
+)
+
+// maxVendorValueLength is the longest value of a vendor-specific attribute: 253 bytes minus the
+// vendor ID, vendor type, and vendor length.
+const maxVendorValueLength = 247
+
+// definition describes an attribute of the dictionary.
+type definition struct {
+	// name is the name of the attribute, as written in the database.
+	name string
+	// typ is the attribute type, or the vendor type of vendor-specific attributes.
+	typ radius.Type
+	// vendor is the vendor ID of vendor-specific attributes. It's zero for standard attributes.
+	vendor uint32
+	// kind is how the value is encoded.
+	kind kind
+}
+
+// definitions are the attributes of the dictionary, by their lowercase name.
+var definitions = map[string]definition{}
+
+// define adds attributes to the dictionary.
+func define(defs ...definition) {
+	for _, def := range defs {
+		definitions[strings.ToLower(def.name)] = def
+	}
+}
+
+func init() { //nolint:gochecknoinits // the dictionary is built once
+	define(
+		definition{name: "Session-Timeout", typ: rfc2865.SessionTimeout_Type, kind: kindInteger},
+		definition{name: "Idle-Timeout", typ: rfc2865.IdleTimeout_Type, kind: kindInteger},
+		definition{name: "Filter-Id", typ: rfc2865.FilterID_Type, kind: kindString},
+		definition{name: "Reply-Message", typ: rfc2865.ReplyMessage_Type, kind: kindString},
+		definition{name: "Class", typ: rfc2865.Class_Type, kind: kindString},
+		definition{name: "Termination-Action", typ: rfc2865.TerminationAction_Type, kind: kindInteger},
+		definition{name: "Acct-Interim-Interval", typ: rfc2869.AcctInterimInterval_Type, kind: kindInteger},
+		definition{name: "WISPr-Redirection-URL", vendor: vendorWISPr, typ: 4, kind: kindString},
+		definition{name: "WISPr-Bandwidth-Max-Up", vendor: vendorWISPr, typ: 7, kind: kindInteger},
+		definition{name: "WISPr-Bandwidth-Max-Down", vendor: vendorWISPr, typ: 8, kind: kindInteger},
+		definition{name: "Mikrotik-Rate-Limit", vendor: vendorMikrotik, typ: 8, kind: kindString},
+		definition{name: "Mikrotik-Address-List", vendor: vendorMikrotik, typ: 19, kind: kindString},
+		definition{name: "Aruba-User-Role", vendor: vendorAruba, typ: 1, kind: kindString},
+		definition{name: "Cisco-AVPair", vendor: vendorCisco, typ: 1, kind: kindString},
+	)
+}
+
+// Encode encodes an attribute. Names are case-insensitive.
+func Encode(a database.Attribute) (*radius.AVP, error) {
+	def, ok := definitions[strings.ToLower(a.Name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAttribute, a.Name)
+	}
+
+	value, err := def.encode(a.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if def.vendor == 0 {
+		return &radius.AVP{Type: def.typ, Attribute: value}, nil
+	}
+
+	if len(value) > maxVendorValueLength {
+		return nil, fmt.Errorf("%w: %s is longer than %d bytes", ErrInvalidValue, def.name, maxVendorValueLength)
+	}
+
+	// Vendor-specific attributes wrap their value in a vendor type and length
+	vsa, err := radius.NewVendorSpecific(def.vendor, append([]byte{byte(def.typ), byte(len(value) + 2)}, value...)) //nolint:gosec // the length was checked above
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidValue, def.name, err)
+	}
+
+	return &radius.AVP{Type: rfc2865.VendorSpecific_Type, Attribute: vsa}, nil
+}
+
+// Decode returns the name and value of an encoded attribute. It returns false if the attribute
+// is not in the dictionary.
+func Decode(avp *radius.AVP) (database.Attribute, bool) {
+	vendor, typ, attr, ok := split(avp)
+	if !ok {
+		return database.Attribute{}, false
+	}
+
+	for _, def := range definitions {
+		if def.vendor != vendor || def.typ != typ {
+			continue
+		}
+
+		value := string(attr)
+		if def.kind == kindInteger {
+			n, err := radius.Integer(attr)
+			if err != nil {
+				return database.Attribute{}, false
+			}
+
+			value = strconv.FormatUint(uint64(n), 10)
+		}
+
+		return database.Attribute{Name: def.name, Value: value}, true
+	}
+
+	return database.Attribute{}, false
+}
+
+// Same reports whether two encoded attributes are of the same type. Vendor-specific attributes are
+// of the same type if they have the same vendor and vendor type.
+func Same(a, b *radius.AVP) bool {
+	aVendor, aType, _, aOK := split(a)
+	bVendor, bType, _, bOK := split(b)
+
+	return aOK && bOK && aVendor == bVendor && aType == bType
+}
+
+// split returns the vendor ID, type, and value of an encoded attribute. The vendor ID is zero for
+// standard attributes. It returns false if a vendor-specific attribute is malformed.
+func split(avp *radius.AVP) (uint32, radius.Type, radius.Attribute, bool) {
+	if avp.Type != rfc2865.VendorSpecific_Type {
+		return 0, avp.Type, avp.Attribute, true
+	}
+
+	vendor, value, err := radius.VendorSpecific(avp.Attribute)
+	if err != nil || len(value) < 2 || int(value[1]) < 2 || int(value[1]) > len(value) {
+		return 0, 0, nil, false
+	}
+
+	return vendor, radius.Type(value[0]), value[2:value[1]], true
+}
+
+// Validate checks that an attribute is in the dictionary and that its value can be encoded.
+func Validate(a database.Attribute) error {
+	_, err := Encode(a)
+
+	return err
+}
+
+// Names returns the names of the attributes in the dictionary, sorted.
+func Names() []string {
+	names := make([]string, 0, len(definitions))
+	for _, def := range definitions {
+		names = append(names, def.name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// encode encodes the value of an attribute.
+func (def definition) encode(value string) (radius.Attribute, error) {
+	switch def.kind {
+	case kindInteger:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be a positive integer, got %q", ErrInvalidValue, def.name, value)
+		}
+
+		return radius.NewInteger(uint32(n)), nil
+	default:
+		if value == "" {
+			return nil, fmt.Errorf("%w: %s can't be empty", ErrInvalidValue, def.name)
+		}
+
+		attr, err := radius.NewString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidValue, def.name, err)
+		}
+
+		return attr, nil
+	}
+}
@@ -0,0 +1,78 @@
+package dictionary_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/dictionary"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		attribute database.Attribute
+		wantErr   error
+	}{
+		{attribute: database.Attribute{Name: "Session-Timeout", Value: "3600"}},
+		{attribute: database.Attribute{Name: "idle-timeout", Value: "600"}},
+		{attribute: database.Attribute{Name: "Filter-Id", Value: "guests"}},
+		{attribute: database.Attribute{Name: "Reply-Message", Value: "Welcome"}},
+		{attribute: database.Attribute{Name: "Class", Value: "staff"}},
+		{attribute: database.Attribute{Name: "Acct-Interim-Interval", Value: "300"}},
+		{attribute: database.Attribute{Name: "WISPr-Bandwidth-Max-Down", Value: "10000000"}},
+		{attribute: database.Attribute{Name: "mikrotik-rate-limit", Value: "10M/20M"}},
+		{attribute: database.Attribute{Name: "Cisco-AVPair", Value: "ip:inacl#1=deny ip any any"}},
+		{attribute: database.Attribute{Name: "Foo", Value: "1"}, wantErr: dictionary.ErrUnknownAttribute},
+		{attribute: database.Attribute{Name: "Tunnel-Private-Group-Id", Value: "10"}, wantErr: dictionary.ErrUnknownAttribute},
+		{attribute: database.Attribute{Name: "WISPr-Bandwidth-Max-Up", Value: "fast"}, wantErr: dictionary.ErrInvalidValue},
+		{attribute: database.Attribute{Name: "Aruba-User-Role", Value: strings.Repeat("a", 248)}, wantErr: dictionary.ErrInvalidValue},
+		{attribute: database.Attribute{Name: "Session-Timeout", Value: "1h"}, wantErr: dictionary.ErrInvalidValue},
+		{attribute: database.Attribute{Name: "Session-Timeout", Value: "-1"}, wantErr: dictionary.ErrInvalidValue},
+		{attribute: database.Attribute{Name: "Filter-Id", Value: ""}, wantErr: dictionary.ErrInvalidValue},
+	}
+
+	for _, tt := range tests {
+		avp, err := dictionary.Encode(tt.attribute)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%+v: got error %v, want %v", tt.attribute, err, tt.wantErr)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		// Decoding gives the canonical name back
+		got, ok := dictionary.Decode(avp)
+		if !ok || got.Value != tt.attribute.Value || dictionary.Validate(got) != nil {
+			t.Errorf("%+v: got %+v, %v after decoding", tt.attribute, got, ok)
+		}
+	}
+}
+
+func TestVendorSpecific(t *testing.T) {
+	t.Parallel()
+
+	up, err := dictionary.Encode(database.Attribute{Name: "WISPr-Bandwidth-Max-Up", Value: "1000"})
+	if err != nil {
+		t.Fatalf("error encoding attribute: %v", err)
+	}
+
+	// Vendor-specific attributes carry the vendor ID, vendor type, and vendor length
+	vendor, value, err := radius.VendorSpecific(up.Attribute)
+	if up.Type != rfc2865.VendorSpecific_Type || err != nil || vendor != 14122 || value[0] != 7 || value[1] != 6 {
+		t.Errorf("got type %d, vendor %d, and value %v, want type 26, vendor 14122, and vendor type 7", up.Type, vendor, value)
+	}
+
+	down, _ := dictionary.Encode(database.Attribute{Name: "WISPr-Bandwidth-Max-Down", Value: "1000"})
+	otherUp, _ := dictionary.Encode(database.Attribute{Name: "WISPr-Bandwidth-Max-Up", Value: "2000"})
+	filterID, _ := dictionary.Encode(database.Attribute{Name: "Filter-Id", Value: "guests"})
+
+	if !dictionary.Same(up, otherUp) || dictionary.Same(up, down) || dictionary.Same(up, filterID) {
+		t.Error("attributes of different vendor types are the same")
+	}
+}
@@ -0,0 +1,466 @@
+package eventlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/maronato/authifi/internal/logging"
+)
+
+const (
+	// DefaultCapacity is the default number of events kept in memory.
+	DefaultCapacity = 1000
+	// DefaultMaxFileSize is the default size in bytes after which the event file is rotated.
+	DefaultMaxFileSize = 10 * 1024 * 1024
+	// rotatedSuffix is appended to the event file path when it's rotated.
+	rotatedSuffix = ".1"
+	// statsSuffix is appended to the event file path to store the device stats snapshot.
+	statsSuffix = ".stats.json"
+	// filePermissions are the permissions used when creating the event files.
+	filePermissions = 0o600
+	// fileRetryBackoff is how long the event file isn't reopened or rotated again after it failed.
+	fileRetryBackoff = time.Minute
+)
+
+// Decision is the outcome of an authentication attempt.
+type Decision string
+
+const (
+	// DecisionAccept is used when the request was accepted.
+	DecisionAccept Decision = "accept"
+	// DecisionReject is used when the request was rejected.
+	DecisionReject Decision = "reject"
+)
+
+// Event is a single authentication event.
+type Event struct {
+	Time            time.Time `json:"time"`
+	Username        string    `json:"username"`
+	MACAddress      string    `json:"macAddress,omitempty"`
+	NASAddress      string    `json:"nasAddress,omitempty"`
+	NASIdentifier   string    `json:"nasIdentifier,omitempty"`
+	SessionID       string    `json:"sessionId,omitempty"`
+	CalledStationID string    `json:"calledStationId,omitempty"`
+	Decision        Decision  `json:"decision"`
+	VlanID          string    `json:"vlan,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+}
+
+// DeviceStats holds aggregated information about a device.
+type DeviceStats struct {
+	FirstSeen           time.Time `json:"firstSeen"`
+	LastSeen            time.Time `json:"lastSeen"`
+	Attempts            int       `json:"attempts"`
+	LastDecision        Decision  `json:"lastDecision"`
+	LastNAS             string    `json:"lastNas,omitempty"`
+	LastMAC             string    `json:"lastMac,omitempty"`
+	LastNASIdentifier   string    `json:"lastNasIdentifier,omitempty"`
+	LastSessionID       string    `json:"lastSessionId,omitempty"`
+	LastCalledStationID string    `json:"lastCalledStationId,omitempty"`
+}
+
+// Log is a ring buffer of authentication events optionally backed by a rotating file.
+// It also keeps first-seen, last-seen and attempt counts per username.
+type Log struct {
+	mu sync.RWMutex
+
+	// filePath is the path to the event file. Events are only kept in memory if empty.
+	filePath string
+	// maxFileSize is the size in bytes after which the event file is rotated.
+	maxFileSize int64
+	// file is the currently open event file.
+	file *os.File
+	// fileSize is the current size of the event file.
+	fileSize int64
+	// opened is true while the log is open for writing, even if the event file couldn't be
+	// reopened after a rotation.
+	opened bool
+	// retryAt is when the event file can be reopened or rotated again after it failed.
+	retryAt time.Time
+
+	// events is the ring buffer of events.
+	events []Event
+	// next is the position in the ring buffer where the next event will be written.
+	next int
+	// full is true once the ring buffer has wrapped around.
+	full bool
+
+	// stats is a map of usernames to their stats.
+	stats map[string]*DeviceStats
+
+	// subscribers are called with every recorded event.
+	subscribers []func(Event)
+}
+
+// NewLog creates a new Log. If filePath is empty, events are only kept in memory.
+func NewLog(filePath string, capacity int, maxFileSize int64) *Log {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+
+	return &Log{
+		filePath:    filePath,
+		maxFileSize: maxFileSize,
+		events:      make([]Event, capacity),
+		stats:       make(map[string]*DeviceStats),
+	}
+}
+
+// Open loads previous events and stats from disk and opens the event file for writing.
+func (l *Log) Open(ctx context.Context) error {
+	logger := logging.FromCtx(ctx)
+
+	if l.filePath == "" {
+		logger.Debug("event log is in-memory only")
+
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.load(); err != nil {
+		return err
+	}
+
+	if err := l.openFile(); err != nil {
+		return err
+	}
+
+	l.opened = true
+
+	logger.Debug("opened event log", slog.String("file", l.filePath), slog.Int("devices", len(l.stats)))
+
+	return nil
+}
+
+// Load loads previous events and stats from disk without opening the event file for writing,
+// so the log can be read while a server writes to it.
+func (l *Log) Load() error {
+	if l.filePath == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.load()
+}
+
+// load loads previous events and stats from disk.
+func (l *Log) load() error {
+	// The stats snapshot covers every event up to the last rotation
+	if err := l.loadStats(); err != nil {
+		return fmt.Errorf("error loading event stats: %w", err)
+	}
+
+	// Replay the rotated file into the ring buffer only, its stats are already in the snapshot
+	if err := l.replay(l.filePath+rotatedSuffix, false); err != nil {
+		return fmt.Errorf("error replaying rotated event file: %w", err)
+	}
+
+	// Replay the current file into both the ring buffer and the stats
+	if err := l.replay(l.filePath, true); err != nil {
+		return fmt.Errorf("error replaying event file: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the event file.
+func (l *Log) Close(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.opened = false
+
+	if l.file == nil {
+		return nil
+	}
+
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("error closing event file: %w", err)
+	}
+
+	l.file = nil
+
+	return nil
+}
+
+// Subscribe calls fn with every event recorded from now on, after it has been added to the log.
+func (l *Log) Subscribe(fn func(Event)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.subscribers = append(l.subscribers, fn)
+}
+
+// Record adds an event to the log, updates the stats for its username and passes it to the subscribers.
+// The event is kept in memory even if it can't be written to the event file.
+func (l *Log) Record(e Event) error {
+	l.mu.Lock()
+	err := l.record(e)
+	subscribers := l.subscribers
+	l.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+
+	return err
+}
+
+// record adds an event to the log and updates the stats for its username. Writing the event to the
+// event file is best effort.
+func (l *Log) record(e Event) error {
+	line, err := l.prepareWrite(e)
+
+	l.push(e)
+	l.updateStats(e)
+
+	if line == nil {
+		return err
+	}
+
+	n, writeErr := l.file.Write(line)
+	l.fileSize += int64(n)
+
+	if writeErr != nil {
+		return errors.Join(err, fmt.Errorf("error writing event: %w", writeErr))
+	}
+
+	return err
+}
+
+// prepareWrite encodes an event and gets the event file ready for it, reopening or rotating the file
+// if needed. The line is nil if the event can't be written now. After a failure, the file isn't
+// reopened or rotated again until fileRetryBackoff has passed.
+func (l *Log) prepareWrite(e Event) ([]byte, error) {
+	now := time.Now()
+
+	// Reopen the event file if it couldn't be reopened after a rotation
+	if l.file == nil {
+		if !l.opened || now.Before(l.retryAt) {
+			return nil, nil
+		}
+
+		if err := l.openFile(); err != nil {
+			l.retryAt = now.Add(fileRetryBackoff)
+
+			return nil, err
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding event: %w", err)
+	}
+
+	line = append(line, '\n')
+
+	// Rotate before counting the event, since it's written to the new file and counted again when
+	// the file is replayed
+	if l.fileSize+int64(len(line)) > l.maxFileSize && !now.Before(l.retryAt) {
+		if err := l.rotate(); err != nil {
+			l.retryAt = now.Add(fileRetryBackoff)
+
+			if l.file == nil {
+				return nil, err
+			}
+
+			// Keep writing to the current file until the rotation is retried
+			return line, err
+		}
+	}
+
+	return line, nil
+}
+
+// Recent returns up to n of the most recent events, newest first.
+func (l *Log) Recent(n int) []Event {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	size := l.next
+	if l.full {
+		size = len(l.events)
+	}
+
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	events := make([]Event, 0, n)
+
+	for i := 1; i <= n; i++ {
+		idx := (l.next - i + len(l.events)) % len(l.events)
+		events = append(events, l.events[idx])
+	}
+
+	return events
+}
+
+// Stats returns the stats for a username.
+func (l *Log) Stats(username string) (DeviceStats, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stats, ok := l.stats[username]
+	if !ok {
+		return DeviceStats{}, false
+	}
+
+	return *stats, true
+}
+
+// push adds an event to the ring buffer.
+func (l *Log) push(e Event) {
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// updateStats updates the stats for the username of the event.
+func (l *Log) updateStats(e Event) {
+	stats, ok := l.stats[e.Username]
+	if !ok {
+		stats = &DeviceStats{FirstSeen: e.Time}
+		l.stats[e.Username] = stats
+	}
+
+	stats.Attempts++
+	stats.LastDecision = e.Decision
+
+	if e.Time.After(stats.LastSeen) {
+		stats.LastSeen = e.Time
+		stats.LastNAS = e.NASAddress
+		stats.LastMAC = e.MACAddress
+		stats.LastNASIdentifier = e.NASIdentifier
+		stats.LastSessionID = e.SessionID
+		stats.LastCalledStationID = e.CalledStationID
+	}
+}
+
+// replay reads the events in filePath into the ring buffer and, optionally, the stats.
+func (l *Log) replay(filePath string, withStats bool) error {
+	f, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		// Skip lines that can't be decoded, they were likely truncated by a crash
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+
+		l.push(e)
+
+		if withStats {
+			l.updateStats(e)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+
+	return nil
+}
+
+// openFile opens the event file for appending.
+func (l *Log) openFile() error {
+	f, err := os.OpenFile(l.filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, filePermissions)
+	if err != nil {
+		return fmt.Errorf("error opening event file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return fmt.Errorf("error reading event file info: %w", err)
+	}
+
+	l.file = f
+	l.fileSize = info.Size()
+
+	return nil
+}
+
+// rotate saves the stats snapshot and moves the current event file out of the way.
+func (l *Log) rotate() error {
+	// Save the stats first so the snapshot covers every event in the file being rotated
+	if err := l.saveStats(); err != nil {
+		return fmt.Errorf("error saving event stats: %w", err)
+	}
+
+	err := l.file.Close()
+	if err != nil {
+		err = fmt.Errorf("error closing event file: %w", err)
+	} else if renameErr := os.Rename(l.filePath, l.filePath+rotatedSuffix); renameErr != nil {
+		err = fmt.Errorf("error rotating event file: %w", renameErr)
+	}
+
+	// Reopen the event file even if the rotation failed, so later events are still written
+	l.file = nil
+
+	if openErr := l.openFile(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+
+	return err
+}
+
+// loadStats loads the stats snapshot from disk.
+func (l *Log) loadStats() error {
+	data, err := os.ReadFile(l.filePath + statsSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+
+	stats := make(map[string]*DeviceStats)
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return fmt.Errorf("error decoding file: %w", err)
+	}
+
+	l.stats = stats
+
+	return nil
+}
+
+// saveStats saves the stats snapshot to disk.
+func (l *Log) saveStats() error {
+	data, err := json.Marshal(l.stats)
+	if err != nil {
+		return fmt.Errorf("error encoding stats: %w", err)
+	}
+
+	if err := os.WriteFile(l.filePath+statsSuffix, data, filePermissions); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
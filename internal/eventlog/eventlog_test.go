@@ -0,0 +1,192 @@
+package eventlog_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/eventlog"
+)
+
+// record records events for a username, one second apart.
+func record(t *testing.T, log *eventlog.Log, username string, n int) {
+	t.Helper()
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := range n {
+		e := eventlog.Event{Time: start.Add(time.Duration(i) * time.Second), Username: username, NASAddress: "192.168.1.1", Decision: eventlog.DecisionAccept}
+		if err := log.Record(e); err != nil {
+			t.Fatalf("error recording event: %v", err)
+		}
+	}
+}
+
+func TestRecent(t *testing.T) {
+	t.Parallel()
+
+	log := eventlog.NewLog("", 3, 0)
+	record(t, log, "phone", 5)
+
+	recent := log.Recent(0)
+	if len(recent) != 3 || recent[0].Time.Second() != 4 || recent[2].Time.Second() != 2 {
+		t.Errorf("got %+v, want the 3 newest events, newest first", recent)
+	}
+
+	if got := log.Recent(1); len(got) != 1 || got[0].Time.Second() != 4 {
+		t.Errorf("got %+v, want the newest event", got)
+	}
+
+	stats, ok := log.Stats("phone")
+	if !ok || stats.Attempts != 5 || stats.FirstSeen.Second() != 0 || stats.LastSeen.Second() != 4 || stats.LastNAS != "192.168.1.1" {
+		t.Errorf("got stats %+v, %v", stats, ok)
+	}
+
+	if _, ok := log.Stats("tablet"); ok {
+		t.Error("got stats for a device that was never seen")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	log := eventlog.NewLog("", 1, 0)
+
+	var got []eventlog.Event
+
+	log.Subscribe(func(e eventlog.Event) {
+		// The event is already in the log
+		if _, ok := log.Stats(e.Username); !ok {
+			t.Errorf("subscriber called before %s was recorded", e.Username)
+		}
+
+		got = append(got, e)
+	})
+
+	record(t, log, "phone", 3)
+
+	if len(got) != 3 || got[0].Time.Second() != 0 || got[2].Time.Second() != 2 {
+		t.Errorf("got %+v, want every recorded event in order", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		maxFileSize int64
+	}{
+		{"without rotation", 0},
+		// Every event rotates the file
+		{"with rotation", 1},
+		// Some events rotate the file
+		{"with some rotations", 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			filePath := filepath.Join(t.TempDir(), "events.jsonl")
+
+			log := eventlog.NewLog(filePath, 0, tt.maxFileSize)
+			if err := log.Open(ctx); err != nil {
+				t.Fatalf("error opening log: %v", err)
+			}
+
+			record(t, log, "phone", 7)
+			record(t, log, "tablet", 2)
+
+			if err := log.Close(ctx); err != nil {
+				t.Fatalf("error closing log: %v", err)
+			}
+
+			if tt.maxFileSize > 0 {
+				if _, err := os.Stat(filePath + ".1"); err != nil {
+					t.Errorf("error checking rotated file: %v", err)
+				}
+			}
+
+			// A new log gets the same stats back from the files
+			loaded := eventlog.NewLog(filePath, 0, tt.maxFileSize)
+			if err := loaded.Open(ctx); err != nil {
+				t.Fatalf("error opening log again: %v", err)
+			}
+
+			defer func() {
+				if err := loaded.Close(ctx); err != nil {
+					t.Errorf("error closing log again: %v", err)
+				}
+			}()
+
+			for username, want := range map[string]int{"phone": 7, "tablet": 2} {
+				if stats, ok := loaded.Stats(username); !ok || stats.Attempts != want {
+					t.Errorf("got %d attempts for %s after loading, want %d", stats.Attempts, username, want)
+				}
+			}
+
+			if recent := loaded.Recent(1); len(recent) != 1 || recent[0].Username != "tablet" {
+				t.Errorf("got recent events %+v, want the last tablet event", recent)
+			}
+		})
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "events.jsonl")
+
+	// Every event rotates the file
+	log := eventlog.NewLog(filePath, 0, 1)
+	if err := log.Open(ctx); err != nil {
+		t.Fatalf("error opening log: %v", err)
+	}
+
+	notified := 0
+	log.Subscribe(func(eventlog.Event) { notified++ })
+
+	// A non-empty directory in place of the rotated file makes every rotation fail
+	rotated := filePath + ".1"
+	if err := os.MkdirAll(filepath.Join(rotated, "blocker"), 0o700); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	e := eventlog.Event{Time: time.Now(), Username: "phone", Decision: eventlog.DecisionAccept}
+	if err := log.Record(e); err == nil {
+		t.Error("got no error recording an event while the file can't be rotated")
+	}
+
+	// The rotation isn't retried right away, and the events are kept in memory and in the current file
+	for range 2 {
+		if err := log.Record(e); err != nil {
+			t.Errorf("error recording an event while the rotation is backing off: %v", err)
+		}
+	}
+
+	if stats, ok := log.Stats("phone"); !ok || stats.Attempts != 3 || notified != 3 {
+		t.Errorf("got stats %+v, %v and %d subscriber calls, want 3 attempts and 3 calls", stats, ok, notified)
+	}
+
+	if err := log.Close(ctx); err != nil {
+		t.Fatalf("error closing log: %v", err)
+	}
+
+	if err := os.RemoveAll(rotated); err != nil {
+		t.Fatalf("error removing directory: %v", err)
+	}
+
+	loaded := eventlog.NewLog(filePath, 0, 1)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("error loading log: %v", err)
+	}
+
+	if stats, ok := loaded.Stats("phone"); !ok || stats.Attempts != 3 {
+		t.Errorf("got stats %+v, %v after loading, want 3 attempts", stats, ok)
+	}
+}
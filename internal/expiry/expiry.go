@@ -0,0 +1,120 @@
+// Package expiry removes guest users and temporary VLAN assignments once they expire.
+package expiry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/logging"
+)
+
+// Store is the database the janitor cleans up.
+type Store interface {
+	// GetUsers returns all the users.
+	GetUsers() ([]database.User, error)
+	// GetUser returns a user by its username.
+	GetUser(username string) (database.User, error)
+	// ExpireUser deletes an expired guest user.
+	ExpireUser(u database.User) error
+	// RevertTempVLAN removes the expired temporary VLAN of a user.
+	RevertTempVLAN(u database.User) error
+}
+
+// Janitor periodically deletes expired users and reverts expired temporary VLANs.
+type Janitor struct {
+	// store is the database that is cleaned up.
+	store Store
+	// interval is the time between sweeps.
+	interval time.Duration
+	// now returns the current time.
+	now func() time.Time
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewJanitor creates a new Janitor.
+func NewJanitor(ctx context.Context, cfg *config.Config, store Store) *Janitor {
+	return &Janitor{
+		store:    store,
+		interval: cfg.ExpiryCheckInterval,
+		now:      time.Now,
+		l:        logging.FromCtx(ctx),
+	}
+}
+
+// Sweep deletes the users that expired at now and reverts their expired temporary VLANs.
+// It keeps going after an error and returns all of them.
+func (j *Janitor) Sweep(now time.Time) error {
+	users, err := j.store.GetUsers()
+	if err != nil {
+		return fmt.Errorf("error getting users: %w", err)
+	}
+
+	var errs []error
+
+	for _, u := range users {
+		if expired(u, now) {
+			if err := j.expire(u.Username, now); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// expired reports whether the user or its temporary VLAN have expired at now.
+func expired(u database.User, now time.Time) bool {
+	return u.Expired(now) || (u.TempVLAN != nil && u.ActiveTempVLAN(now) == nil)
+}
+
+// expire reloads a user and deletes it or reverts its temporary VLAN if it's still expired,
+// so changes made since the sweep started are kept.
+func (j *Janitor) expire(username string, now time.Time) error {
+	u, err := j.store.GetUser(username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting user %s: %w", username, err)
+	}
+
+	switch {
+	case u.Expired(now):
+		if err := j.store.ExpireUser(u); err != nil {
+			return fmt.Errorf("error expiring user %s: %w", username, err)
+		}
+
+		j.l.Info("Guest user expired", slog.String("username", username), slog.Time("expiresAt", *u.ExpiresAt))
+	case expired(u, now):
+		if err := j.store.RevertTempVLAN(u); err != nil {
+			return fmt.Errorf("error reverting temporary VLAN of user %s: %w", username, err)
+		}
+
+		j.l.Info("Temporary VLAN expired", slog.String("username", username), slog.String("vlan", u.TempVLAN.VlanID))
+	}
+
+	return nil
+}
+
+// Start sweeps the database every interval until the context is canceled.
+func (j *Janitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.Sweep(j.now()); err != nil {
+			j.l.Error("Error cleaning up expired users", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
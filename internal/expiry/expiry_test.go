@@ -0,0 +1,91 @@
+package expiry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	memorydatabase "github.com/maronato/authifi/internal/database/memory"
+	"github.com/maronato/authifi/internal/expiry"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+// changeRecorder is a notifier that sends the changes to a channel.
+type changeRecorder struct {
+	changes chan notify.Change
+}
+
+func (r *changeRecorder) NotifyNewDevice(context.Context, notify.Device)      {}
+func (r *changeRecorder) NotifyBlockedAttempt(context.Context, notify.Device) {}
+func (r *changeRecorder) NotifyError(context.Context, notify.Device, error)   {}
+func (r *changeRecorder) NotifyChange(_ context.Context, c notify.Change)     { r.changes <- c }
+
+func TestSweep(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Home"}, {ID: "30", Name: "Guests"}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	users := []database.User{
+		{Username: "expired-guest", VlanID: "30", ExpiresAt: &past},
+		{Username: "guest", VlanID: "30", ExpiresAt: &future},
+		{Username: "laptop", VlanID: "30", TempVLAN: &database.VLANOverride{VlanID: "10", ExpiresAt: past}},
+		{Username: "phone", VlanID: "30", TempVLAN: &database.VLANOverride{VlanID: "10", ExpiresAt: future}},
+	}
+
+	for _, u := range users {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("error creating user: %v", err)
+		}
+	}
+
+	recorder := &changeRecorder{changes: make(chan notify.Change, len(users))}
+	janitor := expiry.NewJanitor(ctx, config.NewConfig(), notify.NewDatabase(ctx, db, recorder))
+
+	if err := janitor.Sweep(now); err != nil {
+		t.Fatalf("error sweeping: %v", err)
+	}
+
+	if _, err := db.GetUser("expired-guest"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("got error %v for the expired guest, want %v", err, database.ErrUserNotFound)
+	}
+
+	if _, err := db.GetUser("guest"); err != nil {
+		t.Errorf("error getting the guest that didn't expire: %v", err)
+	}
+
+	if laptop, _ := db.GetUser("laptop"); laptop.TempVLAN != nil {
+		t.Errorf("got temporary VLAN %+v, want it reverted", laptop.TempVLAN)
+	}
+
+	if phone, _ := db.GetUser("phone"); phone.TempVLAN == nil {
+		t.Error("got the temporary VLAN that didn't expire reverted")
+	}
+
+	got := map[notify.ChangeType]string{}
+
+	for range 2 {
+		select {
+		case c := <-recorder.changes:
+			got[c.Type] = c.Username
+		case <-time.After(time.Second):
+			t.Fatalf("got changes %v, want 2", got)
+		}
+	}
+
+	if got[notify.ChangeUserExpired] != "expired-guest" || got[notify.ChangeTempVLANExpired] != "laptop" {
+		t.Errorf("got changes %v", got)
+	}
+}
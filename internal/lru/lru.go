@@ -2,10 +2,13 @@ package lru
 
 import (
 	"container/list"
+	"sync"
 )
 
 // LRUCache is a type-safe LRU cache implementation using generics.
+// It's safe for concurrent use.
 type Cache[K comparable, V any] struct {
+	mu       sync.Mutex
 	capacity int
 	cache    map[K]*list.Element
 	queue    *list.List
@@ -28,6 +31,9 @@ func NewLRUCache[K comparable, V any](capacity int) *Cache[K, V] {
 
 // Get retrieves a value from the cache. It returns the value and a boolean indicating if the key was found.
 func (c *Cache[K, V]) Get(key K) (V, bool) { //nolint:ireturn // This is a generic function
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if elem, found := c.cache[key]; found {
 		c.queue.MoveToFront(elem)
 
@@ -43,6 +49,9 @@ func (c *Cache[K, V]) Get(key K) (V, bool) { //nolint:ireturn // This is a gener
 
 // Set adds a key-value pair to the cache or updates an existing key. It also handles the eviction of the least recently used item if necessary.
 func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if elem, found := c.cache[key]; found {
 		c.queue.MoveToFront(elem)
 
@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Base implements the notifications that chat notifiers log instead of sending. Blocked attempts
+// only confirm that a block is working, and administrative changes are made by the admins who would
+// receive them. Embed it in a notifier that only sends new devices and errors.
+type Base struct {
+	l *slog.Logger
+}
+
+// NewBase creates a new Base that logs with l.
+func NewBase(l *slog.Logger) Base {
+	return Base{l: l}
+}
+
+// NotifyBlockedAttempt logs an attempt from a blocked device.
+func (b Base) NotifyBlockedAttempt(_ context.Context, d Device) {
+	b.l.Debug("Blocked device tried to connect", slog.String("username", d.Username), slog.String("macAddress", d.MACAddress))
+}
+
+// NotifyChange logs an administrative change.
+func (b Base) NotifyChange(_ context.Context, c Change) {
+	b.l.Debug("Database changed", slog.String("type", string(c.Type)), slog.String("username", c.Username), slog.String("vlan", c.VlanID))
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+)
+
+// Database wraps a database.Database and notifies about every successful change made through it.
+type Database struct {
+	database.Database
+
+	//nolint:containedctx // Changes happen outside of a request, so the server context is used
+	ctx      context.Context
+	notifier Notifier
+}
+
+// NewDatabase creates a new Database that notifies n about the changes made to db.
+func NewDatabase(ctx context.Context, db database.Database, n Notifier) *Database {
+	return &Database{Database: db, ctx: ctx, notifier: n}
+}
+
+// notify sends the change to the notifier in the background if err is nil.
+func (d *Database) notify(c Change, err error) error {
+	if err != nil {
+		return fmt.Errorf("error applying %s change: %w", c.Type, err)
+	}
+
+	c.Time = time.Now()
+
+	go d.notifier.NotifyChange(d.ctx, c)
+
+	return nil
+}
+
+// CreateVLAN creates a new VLAN.
+func (d *Database) CreateVLAN(v database.VLAN) error {
+	return d.notify(Change{Type: ChangeVLANCreated, VlanID: v.ID, Description: v.Name}, d.Database.CreateVLAN(v))
+}
+
+// UpdateVLAN updates a VLAN.
+func (d *Database) UpdateVLAN(v database.VLAN) error {
+	return d.notify(Change{Type: ChangeVLANUpdated, VlanID: v.ID, Description: v.Name}, d.Database.UpdateVLAN(v))
+}
+
+// DeleteVLAN deletes a VLAN by its ID.
+func (d *Database) DeleteVLAN(id string) error {
+	return d.notify(Change{Type: ChangeVLANDeleted, VlanID: id}, d.Database.DeleteVLAN(id))
+}
+
+// ReplaceVLAN moves the users and groups that use a VLAN to another VLAN and deletes it. The moved
+// users and groups are notified as updated.
+func (d *Database) ReplaceVLAN(id, replacementID string) (database.VLANUsage, error) {
+	usage, err := d.Database.ReplaceVLAN(id, replacementID)
+	if err := d.notify(Change{Type: ChangeVLANDeleted, VlanID: id}, err); err != nil {
+		return database.VLANUsage{}, err
+	}
+
+	for _, u := range usage.Users {
+		u = u.MoveVLAN(id, replacementID)
+		d.notify(Change{Type: ChangeUserUpdated, Username: u.Username, VlanID: u.VlanID, Group: u.Group, Description: u.Description}, nil) //nolint:errcheck // never fails without an error
+	}
+
+	for _, g := range usage.Groups {
+		g = g.MoveVLAN(id, replacementID)
+		d.notify(Change{Type: ChangeGroupUpdated, Group: g.Name, VlanID: g.VlanID, Description: g.Description}, nil) //nolint:errcheck // never fails without an error
+	}
+
+	return usage, nil
+}
+
+// CreateGroup creates a new group.
+func (d *Database) CreateGroup(g database.Group) error {
+	return d.notify(Change{Type: ChangeGroupCreated, Group: g.Name, VlanID: g.VlanID, Description: g.Description}, d.Database.CreateGroup(g))
+}
+
+// UpdateGroup updates a group.
+func (d *Database) UpdateGroup(g database.Group) error {
+	return d.notify(Change{Type: ChangeGroupUpdated, Group: g.Name, VlanID: g.VlanID, Description: g.Description}, d.Database.UpdateGroup(g))
+}
+
+// DeleteGroup deletes a group by its name.
+func (d *Database) DeleteGroup(name string) error {
+	return d.notify(Change{Type: ChangeGroupDeleted, Group: name}, d.Database.DeleteGroup(name))
+}
+
+// CreateUser creates a new user.
+func (d *Database) CreateUser(u database.User) error {
+	return d.notify(Change{Type: ChangeUserCreated, Username: u.Username, VlanID: u.VlanID, Group: u.Group, Description: u.Description}, d.Database.CreateUser(u))
+}
+
+// UpdateUser updates a user.
+func (d *Database) UpdateUser(u database.User) error {
+	return d.notify(Change{Type: ChangeUserUpdated, Username: u.Username, VlanID: u.VlanID, Group: u.Group, Description: u.Description}, d.Database.UpdateUser(u))
+}
+
+// DeleteUser deletes a user by its username.
+func (d *Database) DeleteUser(username string) error {
+	return d.notify(Change{Type: ChangeUserDeleted, Username: username}, d.Database.DeleteUser(username))
+}
+
+// BlockUser blocks a user by its username.
+func (d *Database) BlockUser(username string) error {
+	return d.notify(Change{Type: ChangeUserBlocked, Username: username}, d.Database.BlockUser(username))
+}
+
+// UnblockUser unblocks a user by its username.
+func (d *Database) UnblockUser(username string) error {
+	return d.notify(Change{Type: ChangeUserUnblocked, Username: username}, d.Database.UnblockUser(username))
+}
+
+// ExpireUser deletes an expired guest user.
+func (d *Database) ExpireUser(u database.User) error {
+	return d.notify(Change{Type: ChangeUserExpired, Username: u.Username, VlanID: u.VlanID, Group: u.Group, Description: u.Description}, d.Database.DeleteUser(u.Username))
+}
+
+// RevertTempVLAN removes the expired temporary VLAN of a user.
+func (d *Database) RevertTempVLAN(u database.User) error {
+	c := Change{Type: ChangeTempVLANExpired, Username: u.Username, VlanID: u.VlanID, Group: u.Group, Description: u.Description}
+	u.TempVLAN = nil
+
+	return d.notify(c, d.Database.UpdateUser(u))
+}
@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/maronato/authifi/internal/logging"
+)
+
+// DefaultRepeatInterval is the default minimum time between two repeat notifications for the same device.
+const DefaultRepeatInterval = 30 * time.Second
+
+// RepeatNotifier is implemented by notifiers that can update a previous new device
+// notification instead of sending a new one.
+type RepeatNotifier interface {
+	Notifier
+	// NotifyRepeat is called when a device that was already notified tried to connect again.
+	// count is the number of attempts since the original notification.
+	NotifyRepeat(ctx context.Context, d Device, count int)
+}
+
+// DedupOptions configures a Deduplicator.
+type DedupOptions struct {
+	// Window is how long attempts from the same device are suppressed after a notification.
+	// Deduplication is disabled if zero.
+	Window time.Duration
+	// RepeatInterval is the minimum time between two repeat notifications for the same device.
+	// DefaultRepeatInterval is used if zero.
+	RepeatInterval time.Duration
+	// RateLimit is the maximum number of notifications sent per RatePeriod. It's disabled if zero.
+	RateLimit int
+	// RatePeriod is the period of the rate limit.
+	RatePeriod time.Duration
+	// Now returns the current time. time.Now is used if nil.
+	Now func() time.Time
+}
+
+// seen tracks the notifications sent for a key.
+type seen struct {
+	// first is when the notification was sent.
+	first time.Time
+	// count is the number of suppressed attempts since then.
+	count int
+	// lastRepeat is when the last repeat notification was sent.
+	lastRepeat time.Time
+}
+
+// Deduplicator is a Notifier that suppresses repeated notifications for the same device
+// and caps the number of notifications sent to the next notifier.
+//
+// Suppressed new device attempts are aggregated and sent as repeat notifications if
+// the next notifier implements RepeatNotifier. Administrative changes are never suppressed.
+type Deduplicator struct {
+	next Notifier
+	opts DedupOptions
+
+	mu sync.Mutex
+	// seen are the recent notifications by key.
+	seen map[string]*seen
+	// sent are the times of the notifications sent in the current rate period.
+	sent []time.Time
+	// limited is true while notifications are being dropped by the rate limit.
+	limited bool
+
+	l *slog.Logger
+}
+
+// NewDeduplicator creates a new Deduplicator that forwards notifications to next.
+func NewDeduplicator(ctx context.Context, next Notifier, opts DedupOptions) *Deduplicator {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	if opts.RepeatInterval <= 0 {
+		opts.RepeatInterval = DefaultRepeatInterval
+	}
+
+	return &Deduplicator{
+		next: next,
+		opts: opts,
+		seen: make(map[string]*seen),
+		l:    logging.FromCtx(ctx),
+	}
+}
+
+// prune removes the expired entries. It must be called with the lock held.
+func (d *Deduplicator) prune(now time.Time) {
+	for key, s := range d.seen {
+		if now.Sub(s.first) >= d.opts.Window {
+			delete(d.seen, key)
+		}
+	}
+
+	cutoff := now.Add(-d.opts.RatePeriod)
+	for len(d.sent) > 0 && !d.sent[0].After(cutoff) {
+		d.sent = d.sent[1:]
+	}
+}
+
+// allow reports whether the rate limit allows a notification and records it if so.
+// It must be called with the lock held.
+func (d *Deduplicator) allow(now time.Time) bool {
+	if d.opts.RateLimit <= 0 {
+		return true
+	}
+
+	if len(d.sent) >= d.opts.RateLimit {
+		if !d.limited {
+			d.l.Warn("Notification rate limit reached, dropping notifications", slog.Int("limit", d.opts.RateLimit), slog.Duration("period", d.opts.RatePeriod))
+		}
+
+		d.limited = true
+
+		return false
+	}
+
+	d.limited = false
+	d.sent = append(d.sent, now)
+
+	return true
+}
+
+// check decides what to do with an attempt for key. It returns whether a notification
+// should be sent, and the number of suppressed attempts if a repeat notification should be sent.
+func (d *Deduplicator) check(key string) (notify bool, repeats int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.opts.Now()
+	d.prune(now)
+
+	if s, ok := d.seen[key]; ok && d.opts.Window > 0 {
+		s.count++
+
+		if now.Sub(s.lastRepeat) < d.opts.RepeatInterval {
+			return false, 0
+		}
+
+		s.lastRepeat = now
+
+		return false, s.count
+	}
+
+	if !d.allow(now) {
+		return false, 0
+	}
+
+	if d.opts.Window > 0 {
+		d.seen[key] = &seen{first: now, lastRepeat: now}
+	}
+
+	return true, 0
+}
+
+// NotifyNewDevice forwards the first attempt of a device in the window and aggregates the rest.
+func (d *Deduplicator) NotifyNewDevice(ctx context.Context, dev Device) {
+	notify, repeats := d.check("new:" + dev.Username)
+
+	switch {
+	case notify:
+		d.next.NotifyNewDevice(ctx, dev)
+	case repeats > 0:
+		if rn, ok := d.next.(RepeatNotifier); ok {
+			rn.NotifyRepeat(ctx, dev, repeats)
+		}
+	default:
+		d.l.Debug("Suppressed new device notification", slog.String("username", dev.Username))
+	}
+}
+
+// NotifyBlockedAttempt forwards the first blocked attempt of a device in the window.
+func (d *Deduplicator) NotifyBlockedAttempt(ctx context.Context, dev Device) {
+	if notify, _ := d.check("blocked:" + dev.Username); notify {
+		d.next.NotifyBlockedAttempt(ctx, dev)
+	}
+}
+
+// NotifyError forwards the first occurrence of an error for a device in the window.
+func (d *Deduplicator) NotifyError(ctx context.Context, dev Device, err error) {
+	if notify, _ := d.check("error:" + dev.Username + ":" + err.Error()); notify {
+		d.next.NotifyError(ctx, dev, err)
+	}
+}
+
+// NotifyChange forwards administrative changes without suppressing them.
+func (d *Deduplicator) NotifyChange(ctx context.Context, c Change) {
+	d.next.NotifyChange(ctx, c)
+}
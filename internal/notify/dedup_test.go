@@ -0,0 +1,210 @@
+package notify_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/notify"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// recorder is a RepeatNotifier that records the notifications it receives.
+type recorder struct {
+	mu      sync.Mutex
+	devices []string
+	blocked []string
+	errors  []string
+	changes []notify.Change
+	repeats []int
+}
+
+func (r *recorder) NotifyNewDevice(_ context.Context, d notify.Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.devices = append(r.devices, d.Username)
+}
+
+func (r *recorder) NotifyBlockedAttempt(_ context.Context, d notify.Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.blocked = append(r.blocked, d.Username)
+}
+
+func (r *recorder) NotifyError(_ context.Context, d notify.Device, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errors = append(r.errors, d.Username+": "+err.Error())
+}
+
+func (r *recorder) NotifyChange(_ context.Context, c notify.Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.changes = append(r.changes, c)
+}
+
+func (r *recorder) NotifyRepeat(_ context.Context, _ notify.Device, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.repeats = append(r.repeats, count)
+}
+
+func newDeduplicator(opts notify.DedupOptions) (*notify.Deduplicator, *recorder, *fakeClock) {
+	rec := &recorder{}
+	clock := newFakeClock()
+	opts.Now = clock.Now
+
+	return notify.NewDeduplicator(context.Background(), rec, opts), rec, clock
+}
+
+func device(username string) notify.Device {
+	return notify.Device{Username: username, MACAddress: username}
+}
+
+func TestDeduplicatorSuppressesRepeatedAttempts(t *testing.T) {
+	t.Parallel()
+
+	d, rec, clock := newDeduplicator(notify.DedupOptions{Window: 10 * time.Minute, RepeatInterval: 30 * time.Second})
+	ctx := context.Background()
+
+	// An unknown device retries every 5 seconds for 2 minutes
+	for range 24 {
+		d.NotifyNewDevice(ctx, device("a"))
+		clock.Advance(5 * time.Second)
+	}
+
+	if len(rec.devices) != 1 {
+		t.Errorf("got %d new device notifications, want 1", len(rec.devices))
+	}
+
+	// Repeats are aggregated every 30 seconds
+	want := []int{6, 12, 18}
+	if len(rec.repeats) != len(want) {
+		t.Fatalf("got repeats %v, want %v", rec.repeats, want)
+	}
+
+	for i := range want {
+		if rec.repeats[i] != want[i] {
+			t.Errorf("got repeats %v, want %v", rec.repeats, want)
+
+			break
+		}
+	}
+
+	// Other devices are not affected
+	d.NotifyNewDevice(ctx, device("b"))
+
+	if len(rec.devices) != 2 {
+		t.Errorf("got %d new device notifications after another device, want 2", len(rec.devices))
+	}
+
+	// The device is notified again once the window expires
+	clock.Advance(10 * time.Minute)
+	d.NotifyNewDevice(ctx, device("a"))
+
+	if len(rec.devices) != 3 {
+		t.Errorf("got %d new device notifications after the window, want 3", len(rec.devices))
+	}
+}
+
+func TestDeduplicatorRateLimit(t *testing.T) {
+	t.Parallel()
+
+	d, rec, clock := newDeduplicator(notify.DedupOptions{Window: time.Hour, RateLimit: 3, RatePeriod: time.Minute})
+	ctx := context.Background()
+
+	for _, username := range []string{"a", "b", "c", "d", "e"} {
+		d.NotifyNewDevice(ctx, device(username))
+	}
+
+	if len(rec.devices) != 3 {
+		t.Fatalf("got %d notifications, want 3", len(rec.devices))
+	}
+
+	// Dropped devices are notified once the period is over
+	clock.Advance(time.Minute)
+	d.NotifyNewDevice(ctx, device("d"))
+	d.NotifyNewDevice(ctx, device("a"))
+
+	if len(rec.devices) != 4 || rec.devices[3] != "d" {
+		t.Errorf("got notifications %v, want d to be notified after the period", rec.devices)
+	}
+}
+
+func TestDeduplicatorOtherEvents(t *testing.T) {
+	t.Parallel()
+
+	d, rec, _ := newDeduplicator(notify.DedupOptions{Window: time.Minute})
+	ctx := context.Background()
+
+	for range 3 {
+		d.NotifyBlockedAttempt(ctx, device("a"))
+		d.NotifyError(ctx, device("a"), errors.New("vlan not found"))
+		d.NotifyChange(ctx, notify.Change{Type: notify.ChangeUserBlocked, Username: "a"})
+	}
+
+	d.NotifyError(ctx, device("a"), errors.New("other error"))
+
+	// Blocked attempts are tracked separately from new devices
+	d.NotifyNewDevice(ctx, device("a"))
+
+	if len(rec.blocked) != 1 {
+		t.Errorf("got %d blocked notifications, want 1", len(rec.blocked))
+	}
+
+	if len(rec.errors) != 2 {
+		t.Errorf("got errors %v, want one per distinct error", rec.errors)
+	}
+
+	if len(rec.changes) != 3 {
+		t.Errorf("got %d changes, want all 3", len(rec.changes))
+	}
+
+	if len(rec.devices) != 1 {
+		t.Errorf("got %d new device notifications, want 1", len(rec.devices))
+	}
+}
+
+func TestDeduplicatorDisabled(t *testing.T) {
+	t.Parallel()
+
+	d, rec, _ := newDeduplicator(notify.DedupOptions{})
+
+	for range 5 {
+		d.NotifyNewDevice(context.Background(), device("a"))
+	}
+
+	if len(rec.devices) != 5 || len(rec.repeats) != 0 {
+		t.Errorf("got %d notifications and %d repeats, want 5 and 0", len(rec.devices), len(rec.repeats))
+	}
+}
@@ -0,0 +1,99 @@
+package discord
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+const (
+	// RequestTimeout is the timeout for each webhook request.
+	RequestTimeout = 10 * time.Second
+	// Username is the name the messages are posted with.
+	Username = "Authifi"
+	// newDeviceColor and errorColor are the embed colors.
+	newDeviceColor = 0xE74C3C
+	errorColor     = 0xF1C40F
+)
+
+// Embed is a Discord message embed.
+type Embed struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Color       int    `json:"color,omitempty"`
+}
+
+// AllowedMentions controls which mentions in a message ping users.
+type AllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// Payload is the JSON body sent to the Discord webhook.
+type Payload struct {
+	Username        string          `json:"username,omitempty"`
+	Embeds          []Embed         `json:"embeds"`
+	AllowedMentions AllowedMentions `json:"allowed_mentions"` //nolint:tagliatelle // Discord API
+}
+
+// Notifier is a notify.Notifier that posts to a Discord webhook.
+type Notifier struct {
+	// Base logs blocked attempts and administrative changes.
+	notify.Base
+	// webhookURL is the Discord webhook URL.
+	webhookURL string
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// client is the HTTP client.
+	client *http.Client
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewNotifier creates a new Notifier. The linker is optional and adds approval links to new device messages.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker) *Notifier {
+	l := logging.FromCtx(ctx)
+
+	l.Debug("Discord setup complete")
+
+	return &Notifier{
+		Base:       notify.NewBase(l),
+		webhookURL: cfg.DiscordWebhookURL,
+		linker:     linker,
+		client:     &http.Client{Timeout: RequestTimeout},
+		l:          l,
+	}
+}
+
+// NotifyNewDevice posts a new device alert with the approval links.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	m := notify.NewDeviceMessage(d)
+
+	if n.linker != nil {
+		m.Actions = n.linker.Links(d)
+	}
+
+	n.send(ctx, m, newDeviceColor)
+}
+
+// NotifyError posts an error message.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	n.send(ctx, notify.ErrorMessage(d, err), errorColor)
+}
+
+// send posts a message as an embed and logs any error.
+func (n *Notifier) send(ctx context.Context, m notify.Message, color int) {
+	payload := Payload{
+		Username:        Username,
+		Embeds:          []Embed{{Title: m.Title, Description: m.RenderBody(notify.Markdown), Color: color}},
+		AllowedMentions: AllowedMentions{Parse: []string{}},
+	}
+
+	if err := notify.SendJSON(ctx, n.client, http.MethodPost, n.webhookURL, nil, payload); err != nil {
+		n.l.Error("Error sending Discord message", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,67 @@
+package discord_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/discord"
+	"github.com/maronato/authifi/internal/notify/notifytest"
+)
+
+func TestNotifyNewDevice(t *testing.T) {
+	t.Parallel()
+
+	payloads := make(chan discord.Payload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p discord.Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		payloads <- p
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := config.NewConfig()
+	cfg.DiscordWebhookURL = server.URL
+
+	linker := notifytest.Linker{{Label: "Block", URL: "https://authifi.example/approve?token=block"}}
+	d := notify.Device{Username: "aa:bb:cc:dd:ee:ff", Password: "secret-password", MACAddress: "aa:bb:cc:dd:ee:ff"}
+
+	discord.NewNotifier(context.Background(), cfg, linker).NotifyNewDevice(context.Background(), d)
+
+	p := <-payloads
+
+	if len(p.Embeds) != 1 {
+		t.Fatalf("got %d embeds, want 1", len(p.Embeds))
+	}
+
+	if p.Embeds[0].Title != notify.NewDeviceMessage(d).Title {
+		t.Errorf("got title %q", p.Embeds[0].Title)
+	}
+
+	for _, want := range []string{"`aa:bb:cc:dd:ee:ff`", "[Block](https://authifi.example/approve?token=block)"} {
+		if !strings.Contains(p.Embeds[0].Description, want) {
+			t.Errorf("description %q does not contain %q", p.Embeds[0].Description, want)
+		}
+	}
+
+	if strings.Contains(p.Embeds[0].Description, d.Password) {
+		t.Error("password leaked in message")
+	}
+
+	if p.AllowedMentions.Parse == nil || len(p.AllowedMentions.Parse) != 0 {
+		t.Errorf("got allowed mentions %v, want none", p.AllowedMentions.Parse)
+	}
+}
@@ -0,0 +1,471 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/eventlog"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+const (
+	// DialTimeout is the timeout to connect to the SMTP server.
+	DialTimeout = 10 * time.Second
+	// TopRejectedCount is the number of rejected MAC addresses listed in the digest.
+	TopRejectedCount = 5
+	// digestTimeLayout is the layout of the daily digest time.
+	digestTimeLayout = "15:04"
+)
+
+// ErrStartTLSUnsupported is returned when STARTTLS is required but the server does not support it.
+var ErrStartTLSUnsupported = errors.New("SMTP server does not support STARTTLS")
+
+// EventType is the type of an immediate alert.
+type EventType string
+
+const (
+	// EventNewDevice is sent when an unknown device tries to connect.
+	EventNewDevice EventType = "new_device"
+	// EventBlockedAttempt is sent when a blocked device tries to connect.
+	EventBlockedAttempt EventType = "blocked_attempt"
+	// EventError is sent when an error prevents a device from being handled.
+	EventError EventType = "error"
+)
+
+// Alert is the data rendered by the alert template.
+type Alert struct {
+	Event  EventType
+	Device notify.Device
+	Error  string
+	// Actions are the approval links for new devices, if approvals are enabled.
+	Actions []notify.Action
+}
+
+// RejectedMAC is a MAC address and how many times it was rejected.
+type RejectedMAC struct {
+	MACAddress string
+	Count      int
+}
+
+// Digest is the data rendered by the digest template.
+type Digest struct {
+	Since       time.Time
+	Until       time.Time
+	NewDevices  []notify.Device
+	Changes     []notify.Change
+	TopRejected []RejectedMAC
+}
+
+// Empty returns whether there is nothing to report in the digest.
+func (d Digest) Empty() bool {
+	return len(d.NewDevices) == 0 && len(d.Changes) == 0 && len(d.TopRejected) == 0
+}
+
+// Notifier is a notify.Notifier that sends emails. It sends immediate alerts for new
+// and blocked devices and, optionally, a daily digest.
+type Notifier struct {
+	// addr is the address of the SMTP server.
+	addr string
+	// host is the host name of the SMTP server.
+	host string
+	// username and password are the optional SMTP credentials.
+	username string
+	password string
+	// startTLS requires the connection to be upgraded with STARTTLS.
+	startTLS bool
+	// from is the sender address.
+	from *mail.Address
+	// to are the recipient addresses.
+	to []*mail.Address
+	// alert and digest are the email templates.
+	alert  *template.Template
+	digest *template.Template
+	// digestAt is the time of day the digest is sent. The digest is disabled if nil.
+	digestAt *time.Time
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// mu protects the digest state.
+	mu sync.Mutex
+	// since is when the current digest period started.
+	since time.Time
+	// newDevices are the new devices seen in the current digest period, by username.
+	newDevices map[string]notify.Device
+	// changes are the VLAN changes made in the current digest period.
+	changes []notify.Change
+	// rejected counts the rejections in the current digest period, by MAC address.
+	rejected map[string]int
+
+	// l is the logger.
+	l *slog.Logger
+}
+
+// parseTemplate parses a template file, or the fallback if the path is empty.
+func parseTemplate(path, fallback string) (*template.Template, error) {
+	if path == "" {
+		return template.Must(template.New("default").Parse(fallback)), nil
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing email template: %w", err)
+	}
+
+	for _, name := range []string{"subject", "body"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("error parsing email template %s: missing %q template", path, name)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// NewNotifier creates a new Notifier. The linker and the event log are optional.
+// Rejections recorded in the event log are listed in the digest.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker, events *eventlog.Log) (*Notifier, error) {
+	l := logging.FromCtx(ctx)
+
+	alert, err := parseTemplate(cfg.EmailAlertTemplate, defaultAlertTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := parseTemplate(cfg.EmailDigestTemplate, defaultDigestTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(cfg.EmailFrom)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sender address: %w", err)
+	}
+
+	to := make([]*mail.Address, len(cfg.EmailTo))
+	for i, addr := range cfg.EmailTo {
+		if to[i], err = mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("error parsing recipient address: %w", err)
+		}
+	}
+
+	var digestAt *time.Time
+
+	if cfg.EmailDigestTime != "" {
+		t, err := time.Parse(digestTimeLayout, cfg.EmailDigestTime)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing digest time: %w", err)
+		}
+
+		digestAt = &t
+	}
+
+	l.Debug("Email setup complete", slog.String("server", cfg.SMTPHost), slog.Int("recipients", len(cfg.EmailTo)), slog.Bool("digest", digestAt != nil))
+
+	n := &Notifier{
+		addr:       net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:       cfg.SMTPHost,
+		username:   cfg.SMTPUsername,
+		password:   cfg.SMTPPassword,
+		startTLS:   cfg.SMTPStartTLS,
+		from:       from,
+		to:         to,
+		alert:      alert,
+		digest:     digest,
+		digestAt:   digestAt,
+		linker:     linker,
+		since:      time.Now(),
+		newDevices: make(map[string]notify.Device),
+		rejected:   make(map[string]int),
+		l:          l,
+	}
+
+	// Count rejections as they happen, the event log only keeps the most recent events in memory
+	if events != nil && digestAt != nil {
+		events.Subscribe(n.countRejection)
+	}
+
+	return n, nil
+}
+
+// NotifyNewDevice sends a new device alert and records the device for the digest.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	if n.digestAt != nil {
+		n.mu.Lock()
+		if _, ok := n.newDevices[d.Username]; !ok {
+			n.newDevices[d.Username] = d
+		}
+		n.mu.Unlock()
+	}
+
+	a := Alert{Event: EventNewDevice, Device: d}
+	if n.linker != nil {
+		a.Actions = n.linker.Links(d)
+	}
+
+	n.sendAlert(ctx, a)
+}
+
+// NotifyBlockedAttempt sends a blocked attempt alert.
+func (n *Notifier) NotifyBlockedAttempt(ctx context.Context, d notify.Device) {
+	n.sendAlert(ctx, Alert{Event: EventBlockedAttempt, Device: d})
+}
+
+// NotifyError sends an error alert.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	n.sendAlert(ctx, Alert{Event: EventError, Device: d, Error: err.Error()})
+}
+
+// NotifyChange records changes that involve a VLAN for the digest.
+func (n *Notifier) NotifyChange(_ context.Context, c notify.Change) {
+	if n.digestAt == nil || c.VlanID == "" {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.changes = append(n.changes, c)
+}
+
+// sendAlert renders and sends an alert, logging any error.
+func (n *Notifier) sendAlert(ctx context.Context, a Alert) {
+	subject, body, err := render(n.alert, a)
+	if err == nil {
+		err = n.send(ctx, subject, body)
+	}
+
+	if err != nil {
+		n.l.Error("Error sending email alert", slog.Any("error", err), slog.String("event", string(a.Event)))
+	}
+}
+
+// countRejection records a rejected event for the digest.
+func (n *Notifier) countRejection(e eventlog.Event) {
+	if e.Decision != eventlog.DecisionReject || e.MACAddress == "" {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.rejected[e.MACAddress]++
+}
+
+// topRejected returns the MAC addresses rejected the most in the current digest period.
+func (n *Notifier) topRejected() []RejectedMAC {
+	rejected := make([]RejectedMAC, 0, len(n.rejected))
+	for mac, count := range n.rejected {
+		rejected = append(rejected, RejectedMAC{MACAddress: mac, Count: count})
+	}
+
+	sort.Slice(rejected, func(i, j int) bool {
+		if rejected[i].Count != rejected[j].Count {
+			return rejected[i].Count > rejected[j].Count
+		}
+
+		return rejected[i].MACAddress < rejected[j].MACAddress
+	})
+
+	if len(rejected) > TopRejectedCount {
+		rejected = rejected[:TopRejectedCount]
+	}
+
+	return rejected
+}
+
+// collectDigest returns the digest of the current period and starts a new one.
+func (n *Notifier) collectDigest(until time.Time) Digest {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	d := Digest{
+		Since:      n.since,
+		Until:      until,
+		NewDevices: make([]notify.Device, 0, len(n.newDevices)),
+		Changes:    n.changes,
+	}
+
+	for _, device := range n.newDevices {
+		d.NewDevices = append(d.NewDevices, device)
+	}
+
+	sort.Slice(d.NewDevices, func(i, j int) bool { return d.NewDevices[i].Time.Before(d.NewDevices[j].Time) })
+
+	d.TopRejected = n.topRejected()
+
+	n.since = until
+	n.newDevices = make(map[string]notify.Device)
+	n.changes = nil
+	n.rejected = make(map[string]int)
+
+	return d
+}
+
+// SendDigest sends the digest of everything since the last one. Nothing is sent if there is nothing to report.
+func (n *Notifier) SendDigest(ctx context.Context) error {
+	d := n.collectDigest(time.Now())
+	if d.Empty() {
+		n.l.Debug("Nothing to report, skipping email digest")
+
+		return nil
+	}
+
+	subject, body, err := render(n.digest, d)
+	if err != nil {
+		return err
+	}
+
+	return n.send(ctx, subject, body)
+}
+
+// nextDigest returns the next time the digest should be sent after now.
+func nextDigest(now, at time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+// StartDigest sends the daily digest until the context is canceled. It returns immediately if the digest is disabled.
+func (n *Notifier) StartDigest(ctx context.Context) error {
+	if n.digestAt == nil {
+		return nil
+	}
+
+	for {
+		next := nextDigest(time.Now(), *n.digestAt)
+		n.l.Debug("Scheduled email digest", slog.Time("at", next))
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil
+		case <-timer.C:
+		}
+
+		if err := n.SendDigest(ctx); err != nil {
+			n.l.Error("Error sending email digest", slog.Any("error", err))
+		}
+	}
+}
+
+// render executes the subject and body templates.
+func render(tmpl *template.Template, data any) (string, string, error) {
+	var subject, body bytes.Buffer
+
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", fmt.Errorf("error executing subject template: %w", err)
+	}
+
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", fmt.Errorf("error executing body template: %w", err)
+	}
+
+	// Headers can't span multiple lines
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+// message builds the email with its headers.
+func (n *Notifier) message(subject, body string) []byte {
+	var msg bytes.Buffer
+
+	to := make([]string, len(n.to))
+	for i, addr := range n.to {
+		to[i] = addr.String()
+	}
+
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return msg.Bytes()
+}
+
+// send sends an email to all recipients.
+func (n *Notifier) send(ctx context.Context, subject, body string) error {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+
+		return fmt.Errorf("error creating SMTP client: %w", err)
+	}
+	defer c.Close()
+
+	if n.startTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return ErrStartTLSUnsupported
+		}
+
+		if err := c.StartTLS(&tls.Config{ServerName: n.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+
+	if n.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+
+	if err := c.Mail(n.from.Address); err != nil {
+		return fmt.Errorf("error setting sender: %w", err)
+	}
+
+	for _, to := range n.to {
+		if err := c.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("error setting recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("error starting data: %w", err)
+	}
+
+	if _, err := w.Write(n.message(subject, body)); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+
+	if err := c.Quit(); err != nil {
+		return fmt.Errorf("error closing connection: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/eventlog"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/email"
+	"github.com/maronato/authifi/internal/notify/notifytest"
+)
+
+// message is an email received by the fake SMTP server.
+type message struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP is a minimal in-process SMTP server that records the messages it receives.
+type fakeSMTP struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []message
+	auths    []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting fake SMTP server: %v", err)
+	}
+
+	s := &fakeSMTP{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.handle(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+
+	var current message
+
+	tp.PrintfLine("220 localhost ESMTP fake") //nolint:errcheck // test server
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN") //nolint:errcheck // test server
+		case "AUTH":
+			s.mu.Lock()
+			s.auths = append(s.auths, arg)
+			s.mu.Unlock()
+
+			tp.PrintfLine("235 Authenticated") //nolint:errcheck // test server
+		case "MAIL":
+			current = message{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")}
+
+			tp.PrintfLine("250 OK") //nolint:errcheck // test server
+		case "RCPT":
+			current.to = append(current.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+
+			tp.PrintfLine("250 OK") //nolint:errcheck // test server
+		case "DATA":
+			tp.PrintfLine("354 Go ahead") //nolint:errcheck // test server
+
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			current.data = string(data)
+
+			s.mu.Lock()
+			s.messages = append(s.messages, current)
+			s.mu.Unlock()
+
+			tp.PrintfLine("250 OK") //nolint:errcheck // test server
+		case "QUIT":
+			tp.PrintfLine("221 Bye") //nolint:errcheck // test server
+
+			return
+		default:
+			tp.PrintfLine("250 OK") //nolint:errcheck // test server
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.messages
+}
+
+func (s *fakeSMTP) authentications() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.auths
+}
+
+func (s *fakeSMTP) config(t *testing.T) *config.Config {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+
+	cfg := config.NewConfig()
+	cfg.SMTPHost = host
+	cfg.SMTPPort, _ = strconv.Atoi(port)
+	cfg.SMTPStartTLS = false
+	cfg.EmailFrom = "Authifi <authifi@example.com>"
+	cfg.EmailTo = []string{"admin@example.com", "Other Admin <other@example.com>"}
+
+	return cfg
+}
+
+func newNotifier(t *testing.T, cfg *config.Config, linker notify.Linker, events *eventlog.Log) *email.Notifier {
+	t.Helper()
+
+	n, err := email.NewNotifier(context.Background(), cfg, linker, events)
+	if err != nil {
+		t.Fatalf("error creating notifier: %v", err)
+	}
+
+	return n
+}
+
+func testDevice(username string) notify.Device {
+	return notify.Device{
+		Username:   username,
+		Password:   "secret-password",
+		MACAddress: username,
+		NASAddress: "10.0.0.1",
+		Time:       time.Now(),
+	}
+}
+
+func TestNotifyNewDevice(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTP(t)
+	cfg := server.config(t)
+	cfg.SMTPUsername = "user"
+	cfg.SMTPPassword = "pass"
+
+	linker := notifytest.Linker{{Label: "Block", URL: "https://authifi.example/approve?token=block"}}
+
+	newNotifier(t, cfg, linker, nil).NotifyNewDevice(context.Background(), testDevice("aa:bb:cc:dd:ee:ff"))
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	msg := messages[0]
+
+	if msg.from != "authifi@example.com" {
+		t.Errorf("got sender %q, want %q", msg.from, "authifi@example.com")
+	}
+
+	if len(msg.to) != 2 || msg.to[0] != "admin@example.com" || msg.to[1] != "other@example.com" {
+		t.Errorf("got recipients %v", msg.to)
+	}
+
+	if auths := server.authentications(); len(auths) != 1 || !strings.HasPrefix(auths[0], "PLAIN") {
+		t.Errorf("got auths %v, want one PLAIN auth", auths)
+	}
+
+	for _, want := range []string{"Subject: New device detected: aa:bb:cc:dd:ee:ff", "Block: https://authifi.example/approve?token=block"} {
+		if !strings.Contains(msg.data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg.data)
+		}
+	}
+
+	if strings.Contains(msg.data, "secret-password") {
+		t.Error("password leaked in message")
+	}
+}
+
+func TestNotifyBlockedAttempt(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTP(t)
+
+	newNotifier(t, server.config(t), nil, nil).NotifyBlockedAttempt(context.Background(), testDevice("aa:bb:cc:dd:ee:ff"))
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	if want := "Subject: Blocked device tried to connect: aa:bb:cc:dd:ee:ff"; !strings.Contains(messages[0].data, want) {
+		t.Errorf("message does not contain %q:\n%s", want, messages[0].data)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTP(t)
+	cfg := server.config(t)
+	cfg.EmailDigestTime = "08:00"
+
+	// Rejections are counted as they happen, not read back from the event log that only keeps the last one
+	events := eventlog.NewLog("", 1, 0)
+	n := newNotifier(t, cfg, nil, events)
+	ctx := context.Background()
+
+	// Nothing happened yet, so nothing is sent
+	if err := n.SendDigest(ctx); err != nil {
+		t.Fatalf("error sending empty digest: %v", err)
+	}
+
+	if messages := server.received(); len(messages) != 0 {
+		t.Fatalf("got %d messages for an empty digest, want 0", len(messages))
+	}
+
+	rejections := map[string]int{"11:11:11:11:11:11": 3, "22:22:22:22:22:22": 1}
+	for mac, count := range rejections {
+		for range count {
+			events.Record(eventlog.Event{Time: time.Now(), Username: mac, MACAddress: mac, Decision: eventlog.DecisionReject}) //nolint:errcheck // in-memory log
+		}
+	}
+
+	events.Record(eventlog.Event{Time: time.Now(), Username: "ok", MACAddress: "33:33:33:33:33:33", Decision: eventlog.DecisionAccept}) //nolint:errcheck // in-memory log
+
+	n.NotifyNewDevice(ctx, testDevice("aa:aa:aa:aa:aa:aa"))
+	n.NotifyNewDevice(ctx, testDevice("aa:aa:aa:aa:aa:aa"))
+	n.NotifyChange(ctx, notify.Change{Type: notify.ChangeVLANCreated, VlanID: "10", Description: "IoT", Time: time.Now()})
+	n.NotifyChange(ctx, notify.Change{Type: notify.ChangeUserBlocked, Username: "bb:bb:bb:bb:bb:bb", Time: time.Now()})
+
+	if err := n.SendDigest(ctx); err != nil {
+		t.Fatalf("error sending digest: %v", err)
+	}
+
+	messages := server.received()
+
+	// Two new device alerts and the digest
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+
+	digest := messages[2].data
+
+	for _, want := range []string{
+		"Subject: Authifi daily digest",
+		"New devices (1):",
+		"- aa:aa:aa:aa:aa:aa",
+		"VLAN changes (1):",
+		"vlan_created VLAN 10 (IoT)",
+		"- 11:11:11:11:11:11: 3 rejections\n- 22:22:22:22:22:22: 1 rejections",
+	} {
+		if !strings.Contains(digest, want) {
+			t.Errorf("digest does not contain %q:\n%s", want, digest)
+		}
+	}
+
+	if strings.Contains(digest, "33:33:33:33:33:33") {
+		t.Errorf("digest lists an accepted MAC address:\n%s", digest)
+	}
+
+	// The digest period was reset
+	if err := n.SendDigest(ctx); err != nil {
+		t.Fatalf("error sending second digest: %v", err)
+	}
+
+	if messages := server.received(); len(messages) != 3 {
+		t.Errorf("got %d messages after an empty digest, want 3", len(messages))
+	}
+}
+
+func TestStartTLSRequired(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTP(t)
+	cfg := server.config(t)
+	cfg.SMTPStartTLS = true
+	cfg.EmailDigestTime = "08:00"
+
+	n := newNotifier(t, cfg, nil, nil)
+	n.NotifyChange(context.Background(), notify.Change{Type: notify.ChangeVLANDeleted, VlanID: "10", Time: time.Now()})
+
+	if err := n.SendDigest(context.Background()); !errors.Is(err, email.ErrStartTLSUnsupported) {
+		t.Errorf("got error %v, want %v", err, email.ErrStartTLSUnsupported)
+	}
+
+	if messages := server.received(); len(messages) != 0 {
+		t.Errorf("got %d messages without TLS, want 0", len(messages))
+	}
+}
+
+func TestCustomTemplate(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTP(t)
+	cfg := server.config(t)
+
+	dir := t.TempDir()
+	cfg.EmailAlertTemplate = filepath.Join(dir, "alert.tmpl")
+
+	tmpl := `{{ define "subject" }}[authifi] {{ .Event }}{{ end }}{{ define "body" }}Device {{ .Device.Username }}{{ end }}`
+	if err := os.WriteFile(cfg.EmailAlertTemplate, []byte(tmpl), 0o600); err != nil {
+		t.Fatalf("error writing template: %v", err)
+	}
+
+	newNotifier(t, cfg, nil, nil).NotifyNewDevice(context.Background(), testDevice("aa:bb:cc:dd:ee:ff"))
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	for _, want := range []string{"Subject: [authifi] new_device", "\n\nDevice aa:bb:cc:dd:ee:ff"} {
+		if !strings.Contains(messages[0].data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, messages[0].data)
+		}
+	}
+
+	// Templates must define both a subject and a body
+	if err := os.WriteFile(cfg.EmailAlertTemplate, []byte(`{{ define "subject" }}x{{ end }}`), 0o600); err != nil {
+		t.Fatalf("error writing template: %v", err)
+	}
+
+	if _, err := email.NewNotifier(context.Background(), cfg, nil, nil); err == nil {
+		t.Error("expected an error for a template without a body")
+	}
+}
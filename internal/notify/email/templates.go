@@ -0,0 +1,55 @@
+package email
+
+// defaultAlertTemplate is used for immediate alerts when no template is configured.
+// It must define a "subject" and a "body" template.
+const defaultAlertTemplate = `{{ define "subject" -}}
+{{ if eq .Event "new_device" }}New device detected: {{ .Device.Username }}
+{{- else if eq .Event "blocked_attempt" }}Blocked device tried to connect: {{ .Device.Username }}
+{{- else }}Error handling device: {{ .Device.Username }}{{ end }}
+{{- end }}
+{{ define "body" -}}
+Username:    {{ .Device.Username }}
+MAC Address: {{ .Device.MACAddress }}
+NAS:         {{ .Device.NASAddress }}
+Time:        {{ .Device.Time.Format "2006-01-02 15:04:05 MST" }}
+{{- if .Error }}
+Error:       {{ .Error }}
+{{- end }}
+{{- if .Actions }}
+
+Actions:
+{{- range .Actions }}
+- {{ .Label }}: {{ .URL }}
+{{- end }}
+{{- end }}
+{{ end }}`
+
+// defaultDigestTemplate is used for the daily digest when no template is configured.
+// It must define a "subject" and a "body" template.
+const defaultDigestTemplate = `{{ define "subject" -}}
+Authifi daily digest for {{ .Until.Format "2006-01-02" }}
+{{- end }}
+{{ define "body" -}}
+Activity from {{ .Since.Format "2006-01-02 15:04" }} to {{ .Until.Format "2006-01-02 15:04 MST" }}.
+
+New devices ({{ len .NewDevices }}):
+{{- range .NewDevices }}
+- {{ .Username }} ({{ .MACAddress }}) via {{ .NASAddress }}
+{{- else }}
+- None
+{{- end }}
+
+VLAN changes ({{ len .Changes }}):
+{{- range .Changes }}
+- {{ .Time.Format "15:04" }} {{ .Type }}{{ with .Username }} {{ . }}{{ end }}{{ with .Group }} group {{ . }}{{ end }}{{ with .VlanID }} VLAN {{ . }}{{ end }}{{ with .Description }} ({{ . }}){{ end }}
+{{- else }}
+- None
+{{- end }}
+
+Top rejected MAC addresses:
+{{- range .TopRejected }}
+- {{ .MACAddress }}: {{ .Count }} rejections
+{{- else }}
+- None
+{{- end }}
+{{ end }}`
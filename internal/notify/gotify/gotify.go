@@ -0,0 +1,113 @@
+package gotify
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+const (
+	// RequestTimeout is the timeout for each message request.
+	RequestTimeout = 10 * time.Second
+	// TokenHeader is the header that holds the application token.
+	TokenHeader = "X-Gotify-Key"
+	// MessagePath is the path of the message endpoint.
+	MessagePath = "/message"
+	// errorPriority is the priority of error messages.
+	errorPriority = 5
+)
+
+// Message is the JSON body sent to Gotify.
+type Message struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+// Notifier is a notify.Notifier that sends messages to a Gotify server.
+type Notifier struct {
+	// Base logs blocked attempts and administrative changes.
+	notify.Base
+	// serverURL is the base URL of the Gotify server.
+	serverURL string
+	// token is the application token.
+	token string
+	// priority is the priority of new device messages.
+	priority int
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// client is the HTTP client.
+	client *http.Client
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewNotifier creates a new Notifier. The linker is optional and adds approval links to new device messages.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker) *Notifier {
+	l := logging.FromCtx(ctx)
+
+	l.Debug("Gotify setup complete", slog.String("server", cfg.GotifyURL), slog.Int("priority", cfg.GotifyPriority))
+
+	return &Notifier{
+		Base:      notify.NewBase(l),
+		serverURL: strings.TrimSuffix(cfg.GotifyURL, "/"),
+		token:     cfg.GotifyToken,
+		priority:  cfg.GotifyPriority,
+		linker:    linker,
+		client:    &http.Client{Timeout: RequestTimeout},
+		l:         l,
+	}
+}
+
+// markdownExtras makes Gotify clients render the message as Markdown.
+func markdownExtras() map[string]any {
+	return map[string]any{
+		"client::display": map[string]any{"contentType": "text/markdown"},
+	}
+}
+
+// NotifyNewDevice sends a new device alert. Gotify has no action buttons, so the
+// approval links are added to the message as Markdown links.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	m := notify.NewDeviceMessage(d)
+
+	if n.linker != nil {
+		m.Actions = n.linker.Links(d)
+	}
+
+	n.send(ctx, m, n.priority)
+}
+
+// NotifyError sends an error message.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	n.send(ctx, notify.ErrorMessage(d, err), errorPriority)
+}
+
+// send sends a message to the Gotify server and logs any error.
+func (n *Notifier) send(ctx context.Context, m notify.Message, priority int) {
+	msg := Message{
+		Title:    m.Title,
+		Message:  m.RenderBody(notify.Markdown),
+		Priority: priority,
+		Extras:   markdownExtras(),
+	}
+
+	if err := n.post(ctx, msg); err != nil {
+		n.l.Error("Error sending Gotify message", slog.Any("error", err))
+	}
+}
+
+// post sends a message as JSON to the message endpoint.
+func (n *Notifier) post(ctx context.Context, msg Message) error {
+	headers := http.Header{}
+	headers.Set(TokenHeader, n.token)
+
+	return notify.SendJSON(ctx, n.client, http.MethodPost, n.serverURL+MessagePath, headers, msg)
+}
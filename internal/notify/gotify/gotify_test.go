@@ -0,0 +1,139 @@
+package gotify_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/gotify"
+	"github.com/maronato/authifi/internal/notify/notifytest"
+)
+
+const testToken = "app-token"
+
+// recorder is an httptest handler that stands in for the Gotify message endpoint.
+type recorder struct {
+	mu       sync.Mutex
+	messages []gotify.Message
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != gotify.MessagePath {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if r.Header.Get(gotify.TokenHeader) != testToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	var msg gotify.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.messages = append(rec.messages, msg)
+}
+
+func (rec *recorder) requests() []gotify.Message {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.messages
+}
+
+func newTestServer(t *testing.T) (*recorder, *config.Config) {
+	t.Helper()
+
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	cfg := config.NewConfig()
+	cfg.GotifyURL = server.URL + "/"
+	cfg.GotifyToken = testToken
+
+	return rec, cfg
+}
+
+var testDevice = notify.Device{ //nolint:gochecknoglobals // test fixture
+	Username:   "aa:bb:cc:dd:ee:ff",
+	Password:   "secret-password",
+	MACAddress: "aa:bb:cc:dd:ee:ff",
+	NASAddress: "10.0.0.1",
+}
+
+func TestNotifyNewDevice(t *testing.T) {
+	t.Parallel()
+
+	rec, cfg := newTestServer(t)
+
+	linker := notifytest.Linker{
+		{Label: "Add to Main", URL: "https://authifi.example/approve?token=main"},
+		{Label: "Block", URL: "https://authifi.example/approve?token=block"},
+	}
+
+	gotify.NewNotifier(context.Background(), cfg, linker).NotifyNewDevice(context.Background(), testDevice)
+
+	messages := rec.requests()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	msg := messages[0]
+
+	if msg.Priority != config.DefaultGotifyPriority {
+		t.Errorf("got priority %d, want %d", msg.Priority, config.DefaultGotifyPriority)
+	}
+
+	if !strings.Contains(msg.Message, testDevice.Username) {
+		t.Errorf("message %q does not contain the username", msg.Message)
+	}
+
+	if strings.Contains(msg.Message, testDevice.Password) {
+		t.Error("password leaked in message")
+	}
+
+	for _, link := range linker {
+		if want := "[" + link.Label + "](" + link.URL + ")"; !strings.Contains(msg.Message, want) {
+			t.Errorf("message %q does not contain link %q", msg.Message, want)
+		}
+	}
+
+	display, _ := msg.Extras["client::display"].(map[string]any)
+	if display["contentType"] != "text/markdown" {
+		t.Errorf("got extras %v, want markdown content type", msg.Extras)
+	}
+}
+
+func TestNotifyError(t *testing.T) {
+	t.Parallel()
+
+	rec, cfg := newTestServer(t)
+
+	gotify.NewNotifier(context.Background(), cfg, nil).NotifyError(context.Background(), testDevice, errors.New("boom"))
+
+	messages := rec.requests()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	if !strings.Contains(messages[0].Message, "boom") {
+		t.Errorf("message %q does not contain the error", messages[0].Message)
+	}
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// jsonContentType is the Content-Type of JSON bodies.
+const jsonContentType = "application/json"
+
+// ErrUnexpectedStatus is returned when a server responds with a non-2xx status code.
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// StatusError is returned when a server responds with a non-2xx status code. It wraps ErrUnexpectedStatus.
+type StatusError struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+}
+
+// Error returns the error message.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d", ErrUnexpectedStatus, e.StatusCode)
+}
+
+// Unwrap returns ErrUnexpectedStatus.
+func (e *StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// Retryable reports whether a request that failed with err may succeed if it's sent again. Only
+// network errors, rate limits, and server errors are retryable.
+func Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var urlErr *url.Error
+
+	return errors.As(err, &urlErr) && urlErr.Op != "parse"
+}
+
+// SendJSON encodes body as JSON and sends it with the given method and headers.
+// It's shared by the notifiers that talk to HTTP APIs.
+func SendJSON(ctx context.Context, client *http.Client, method, url string, headers http.Header, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error encoding body: %w", err)
+	}
+
+	return Send(ctx, client, method, url, headers, b)
+}
+
+// Send sends an already encoded body with the given method and headers. The body is sent as JSON
+// unless the headers set another Content-Type.
+func Send(ctx context.Context, client *http.Client, method, url string, headers http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", jsonContentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck // best effort
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
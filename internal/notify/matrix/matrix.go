@@ -0,0 +1,107 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+const (
+	// RequestTimeout is the timeout for each request.
+	RequestTimeout = 10 * time.Second
+	// HTMLFormat is the format of the formatted body.
+	HTMLFormat = "org.matrix.custom.html"
+)
+
+// Event is the m.room.message event sent to the room.
+type Event struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"` //nolint:tagliatelle // Matrix API
+}
+
+// Notifier is a notify.Notifier that sends messages to a Matrix room through the client-server API.
+type Notifier struct {
+	// Base logs blocked attempts and administrative changes.
+	notify.Base
+	// homeserverURL is the base URL of the homeserver.
+	homeserverURL string
+	// accessToken is the access token of the bot user.
+	accessToken string
+	// roomID is the room the messages are sent to.
+	roomID string
+	// txnPrefix and txnCounter create unique transaction IDs.
+	txnPrefix  string
+	txnCounter atomic.Uint64
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// client is the HTTP client.
+	client *http.Client
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewNotifier creates a new Notifier. The linker is optional and adds approval links to new device messages.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker) *Notifier {
+	l := logging.FromCtx(ctx)
+
+	l.Debug("Matrix setup complete", slog.String("homeserver", cfg.MatrixHomeserverURL), slog.String("room", cfg.MatrixRoomID))
+
+	return &Notifier{
+		Base:          notify.NewBase(l),
+		homeserverURL: strings.TrimSuffix(cfg.MatrixHomeserverURL, "/"),
+		accessToken:   cfg.MatrixAccessToken,
+		roomID:        cfg.MatrixRoomID,
+		// Transaction IDs must be unique per access token, even across restarts
+		txnPrefix: fmt.Sprintf("authifi-%d-", time.Now().UnixNano()),
+		linker:    linker,
+		client:    &http.Client{Timeout: RequestTimeout},
+		l:         l,
+	}
+}
+
+// NotifyNewDevice sends a new device alert with the approval links.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	m := notify.NewDeviceMessage(d)
+
+	if n.linker != nil {
+		m.Actions = n.linker.Links(d)
+	}
+
+	n.send(ctx, m)
+}
+
+// NotifyError sends an error message.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	n.send(ctx, notify.ErrorMessage(d, err))
+}
+
+// send sends a message to the room and logs any error.
+func (n *Notifier) send(ctx context.Context, m notify.Message) {
+	event := Event{
+		MsgType:       "m.text",
+		Body:          m.Render(notify.PlainText),
+		Format:        HTMLFormat,
+		FormattedBody: m.Render(notify.HTML),
+	}
+
+	txnID := n.txnPrefix + fmt.Sprint(n.txnCounter.Add(1))
+	endpoint := n.homeserverURL + "/_matrix/client/v3/rooms/" + url.PathEscape(n.roomID) + "/send/m.room.message/" + url.PathEscape(txnID)
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer "+n.accessToken)
+
+	if err := notify.SendJSON(ctx, n.client, http.MethodPut, endpoint, headers, event); err != nil {
+		n.l.Error("Error sending Matrix message", slog.Any("error", err), slog.String("room", n.roomID))
+	}
+}
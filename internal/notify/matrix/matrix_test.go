@@ -0,0 +1,84 @@
+package matrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/matrix"
+)
+
+func TestNotifyNewDevice(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		paths  []string
+		events []matrix.Event
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer matrix-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		var e matrix.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		paths = append(paths, r.URL.EscapedPath())
+		events = append(events, e)
+
+		w.Write([]byte(`{"event_id": "$event"}`)) //nolint:errcheck // test server
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := config.NewConfig()
+	cfg.MatrixHomeserverURL = server.URL
+	cfg.MatrixAccessToken = "matrix-token"
+	cfg.MatrixRoomID = "!room:example.com"
+
+	n := matrix.NewNotifier(context.Background(), cfg, nil)
+	d := notify.Device{Username: "aa:bb:cc:dd:ee:ff", MACAddress: "aa:bb:cc:dd:ee:ff"}
+
+	n.NotifyNewDevice(context.Background(), d)
+	n.NotifyNewDevice(context.Background(), d)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+
+	prefix := "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/"
+	if !strings.HasPrefix(paths[0], prefix) {
+		t.Errorf("got path %q, want prefix %q", paths[0], prefix)
+	}
+
+	if paths[0] == paths[1] {
+		t.Errorf("transaction IDs are not unique: %q", paths[0])
+	}
+
+	if events[0].Format != matrix.HTMLFormat || !strings.Contains(events[0].FormattedBody, "<code>aa:bb:cc:dd:ee:ff</code>") {
+		t.Errorf("got formatted body %q", events[0].FormattedBody)
+	}
+
+	if !strings.Contains(events[0].Body, "Username: aa:bb:cc:dd:ee:ff") {
+		t.Errorf("got body %q", events[0].Body)
+	}
+}
@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"html"
+	"strings"
+	"time"
+)
+
+// Field is a labeled value shown in a Message.
+type Field struct {
+	// Name is the label of the field.
+	Name string
+	// Value is the value of the field. It's rendered as code.
+	Value string
+}
+
+// Message is a backend-agnostic notification. Notifiers build it with the
+// constructors below and render it with the Format of their backend, so the
+// text is the same everywhere.
+type Message struct {
+	// Title is the headline of the message.
+	Title string
+	// Fields are the details of the message.
+	Fields []Field
+	// Actions are rendered as links after the fields.
+	Actions []Action
+	// Footer is an optional closing line.
+	Footer string
+}
+
+// NewDeviceMessage creates the message sent when an unknown device tries to connect.
+func NewDeviceMessage(d Device) Message {
+	m := Message{
+		Title: "🚨 New Device Detected! 🚨",
+		Fields: []Field{
+			{Name: "Username", Value: d.Username},
+			{Name: "Mac Address", Value: d.MACAddress},
+		},
+	}
+
+	if d.NASAddress != "" {
+		m.Fields = append(m.Fields, Field{Name: "NAS", Value: d.NASAddress})
+	}
+
+	if !d.Time.IsZero() {
+		m.Fields = append(m.Fields, Field{Name: "Connection time", Value: d.Time.Format(time.RFC1123)})
+	}
+
+	return m
+}
+
+// ErrorMessage creates the message sent when a device could not be handled.
+func ErrorMessage(d Device, err error) Message {
+	return Message{
+		Title: "⚠️ Error Handling Device ⚠️",
+		Fields: []Field{
+			{Name: "Username", Value: d.Username},
+			{Name: "Mac Address", Value: d.MACAddress},
+			{Name: "Error", Value: err.Error()},
+		},
+	}
+}
+
+// ExpiryMessage creates the message sent when a guest user or a temporary VLAN expires.
+func ExpiryMessage(c Change) Message {
+	m := Message{Title: "⌛ Guest Access Expired ⌛", Fields: []Field{{Name: "Username", Value: c.Username}}}
+	if c.Type == ChangeTempVLANExpired {
+		m.Title = "⌛ Temporary Network Expired ⌛"
+	}
+
+	if c.Description != "" {
+		m.Fields = append(m.Fields, Field{Name: "Name", Value: c.Description})
+	}
+
+	if c.Type == ChangeTempVLANExpired && c.VlanID != "" {
+		m.Fields = append(m.Fields, Field{Name: "Back on VLAN", Value: c.VlanID})
+	}
+
+	return m
+}
+
+// Format describes the markup of a backend.
+type Format struct {
+	// Bold makes already escaped text bold.
+	Bold func(s string) string
+	// Code renders raw text as inline code.
+	Code func(s string) string
+	// Link renders a raw label and URL as a link.
+	Link func(label, url string) string
+	// Escape escapes raw text.
+	Escape func(s string) string
+	// LineBreak separates lines.
+	LineBreak string
+}
+
+// identity returns s unchanged.
+func identity(s string) string { return s }
+
+//nolint:gochecknoglobals // formats are shared by the notifiers
+var (
+	// Markdown renders messages as CommonMark.
+	Markdown = Format{
+		Bold:      func(s string) string { return "**" + s + "**" },
+		Code:      func(s string) string { return "`" + strings.ReplaceAll(s, "`", "'") + "`" },
+		Link:      func(label, url string) string { return "[" + label + "](" + url + ")" },
+		Escape:    identity,
+		LineBreak: "  \n",
+	}
+
+	// PlainText renders messages without markup.
+	PlainText = Format{
+		Bold:      identity,
+		Code:      identity,
+		Link:      func(label, url string) string { return label + ": " + url },
+		Escape:    identity,
+		LineBreak: "\n",
+	}
+
+	// HTML renders messages as HTML.
+	HTML = Format{
+		Bold: func(s string) string { return "<b>" + s + "</b>" },
+		Code: func(s string) string { return "<code>" + html.EscapeString(s) + "</code>" },
+		Link: func(label, url string) string {
+			return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(label) + "</a>"
+		},
+		Escape:    html.EscapeString,
+		LineBreak: "<br>",
+	}
+)
+
+// Render renders the title and the body of the message with a format.
+func (m Message) Render(f Format) string {
+	return f.Bold(f.Escape(m.Title)) + f.LineBreak + f.LineBreak + m.RenderBody(f)
+}
+
+// RenderBody renders the message without its title, for backends that show the title separately.
+func (m Message) RenderBody(f Format) string {
+	lines := make([]string, 0, len(m.Fields))
+
+	for _, field := range m.Fields {
+		lines = append(lines, f.Bold(f.Escape(field.Name+":"))+" "+f.Code(field.Value))
+	}
+
+	if len(m.Actions) > 0 {
+		lines = append(lines, "")
+
+		for _, a := range m.Actions {
+			lines = append(lines, "- "+f.Link(a.Label, a.URL))
+		}
+	}
+
+	if m.Footer != "" {
+		lines = append(lines, "", f.Escape(m.Footer))
+	}
+
+	return strings.Join(lines, f.LineBreak)
+}
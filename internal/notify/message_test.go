@@ -0,0 +1,77 @@
+package notify_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/notify"
+)
+
+func TestMessageRender(t *testing.T) {
+	t.Parallel()
+
+	d := notify.Device{
+		Username:   "aa:bb:cc:dd:ee:ff",
+		Password:   "secret-password",
+		MACAddress: "aa:bb:cc:dd:ee:ff",
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	m := notify.NewDeviceMessage(d)
+	m.Actions = []notify.Action{{Label: "Block <now>", URL: "https://authifi.example/approve?a=1&b=2"}}
+
+	tests := []struct {
+		name   string
+		msg    notify.Message
+		format notify.Format
+		want   string
+	}{
+		{
+			name:   "markdown",
+			msg:    m,
+			format: notify.Markdown,
+			want: "**🚨 New Device Detected! 🚨**  \n  \n" +
+				"**Username:** `aa:bb:cc:dd:ee:ff`  \n" +
+				"**Mac Address:** `aa:bb:cc:dd:ee:ff`  \n" +
+				"**Connection time:** `Tue, 02 Jan 2024 03:04:05 UTC`  \n  \n" +
+				"- [Block <now>](https://authifi.example/approve?a=1&b=2)",
+		},
+		{
+			name:   "plain text",
+			msg:    notify.ErrorMessage(d, errors.New("boom")),
+			format: notify.PlainText,
+			want: "⚠️ Error Handling Device ⚠️\n\n" +
+				"Username: aa:bb:cc:dd:ee:ff\n" +
+				"Mac Address: aa:bb:cc:dd:ee:ff\n" +
+				"Error: boom",
+		},
+		{
+			name:   "temporary VLAN expired",
+			msg:    notify.ExpiryMessage(notify.Change{Type: notify.ChangeTempVLANExpired, Username: "laptop", VlanID: "30", Description: "Work laptop"}),
+			format: notify.PlainText,
+			want: "⌛ Temporary Network Expired ⌛\n\n" +
+				"Username: laptop\n" +
+				"Name: Work laptop\n" +
+				"Back on VLAN: 30",
+		},
+		{
+			name:   "html escapes values",
+			msg:    notify.Message{Title: "<b>", Fields: []notify.Field{{Name: "A&B", Value: "<script>"}}, Actions: m.Actions},
+			format: notify.HTML,
+			want: "<b>&lt;b&gt;</b><br><br>" +
+				"<b>A&amp;B:</b> <code>&lt;script&gt;</code><br><br>" +
+				`- <a href="https://authifi.example/approve?a=1&amp;b=2">Block &lt;now&gt;</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.msg.Render(tt.format); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
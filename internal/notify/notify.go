@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Device holds the information about a device that tried to connect to the network.
+type Device struct {
+	// Username is the username of the device.
+	Username string `json:"username"`
+	// Password is the password of the device. It's never serialized.
+	Password string `json:"-"`
+	// MACAddress is the MAC address of the device.
+	MACAddress string `json:"macAddress"`
+	// NASAddress is the address of the NAS that authenticated the device.
+	NASAddress string `json:"nasAddress,omitempty"`
+	// CalledStationID is the Called-Station-Id of the request, usually the AP MAC and SSID.
+	CalledStationID string `json:"calledStationId,omitempty"`
+	// Time is when the device tried to connect.
+	Time time.Time `json:"time"`
+}
+
+// ChangeType is the type of an administrative change.
+type ChangeType string
+
+const (
+	// ChangeUserCreated is used when a user is created.
+	ChangeUserCreated ChangeType = "user_created"
+	// ChangeUserUpdated is used when a user is updated.
+	ChangeUserUpdated ChangeType = "user_updated"
+	// ChangeUserDeleted is used when a user is deleted.
+	ChangeUserDeleted ChangeType = "user_deleted"
+	// ChangeUserBlocked is used when a user is blocked.
+	ChangeUserBlocked ChangeType = "user_blocked"
+	// ChangeUserUnblocked is used when a user is unblocked.
+	ChangeUserUnblocked ChangeType = "user_unblocked"
+	// ChangeVLANCreated is used when a VLAN is created.
+	ChangeVLANCreated ChangeType = "vlan_created"
+	// ChangeVLANUpdated is used when a VLAN is updated.
+	ChangeVLANUpdated ChangeType = "vlan_updated"
+	// ChangeVLANDeleted is used when a VLAN is deleted.
+	ChangeVLANDeleted ChangeType = "vlan_deleted"
+	// ChangeGroupCreated is used when a group is created.
+	ChangeGroupCreated ChangeType = "group_created"
+	// ChangeGroupUpdated is used when a group is updated.
+	ChangeGroupUpdated ChangeType = "group_updated"
+	// ChangeGroupDeleted is used when a group is deleted.
+	ChangeGroupDeleted ChangeType = "group_deleted"
+	// ChangeUserExpired is used when a guest user is deleted because it expired.
+	ChangeUserExpired ChangeType = "user_expired"
+	// ChangeTempVLANExpired is used when a temporary VLAN expires and the user goes back to its own VLAN.
+	ChangeTempVLANExpired ChangeType = "temp_vlan_expired"
+)
+
+// Change is an administrative change to the database.
+type Change struct {
+	// Type is the type of the change.
+	Type ChangeType `json:"type"`
+	// Username is the username of the changed user, if any.
+	Username string `json:"username,omitempty"`
+	// VlanID is the ID of the changed VLAN, or the VLAN of the changed user or group.
+	VlanID string `json:"vlan,omitempty"`
+	// Group is the name of the changed group, or the group of the changed user.
+	Group string `json:"group,omitempty"`
+	// Description is the description of the changed user or group, or the name of the changed VLAN.
+	Description string `json:"description,omitempty"`
+	// Time is when the change happened.
+	Time time.Time `json:"time"`
+}
+
+// Action is a one-tap action that can be attached to a new device notification.
+type Action struct {
+	// Label is the text shown to the user.
+	Label string `json:"label"`
+	// URL performs the action when opened or POSTed to.
+	URL string `json:"url"`
+}
+
+// Linker creates one-tap actions for new devices.
+type Linker interface {
+	// Links returns the actions available for a new device.
+	Links(d Device) []Action
+}
+
+// Notifier is the interface that wraps the notifications sent by Authifi.
+//
+// Implementations must be safe for concurrent use.
+type Notifier interface {
+	// NotifyNewDevice is called when an unknown device tries to connect.
+	NotifyNewDevice(ctx context.Context, d Device)
+	// NotifyBlockedAttempt is called when a blocked device tries to connect.
+	NotifyBlockedAttempt(ctx context.Context, d Device)
+	// NotifyError is called when an error prevents a device from being handled.
+	NotifyError(ctx context.Context, d Device, err error)
+	// NotifyChange is called when an administrative change is made to the database.
+	NotifyChange(ctx context.Context, c Change)
+}
+
+// Multi is a Notifier that fans out notifications to multiple notifiers.
+type Multi struct {
+	notifiers []Notifier
+}
+
+// NewMulti creates a new Multi with the given notifiers.
+func NewMulti(notifiers ...Notifier) *Multi {
+	return &Multi{notifiers: notifiers}
+}
+
+// Add adds a notifier to the Multi.
+func (m *Multi) Add(n Notifier) {
+	m.notifiers = append(m.notifiers, n)
+}
+
+// Len returns the number of notifiers.
+func (m *Multi) Len() int {
+	return len(m.notifiers)
+}
+
+// each calls fn for every notifier concurrently and waits for all of them to return.
+func (m *Multi) each(fn func(n Notifier)) {
+	var wg sync.WaitGroup
+
+	for _, n := range m.notifiers {
+		wg.Add(1)
+
+		go func(n Notifier) {
+			defer wg.Done()
+
+			fn(n)
+		}(n)
+	}
+
+	wg.Wait()
+}
+
+// NotifyNewDevice notifies all the notifiers of a new device.
+func (m *Multi) NotifyNewDevice(ctx context.Context, d Device) {
+	m.each(func(n Notifier) { n.NotifyNewDevice(ctx, d) })
+}
+
+// NotifyBlockedAttempt notifies all the notifiers of a blocked attempt.
+func (m *Multi) NotifyBlockedAttempt(ctx context.Context, d Device) {
+	m.each(func(n Notifier) { n.NotifyBlockedAttempt(ctx, d) })
+}
+
+// NotifyError notifies all the notifiers of an error.
+func (m *Multi) NotifyError(ctx context.Context, d Device, err error) {
+	m.each(func(n Notifier) { n.NotifyError(ctx, d, err) })
+}
+
+// NotifyChange notifies all the notifiers of an administrative change.
+func (m *Multi) NotifyChange(ctx context.Context, c Change) {
+	m.each(func(n Notifier) { n.NotifyChange(ctx, c) })
+}
+
+// NotifyRepeat notifies the notifiers that implement RepeatNotifier of a repeated attempt.
+func (m *Multi) NotifyRepeat(ctx context.Context, d Device, count int) {
+	m.each(func(n Notifier) {
+		if rn, ok := n.(RepeatNotifier); ok {
+			rn.NotifyRepeat(ctx, d, count)
+		}
+	})
+}
@@ -0,0 +1,12 @@
+// Package notifytest provides utilities for testing notifiers.
+package notifytest
+
+import "github.com/maronato/authifi/internal/notify"
+
+// Linker is a notify.Linker that returns a fixed list of links.
+type Linker []notify.Action
+
+// Links returns the links.
+func (f Linker) Links(notify.Device) []notify.Action {
+	return f
+}
@@ -0,0 +1,156 @@
+package ntfy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+const (
+	// RequestTimeout is the timeout for each publish request.
+	RequestTimeout = 10 * time.Second
+	// MaxActions is the maximum number of actions ntfy accepts per message.
+	MaxActions = 3
+	// DefaultTag is the tag of new device messages if none is configured.
+	DefaultTag = "rotating_light"
+	// errorPriority is the priority of error messages.
+	errorPriority = 3
+)
+
+// Action is an ntfy action button.
+type Action struct {
+	Action string `json:"action"`
+	Label  string `json:"label"`
+	URL    string `json:"url"`
+	Method string `json:"method,omitempty"`
+	Clear  bool   `json:"clear,omitempty"`
+}
+
+// Message is the JSON body published to ntfy.
+type Message struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Markdown bool     `json:"markdown,omitempty"`
+	Actions  []Action `json:"actions,omitempty"`
+}
+
+// Notifier is a notify.Notifier that publishes to an ntfy topic.
+type Notifier struct {
+	// Base logs blocked attempts and administrative changes.
+	notify.Base
+	// serverURL is the base URL of the ntfy server.
+	serverURL string
+	// topic is the topic to publish to.
+	topic string
+	// token is the optional access token.
+	token string
+	// priority is the priority of new device messages.
+	priority int
+	// tags are the tags of new device messages.
+	tags []string
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// client is the HTTP client.
+	client *http.Client
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewNotifier creates a new Notifier. The linker is optional and adds action buttons to new device messages.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker) *Notifier {
+	l := logging.FromCtx(ctx)
+
+	tags := cfg.NtfyTags
+	if len(tags) == 0 {
+		tags = []string{DefaultTag}
+	}
+
+	l.Debug("ntfy setup complete", slog.String("server", cfg.NtfyURL), slog.String("topic", cfg.NtfyTopic), slog.Int("priority", cfg.NtfyPriority))
+
+	return &Notifier{
+		Base:      notify.NewBase(l),
+		serverURL: strings.TrimSuffix(cfg.NtfyURL, "/"),
+		topic:     cfg.NtfyTopic,
+		token:     cfg.NtfyToken,
+		priority:  cfg.NtfyPriority,
+		tags:      tags,
+		linker:    linker,
+		client:    &http.Client{Timeout: RequestTimeout},
+		l:         l,
+	}
+}
+
+// buildActions converts approval links into ntfy HTTP actions. Since ntfy limits the number
+// of actions, the last one (block) is always kept and the rest are filled with the first ones.
+func buildActions(links []notify.Action) []Action {
+	if len(links) > MaxActions {
+		links = append(links[:MaxActions-1:MaxActions-1], links[len(links)-1])
+	}
+
+	actions := make([]Action, len(links))
+	for i, link := range links {
+		actions[i] = Action{Action: "http", Label: link.Label, URL: link.URL, Method: http.MethodPost, Clear: true}
+	}
+
+	return actions
+}
+
+// NotifyNewDevice publishes a new device alert with action buttons.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	m := notify.NewDeviceMessage(d)
+
+	msg := Message{
+		Topic:    n.topic,
+		Title:    m.Title,
+		Message:  m.RenderBody(notify.Markdown),
+		Priority: n.priority,
+		Tags:     n.tags,
+		Markdown: true,
+	}
+
+	if n.linker != nil {
+		msg.Actions = buildActions(n.linker.Links(d))
+	}
+
+	n.publish(ctx, msg)
+}
+
+// NotifyError publishes an error message.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	m := notify.ErrorMessage(d, err)
+
+	n.publish(ctx, Message{
+		Topic:    n.topic,
+		Title:    m.Title,
+		Message:  m.RenderBody(notify.Markdown),
+		Priority: errorPriority,
+		Tags:     []string{"warning"},
+		Markdown: true,
+	})
+}
+
+// publish sends a message to the ntfy server.
+func (n *Notifier) publish(ctx context.Context, msg Message) {
+	if err := n.post(ctx, msg); err != nil {
+		n.l.Error("Error publishing to ntfy", slog.Any("error", err), slog.String("topic", n.topic))
+	}
+}
+
+// post sends a message as JSON to the root of the ntfy server.
+func (n *Notifier) post(ctx context.Context, msg Message) error {
+	headers := http.Header{}
+	if n.token != "" {
+		headers.Set("Authorization", "Bearer "+n.token)
+	}
+
+	return notify.SendJSON(ctx, n.client, http.MethodPost, n.serverURL, headers, msg)
+}
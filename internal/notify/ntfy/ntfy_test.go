@@ -0,0 +1,163 @@
+package ntfy_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/notifytest"
+	"github.com/maronato/authifi/internal/notify/ntfy"
+)
+
+// recorder is an httptest handler that records the published messages.
+type recorder struct {
+	mu       sync.Mutex
+	messages []ntfy.Message
+	headers  []http.Header
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg ntfy.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.messages = append(rec.messages, msg)
+	rec.headers = append(rec.headers, r.Header.Clone())
+}
+
+func (rec *recorder) requests() ([]ntfy.Message, []http.Header) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.messages, rec.headers
+}
+
+func newTestServer(t *testing.T) (*recorder, *config.Config) {
+	t.Helper()
+
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	cfg := config.NewConfig()
+	cfg.NtfyURL = server.URL
+	cfg.NtfyTopic = "authifi"
+
+	return rec, cfg
+}
+
+var testDevice = notify.Device{ //nolint:gochecknoglobals // test fixture
+	Username:   "aa:bb:cc:dd:ee:ff",
+	Password:   "secret-password",
+	MACAddress: "aa:bb:cc:dd:ee:ff",
+	NASAddress: "10.0.0.1",
+}
+
+func TestNotifyNewDevice(t *testing.T) {
+	t.Parallel()
+
+	rec, cfg := newTestServer(t)
+	cfg.NtfyToken = "tk_test"
+	cfg.NtfyPriority = 5
+	cfg.NtfyTags = []string{"computer", "warning"}
+
+	linker := notifytest.Linker{
+		{Label: "Add to Main", URL: "https://authifi.example/approve?token=main"},
+		{Label: "Add to Guest", URL: "https://authifi.example/approve?token=guest"},
+		{Label: "Add to IoT", URL: "https://authifi.example/approve?token=iot"},
+		{Label: "Ignore", URL: "https://authifi.example/approve?token=ignore"},
+		{Label: "Block", URL: "https://authifi.example/approve?token=block"},
+	}
+
+	ntfy.NewNotifier(context.Background(), cfg, linker).NotifyNewDevice(context.Background(), testDevice)
+
+	messages, headers := rec.requests()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	msg := messages[0]
+
+	if msg.Topic != cfg.NtfyTopic {
+		t.Errorf("got topic %q, want %q", msg.Topic, cfg.NtfyTopic)
+	}
+
+	if msg.Priority != cfg.NtfyPriority {
+		t.Errorf("got priority %d, want %d", msg.Priority, cfg.NtfyPriority)
+	}
+
+	if len(msg.Tags) != 2 || msg.Tags[0] != "computer" || msg.Tags[1] != "warning" {
+		t.Errorf("got tags %v, want %v", msg.Tags, cfg.NtfyTags)
+	}
+
+	if got := headers[0].Get("Authorization"); got != "Bearer tk_test" {
+		t.Errorf("got authorization %q, want %q", got, "Bearer tk_test")
+	}
+
+	wantLabels := []string{"Add to Main", "Add to Guest", "Block"}
+	if len(msg.Actions) != len(wantLabels) {
+		t.Fatalf("got %d actions, want %d", len(msg.Actions), len(wantLabels))
+	}
+
+	for i, action := range msg.Actions {
+		if action.Label != wantLabels[i] {
+			t.Errorf("got action %d label %q, want %q", i, action.Label, wantLabels[i])
+		}
+
+		if action.Action != "http" || action.Method != http.MethodPost {
+			t.Errorf("got action %d %s %s, want http POST", i, action.Action, action.Method)
+		}
+	}
+}
+
+func TestNotifyNewDeviceDefaults(t *testing.T) {
+	t.Parallel()
+
+	rec, cfg := newTestServer(t)
+
+	ntfy.NewNotifier(context.Background(), cfg, nil).NotifyNewDevice(context.Background(), testDevice)
+
+	messages, headers := rec.requests()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	if messages[0].Priority != config.DefaultNtfyPriority {
+		t.Errorf("got priority %d, want %d", messages[0].Priority, config.DefaultNtfyPriority)
+	}
+
+	if len(messages[0].Tags) != 1 || messages[0].Tags[0] != ntfy.DefaultTag {
+		t.Errorf("got tags %v, want [%s]", messages[0].Tags, ntfy.DefaultTag)
+	}
+
+	if len(messages[0].Actions) != 0 {
+		t.Errorf("got %d actions without a linker, want 0", len(messages[0].Actions))
+	}
+
+	if got := headers[0].Get("Authorization"); got != "" {
+		t.Errorf("got authorization %q without a token", got)
+	}
+}
+
+func TestNotifyBlockedAttemptIsNotPublished(t *testing.T) {
+	t.Parallel()
+
+	rec, cfg := newTestServer(t)
+
+	ntfy.NewNotifier(context.Background(), cfg, nil).NotifyBlockedAttempt(context.Background(), testDevice)
+
+	if messages, _ := rec.requests(); len(messages) != 0 {
+		t.Errorf("got %d messages, want 0", len(messages))
+	}
+}
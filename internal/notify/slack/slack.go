@@ -0,0 +1,85 @@
+package slack
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+// RequestTimeout is the timeout for each webhook request.
+const RequestTimeout = 10 * time.Second
+
+// Payload is the JSON body sent to the Slack incoming webhook.
+type Payload struct {
+	Text string `json:"text"`
+}
+
+// escaper escapes the characters that have a meaning in Slack's mrkdwn.
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;") //nolint:gochecknoglobals // the replacer is safe for concurrent use
+
+// Format renders notify messages with Slack's mrkdwn.
+var Format = notify.Format{ //nolint:gochecknoglobals // shared by the messages
+	Bold:      func(s string) string { return "*" + s + "*" },
+	Code:      func(s string) string { return "`" + escaper.Replace(strings.ReplaceAll(s, "`", "'")) + "`" },
+	Link:      func(label, url string) string { return "<" + url + "|" + escaper.Replace(label) + ">" },
+	Escape:    escaper.Replace,
+	LineBreak: "\n",
+}
+
+// Notifier is a notify.Notifier that posts to a Slack incoming webhook.
+type Notifier struct {
+	// Base logs blocked attempts and administrative changes.
+	notify.Base
+	// webhookURL is the Slack incoming webhook URL.
+	webhookURL string
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// client is the HTTP client.
+	client *http.Client
+	// l is the logger.
+	l *slog.Logger
+}
+
+// NewNotifier creates a new Notifier. The linker is optional and adds approval links to new device messages.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker) *Notifier {
+	l := logging.FromCtx(ctx)
+
+	l.Debug("Slack setup complete")
+
+	return &Notifier{
+		Base:       notify.NewBase(l),
+		webhookURL: cfg.SlackWebhookURL,
+		linker:     linker,
+		client:     &http.Client{Timeout: RequestTimeout},
+		l:          l,
+	}
+}
+
+// NotifyNewDevice posts a new device alert with the approval links.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	m := notify.NewDeviceMessage(d)
+
+	if n.linker != nil {
+		m.Actions = n.linker.Links(d)
+	}
+
+	n.send(ctx, m)
+}
+
+// NotifyError posts an error message.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	n.send(ctx, notify.ErrorMessage(d, err))
+}
+
+// send posts a message and logs any error.
+func (n *Notifier) send(ctx context.Context, m notify.Message) {
+	if err := notify.SendJSON(ctx, n.client, http.MethodPost, n.webhookURL, nil, Payload{Text: m.Render(Format)}); err != nil {
+		n.l.Error("Error sending Slack message", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,48 @@
+package slack_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/slack"
+)
+
+func TestNotifyError(t *testing.T) {
+	t.Parallel()
+
+	payloads := make(chan slack.Payload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p slack.Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		payloads <- p
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := config.NewConfig()
+	cfg.SlackWebhookURL = server.URL
+
+	d := notify.Device{Username: "aa:bb:cc:dd:ee:ff", MACAddress: "aa:bb:cc:dd:ee:ff"}
+
+	slack.NewNotifier(context.Background(), cfg, nil).NotifyError(context.Background(), d, errors.New("vlan <99> & more"))
+
+	p := <-payloads
+
+	for _, want := range []string{"*⚠️ Error Handling Device ⚠️*", "*Username:* `aa:bb:cc:dd:ee:ff`", "`vlan &lt;99&gt; &amp; more`"} {
+		if !strings.Contains(p.Text, want) {
+			t.Errorf("text %q does not contain %q", p.Text, want)
+		}
+	}
+}
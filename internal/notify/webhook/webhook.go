@@ -0,0 +1,320 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/notify"
+)
+
+const (
+	// SignatureHeader is the header that holds the HMAC-SHA256 signature of the body.
+	SignatureHeader = "X-Authifi-Signature"
+	// EventHeader is the header that holds the event type.
+	EventHeader = "X-Authifi-Event"
+	// RequestTimeout is the timeout for each webhook request.
+	RequestTimeout = 10 * time.Second
+	// DefaultTemplateContentType is the Content-Type of templated bodies if the template doesn't set one.
+	DefaultTemplateContentType = "application/json"
+	// signaturePrefix is prepended to the hex encoded signature.
+	signaturePrefix = "sha256="
+)
+
+// EventType is the type of the event sent in the payload.
+type EventType string
+
+const (
+	// EventNewDevice is sent when an unknown device tries to connect.
+	EventNewDevice EventType = "new_device"
+	// EventBlockedAttempt is sent when a blocked device tries to connect.
+	EventBlockedAttempt EventType = "blocked_attempt"
+	// EventError is sent when an error prevents a device from being handled.
+	EventError EventType = "error"
+	// EventChange is sent when an administrative change is made to the database.
+	EventChange EventType = "change"
+)
+
+// Payload is the data sent to the webhooks. It's encoded as JSON unless a template is set.
+type Payload struct {
+	Event  EventType      `json:"event"`
+	Time   time.Time      `json:"time"`
+	Device *notify.Device `json:"device,omitempty"`
+	Change *notify.Change `json:"change,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	// Actions are the approval links for new devices, if approvals are enabled.
+	Actions []notify.Action `json:"actions,omitempty"`
+}
+
+// endpoint is a webhook URL and its optional body template.
+type endpoint struct {
+	url  string
+	tmpl *template.Template
+	// contentType is the Content-Type of the templated body.
+	contentType string
+}
+
+// Notifier is a notify.Notifier that POSTs events to webhooks.
+type Notifier struct {
+	// endpoints are the webhooks to send events to.
+	endpoints []endpoint
+	// secret is used to sign the body. Requests are not signed if empty.
+	secret []byte
+	// retries is the number of times a failed request is retried.
+	retries int
+	// backoff is the wait before the first retry. It doubles on every retry.
+	backoff time.Duration
+	// linker creates the approval links for new devices. It's nil if approvals are disabled.
+	linker notify.Linker
+	// client is the HTTP client.
+	client *http.Client
+	// ctx is the context of the retries, which outlive the notifications that start them.
+	ctx context.Context
+	// cancel stops the retries.
+	cancel context.CancelFunc
+	// retrying tracks the retries running in the background.
+	retrying sync.WaitGroup
+	// l is the logger.
+	l *slog.Logger
+}
+
+// templateFuncs are the functions available to the body templates.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// json encodes a value as JSON so it can be safely embedded in the body.
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("error encoding value: %w", err)
+			}
+
+			return string(b), nil
+		},
+	}
+}
+
+// NewNotifier creates a new Notifier. The linker is optional and adds approval links to new device events.
+func NewNotifier(ctx context.Context, cfg *config.Config, linker notify.Linker) (*Notifier, error) {
+	l := logging.FromCtx(ctx)
+
+	// Load the templates for each URL
+	templates := make(map[string]endpoint, len(cfg.WebhookTemplates))
+
+	for _, t := range cfg.WebhookTemplates {
+		url, path, contentType, _ := config.ParseWebhookTemplate(t)
+
+		tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs()).ParseFiles(path)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing webhook template: %w", err)
+		}
+
+		if contentType == "" {
+			contentType = DefaultTemplateContentType
+		}
+
+		templates[url] = endpoint{url: url, tmpl: tmpl, contentType: contentType}
+	}
+
+	endpoints := make([]endpoint, len(cfg.WebhookURLs))
+	for i, url := range cfg.WebhookURLs {
+		endpoints[i] = endpoint{url: url}
+		if e, ok := templates[url]; ok {
+			endpoints[i] = e
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	l.Debug("Webhook setup complete", slog.Int("endpoints", len(endpoints)), slog.Int("templates", len(templates)), slog.Bool("signed", cfg.WebhookSecret != ""))
+
+	return &Notifier{
+		endpoints: endpoints,
+		secret:    []byte(cfg.WebhookSecret),
+		retries:   cfg.WebhookRetries,
+		backoff:   cfg.WebhookRetryBackoff,
+		linker:    linker,
+		client:    &http.Client{Timeout: RequestTimeout},
+		ctx:       ctx,
+		cancel:    cancel,
+		l:         l,
+	}, nil
+}
+
+// NotifyNewDevice sends a new device event to the webhooks.
+func (n *Notifier) NotifyNewDevice(ctx context.Context, d notify.Device) {
+	p := Payload{Event: EventNewDevice, Time: d.Time, Device: &d}
+
+	if n.linker != nil {
+		p.Actions = n.linker.Links(d)
+	}
+
+	n.send(ctx, p)
+}
+
+// NotifyBlockedAttempt sends a blocked attempt event to the webhooks.
+func (n *Notifier) NotifyBlockedAttempt(ctx context.Context, d notify.Device) {
+	n.send(ctx, Payload{Event: EventBlockedAttempt, Time: d.Time, Device: &d})
+}
+
+// NotifyError sends an error event to the webhooks.
+func (n *Notifier) NotifyError(ctx context.Context, d notify.Device, err error) {
+	n.send(ctx, Payload{Event: EventError, Time: d.Time, Device: &d, Error: err.Error()})
+}
+
+// NotifyChange sends an administrative change event to the webhooks.
+func (n *Notifier) NotifyChange(ctx context.Context, c notify.Change) {
+	n.send(ctx, Payload{Event: EventChange, Time: c.Time, Change: &c})
+}
+
+// send delivers the payload to every endpoint concurrently.
+func (n *Notifier) send(ctx context.Context, p Payload) {
+	var wg sync.WaitGroup
+
+	for _, e := range n.endpoints {
+		wg.Add(1)
+
+		go func(e endpoint) {
+			defer wg.Done()
+
+			if err := n.deliver(ctx, e, p); err != nil {
+				n.l.Error("Error sending webhook", slog.Any("error", err), slog.String("url", e.url), slog.String("event", string(p.Event)))
+			}
+		}(e)
+	}
+
+	wg.Wait()
+}
+
+// render creates the request body for an endpoint.
+func (e endpoint) render(p Payload) ([]byte, error) {
+	if e.tmpl == nil {
+		body, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding payload: %w", err)
+		}
+
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	if err := e.tmpl.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("error executing template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sign returns the signature header value for a body.
+func (n *Notifier) sign(body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends the payload to an endpoint. Failed requests are retried in the background, so
+// slow or broken endpoints don't hold up the notifications.
+func (n *Notifier) deliver(ctx context.Context, e endpoint, p Payload) error {
+	body, err := e.render(p)
+	if err != nil {
+		return err
+	}
+
+	headers := http.Header{}
+	headers.Set(EventHeader, string(p.Event))
+
+	if e.tmpl != nil {
+		headers.Set("Content-Type", e.contentType)
+	}
+
+	if len(n.secret) > 0 {
+		headers.Set(SignatureHeader, n.sign(body))
+	}
+
+	err = notify.Send(ctx, n.client, http.MethodPost, e.url, headers, body)
+	if err == nil {
+		return nil
+	}
+
+	if !notify.Retryable(err) || n.retries == 0 {
+		return fmt.Errorf("error delivering webhook: %w", err)
+	}
+
+	n.retrying.Add(1)
+
+	go func() {
+		defer n.retrying.Done()
+
+		if err := n.retry(e.url, headers, body, err); err != nil {
+			n.l.Error("Error sending webhook", slog.Any("error", err), slog.String("url", e.url), slog.String("event", string(p.Event)))
+		}
+	}()
+
+	return nil
+}
+
+// retry sends a request that failed with err again with exponential backoff until it succeeds,
+// fails with an error that can't be retried, or runs out of retries.
+func (n *Notifier) retry(url string, headers http.Header, body []byte, err error) error {
+	backoff := n.backoff
+
+	for attempt := 1; ; attempt++ {
+		n.l.Debug("Retrying webhook", slog.Any("error", err), slog.String("url", url), slog.Duration("backoff", backoff))
+
+		select {
+		case <-n.ctx.Done():
+			return fmt.Errorf("error delivering webhook: %w", n.ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		err = notify.Send(n.ctx, n.client, http.MethodPost, url, headers, body)
+		if err == nil {
+			return nil
+		}
+
+		if !notify.Retryable(err) || attempt >= n.retries {
+			return fmt.Errorf("error delivering webhook after %d attempts: %w", attempt+1, err)
+		}
+
+		backoff *= 2
+	}
+}
+
+// Wait blocks until the retries running in the background are done.
+func (n *Notifier) Wait() {
+	n.retrying.Wait()
+}
+
+// Close waits for the retries running in the background, and stops them once ctx is done.
+func (n *Notifier) Close(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		n.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		n.cancel()
+
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+
+		return fmt.Errorf("error waiting for webhook retries: %w", ctx.Err())
+	}
+}
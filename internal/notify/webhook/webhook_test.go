@@ -0,0 +1,303 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/notify/webhook"
+)
+
+// recorder is an httptest handler that records requests and replies with a list of status codes.
+type recorder struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.bodies = append(rec.bodies, body)
+	rec.headers = append(rec.headers, r.Header.Clone())
+
+	status := http.StatusOK
+	if len(rec.statuses) > 0 {
+		status = rec.statuses[0]
+		rec.statuses = rec.statuses[1:]
+	}
+
+	w.WriteHeader(status)
+}
+
+func (rec *recorder) requests() ([][]byte, []http.Header) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.bodies, rec.headers
+}
+
+func newNotifier(t *testing.T, cfg *config.Config) *webhook.Notifier {
+	t.Helper()
+
+	n, err := webhook.NewNotifier(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("error creating notifier: %v", err)
+	}
+
+	return n
+}
+
+func newTestConfig(url string) *config.Config {
+	cfg := config.NewConfig()
+	cfg.WebhookURLs = []string{url}
+	cfg.WebhookRetryBackoff = time.Millisecond
+
+	return cfg
+}
+
+var testDevice = notify.Device{ //nolint:gochecknoglobals // test fixture
+	Username:   "aa:bb:cc:dd:ee:ff",
+	Password:   "secret-password",
+	MACAddress: "aa:bb:cc:dd:ee:ff",
+	NASAddress: "10.0.0.1",
+	Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func TestNotifyNewDeviceSignsPayload(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(server.URL)
+	cfg.WebhookSecret = "hmac-secret"
+
+	newNotifier(t, cfg).NotifyNewDevice(context.Background(), testDevice)
+
+	bodies, headers := rec.requests()
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(bodies))
+	}
+
+	var payload webhook.Payload
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatalf("error decoding payload: %v", err)
+	}
+
+	if payload.Event != webhook.EventNewDevice {
+		t.Errorf("got event %q, want %q", payload.Event, webhook.EventNewDevice)
+	}
+
+	if payload.Device == nil || payload.Device.Username != testDevice.Username {
+		t.Errorf("got device %+v, want username %q", payload.Device, testDevice.Username)
+	}
+
+	if payload.Device != nil && payload.Device.Password != "" {
+		t.Error("password leaked in payload")
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.WebhookSecret))
+	mac.Write(bodies[0])
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := headers[0].Get(webhook.SignatureHeader); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+
+	if got := headers[0].Get(webhook.EventHeader); got != string(webhook.EventNewDevice) {
+		t.Errorf("got event header %q, want %q", got, webhook.EventNewDevice)
+	}
+}
+
+func TestNotifyRetries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		retries      int
+		statuses     []int
+		wantRequests int
+	}{
+		{name: "success after server errors", retries: 3, statuses: []int{500, 503}, wantRequests: 3},
+		{name: "gives up after retries", retries: 2, statuses: []int{500, 500, 500, 500}, wantRequests: 3},
+		{name: "retries rate limits", retries: 1, statuses: []int{429}, wantRequests: 2},
+		{name: "does not retry client errors", retries: 3, statuses: []int{400}, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := &recorder{statuses: tt.statuses}
+			server := httptest.NewServer(rec)
+			t.Cleanup(server.Close)
+
+			cfg := newTestConfig(server.URL)
+			cfg.WebhookRetries = tt.retries
+
+			n := newNotifier(t, cfg)
+			n.NotifyBlockedAttempt(context.Background(), testDevice)
+			n.Wait()
+
+			if bodies, _ := rec.requests(); len(bodies) != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", len(bodies), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestNotifyDoesNotWaitForRetries(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{statuses: []int{500, 500}}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(server.URL)
+	cfg.WebhookRetries = 3
+	cfg.WebhookRetryBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n, err := webhook.NewNotifier(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("error creating notifier: %v", err)
+	}
+
+	// The first attempt is sent right away and the retry waits in the background
+	n.NotifyBlockedAttempt(context.Background(), testDevice)
+
+	if bodies, _ := rec.requests(); len(bodies) != 1 {
+		t.Errorf("got %d requests, want 1", len(bodies))
+	}
+
+	// Retries stop when the notifier's context is done
+	cancel()
+	n.Wait()
+
+	if bodies, _ := rec.requests(); len(bodies) != 1 {
+		t.Errorf("got %d requests after stopping, want 1", len(bodies))
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	tmplPath := filepath.Join(t.TempDir(), "body.tmpl")
+	tmpl := `{"message": {{ json (printf "%s: %s" .Event .Change.Username) }}}`
+
+	if err := os.WriteFile(tmplPath, []byte(tmpl), 0o600); err != nil {
+		t.Fatalf("error writing template: %v", err)
+	}
+
+	cfg := newTestConfig(server.URL)
+	cfg.WebhookTemplates = []string{server.URL + " " + tmplPath}
+
+	newNotifier(t, cfg).NotifyChange(context.Background(), notify.Change{Type: notify.ChangeUserBlocked, Username: `"quoted"`})
+
+	bodies, headers := rec.requests()
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(bodies))
+	}
+
+	want := `{"message": "change: \"quoted\""}`
+	if got := string(bodies[0]); got != want {
+		t.Errorf("got body %s, want %s", got, want)
+	}
+
+	if got := headers[0].Get("Content-Type"); got != webhook.DefaultTemplateContentType {
+		t.Errorf("got content type %q, want %q", got, webhook.DefaultTemplateContentType)
+	}
+}
+
+func TestNotifyTemplateContentType(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	tmplPath := filepath.Join(t.TempDir(), "body.tmpl")
+	if err := os.WriteFile(tmplPath, []byte(`New device: {{ .Device.Username }}`), 0o600); err != nil {
+		t.Fatalf("error writing template: %v", err)
+	}
+
+	cfg := newTestConfig(server.URL)
+	cfg.WebhookTemplates = []string{server.URL + " " + tmplPath + " text/plain; charset=utf-8"}
+
+	newNotifier(t, cfg).NotifyNewDevice(context.Background(), testDevice)
+
+	_, headers := rec.requests()
+	if len(headers) != 1 {
+		t.Fatalf("got %d requests, want 1", len(headers))
+	}
+
+	if got := headers[0].Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("got content type %q, want text/plain; charset=utf-8", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		backoff      time.Duration
+		timeout      time.Duration
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "waits for retries", backoff: time.Millisecond, timeout: time.Minute, wantRequests: 2},
+		{name: "stops retries when done", backoff: time.Hour, timeout: 50 * time.Millisecond, wantErr: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := &recorder{statuses: []int{500}}
+			server := httptest.NewServer(rec)
+			t.Cleanup(server.Close)
+
+			cfg := newTestConfig(server.URL)
+			cfg.WebhookRetries = 1
+			cfg.WebhookRetryBackoff = tt.backoff
+
+			n := newNotifier(t, cfg)
+			n.NotifyBlockedAttempt(context.Background(), testDevice)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			if err := n.Close(ctx); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if bodies, _ := rec.requests(); len(bodies) != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", len(bodies), tt.wantRequests)
+			}
+		})
+	}
+}
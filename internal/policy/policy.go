@@ -0,0 +1,241 @@
+// Package policy evaluates the rules of the access policy, an ordered list of rules that accept,
+// reject, or move requests to another VLAN, or add reply attributes to them.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/dictionary"
+	"github.com/maronato/authifi/internal/schedule"
+)
+
+// maxMACDigits is the number of hex digits of a MAC address.
+const maxMACDigits = 12
+
+var (
+	// ErrInvalidRule is returned when a policy rule is not valid.
+	ErrInvalidRule = errors.New("invalid policy rule")
+	// ErrInvalidAction is returned when a policy rule has an unknown action.
+	ErrInvalidAction = errors.New("invalid policy action")
+)
+
+// Request is what the policy rules are matched against.
+type Request struct {
+	// Username is the User-Name of the request.
+	Username string
+	// MACAddress is the Calling-Station-Id of the request.
+	MACAddress string
+	// SSID is the SSID of the request.
+	SSID string
+	// NASIdentifier is the NAS-Identifier of the request.
+	NASIdentifier string
+	// NASAddress is the IP address of the NAS.
+	NASAddress string
+	// Group is the group of the user, if it's known and has one.
+	Group string
+	// Time is when the request was received.
+	Time time.Time
+}
+
+// Validate checks that a policy rule is valid. It doesn't check that its VLAN and group exist.
+func Validate(r database.PolicyRule) error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: rules need a name", ErrInvalidRule)
+	}
+
+	switch r.Action {
+	case database.PolicyActionAccept, database.PolicyActionReject:
+		if r.VlanID != "" || len(r.Attributes) > 0 {
+			return fmt.Errorf("%w: %s rules can't have a VLAN or attributes", ErrInvalidRule, r.Action)
+		}
+	case database.PolicyActionVLAN:
+		if r.VlanID == "" {
+			return fmt.Errorf("%w: vlan rules need a VLAN", ErrInvalidRule)
+		}
+	case database.PolicyActionAttributes:
+		if r.VlanID != "" || len(r.Attributes) == 0 {
+			return fmt.Errorf("%w: attributes rules need attributes and can't have a VLAN", ErrInvalidRule)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidAction, r.Action)
+	}
+
+	for _, a := range r.Attributes {
+		if err := dictionary.Validate(a); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRule, err)
+		}
+	}
+
+	m := r.Match
+
+	if _, err := path.Match(m.Username, ""); err != nil {
+		return fmt.Errorf("%w: bad username pattern %q", ErrInvalidRule, m.Username)
+	}
+
+	if prefix := macDigits(m.MACPrefix); m.MACPrefix != "" && (prefix == "" || len(prefix) > maxMACDigits) {
+		return fmt.Errorf("%w: bad MAC prefix %q", ErrInvalidRule, m.MACPrefix)
+	}
+
+	if window, ok := timeWindow(m); ok {
+		if err := schedule.Validate(window); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRule, err)
+		}
+	} else if len(m.Days) > 0 || m.Timezone != "" {
+		return fmt.Errorf("%w: days and timezone need a from and to time", ErrInvalidRule)
+	}
+
+	return nil
+}
+
+// Match reports whether a policy rule matches a request. If it doesn't, it also returns why.
+func Match(r database.PolicyRule, req Request) (bool, string) {
+	if ok, why := matchConditions(r.Match, req); !ok {
+		return false, why
+	}
+
+	if window, ok := timeWindow(r.Match); ok {
+		// Rules are validated when they are saved, so errors don't match
+		active, _, err := schedule.Evaluate([]database.Schedule{window}, req.Time)
+		if err != nil || active == nil {
+			return false, fmt.Sprintf("%s is outside of %s", req.Time.Format(time.RFC1123), describeWindow(window))
+		}
+	}
+
+	return true, ""
+}
+
+// matchConditions checks every condition of a rule but its time window.
+func matchConditions(m database.PolicyMatch, req Request) (bool, string) {
+	if m.Username != "" {
+		if ok, _ := path.Match(m.Username, req.Username); !ok {
+			return false, fmt.Sprintf("username %q doesn't match %q", req.Username, m.Username)
+		}
+	}
+
+	if m.MACPrefix != "" && !strings.HasPrefix(macDigits(req.MACAddress), macDigits(m.MACPrefix)) {
+		return false, fmt.Sprintf("MAC address %q doesn't start with %q", req.MACAddress, m.MACPrefix)
+	}
+
+	if m.SSID != "" && m.SSID != req.SSID {
+		return false, fmt.Sprintf("SSID %q isn't %q", req.SSID, m.SSID)
+	}
+
+	if m.NAS != "" && m.NAS != req.NASIdentifier && m.NAS != req.NASAddress {
+		return false, fmt.Sprintf("NAS %q (%s) isn't %q", req.NASIdentifier, req.NASAddress, m.NAS)
+	}
+
+	if m.Group != "" && m.Group != req.Group {
+		return false, fmt.Sprintf("group %q isn't %q", req.Group, m.Group)
+	}
+
+	return true, ""
+}
+
+// Next returns the next time the time window of a rule that could match a request starts or
+// ends. It's zero if none of those rules has a time window.
+func Next(rules []database.PolicyRule, req Request) time.Time {
+	windows := []database.Schedule{}
+
+	for _, r := range rules {
+		if window, ok := timeWindow(r.Match); ok {
+			if matched, _ := matchConditions(r.Match, req); matched {
+				windows = append(windows, window)
+			}
+		}
+	}
+
+	if len(windows) == 0 {
+		return time.Time{}
+	}
+
+	_, next, err := schedule.Evaluate(windows, req.Time)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return next
+}
+
+// Describe returns a short human readable description of a rule, like
+// "iot: mac B8:27:EB, ssid IoT: vlan 20".
+func Describe(r database.PolicyRule) string {
+	m := r.Match
+	conditions := []string{}
+
+	for _, c := range []struct{ name, value string }{
+		{"username", m.Username},
+		{"mac", m.MACPrefix},
+		{"ssid", m.SSID},
+		{"nas", m.NAS},
+		{"group", m.Group},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.name+" "+c.value)
+		}
+	}
+
+	if window, ok := timeWindow(m); ok {
+		conditions = append(conditions, describeWindow(window))
+	}
+
+	if len(conditions) == 0 {
+		conditions = append(conditions, "every request")
+	}
+
+	action := string(r.Action)
+	if r.VlanID != "" {
+		action += " " + r.VlanID
+	}
+
+	for _, a := range r.Attributes {
+		action += ", " + a.Name + "=" + a.Value
+	}
+
+	return r.Name + ": " + strings.Join(conditions, ", ") + ": " + action
+}
+
+// timeWindow returns the time window of the conditions as a schedule rule, if they have one.
+func timeWindow(m database.PolicyMatch) (database.Schedule, bool) {
+	if m.From == "" && m.To == "" {
+		return database.Schedule{}, false
+	}
+
+	return database.Schedule{
+		Days:     m.Days,
+		From:     m.From,
+		To:       m.To,
+		Timezone: m.Timezone,
+		Action:   database.ScheduleActionReject,
+	}, true
+}
+
+// describeWindow describes a time window without its action.
+func describeWindow(window database.Schedule) string {
+	desc := schedule.Describe(window)
+
+	return strings.TrimSuffix(desc, ": "+string(window.Action))
+}
+
+// macDigits returns the lowercase hex digits of a MAC address or prefix, without separators.
+func macDigits(mac string) string {
+	var b strings.Builder
+
+	for _, c := range strings.ToLower(mac) {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f':
+			b.WriteRune(c)
+		case c == ':' || c == '-' || c == '.':
+			// Skip the separators
+		default:
+			// Not a MAC address
+			return ""
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package policy_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/policy"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	attributes := []database.Attribute{{Name: "Session-Timeout", Value: "3600"}}
+
+	tests := []struct {
+		name    string
+		rule    database.PolicyRule
+		wantErr error
+	}{
+		{name: "accept", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{Username: "guest-*"}, Action: database.PolicyActionAccept}},
+		{name: "reject", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{NAS: "ap", From: "00:00", To: "06:00"}, Action: database.PolicyActionReject}},
+		{name: "vlan", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{MACPrefix: "B8-27-EB"}, Action: database.PolicyActionVLAN, VlanID: "20"}},
+		{name: "attributes", rule: database.PolicyRule{Name: "a", Action: database.PolicyActionAttributes, Attributes: attributes}},
+		{name: "no name", rule: database.PolicyRule{Action: database.PolicyActionAccept}, wantErr: policy.ErrInvalidRule},
+		{name: "unknown action", rule: database.PolicyRule{Name: "a", Action: "allow"}, wantErr: policy.ErrInvalidAction},
+		{name: "vlan without VLAN", rule: database.PolicyRule{Name: "a", Action: database.PolicyActionVLAN}, wantErr: policy.ErrInvalidRule},
+		{name: "reject with VLAN", rule: database.PolicyRule{Name: "a", Action: database.PolicyActionReject, VlanID: "20"}, wantErr: policy.ErrInvalidRule},
+		{name: "attributes without attributes", rule: database.PolicyRule{Name: "a", Action: database.PolicyActionAttributes}, wantErr: policy.ErrInvalidRule},
+		{name: "unknown attribute", rule: database.PolicyRule{Name: "a", Action: database.PolicyActionAttributes, Attributes: []database.Attribute{{Name: "Foo", Value: "1"}}}, wantErr: policy.ErrInvalidRule},
+		{name: "bad username pattern", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{Username: "[a"}, Action: database.PolicyActionReject}, wantErr: policy.ErrInvalidRule},
+		{name: "bad MAC prefix", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{MACPrefix: "raspberry"}, Action: database.PolicyActionReject}, wantErr: policy.ErrInvalidRule},
+		{name: "bad time", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{From: "midnight", To: "06:00"}, Action: database.PolicyActionReject}, wantErr: policy.ErrInvalidRule},
+		{name: "days without time", rule: database.PolicyRule{Name: "a", Match: database.PolicyMatch{Days: []string{"mon"}}, Action: database.PolicyActionReject}, wantErr: policy.ErrInvalidRule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := policy.Validate(tt.rule); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	night := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	req := policy.Request{Username: "guest-1", MACAddress: "B8:27:EB:12:34:56", SSID: "IoT", NASIdentifier: "lobby-ap", NASAddress: "10.0.0.2", Group: "kids", Time: night}
+
+	tests := []struct {
+		name  string
+		match database.PolicyMatch
+		want  bool
+	}{
+		{"every request", database.PolicyMatch{}, true},
+		{"username pattern", database.PolicyMatch{Username: "guest-*"}, true},
+		{"other username", database.PolicyMatch{Username: "admin-*"}, false},
+		{"OUI in another format", database.PolicyMatch{MACPrefix: "b827eb"}, true},
+		{"other OUI", database.PolicyMatch{MACPrefix: "00-11-22"}, false},
+		{"SSID", database.PolicyMatch{SSID: "IoT"}, true},
+		{"other SSID", database.PolicyMatch{SSID: "Guest"}, false},
+		{"NAS identifier", database.PolicyMatch{NAS: "lobby-ap"}, true},
+		{"NAS address", database.PolicyMatch{NAS: "10.0.0.2"}, true},
+		{"other NAS", database.PolicyMatch{NAS: "garage-ap"}, false},
+		{"group", database.PolicyMatch{Group: "kids"}, true},
+		{"other group", database.PolicyMatch{Group: "cameras"}, false},
+		{"inside the window", database.PolicyMatch{From: "00:00", To: "06:00", Timezone: "UTC"}, true},
+		{"outside the window", database.PolicyMatch{From: "09:00", To: "17:00", Timezone: "UTC"}, false},
+		{"every condition", database.PolicyMatch{SSID: "IoT", NAS: "lobby-ap", From: "00:00", To: "06:00", Timezone: "UTC"}, true},
+		{"one condition fails", database.PolicyMatch{SSID: "IoT", NAS: "garage-ap"}, false},
+	}
+
+	for _, tt := range tests {
+		got, why := policy.Match(database.PolicyRule{Name: tt.name, Match: tt.match}, req)
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+
+		if !got && why == "" {
+			t.Errorf("%s: got no explanation", tt.name)
+		}
+	}
+
+	// Only the windows of rules that could match are considered
+	rules := []database.PolicyRule{
+		{Name: "garage", Match: database.PolicyMatch{NAS: "garage-ap", From: "10:00", To: "11:00", Timezone: "UTC"}},
+		{Name: "lobby", Match: database.PolicyMatch{NAS: "lobby-ap", From: "00:00", To: "06:00", Timezone: "UTC"}},
+	}
+
+	if got, want := policy.Next(rules, req), night.Add(3*time.Hour); !got.Equal(want) {
+		t.Errorf("got next %s, want %s", got, want)
+	}
+
+	req.Time = noon
+	if got, want := policy.Next(rules, req), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got next %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,707 @@
+package radiusserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/dictionary"
+	"github.com/maronato/authifi/internal/policy"
+	"github.com/maronato/authifi/internal/schedule"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+)
+
+// Reason explains why an access decision was made.
+type Reason int
+
+const (
+	// ReasonUserVLAN is used when a known user is accepted on its own VLAN.
+	ReasonUserVLAN Reason = iota
+	// ReasonUnknownDevice is used when an unknown device is accepted on the default VLAN.
+	ReasonUnknownDevice
+	// ReasonUnknownDeviceNoDefault is used when an unknown device is rejected because there's no default VLAN.
+	ReasonUnknownDeviceNoDefault
+	// ReasonMissingUserVLAN is used when a known user's VLAN doesn't exist and it's accepted on the default VLAN.
+	ReasonMissingUserVLAN
+	// ReasonMissingUserVLANNoDefault is used when a known user's VLAN doesn't exist and there's no default VLAN.
+	ReasonMissingUserVLANNoDefault
+	// ReasonWrongPassword is used when a known user sends the wrong password.
+	ReasonWrongPassword
+	// ReasonBlocked is used when the user is blocked.
+	ReasonBlocked
+	// ReasonBlocklistError is used when the blocklist could not be checked.
+	ReasonBlocklistError
+	// ReasonGroupVLAN is used when a known user is accepted on the VLAN of its group.
+	ReasonGroupVLAN
+	// ReasonScheduleVLAN is used when a schedule rule moves a known user to another VLAN.
+	ReasonScheduleVLAN
+	// ReasonScheduleReject is used when a schedule rule rejects a known user.
+	ReasonScheduleReject
+	// ReasonUserExpired is used when a guest user is rejected because it expired and wasn't deleted yet.
+	ReasonUserExpired
+	// ReasonTempVLAN is used when a known user is accepted on its temporary VLAN.
+	ReasonTempVLAN
+	// ReasonQuarantine is used when an unknown device is accepted on the quarantine VLAN.
+	ReasonQuarantine
+	// ReasonNetworkDefault is used when an unknown device is accepted on the default VLAN of its network.
+	ReasonNetworkDefault
+	// ReasonNetworkVLAN is used when a known user is accepted on its VLAN for the network it connected to.
+	ReasonNetworkVLAN
+	// ReasonPolicyReject is used when a policy rule rejects the request.
+	ReasonPolicyReject
+	// ReasonPolicyVLAN is used when a policy rule moves a known user to another VLAN.
+	ReasonPolicyVLAN
+	// ReasonPolicyNewDevice is used when a policy rule moves an unknown device to another VLAN.
+	ReasonPolicyNewDevice
+	// ReasonScheduleError is used when the schedules of a known user could not be checked.
+	ReasonScheduleError
+)
+
+// String returns the reason as a short identifier suitable for logs.
+func (r Reason) String() string {
+	switch r {
+	case ReasonUserVLAN:
+		return "user_vlan"
+	case ReasonUnknownDevice:
+		return "unknown_device"
+	case ReasonUnknownDeviceNoDefault:
+		return "unknown_device_no_default"
+	case ReasonMissingUserVLAN:
+		return "missing_user_vlan"
+	case ReasonMissingUserVLANNoDefault:
+		return "missing_user_vlan_no_default"
+	case ReasonWrongPassword:
+		return "wrong_password"
+	case ReasonBlocked:
+		return "blocked"
+	case ReasonBlocklistError:
+		return "blocklist_error"
+	case ReasonGroupVLAN:
+		return "group_vlan"
+	case ReasonScheduleVLAN:
+		return "schedule_vlan"
+	case ReasonScheduleReject:
+		return "schedule_reject"
+	case ReasonUserExpired:
+		return "user_expired"
+	case ReasonTempVLAN:
+		return "temp_vlan"
+	case ReasonQuarantine:
+		return "quarantine"
+	case ReasonNetworkDefault:
+		return "network_default"
+	case ReasonNetworkVLAN:
+		return "network_vlan"
+	case ReasonPolicyReject:
+		return "policy_reject"
+	case ReasonPolicyVLAN:
+		return "policy_vlan"
+	case ReasonPolicyNewDevice:
+		return "policy_new_device"
+	case ReasonScheduleError:
+		return "schedule_error"
+	default:
+		return "unknown"
+	}
+}
+
+// Message returns a human readable description of the reason.
+func (r Reason) Message() string {
+	switch r {
+	case ReasonUserVLAN:
+		return "Welcome back"
+	case ReasonUnknownDevice:
+		return "New device, assigned to the default network"
+	case ReasonUnknownDeviceNoDefault:
+		return "New device, no default network available"
+	case ReasonMissingUserVLAN:
+		return "Device network not found, assigned to the default network"
+	case ReasonMissingUserVLANNoDefault:
+		return "Device network not found and no default network available"
+	case ReasonWrongPassword:
+		return "Incorrect password"
+	case ReasonBlocked:
+		return "Device is blocked"
+	case ReasonBlocklistError:
+		return "Could not verify the device"
+	case ReasonGroupVLAN:
+		return "Welcome back"
+	case ReasonScheduleVLAN:
+		return "Welcome back, assigned to a scheduled network"
+	case ReasonScheduleReject:
+		return "Access is not allowed at this time"
+	case ReasonUserExpired:
+		return "Guest access has expired"
+	case ReasonTempVLAN:
+		return "Welcome back, assigned to a temporary network"
+	case ReasonQuarantine:
+		return "New device, isolated until it's approved"
+	case ReasonNetworkDefault:
+		return "New device, assigned to the default network for this connection"
+	case ReasonNetworkVLAN:
+		return "Welcome back, assigned to the network for this connection"
+	case ReasonPolicyReject:
+		return "Access is not allowed by the network policy"
+	case ReasonPolicyVLAN:
+		return "Welcome back, assigned to a network by the network policy"
+	case ReasonPolicyNewDevice:
+		return "New device, assigned to a network by the network policy"
+	case ReasonScheduleError:
+		return "Could not check when the device is allowed"
+	default:
+		return "Unknown reason"
+	}
+}
+
+// UnknownDevice reports whether the reason is used for devices that are not in the database.
+func (r Reason) UnknownDevice() bool {
+	return r == ReasonUnknownDevice || r == ReasonUnknownDeviceNoDefault || r == ReasonQuarantine || r == ReasonNetworkDefault ||
+		r == ReasonPolicyNewDevice
+}
+
+// Request holds the information of an Access-Request relevant to the access decision.
+type Request struct {
+	// Username is the User-Name of the request.
+	Username string
+	// Password is the User-Password of the request.
+	Password string
+	// MACAddress is the Calling-Station-Id of the request.
+	MACAddress string
+	// NASAddress is the NAS-IP-Address of the request, or its source address.
+	NASAddress string
+	// CalledStationID is the Called-Station-Id of the request.
+	CalledStationID string
+	// SSID is the SSID of the request, taken from its Called-Station-Id. It's empty if the NAS doesn't send it.
+	SSID string
+	// NASIdentifier is the NAS-Identifier of the request.
+	NASIdentifier string
+	// SessionID is the Acct-Session-Id of the request, if the NAS sends it. It identifies the
+	// session in Dynamic Authorization requests.
+	SessionID string
+	// Time is when the request was received. Schedules are evaluated at this time.
+	Time time.Time
+}
+
+// newRequest extracts a Request from a RADIUS request.
+func newRequest(r *radius.Request) Request {
+	calledStationID := rfc2865.CalledStationID_GetString(r.Packet)
+
+	return Request{
+		Username:        rfc2865.UserName_GetString(r.Packet),
+		Password:        rfc2865.UserPassword_GetString(r.Packet),
+		MACAddress:      rfc2865.CallingStationID_GetString(r.Packet),
+		NASAddress:      getNASAddress(r),
+		CalledStationID: calledStationID,
+		SSID:            ParseSSID(calledStationID),
+		NASIdentifier:   rfc2865.NASIdentifier_GetString(r.Packet),
+		SessionID:       rfc2866.AcctSessionID_GetString(r.Packet),
+		Time:            time.Now(),
+	}
+}
+
+// Decision is the outcome of an access request.
+type Decision struct {
+	// Code is the response code, either Access-Accept or Access-Reject.
+	Code radius.Code
+	// VLAN is the VLAN assigned to the request. It's nil if no VLAN was assigned.
+	VLAN *database.VLAN
+	// Reason explains why the decision was made.
+	Reason Reason
+	// Attributes are the attributes added to the response.
+	Attributes radius.Attributes
+	// Err is the database error that led to the decision, if any.
+	Err error
+	// Rules are the names of the policy rules that matched the request, in order.
+	Rules []string
+}
+
+// Accepted returns true if the decision is to accept the request.
+func (d Decision) Accepted() bool {
+	return d.Code == radius.CodeAccessAccept
+}
+
+// Apply adds the decision's attributes to a response packet.
+func (d Decision) Apply(packet *radius.Packet) {
+	for _, avp := range d.Attributes {
+		packet.Add(avp.Type, avp.Attribute)
+	}
+}
+
+// accept creates an Access-Accept decision on the given VLAN.
+func accept(reason Reason, vlan database.VLAN, err error) Decision {
+	// Build the VLAN attributes on a scratch packet so they can be inspected before being applied
+	scratch := &radius.Packet{}
+	setPacketVLAN(scratch, vlan)
+
+	return Decision{
+		Code:       radius.CodeAccessAccept,
+		VLAN:       &vlan,
+		Reason:     reason,
+		Attributes: scratch.Attributes,
+		Err:        err,
+	}
+}
+
+// reject creates an Access-Reject decision.
+func reject(reason Reason, err error) Decision {
+	return Decision{
+		Code:   radius.CodeAccessReject,
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// PolicyStep is how a policy rule was evaluated for a request.
+type PolicyStep struct {
+	// Rule is the evaluated rule.
+	Rule database.PolicyRule
+	// Matched is true if the rule matched the request.
+	Matched bool
+	// Why explains why the rule didn't match.
+	Why string
+}
+
+// Explanation details how the access decision for a request was made, for dry runs.
+type Explanation struct {
+	// Usual is the decision before the policy was applied.
+	Usual Decision
+	// Steps are the policy rules that were evaluated, in order. The rules after the one that
+	// ended the evaluation are left out.
+	Steps []PolicyStep
+	// Decision is the final decision.
+	Decision Decision
+}
+
+// Decide computes the access decision for a request. It only reads from the database.
+func Decide(db database.Database, req Request) Decision {
+	usual, user := decide(db, req)
+
+	return applyReplyAttributes(db, user, applyPolicy(db, req, user, usual, nil))
+}
+
+// Explain computes the access decision for a request like Decide, and details how it was made.
+func Explain(db database.Database, req Request) Explanation {
+	usual, user := decide(db, req)
+
+	e := Explanation{Usual: applyReplyAttributes(db, user, usual)}
+	e.Decision = applyReplyAttributes(db, user, applyPolicy(db, req, user, usual, func(step PolicyStep) { e.Steps = append(e.Steps, step) }))
+
+	return e
+}
+
+// decide computes the usual access decision for a request, before the policy is applied. It also
+// returns the user of the request, or nil if it's unknown.
+func decide(db database.Database, req Request) (Decision, *database.User) {
+	// Start by checking if the user is blocked
+	userBlocked, err := db.IsUserBlocked(req.Username)
+	if err != nil {
+		return reject(ReasonBlocklistError, err), nil
+	}
+
+	if userBlocked {
+		return reject(ReasonBlocked, nil), nil
+	}
+
+	user, err := db.GetUser(req.Username)
+	if err != nil {
+		// Unknown devices are expected, so only other errors are reported
+		if errors.Is(err, database.ErrUserNotFound) {
+			err = nil
+		}
+
+		return decideUnknown(db, req, err), nil
+	}
+
+	if user.Password != req.Password {
+		return reject(ReasonWrongPassword, nil), &user
+	}
+
+	// Expired guests are rejected until they are deleted
+	if user.Expired(req.Time) {
+		return reject(ReasonUserExpired, nil), &user
+	}
+
+	// Users without a VLAN of their own use their group's
+	vlanID, err := database.UserVLANID(db, user)
+
+	var vlan database.VLAN
+	if err == nil {
+		vlan, err = db.GetVLAN(vlanID)
+	}
+
+	var d Decision
+
+	switch {
+	case err == nil && user.VlanID == "":
+		d = accept(ReasonGroupVLAN, vlan, nil)
+	case err == nil:
+		d = accept(ReasonUserVLAN, vlan, nil)
+	default:
+		// Fallback to the default VLAN if the user's VLAN doesn't exist. The device's networks,
+		// temporary VLAN, and schedules still apply.
+		defaultVLAN, defaultErr := db.GetDefaultVLAN()
+		if defaultErr != nil {
+			return reject(ReasonMissingUserVLANNoDefault, err), &user
+		}
+
+		d = accept(ReasonMissingUserVLAN, defaultVLAN, err)
+	}
+
+	d = applyNetworks(db, user, req, d)
+	d = applyTempVLAN(db, user, req.Time, d)
+
+	d, next := applySchedules(db, user, req.Time, d)
+	if !d.Accepted() {
+		return d, &user
+	}
+
+	// Devices re-authenticate when a rule starts or ends, or when their access or temporary VLAN expire
+	if user.ExpiresAt != nil {
+		next = earliest(next, *user.ExpiresAt)
+	}
+
+	if override := user.ActiveTempVLAN(req.Time); override != nil {
+		next = earliest(next, override.ExpiresAt)
+	}
+
+	if !next.IsZero() {
+		d.Attributes = withSessionTimeout(d.Attributes, next.Sub(req.Time))
+	}
+
+	return d, &user
+}
+
+// decideUnknown computes the access decision for a device that is not in the database. err is the
+// error looking it up, if it's unexpected.
+func decideUnknown(db database.Database, req Request, err error) Decision {
+	// Unknown devices use the default VLAN of their network, if it has one
+	if networkVLAN, networkErr := database.NetworkDefaultVLAN(db, req.SSID, req.NASIdentifier, req.NASAddress); networkErr == nil {
+		return accept(ReasonNetworkDefault, networkVLAN, err)
+	}
+
+	// Otherwise they are isolated in the quarantine VLAN, if there's one
+	if quarantineVLAN, quarantineErr := db.GetQuarantineVLAN(); quarantineErr == nil {
+		return accept(ReasonQuarantine, quarantineVLAN, err)
+	}
+
+	// Otherwise they get the default VLAN, if there's one
+	defaultVLAN, defaultErr := db.GetDefaultVLAN()
+	if defaultErr != nil {
+		return reject(ReasonUnknownDeviceNoDefault, errors.Join(err, defaultErr))
+	}
+
+	return accept(ReasonUnknownDevice, defaultVLAN, err)
+}
+
+// applyPolicy applies the rules of the access policy to a decision, calling explain with each
+// evaluated rule if it's not nil. The user is nil for unknown devices.
+func applyPolicy(db database.Database, req Request, user *database.User, d Decision, explain func(PolicyStep)) Decision {
+	rules, err := db.GetPolicy()
+	if err != nil {
+		d.Err = fmt.Errorf("error getting policy: %w", err)
+
+		return d
+	}
+
+	if len(rules) == 0 {
+		return d
+	}
+
+	policyReq := policy.Request{
+		Username:      req.Username,
+		MACAddress:    req.MACAddress,
+		SSID:          req.SSID,
+		NASIdentifier: req.NASIdentifier,
+		NASAddress:    req.NASAddress,
+		Time:          req.Time,
+	}
+
+	if user != nil {
+		policyReq.Group = user.Group
+	}
+
+	d = evaluatePolicy(db, rules, policyReq, d, explain)
+
+	// Devices re-authenticate when a time window starts or ends
+	if next := policy.Next(rules, policyReq); !next.IsZero() && d.Accepted() {
+		d.Attributes = withSessionTimeout(d.Attributes, next.Sub(req.Time))
+	}
+
+	return d
+}
+
+// evaluatePolicy evaluates the policy rules in order until one ends the evaluation.
+func evaluatePolicy(db database.Database, rules []database.PolicyRule, req policy.Request, d Decision, explain func(PolicyStep)) Decision {
+	for _, rule := range rules {
+		matched, why := policy.Match(rule, req)
+		if explain != nil {
+			explain(PolicyStep{Rule: rule, Matched: matched, Why: why})
+		}
+
+		if !matched {
+			continue
+		}
+
+		d.Rules = append(d.Rules, rule.Name)
+
+		switch rule.Action {
+		case database.PolicyActionAccept:
+			return d
+		case database.PolicyActionReject:
+			rejected := reject(ReasonPolicyReject, nil)
+			rejected.Rules = d.Rules
+
+			return rejected
+		case database.PolicyActionVLAN:
+			return applyPolicyVLAN(db, rule, d)
+		case database.PolicyActionAttributes:
+			d = applyPolicyAttributes(rule, d)
+		}
+	}
+
+	return d
+}
+
+// applyPolicyVLAN moves an accepted decision to the VLAN of a policy rule and adds the rule's
+// attributes. Rejected decisions stay rejected.
+func applyPolicyVLAN(db database.Database, rule database.PolicyRule, d Decision) Decision {
+	if !d.Accepted() {
+		return d
+	}
+
+	vlan, err := db.GetVLAN(rule.VlanID)
+	if err != nil {
+		// Keep the usual VLAN if the rule's doesn't exist anymore
+		d.Err = fmt.Errorf("error getting VLAN of policy rule %s: %w", rule.Name, err)
+
+		return d
+	}
+
+	reason := ReasonPolicyVLAN
+	if d.Reason.UnknownDevice() {
+		reason = ReasonPolicyNewDevice
+	}
+
+	moved := accept(reason, vlan, d.Err)
+	moved.Rules = d.Rules
+
+	// Keep the attributes that are not about the VLAN, like the Session-Timeout
+	for _, avp := range d.Attributes {
+		if !IsTunnelAttribute(avp.Type) {
+			moved.Attributes.Add(avp.Type, avp.Attribute)
+		}
+	}
+
+	return applyPolicyAttributes(rule, moved)
+}
+
+// applyPolicyAttributes adds the attributes of a policy rule to an accepted decision, replacing
+// the attributes of the same type.
+func applyPolicyAttributes(rule database.PolicyRule, d Decision) Decision {
+	return addAttributes(d, rule.Attributes, "policy rule "+rule.Name, true)
+}
+
+// applyReplyAttributes adds the attributes of the user, its group, and its VLAN to an accepted
+// decision. The user's attributes take precedence over the group's, and the group's over the
+// VLAN's. The attributes set by the policy are kept.
+func applyReplyAttributes(db database.Database, user *database.User, d Decision) Decision {
+	if !d.Accepted() {
+		return d
+	}
+
+	if user != nil {
+		d = addAttributes(d, user.Attributes, "user "+user.Username, false)
+
+		if user.Group != "" {
+			if group, err := db.GetGroup(user.Group); err == nil {
+				d = addAttributes(d, group.Attributes, "group "+group.Name, false)
+			}
+		}
+	}
+
+	if d.VLAN != nil {
+		d = addAttributes(d, d.VLAN.Attributes, "VLAN "+d.VLAN.ID, false)
+	}
+
+	return d
+}
+
+// addAttributes adds attributes to an accepted decision. The attributes of the same type that the
+// decision already has are replaced if replace is true, and kept otherwise. The shortest
+// Session-Timeout always wins, so devices still re-authenticate when their access changes.
+func addAttributes(d Decision, attributes []database.Attribute, owner string, replace bool) Decision {
+	if !d.Accepted() || len(attributes) == 0 {
+		return d
+	}
+
+	existing := slices.Clone(d.Attributes)
+	added := radius.Attributes{}
+
+	for _, a := range attributes {
+		avp, err := dictionary.Encode(a)
+		if err != nil {
+			// Attributes are validated when they are saved
+			d.Err = fmt.Errorf("error encoding attribute of %s: %w", owner, err)
+
+			continue
+		}
+
+		same := func(other *radius.AVP) bool { return dictionary.Same(avp, other) }
+
+		if i := slices.IndexFunc(existing, same); i >= 0 {
+			if avp.Type == rfc2865.SessionTimeout_Type {
+				if binary.BigEndian.Uint32(existing[i].Attribute) <= binary.BigEndian.Uint32(avp.Attribute) {
+					continue
+				}
+			} else if !replace {
+				continue
+			}
+
+			existing = slices.DeleteFunc(existing, same)
+		}
+
+		added = append(added, avp)
+	}
+
+	d.Attributes = append(existing, added...)
+
+	return d
+}
+
+// IsTunnelAttribute reports whether an attribute type is one of the VLAN attributes, which are set from
+// the VLAN of a decision.
+func IsTunnelAttribute(t radius.Type) bool {
+	return t == rfc2868.TunnelType_Type || t == rfc2868.TunnelMediumType_Type || t == rfc2868.TunnelPrivateGroupID_Type
+}
+
+// applyNetworks moves a user to its VLAN for the network of the request, if it has one.
+func applyNetworks(db database.Database, user database.User, req Request, d Decision) Decision {
+	networks, err := database.UserNetworks(db, user)
+	if err != nil {
+		d.Err = fmt.Errorf("error getting networks: %w", err)
+
+		return d
+	}
+
+	for _, n := range networks {
+		if !n.Matches(req.SSID, req.NASIdentifier, req.NASAddress) {
+			continue
+		}
+
+		vlan, err := db.GetVLAN(n.VlanID)
+		if err != nil {
+			// Keep the user's own VLAN if the network's doesn't exist anymore
+			d.Err = fmt.Errorf("error getting VLAN of network %s: %w", n.Network, err)
+
+			return d
+		}
+
+		return accept(ReasonNetworkVLAN, vlan, nil)
+	}
+
+	return d
+}
+
+// applyTempVLAN moves a user to its temporary VLAN if it's active at t.
+func applyTempVLAN(db database.Database, user database.User, t time.Time, d Decision) Decision {
+	override := user.ActiveTempVLAN(t)
+	if override == nil {
+		return d
+	}
+
+	vlan, err := db.GetVLAN(override.VlanID)
+	if err != nil {
+		// Keep the user's own VLAN if the temporary one doesn't exist anymore
+		d.Err = fmt.Errorf("error getting temporary VLAN: %w", err)
+
+		return d
+	}
+
+	return accept(ReasonTempVLAN, vlan, nil)
+}
+
+// applySchedules applies the schedule rule of a user that is active at t to its decision.
+// It also returns the next time a rule starts or ends, or zero if the user has no rules.
+// Users are rejected if their schedules can't be loaded, since they could be outside of them.
+func applySchedules(db database.Database, user database.User, t time.Time, d Decision) (Decision, time.Time) {
+	rules, err := database.UserSchedules(db, user)
+	if err != nil {
+		return reject(ReasonScheduleError, fmt.Errorf("error getting schedules: %w", err)), time.Time{}
+	}
+
+	if len(rules) == 0 {
+		return d, time.Time{}
+	}
+
+	active, next, err := schedule.Evaluate(rules, t)
+	if err != nil {
+		// Rules are validated when they are saved, so keep the device's own VLAN
+		d.Err = fmt.Errorf("error evaluating schedules: %w", err)
+
+		return d, time.Time{}
+	}
+
+	if active != nil {
+		switch active.Action {
+		case database.ScheduleActionReject:
+			return reject(ReasonScheduleReject, nil), next
+		case database.ScheduleActionVLAN:
+			vlan, err := db.GetVLAN(active.VlanID)
+			if err != nil {
+				d.Err = fmt.Errorf("error getting scheduled VLAN: %w", err)
+
+				return d, next
+			}
+
+			d = accept(ReasonScheduleVLAN, vlan, nil)
+		}
+	}
+
+	return d, next
+}
+
+// calledStationIDPattern matches a Called-Station-Id formatted as "<BSSID>:<SSID>" (RFC 3580).
+var calledStationIDPattern = regexp.MustCompile(`^(?:[0-9A-Fa-f]{2}(?:[-:.]?[0-9A-Fa-f]{2}){5}|[0-9A-Fa-f]{4}\.[0-9A-Fa-f]{4}\.[0-9A-Fa-f]{4}):(.+)$`)
+
+// ParseSSID returns the SSID of a Called-Station-Id, which most access points format as
+// "<BSSID>:<SSID>". It's empty if the Called-Station-Id has no SSID.
+func ParseSSID(calledStationID string) string {
+	match := calledStationIDPattern.FindStringSubmatch(calledStationID)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// earliest returns the earliest of two times, ignoring a if it's zero.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || b.Before(a) {
+		return b
+	}
+
+	return a
+}
+
+// withSessionTimeout adds a Session-Timeout attribute, rounded up to the next second. A shorter
+// Session-Timeout that is already set is kept.
+func withSessionTimeout(attributes radius.Attributes, timeout time.Duration) radius.Attributes {
+	seconds := max(math.Ceil(timeout.Seconds()), 1)
+
+	scratch := &radius.Packet{Attributes: attributes}
+	if current, err := rfc2865.SessionTimeout_Lookup(scratch); err == nil && float64(current) <= seconds {
+		return attributes
+	}
+
+	rfc2865.SessionTimeout_Set(scratch, rfc2865.SessionTimeout(seconds)) //nolint:errcheck // this doesn't return an error
+
+	return scratch.Attributes
+}
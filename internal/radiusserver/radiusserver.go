@@ -4,13 +4,16 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/maronato/authifi/internal/config"
 	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/eventlog"
 	"github.com/maronato/authifi/internal/logging"
-	"github.com/maronato/authifi/internal/telegram"
+	"github.com/maronato/authifi/internal/notify"
 	"golang.org/x/sync/errgroup"
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
@@ -23,6 +26,10 @@ const (
 	filledPassword                    = "********"
 )
 
+// ErrorNotifyInterval is the minimum time between two error notifications of the same kind. Errors
+// repeat on every request until they are fixed, so the ones in between are only logged.
+const ErrorNotifyInterval = time.Hour
+
 // setPacketVLAN sets the VLAN information in the RADIUS packet.
 func setPacketVLAN(packet *radius.Packet, vlan database.VLAN) {
 	rfc2868.TunnelPrivateGroupID_SetString(packet, 0, vlan.ID) //nolint:errcheck // this doesn't return an error
@@ -41,26 +48,140 @@ func setPacketVLAN(packet *radius.Packet, vlan database.VLAN) {
 	}
 }
 
-// StartServer starts the RADIUS server.
-func StartServer(ctx context.Context, cfg *config.Config, db database.Database, botServer *telegram.BotServer) error {
-	eg, egCtx := errgroup.WithContext(ctx)
+// getNASAddress returns the NAS-IP-Address of the request, falling back to its source address.
+func getNASAddress(r *radius.Request) string {
+	if ip := rfc2865.NASIPAddress_Get(r.Packet); ip != nil {
+		return ip.String()
+	}
 
-	// RADIUS handler for all requests
-	handler := radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr.String()); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr.String()
+}
+
+// Hook is called after every access decision has been sent.
+type Hook func(ctx context.Context, req Request, decision Decision)
+
+// EventLogHook records every access decision in the event log.
+func EventLogHook(events *eventlog.Log) Hook {
+	return func(ctx context.Context, req Request, decision Decision) {
+		event := eventlog.Event{
+			Time:            time.Now(),
+			Username:        req.Username,
+			MACAddress:      req.MACAddress,
+			NASAddress:      req.NASAddress,
+			NASIdentifier:   req.NASIdentifier,
+			SessionID:       req.SessionID,
+			CalledStationID: req.CalledStationID,
+			Decision:        eventlog.DecisionReject,
+			Reason:          decision.Reason.String(),
+		}
+
+		if decision.Accepted() {
+			event.Decision = eventlog.DecisionAccept
+		}
+
+		if decision.VLAN != nil {
+			event.VlanID = decision.VLAN.ID
+		}
+
+		if err := events.Record(event); err != nil {
+			logging.FromCtx(ctx).Error("error recording authentication event", slog.Any("error", err))
+		}
+	}
+}
+
+// PendingHook records unknown devices in the pending device queue so they survive restarts.
+func PendingHook(db database.Database) Hook {
+	return func(ctx context.Context, req Request, decision Decision) {
+		if !decision.Reason.UnknownDevice() {
+			return
+		}
+
+		pending := database.PendingDevice{
+			Username:   req.Username,
+			Password:   req.Password,
+			MACAddress: req.MACAddress,
+			NASAddress: req.NASAddress,
+			LastSeen:   time.Now(),
+		}
+
+		if _, err := db.RecordPendingDevice(pending); err != nil {
+			logging.FromCtx(ctx).Error("error recording pending device", slog.Any("error", err))
+		}
+	}
+}
+
+// NotifierHook notifies about new devices, blocked attempts, and errors. Errors of the same kind
+// are notified at most once every ErrorNotifyInterval, whichever device they come from.
+func NotifierHook(n notify.Notifier) Hook {
+	var (
+		mu sync.Mutex
+		// notifiedErrors are when each kind of error was last notified.
+		notifiedErrors = make(map[Reason]time.Time)
+	)
+
+	// shouldNotifyError reports whether an error of a kind should be notified at t, and records it if so.
+	shouldNotifyError := func(reason Reason, t time.Time) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if last, ok := notifiedErrors[reason]; ok && t.Sub(last) < ErrorNotifyInterval {
+			return false
+		}
+
+		notifiedErrors[reason] = t
+
+		return true
+	}
+
+	return func(ctx context.Context, req Request, decision Decision) {
+		device := notify.Device{
+			Username:        req.Username,
+			Password:        req.Password,
+			MACAddress:      req.MACAddress,
+			NASAddress:      req.NASAddress,
+			CalledStationID: req.CalledStationID,
+			Time:            req.Time,
+		}
+
+		switch decision.Reason {
+		case ReasonUnknownDevice, ReasonUnknownDeviceNoDefault, ReasonQuarantine, ReasonNetworkDefault, ReasonPolicyNewDevice:
+			n.NotifyNewDevice(ctx, device)
+		case ReasonBlocked:
+			n.NotifyBlockedAttempt(ctx, device)
+		case ReasonBlocklistError, ReasonMissingUserVLAN, ReasonMissingUserVLANNoDefault, ReasonScheduleError:
+			if !shouldNotifyError(decision.Reason, req.Time) {
+				logging.FromCtx(ctx).Debug("Suppressed error notification", slog.String("reason", decision.Reason.String()), slog.String("username", req.Username))
+
+				return
+			}
+
+			n.NotifyError(ctx, device, decision.Err)
+		case ReasonUserVLAN, ReasonGroupVLAN, ReasonScheduleVLAN, ReasonScheduleReject, ReasonWrongPassword, ReasonUserExpired, ReasonTempVLAN,
+			ReasonNetworkVLAN, ReasonPolicyReject, ReasonPolicyVLAN:
+			// Nothing to notify
+		}
+	}
+}
+
+// NewHandler creates the RADIUS handler for access requests.
+func NewHandler(ctx context.Context, cfg *config.Config, db database.Database, hooks ...Hook) radius.Handler {
+	return radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
 		startTime := time.Now()
 
 		// Initialize logger and context
-		l := logging.FromCtx(egCtx)
-		r = r.WithContext(egCtx)
+		l := logging.FromCtx(ctx)
+		r = r.WithContext(ctx)
 
 		// Get the request information
-		username := rfc2865.UserName_GetString(r.Packet)
-		password := rfc2865.UserPassword_GetString(r.Packet)
-		macAddress := rfc2865.CallingStationID_GetString(r.Packet)
+		req := newRequest(r)
 
 		// Censor the password and secret in the logs
 		privacyPassword := emptyPassword
-		if password != "" {
+		if req.Password != "" {
 			privacyPassword = filledPassword
 		}
 
@@ -73,10 +194,12 @@ func StartServer(ctx context.Context, cfg *config.Config, db database.Database,
 		var requestGroup slog.Attr
 		if cfg.Verbose >= config.VerboseLevelAccessLogs {
 			requestGroup = slog.Group("request",
-				slog.String("username", username),
+				slog.String("username", req.Username),
 				slog.String("password", privacyPassword),
-				slog.String("mac_address", macAddress),
+				slog.String("mac_address", req.MACAddress),
 				slog.String("remote_addr", r.RemoteAddr.String()),
+				slog.String("nas_address", req.NASAddress),
+				slog.String("called_station_id", req.CalledStationID),
 				slog.String("identifier", fmt.Sprintf("%d", r.Identifier)),
 				slog.String("authenticator", fmt.Sprintf("%x", r.Authenticator)),
 				slog.String("secret", privacySecret),
@@ -84,8 +207,8 @@ func StartServer(ctx context.Context, cfg *config.Config, db database.Database,
 			)
 		} else {
 			requestGroup = slog.Group("request",
-				slog.String("username", username),
-				slog.String("mac_address", macAddress),
+				slog.String("username", req.Username),
+				slog.String("mac_address", req.MACAddress),
 				slog.String("remote_addr", r.RemoteAddr.String()),
 			)
 		}
@@ -93,55 +216,19 @@ func StartServer(ctx context.Context, cfg *config.Config, db database.Database,
 		// Add the request log group to the logger
 		l = l.With(requestGroup)
 
-		// Response packet
-		var response *radius.Packet
+		// Decide what to do with the request
+		decision := Decide(db, req)
+		if decision.Err != nil {
+			l.Debug("database error during access decision", slog.String("reason", decision.Reason.String()), slog.Any("error", decision.Err))
+		}
 
-		// Get default VLAN
-		vlan, err := db.GetDefaultVLAN()
-		if err != nil {
-			l.Debug("error getting default VLAN", slog.Any("error", err))
+		// Build the response packet
+		response := r.Response(decision.Code)
+		decision.Apply(response)
 
-			// If there's an error getting the default VLAN, default to rejecting the request
-			response = r.Response(radius.CodeAccessReject)
-		} else {
-			// If there's a default VLAN, default to accepting the request and setting the VLAN in the response
-			response = r.Response(radius.CodeAccessAccept)
-			setPacketVLAN(response, vlan)
-		}
-
-		var user database.User
-
-		// Start by checking if the user is blocked
-		userBlocked, err := db.IsUserBlocked(username)
-		if err != nil { //nolint:nestif // This is the simplest way to handle the errors
-			// If there's an error checking if the user is blocked, log it and fallback to rejecting the request
-			l.Debug("error checking if user is blocked", slog.Any("error", err))
-
-			response = r.Response(radius.CodeAccessReject)
-		} else if userBlocked {
-			// If the user is blocked, reject the request
-			l.Debug("user is blocked")
-
-			response = r.Response(radius.CodeAccessReject)
-		} else if user, err = db.GetUser(username); err != nil {
-			// If the user doesn't exist, notify the bot of the login attempt and keep the response as is
-			l.Debug("error getting user", slog.Any("error", err))
-
-			// Notify the user of the login attempt
-			go botServer.NotifyLoginAttempt(username, password, macAddress)
-		} else if user.Password != password {
-			// If the password is incorrect, reject the request
-			l.Debug("incorrect password for user")
-
-			// If the password is incorrect, reject the request
-			response = r.Response(radius.CodeAccessReject)
-		} else if vlan, err = db.GetVLAN(user.VlanID); err != nil {
-			// If there's an error getting the user's VLAN, log it and keep the response as is
-			l.Debug("error getting VLAN for user", slog.Any("error", err))
-		} else {
-			// If the user exists and the password is correct, accept the request and set the VLAN in the response
-			response = r.Response(radius.CodeAccessAccept)
-			setPacketVLAN(response, vlan)
+		// A Reply-Message from the database takes precedence over the reason
+		if _, err := rfc2865.ReplyMessage_LookupString(response); cfg.ReplyMessage && err != nil {
+			rfc2865.ReplyMessage_SetString(response, decision.Reason.Message()) //nolint:errcheck // the message is always short enough
 		}
 
 		// Censor the response secret in the logs
@@ -165,6 +252,8 @@ func StartServer(ctx context.Context, cfg *config.Config, db database.Database,
 				slog.String("authenticator", fmt.Sprintf("%x", response.Authenticator)),
 				slog.String("secret", privacyResponseSecret),
 				slog.String("duration", elapsed.String()),
+				slog.String("reason", decision.Reason.String()),
+				slog.Any("policy_rules", decision.Rules),
 				// VLAN information
 				slog.String("vlan_id", rVlanID),
 				slog.Any("tunnel_type", rTunnelType),
@@ -187,7 +276,21 @@ func StartServer(ctx context.Context, cfg *config.Config, db database.Database,
 				l.Error("Unknown response code")
 			}
 		}
+
+		// Let the hooks know about the decision
+		for _, hook := range hooks {
+			hook(ctx, req, decision)
+		}
 	})
+}
+
+// StartServer starts the RADIUS server.
+func StartServer(ctx context.Context, cfg *config.Config, db database.Database, notifier notify.Notifier, events *eventlog.Log) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	// RADIUS handler for all requests
+	// Pending devices are recorded before notifying so notifiers can find them
+	handler := NewHandler(egCtx, cfg, db, EventLogHook(events), PendingHook(db), NotifierHook(notifier))
 
 	l := logging.FromCtx(egCtx)
 
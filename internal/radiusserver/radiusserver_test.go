@@ -0,0 +1,1047 @@
+package radiusserver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	memorydatabase "github.com/maronato/authifi/internal/database/memory"
+	"github.com/maronato/authifi/internal/dictionary"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/radiusserver"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2868"
+)
+
+const (
+	testSecret  = "test-secret"
+	testTimeout = 2 * time.Second
+
+	// vlanTunnelType is the default tunnel type, VLAN(13).
+	vlanTunnelType rfc2868.TunnelType = 13
+)
+
+// fakeNotifier records the notifications it receives.
+type fakeNotifier struct {
+	mu      sync.Mutex
+	devices []string
+	blocked []string
+	errors  []error
+}
+
+func (n *fakeNotifier) NotifyNewDevice(_ context.Context, d notify.Device) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.devices = append(n.devices, d.Username)
+}
+
+func (n *fakeNotifier) NotifyBlockedAttempt(_ context.Context, d notify.Device) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.blocked = append(n.blocked, d.Username)
+}
+
+func (n *fakeNotifier) NotifyError(_ context.Context, _ notify.Device, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.errors = append(n.errors, err)
+}
+
+func (n *fakeNotifier) NotifyChange(_ context.Context, _ notify.Change) {}
+
+func (n *fakeNotifier) counts() (devices, blocked, errors int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.devices), len(n.blocked), len(n.errors)
+}
+
+// brokenVLANDatabase is a MemoryDatabase where one VLAN can't be found.
+type brokenVLANDatabase struct {
+	*memorydatabase.MemoryDatabase
+	missingVLANID string
+}
+
+func (d *brokenVLANDatabase) GetVLAN(id string) (database.VLAN, error) {
+	if id == d.missingVLANID {
+		return database.VLAN{}, fmt.Errorf("error getting VLAN %s: %w", id, database.ErrVLANNotFound)
+	}
+
+	return d.MemoryDatabase.GetVLAN(id) //nolint:wrapcheck // passthrough
+}
+
+// brokenGroupDatabase is a MemoryDatabase where groups can't be read.
+type brokenGroupDatabase struct {
+	*memorydatabase.MemoryDatabase
+}
+
+func (d *brokenGroupDatabase) GetGroup(name string) (database.Group, error) {
+	return database.Group{}, fmt.Errorf("error reading group %s: %w", name, errBroken)
+}
+
+// errBroken is returned by the broken databases.
+var errBroken = errors.New("broken database")
+
+// harness runs the access handler on a loopback PacketServer.
+type harness struct {
+	addr      string
+	notifier  *fakeNotifier
+	decisions chan radiusserver.Decision
+}
+
+func startHarness(t *testing.T, cfg *config.Config, db database.Database) *harness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := &harness{
+		notifier:  &fakeNotifier{},
+		decisions: make(chan radiusserver.Decision, 1),
+	}
+
+	// The decision hook runs last, so the notifier has been called once a decision is received
+	decisionHook := func(_ context.Context, _ radiusserver.Request, d radiusserver.Decision) {
+		h.decisions <- d
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+
+	server := &radius.PacketServer{
+		Handler:      radiusserver.NewHandler(ctx, cfg, db, radiusserver.PendingHook(db), radiusserver.NotifierHook(h.notifier), decisionHook),
+		SecretSource: radius.StaticSecretSource([]byte(testSecret)),
+	}
+
+	go server.Serve(conn) //nolint:errcheck // Serve returns when the server is shut down
+
+	t.Cleanup(func() {
+		server.Shutdown(context.Background()) //nolint:errcheck // best effort
+	})
+
+	h.addr = conn.LocalAddr().String()
+
+	return h
+}
+
+// exchange sends an Access-Request and waits for both the response and the handler's decision.
+func (h *harness) exchange(t *testing.T, username, password string) (*radius.Packet, radiusserver.Decision) {
+	t.Helper()
+
+	packet := radius.New(radius.CodeAccessRequest, []byte(testSecret))
+	rfc2865.UserName_SetString(packet, username)         //nolint:errcheck // test
+	rfc2865.UserPassword_SetString(packet, password)     //nolint:errcheck // test
+	rfc2865.CallingStationID_SetString(packet, username) //nolint:errcheck // test
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	response, err := radius.Exchange(ctx, packet, h.addr)
+	if err != nil {
+		t.Fatalf("error exchanging packet: %v", err)
+	}
+
+	select {
+	case d := <-h.decisions:
+		return response, d
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for decision")
+	}
+
+	return nil, radiusserver.Decision{}
+}
+
+func newTestConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.RadiusSecret = testSecret
+
+	return cfg
+}
+
+func TestAccessHandler(t *testing.T) {
+	t.Parallel()
+
+	defaultVLAN := database.VLAN{ID: "30", Name: "Guest", Default: true}
+	mainVLAN := database.VLAN{ID: "10", Name: "Main"}
+	customVLAN := database.VLAN{ID: "40", Name: "Custom", TunnelType: 3, TunnelMediumType: 1}
+
+	tests := []struct {
+		name           string
+		withDefault    bool
+		username       string
+		password       string
+		wantCode       radius.Code
+		wantReason     radiusserver.Reason
+		wantVLAN       string
+		wantTunnelType rfc2868.TunnelType
+		wantMediumType rfc2868.TunnelMediumType
+		wantNotified   bool
+		wantBlocked    bool
+		wantError      bool
+	}{
+		{
+			name:           "known user on its VLAN",
+			withDefault:    true,
+			username:       "known",
+			password:       "known",
+			wantCode:       radius.CodeAccessAccept,
+			wantReason:     radiusserver.ReasonUserVLAN,
+			wantVLAN:       mainVLAN.ID,
+			wantTunnelType: vlanTunnelType,
+			wantMediumType: rfc2868.TunnelMediumType_Value_IEEE802,
+		},
+		{
+			name:         "blocked user",
+			withDefault:  true,
+			username:     "blocked",
+			password:     "blocked",
+			wantCode:     radius.CodeAccessReject,
+			wantReason:   radiusserver.ReasonBlocked,
+			wantNotified: false,
+			wantBlocked:  true,
+		},
+		{
+			name:       "wrong password",
+			username:   "known",
+			password:   "wrong",
+			wantCode:   radius.CodeAccessReject,
+			wantReason: radiusserver.ReasonWrongPassword,
+		},
+		{
+			name:           "unknown device with default VLAN",
+			withDefault:    true,
+			username:       "unknown",
+			password:       "unknown",
+			wantCode:       radius.CodeAccessAccept,
+			wantReason:     radiusserver.ReasonUnknownDevice,
+			wantVLAN:       defaultVLAN.ID,
+			wantTunnelType: vlanTunnelType,
+			wantMediumType: rfc2868.TunnelMediumType_Value_IEEE802,
+			wantNotified:   true,
+		},
+		{
+			name:         "unknown device without default VLAN",
+			username:     "unknown",
+			password:     "unknown",
+			wantCode:     radius.CodeAccessReject,
+			wantReason:   radiusserver.ReasonUnknownDeviceNoDefault,
+			wantNotified: true,
+		},
+		{
+			name:           "missing user VLAN with default VLAN",
+			withDefault:    true,
+			username:       "orphan",
+			password:       "orphan",
+			wantCode:       radius.CodeAccessAccept,
+			wantReason:     radiusserver.ReasonMissingUserVLAN,
+			wantVLAN:       defaultVLAN.ID,
+			wantTunnelType: vlanTunnelType,
+			wantMediumType: rfc2868.TunnelMediumType_Value_IEEE802,
+			wantError:      true,
+		},
+		{
+			name:       "missing user VLAN without default VLAN",
+			username:   "orphan",
+			password:   "orphan",
+			wantCode:   radius.CodeAccessReject,
+			wantReason: radiusserver.ReasonMissingUserVLANNoDefault,
+			wantError:  true,
+		},
+		{
+			name:           "user inherits the VLAN of its group",
+			username:       "camera",
+			password:       "camera",
+			wantCode:       radius.CodeAccessAccept,
+			wantReason:     radiusserver.ReasonGroupVLAN,
+			wantVLAN:       customVLAN.ID,
+			wantTunnelType: rfc2868.TunnelType(customVLAN.TunnelType),
+			wantMediumType: rfc2868.TunnelMediumType(customVLAN.TunnelMediumType),
+		},
+		{
+			name:           "user VLAN overrides the group",
+			username:       "doorbell",
+			password:       "doorbell",
+			wantCode:       radius.CodeAccessAccept,
+			wantReason:     radiusserver.ReasonUserVLAN,
+			wantVLAN:       mainVLAN.ID,
+			wantTunnelType: vlanTunnelType,
+			wantMediumType: rfc2868.TunnelMediumType_Value_IEEE802,
+		},
+		{
+			name:           "custom tunnel types",
+			username:       "custom",
+			password:       "custom",
+			wantCode:       radius.CodeAccessAccept,
+			wantReason:     radiusserver.ReasonUserVLAN,
+			wantVLAN:       customVLAN.ID,
+			wantTunnelType: rfc2868.TunnelType(customVLAN.TunnelType),
+			wantMediumType: rfc2868.TunnelMediumType(customVLAN.TunnelMediumType),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			memory := memorydatabase.NewMemoryDatabase()
+			db := &brokenVLANDatabase{MemoryDatabase: memory, missingVLANID: "99"}
+
+			vlans := []database.VLAN{mainVLAN, customVLAN, {ID: "99", Name: "Deleted"}}
+			if tt.withDefault {
+				vlans = append(vlans, defaultVLAN)
+			}
+
+			for _, v := range vlans {
+				if err := memory.CreateVLAN(v); err != nil {
+					t.Fatalf("error creating VLAN: %v", err)
+				}
+			}
+
+			if err := memory.CreateGroup(database.Group{Name: "cameras", VlanID: customVLAN.ID}); err != nil {
+				t.Fatalf("error creating group: %v", err)
+			}
+
+			users := []database.User{
+				{Username: "known", Password: "known", VlanID: mainVLAN.ID},
+				{Username: "camera", Password: "camera", Group: "cameras"},
+				{Username: "doorbell", Password: "doorbell", VlanID: mainVLAN.ID, Group: "cameras"},
+				{Username: "custom", Password: "custom", VlanID: customVLAN.ID},
+				{Username: "orphan", Password: "orphan", VlanID: "99"},
+			}
+			for _, u := range users {
+				if err := memory.CreateUser(u); err != nil {
+					t.Fatalf("error creating user: %v", err)
+				}
+			}
+
+			if err := memory.BlockUser("blocked"); err != nil {
+				t.Fatalf("error blocking user: %v", err)
+			}
+
+			h := startHarness(t, newTestConfig(), db)
+
+			response, decision := h.exchange(t, tt.username, tt.password)
+
+			if response.Code != tt.wantCode {
+				t.Errorf("got code %s, want %s", response.Code, tt.wantCode)
+			}
+
+			if decision.Reason != tt.wantReason {
+				t.Errorf("got reason %s, want %s", decision.Reason, tt.wantReason)
+			}
+
+			_, vlanID := rfc2868.TunnelPrivateGroupID_GetString(response)
+			if vlanID != tt.wantVLAN {
+				t.Errorf("got VLAN %q, want %q", vlanID, tt.wantVLAN)
+			}
+
+			if tt.wantVLAN != "" {
+				if _, tunnelType := rfc2868.TunnelType_Get(response); tunnelType != tt.wantTunnelType {
+					t.Errorf("got tunnel type %d, want %d", tunnelType, tt.wantTunnelType)
+				}
+
+				if _, mediumType := rfc2868.TunnelMediumType_Get(response); mediumType != tt.wantMediumType {
+					t.Errorf("got tunnel medium type %d, want %d", mediumType, tt.wantMediumType)
+				}
+			}
+
+			devices, blocked, errors := h.notifier.counts()
+			if notified := devices > 0; notified != tt.wantNotified {
+				t.Errorf("got notified %t, want %t", notified, tt.wantNotified)
+			}
+
+			if notified := blocked > 0; notified != tt.wantBlocked {
+				t.Errorf("got blocked notification %t, want %t", notified, tt.wantBlocked)
+			}
+
+			if notified := errors > 0; notified != tt.wantError {
+				t.Errorf("got error notification %t, want %t", notified, tt.wantError)
+			}
+
+			// Only unknown devices are queued as pending
+			pending, err := db.GetPendingDevices()
+			if err != nil {
+				t.Fatalf("error getting pending devices: %v", err)
+			}
+
+			if queued := len(pending) > 0; queued != tt.wantNotified {
+				t.Errorf("got pending %t, want %t", queued, tt.wantNotified)
+			}
+		})
+	}
+}
+
+func TestAccessHandlerReplyMessage(t *testing.T) {
+	t.Parallel()
+
+	// Seed the database before the server starts, since the handler reads it concurrently
+	db := memorydatabase.NewMemoryDatabase()
+	if err := db.BlockUser("blocked"); err != nil {
+		t.Fatalf("error blocking user: %v", err)
+	}
+
+	vlan := database.VLAN{ID: "10", Name: "Main", Default: true, Attributes: []database.Attribute{{Name: "Reply-Message", Value: "Welcome"}}}
+	if err := db.CreateVLAN(vlan); err != nil {
+		t.Fatalf("error creating VLAN: %v", err)
+	}
+
+	cfg := newTestConfig()
+	cfg.ReplyMessage = true
+
+	h := startHarness(t, cfg, db)
+
+	response, _ := h.exchange(t, "blocked", "blocked")
+
+	if got, want := rfc2865.ReplyMessage_GetString(response), radiusserver.ReasonBlocked.Message(); got != want {
+		t.Errorf("got Reply-Message %q, want %q", got, want)
+	}
+
+	// A Reply-Message from the database takes precedence over the reason
+	response, _ = h.exchange(t, "new-device", "new-device")
+
+	if got := rfc2865.ReplyMessage_GetString(response); got != "Welcome" {
+		t.Errorf("got Reply-Message %q, want %q", got, "Welcome")
+	}
+}
+
+func TestNotifierHookErrors(t *testing.T) {
+	t.Parallel()
+
+	n := &fakeNotifier{}
+	hook := radiusserver.NotifierHook(n)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		username string
+		reason   radiusserver.Reason
+		after    time.Duration
+	}{
+		{username: "phone", reason: radiusserver.ReasonMissingUserVLAN},
+		// Errors of the same kind are suppressed, whichever device they come from
+		{username: "phone", reason: radiusserver.ReasonMissingUserVLAN, after: time.Minute},
+		{username: "laptop", reason: radiusserver.ReasonMissingUserVLAN, after: 2 * time.Minute},
+		// Other kinds of errors are notified
+		{username: "phone", reason: radiusserver.ReasonScheduleError, after: 3 * time.Minute},
+		// And the same kind is notified again after the interval
+		{username: "laptop", reason: radiusserver.ReasonMissingUserVLAN, after: radiusserver.ErrorNotifyInterval},
+	}
+
+	for _, step := range steps {
+		req := radiusserver.Request{Username: step.username, Time: start.Add(step.after)}
+		hook(context.Background(), req, radiusserver.Decision{Reason: step.reason, Err: errBroken})
+	}
+
+	if _, _, errors := n.counts(); errors != 3 {
+		t.Errorf("got %d error notifications, want 3", errors)
+	}
+}
+
+func TestPendingDevices(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+	if err := db.CreateVLAN(database.VLAN{ID: "30", Name: "Guest", Default: true}); err != nil {
+		t.Fatalf("error creating VLAN: %v", err)
+	}
+
+	h := startHarness(t, newTestConfig(), db)
+
+	for range 3 {
+		h.exchange(t, "unknown", "unknown")
+	}
+
+	h.exchange(t, "other", "other")
+
+	pending, err := db.GetPendingDevices()
+	if err != nil {
+		t.Fatalf("error getting pending devices: %v", err)
+	}
+
+	if len(pending) != 2 {
+		t.Fatalf("got %d pending devices, want 2", len(pending))
+	}
+
+	if pending[0].Username != "unknown" || pending[0].Attempts != 3 {
+		t.Errorf("got first pending device %s with %d attempts, want unknown with 3", pending[0].Username, pending[0].Attempts)
+	}
+
+	if pending[0].LastSeen.Before(pending[0].FirstSeen) {
+		t.Errorf("last seen %s is before first seen %s", pending[0].LastSeen, pending[0].FirstSeen)
+	}
+
+	// Handled devices are no longer pending
+	if err := db.CreateUser(database.User{Username: "unknown", Password: "unknown", VlanID: "30"}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	if err := db.BlockUser("other"); err != nil {
+		t.Fatalf("error blocking user: %v", err)
+	}
+
+	if pending, _ := db.GetPendingDevices(); len(pending) != 0 {
+		t.Errorf("got %d pending devices after handling them, want 0", len(pending))
+	}
+}
+
+func TestPolicyUnknownDevices(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main", Default: true}, {ID: "20", Name: "Printers"}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	rules := []database.PolicyRule{
+		{Name: "printers", Match: database.PolicyMatch{Username: "printer-*"}, Action: database.PolicyActionVLAN, VlanID: "20"},
+		{Name: "spam", Match: database.PolicyMatch{Username: "spam-*"}, Action: database.PolicyActionReject},
+	}
+	if err := db.SetPolicy(rules); err != nil {
+		t.Fatalf("error setting policy: %v", err)
+	}
+
+	h := startHarness(t, newTestConfig(), db)
+
+	// Unknown devices moved by a rule are announced and wait for approval
+	if _, d := h.exchange(t, "printer-1", "printer-1"); d.Reason != radiusserver.ReasonPolicyNewDevice || d.VLAN == nil || d.VLAN.ID != "20" {
+		t.Errorf("got reason %s on VLAN %v, want %s on 20", d.Reason, d.VLAN, radiusserver.ReasonPolicyNewDevice)
+	}
+
+	// Unknown devices rejected by a rule are neither announced nor queued
+	if _, d := h.exchange(t, "spam-1", "spam-1"); d.Reason != radiusserver.ReasonPolicyReject {
+		t.Errorf("got reason %s, want %s", d.Reason, radiusserver.ReasonPolicyReject)
+	}
+
+	if devices, blocked, errors := h.notifier.counts(); devices != 1 || blocked != 0 || errors != 0 {
+		t.Errorf("got %d new devices, %d blocked attempts, and %d errors, want 1, 0, and 0", devices, blocked, errors)
+	}
+
+	pending, err := db.GetPendingDevices()
+	if err != nil {
+		t.Fatalf("error getting pending devices: %v", err)
+	}
+
+	if len(pending) != 1 || pending[0].Username != "printer-1" {
+		t.Errorf("got pending devices %+v, want printer-1", pending)
+	}
+}
+
+func TestDecideSchedules(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main"}, {ID: "20", Name: "Restricted"}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	// The group moves tablets to the restricted VLAN in the afternoon, and the user is rejected at night
+	group := database.Group{Name: "tablets", VlanID: "10", Schedules: []database.Schedule{
+		{From: "15:00", To: "18:00", Timezone: "UTC", Action: database.ScheduleActionVLAN, VlanID: "20"},
+	}}
+	if err := db.CreateGroup(group); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	user := database.User{Username: "tablet", Password: "tablet", Group: "tablets", Schedules: []database.Schedule{
+		{From: "21:00", To: "07:00", Timezone: "UTC", Action: database.ScheduleActionReject},
+	}}
+	if err := db.CreateUser(user); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		hour        int
+		wantReason  radiusserver.Reason
+		wantVLAN    string
+		wantTimeout rfc2865.SessionTimeout
+	}{
+		{name: "outside the windows", hour: 10, wantReason: radiusserver.ReasonGroupVLAN, wantVLAN: "10", wantTimeout: 5 * 60 * 60},
+		{name: "group window", hour: 16, wantReason: radiusserver.ReasonScheduleVLAN, wantVLAN: "20", wantTimeout: 2 * 60 * 60},
+		{name: "user window", hour: 23, wantReason: radiusserver.ReasonScheduleReject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := radiusserver.Request{Username: "tablet", Password: "tablet", Time: time.Date(2024, 1, 1, tt.hour, 0, 0, 0, time.UTC)}
+			d := radiusserver.Decide(db, req)
+
+			if d.Reason != tt.wantReason {
+				t.Errorf("got reason %s, want %s", d.Reason, tt.wantReason)
+			}
+
+			if tt.wantVLAN == "" {
+				if d.Accepted() {
+					t.Errorf("got accepted on %v, want rejected", d.VLAN)
+				}
+
+				return
+			}
+
+			if d.VLAN == nil || d.VLAN.ID != tt.wantVLAN {
+				t.Errorf("got VLAN %v, want %s", d.VLAN, tt.wantVLAN)
+			}
+
+			packet := &radius.Packet{Attributes: d.Attributes}
+			if got := rfc2865.SessionTimeout_Get(packet); got != tt.wantTimeout {
+				t.Errorf("got Session-Timeout %d, want %d", got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestDecideScheduleError(t *testing.T) {
+	t.Parallel()
+
+	memory := memorydatabase.NewMemoryDatabase()
+
+	if err := memory.CreateVLAN(database.VLAN{ID: "10", Name: "Main", Default: true}); err != nil {
+		t.Fatalf("error creating VLAN: %v", err)
+	}
+
+	if err := memory.CreateGroup(database.Group{Name: "tablets", VlanID: "10"}); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	if err := memory.CreateUser(database.User{Username: "tablet", Password: "tablet", VlanID: "10", Group: "tablets"}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	// Devices are rejected when their group's schedules can't be read
+	db := &brokenGroupDatabase{MemoryDatabase: memory}
+
+	d := radiusserver.Decide(db, radiusserver.Request{Username: "tablet", Password: "tablet", Time: time.Now()})
+	if d.Accepted() || d.Reason != radiusserver.ReasonScheduleError || !errors.Is(d.Err, errBroken) {
+		t.Errorf("got reason %s and error %v, want a rejection with %s", d.Reason, d.Err, radiusserver.ReasonScheduleError)
+	}
+}
+
+func TestDecideMissingVLANSchedules(t *testing.T) {
+	t.Parallel()
+
+	memory := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main", Default: true}, {ID: "99", Name: "Deleted"}} {
+		if err := memory.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	// The tablet's VLAN is gone, and it's rejected at night
+	user := database.User{Username: "tablet", Password: "tablet", VlanID: "99", Schedules: []database.Schedule{
+		{From: "21:00", To: "07:00", Timezone: "UTC", Action: database.ScheduleActionReject},
+	}}
+	if err := memory.CreateUser(user); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	db := &brokenVLANDatabase{MemoryDatabase: memory, missingVLANID: "99"}
+
+	tests := []struct {
+		name        string
+		hour        int
+		wantReason  radiusserver.Reason
+		wantTimeout rfc2865.SessionTimeout
+	}{
+		{name: "outside the window", hour: 10, wantReason: radiusserver.ReasonMissingUserVLAN, wantTimeout: 11 * 60 * 60},
+		{name: "inside the window", hour: 23, wantReason: radiusserver.ReasonScheduleReject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := radiusserver.Request{Username: "tablet", Password: "tablet", Time: time.Date(2024, 1, 1, tt.hour, 0, 0, 0, time.UTC)}
+			d := radiusserver.Decide(db, req)
+
+			if d.Reason != tt.wantReason {
+				t.Errorf("got reason %s, want %s", d.Reason, tt.wantReason)
+			}
+
+			if tt.wantTimeout == 0 {
+				if d.Accepted() {
+					t.Errorf("got accepted on %v, want rejected", d.VLAN)
+				}
+
+				return
+			}
+
+			if d.VLAN == nil || d.VLAN.ID != "10" {
+				t.Errorf("got VLAN %v, want 10", d.VLAN)
+			}
+
+			packet := &radius.Packet{Attributes: d.Attributes}
+			if got := rfc2865.SessionTimeout_Get(packet); got != tt.wantTimeout {
+				t.Errorf("got Session-Timeout %d, want %d", got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestDecideExpiry(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main"}, {ID: "30", Name: "Guests"}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	// The guest can connect for a day, and is on the main VLAN for the first two hours
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(24 * time.Hour)
+
+	user := database.User{
+		Username:  "guest",
+		Password:  "guest",
+		VlanID:    "30",
+		ExpiresAt: &expiresAt,
+		TempVLAN:  &database.VLANOverride{VlanID: "10", ExpiresAt: now.Add(2 * time.Hour)},
+	}
+	if err := db.CreateUser(user); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		after       time.Duration
+		wantReason  radiusserver.Reason
+		wantVLAN    string
+		wantTimeout rfc2865.SessionTimeout
+	}{
+		{name: "temporary VLAN", wantReason: radiusserver.ReasonTempVLAN, wantVLAN: "10", wantTimeout: 2 * 60 * 60},
+		{name: "own VLAN", after: 4 * time.Hour, wantReason: radiusserver.ReasonUserVLAN, wantVLAN: "30", wantTimeout: 20 * 60 * 60},
+		{name: "expired", after: 24 * time.Hour, wantReason: radiusserver.ReasonUserExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := radiusserver.Request{Username: "guest", Password: "guest", Time: now.Add(tt.after)}
+			d := radiusserver.Decide(db, req)
+
+			if d.Reason != tt.wantReason {
+				t.Errorf("got reason %s, want %s", d.Reason, tt.wantReason)
+			}
+
+			if tt.wantVLAN == "" {
+				if d.Accepted() {
+					t.Errorf("got accepted on %v, want rejected", d.VLAN)
+				}
+
+				return
+			}
+
+			if d.VLAN == nil || d.VLAN.ID != tt.wantVLAN {
+				t.Errorf("got VLAN %v, want %s", d.VLAN, tt.wantVLAN)
+			}
+
+			packet := &radius.Packet{Attributes: d.Attributes}
+			if got := rfc2865.SessionTimeout_Get(packet); got != tt.wantTimeout {
+				t.Errorf("got Session-Timeout %d, want %d", got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestDecideQuarantine(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main", Default: true}, {ID: "99", Name: "Quarantine", Quarantine: true}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	if err := db.CreateUser(database.User{Username: "phone", Password: "phone", VlanID: "10"}); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	// Unknown devices are isolated, known devices keep their VLAN
+	d := radiusserver.Decide(db, radiusserver.Request{Username: "unknown", Password: "unknown"})
+	if d.Reason != radiusserver.ReasonQuarantine || d.VLAN == nil || d.VLAN.ID != "99" {
+		t.Errorf("got reason %s on VLAN %v for an unknown device, want %s on 99", d.Reason, d.VLAN, radiusserver.ReasonQuarantine)
+	}
+
+	if !d.Reason.UnknownDevice() {
+		t.Errorf("got %s not being an unknown device reason", d.Reason)
+	}
+
+	// Unknown devices are expected, so they aren't reported as database errors
+	if d.Err != nil {
+		t.Errorf("got error %v for an unknown device, want nil", d.Err)
+	}
+
+	d = radiusserver.Decide(db, radiusserver.Request{Username: "phone", Password: "phone"})
+	if d.Reason != radiusserver.ReasonUserVLAN || d.VLAN == nil || d.VLAN.ID != "10" {
+		t.Errorf("got reason %s on VLAN %v for a known device, want %s on 10", d.Reason, d.VLAN, radiusserver.ReasonUserVLAN)
+	}
+}
+
+func TestParseSSID(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"AA-BB-CC-DD-EE-FF:IoT":        "IoT",
+		"aa:bb:cc:dd:ee:ff:Guest Wifi": "Guest Wifi",
+		"aabbccddeeff:Home:5G":         "Home:5G",
+		"aabb.ccdd.eeff:Office":        "Office",
+		"AA-BB-CC-DD-EE-FF":            "",
+		"":                             "",
+		"not a station:IoT":            "",
+	}
+
+	for calledStationID, want := range tests {
+		if got := radiusserver.ParseSSID(calledStationID); got != want {
+			t.Errorf("ParseSSID(%q) = %q, want %q", calledStationID, got, want)
+		}
+	}
+}
+
+func TestDecideNetworks(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	vlans := []database.VLAN{
+		{ID: "10", Name: "Main", Default: true},
+		{ID: "20", Name: "IoT", DefaultFor: []database.Network{{SSID: "IoT"}}},
+		{ID: "30", Name: "Guests", DefaultFor: []database.Network{{SSID: "Guest"}, {NAS: "lobby-ap"}}},
+		{ID: "40", Name: "Lobby Guests", DefaultFor: []database.Network{{SSID: "Guest", NAS: "lobby-ap"}}},
+		{ID: "99", Name: "Quarantine", Quarantine: true},
+	}
+	for _, v := range vlans {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	group := database.Group{Name: "kids", VlanID: "10", Networks: []database.NetworkVLAN{{Network: database.Network{SSID: "Guest"}, VlanID: "30"}}}
+	if err := db.CreateGroup(group); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	users := []database.User{
+		{Username: "phone", Password: "phone", VlanID: "10"},
+		{Username: "tablet", Password: "tablet", Group: "kids"},
+		// The user's networks are checked before the group's
+		{Username: "laptop", Password: "laptop", Group: "kids", Networks: []database.NetworkVLAN{{Network: database.Network{SSID: "Guest"}, VlanID: "20"}}},
+	}
+	for _, u := range users {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("error creating user: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		req    radiusserver.Request
+		reason radiusserver.Reason
+		vlanID string
+	}{
+		{"unknown on IoT", radiusserver.Request{Username: "new", SSID: "IoT"}, radiusserver.ReasonNetworkDefault, "20"},
+		{"unknown on Guest", radiusserver.Request{Username: "new", SSID: "Guest"}, radiusserver.ReasonNetworkDefault, "30"},
+		{"unknown on the lobby NAS", radiusserver.Request{Username: "new", SSID: "Main", NASIdentifier: "lobby-ap"}, radiusserver.ReasonNetworkDefault, "30"},
+		// The most specific network wins when several match
+		{"unknown on IoT on the lobby NAS", radiusserver.Request{Username: "new", SSID: "IoT", NASIdentifier: "lobby-ap"}, radiusserver.ReasonNetworkDefault, "20"},
+		{"unknown on Guest on the lobby NAS", radiusserver.Request{Username: "new", SSID: "Guest", NASIdentifier: "lobby-ap"}, radiusserver.ReasonNetworkDefault, "40"},
+		{"unknown elsewhere", radiusserver.Request{Username: "new", SSID: "Main"}, radiusserver.ReasonQuarantine, "99"},
+		{"known without networks", radiusserver.Request{Username: "phone", Password: "phone", SSID: "Guest"}, radiusserver.ReasonUserVLAN, "10"},
+		{"group network", radiusserver.Request{Username: "tablet", Password: "tablet", SSID: "Guest"}, radiusserver.ReasonNetworkVLAN, "30"},
+		{"group elsewhere", radiusserver.Request{Username: "tablet", Password: "tablet", SSID: "Main"}, radiusserver.ReasonGroupVLAN, "10"},
+		{"user network", radiusserver.Request{Username: "laptop", Password: "laptop", SSID: "Guest"}, radiusserver.ReasonNetworkVLAN, "20"},
+	}
+
+	for _, tt := range tests {
+		d := radiusserver.Decide(db, tt.req)
+		if d.Reason != tt.reason || d.VLAN == nil || d.VLAN.ID != tt.vlanID {
+			t.Errorf("%s: got reason %s on VLAN %v, want %s on %s", tt.name, d.Reason, d.VLAN, tt.reason, tt.vlanID)
+		}
+	}
+}
+
+func TestDecidePolicy(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	for _, v := range []database.VLAN{{ID: "10", Name: "Main", Default: true}, {ID: "20", Name: "IoT"}} {
+		if err := db.CreateVLAN(v); err != nil {
+			t.Fatalf("error creating VLAN: %v", err)
+		}
+	}
+
+	for _, u := range []database.User{{Username: "phone", Password: "phone", VlanID: "10"}, {Username: "blocked", Password: "blocked", VlanID: "10"}} {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("error creating user: %v", err)
+		}
+	}
+
+	if err := db.BlockUser("blocked"); err != nil {
+		t.Fatalf("error blocking user: %v", err)
+	}
+
+	rules := []database.PolicyRule{
+		{Name: "trusted", Match: database.PolicyMatch{Username: "phone", NAS: "lobby-ap"}, Action: database.PolicyActionAccept},
+		{Name: "guests", Match: database.PolicyMatch{SSID: "Guest"}, Action: database.PolicyActionAttributes, Attributes: []database.Attribute{{Name: "Filter-Id", Value: "guests"}}},
+		{Name: "raspberry-pis", Match: database.PolicyMatch{MACPrefix: "B8:27:EB"}, Action: database.PolicyActionVLAN, VlanID: "20"},
+		{Name: "lobby-at-night", Match: database.PolicyMatch{NAS: "lobby-ap", From: "00:00", To: "06:00", Timezone: "UTC"}, Action: database.PolicyActionReject},
+	}
+	if err := db.SetPolicy(rules); err != nil {
+		t.Fatalf("error setting policy: %v", err)
+	}
+
+	night := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		req    radiusserver.Request
+		reason radiusserver.Reason
+		vlanID string
+		rules  []string
+	}{
+		{"no rule", radiusserver.Request{Username: "phone", Password: "phone", Time: noon}, radiusserver.ReasonUserVLAN, "10", nil},
+		{"vendor of a new device", radiusserver.Request{Username: "pi", MACAddress: "b8-27-eb-00-00-01", Time: noon}, radiusserver.ReasonPolicyNewDevice, "20", []string{"raspberry-pis"}},
+		{"vendor of a known device", radiusserver.Request{Username: "phone", Password: "phone", MACAddress: "B8:27:EB:00:00:02", Time: noon}, radiusserver.ReasonPolicyVLAN, "20", []string{"raspberry-pis"}},
+		{"blocked vendor", radiusserver.Request{Username: "blocked", Password: "blocked", MACAddress: "B8:27:EB:00:00:03", Time: noon}, radiusserver.ReasonBlocked, "", []string{"raspberry-pis"}},
+		{"NAS at night", radiusserver.Request{Username: "tablet", NASIdentifier: "lobby-ap", Time: night}, radiusserver.ReasonPolicyReject, "", []string{"lobby-at-night"}},
+		{"NAS during the day", radiusserver.Request{Username: "tablet", NASIdentifier: "lobby-ap", Time: noon}, radiusserver.ReasonUnknownDevice, "10", nil},
+		{"accepted before the reject", radiusserver.Request{Username: "phone", Password: "phone", NASIdentifier: "lobby-ap", Time: night}, radiusserver.ReasonUserVLAN, "10", []string{"trusted"}},
+	}
+
+	for _, tt := range tests {
+		d := radiusserver.Decide(db, tt.req)
+
+		vlanID := ""
+		if d.VLAN != nil {
+			vlanID = d.VLAN.ID
+		}
+
+		if d.Reason != tt.reason || vlanID != tt.vlanID || fmt.Sprint(d.Rules) != fmt.Sprint(tt.rules) {
+			t.Errorf("%s: got reason %s on VLAN %q with rules %v, want %s on %q with %v", tt.name, d.Reason, vlanID, d.Rules, tt.reason, tt.vlanID, tt.rules)
+		}
+	}
+
+	// Attributes rules add attributes and keep evaluating the policy
+	d := radiusserver.Decide(db, radiusserver.Request{Username: "pi", MACAddress: "B8:27:EB:00:00:01", SSID: "Guest", Time: noon})
+	packet := &radius.Packet{Attributes: d.Attributes}
+
+	if got := rfc2865.FilterID_GetString(packet); got != "guests" || d.VLAN == nil || d.VLAN.ID != "20" {
+		t.Errorf("got Filter-Id %q on VLAN %v, want guests on 20", got, d.VLAN)
+	}
+
+	// Devices re-authenticate when a time window of a rule that could match them starts
+	d = radiusserver.Decide(db, radiusserver.Request{Username: "tablet", NASIdentifier: "lobby-ap", Time: noon})
+	if got := rfc2865.SessionTimeout_Get(&radius.Packet{Attributes: d.Attributes}); got != 12*60*60 {
+		t.Errorf("got Session-Timeout %d, want 12 hours", got)
+	}
+
+	// Dry runs explain every evaluated rule
+	e := radiusserver.Explain(db, radiusserver.Request{Username: "tablet", NASIdentifier: "lobby-ap", Time: night})
+	if len(e.Steps) != len(rules) || !e.Steps[3].Matched || e.Steps[0].Matched || e.Steps[0].Why == "" {
+		t.Errorf("got steps %+v", e.Steps)
+	}
+
+	if e.Usual.Reason != radiusserver.ReasonUnknownDevice || e.Decision.Reason != radiusserver.ReasonPolicyReject {
+		t.Errorf("got usual reason %s and decision %s", e.Usual.Reason, e.Decision.Reason)
+	}
+}
+
+func TestDecideReplyAttributes(t *testing.T) {
+	t.Parallel()
+
+	db := memorydatabase.NewMemoryDatabase()
+
+	vlan := database.VLAN{ID: "10", Name: "Main", Default: true, Attributes: []database.Attribute{
+		{Name: "Filter-Id", Value: "main"},
+		{Name: "Reply-Message", Value: "Welcome"},
+		{Name: "Session-Timeout", Value: "600"},
+	}}
+	if err := db.CreateVLAN(vlan); err != nil {
+		t.Fatalf("error creating VLAN: %v", err)
+	}
+
+	group := database.Group{Name: "kids", VlanID: "10", Attributes: []database.Attribute{
+		{Name: "Filter-Id", Value: "kids"},
+		{Name: "WISPr-Bandwidth-Max-Down", Value: "5000000"},
+	}}
+	if err := db.CreateGroup(group); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	tablet := database.User{Username: "tablet", Password: "tablet", Group: "kids", Attributes: []database.Attribute{
+		{Name: "WISPr-Bandwidth-Max-Down", Value: "1000000"},
+		{Name: "Session-Timeout", Value: "7200"},
+	}}
+	if err := db.CreateUser(tablet); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	decode := func(d radiusserver.Decision) map[string]string {
+		attributes := map[string]string{}
+
+		for _, avp := range d.Attributes {
+			if a, ok := dictionary.Decode(avp); ok {
+				attributes[a.Name] = a.Value
+			}
+		}
+
+		return attributes
+	}
+
+	// The user's attributes take precedence over the group's, and the group's over the VLAN's,
+	// but the shortest Session-Timeout wins
+	got := decode(radiusserver.Decide(db, radiusserver.Request{Username: "tablet", Password: "tablet", Time: time.Now()}))
+	want := map[string]string{"Filter-Id": "kids", "Reply-Message": "Welcome", "Session-Timeout": "600", "WISPr-Bandwidth-Max-Down": "1000000"}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got attributes %v, want %v", got, want)
+	}
+
+	// Unknown devices get the attributes of their VLAN
+	got = decode(radiusserver.Decide(db, radiusserver.Request{Username: "phone", Time: time.Now()}))
+	if got["Filter-Id"] != "main" || got["WISPr-Bandwidth-Max-Down"] != "" {
+		t.Errorf("got attributes %v for an unknown device, want the VLAN's", got)
+	}
+
+	// Rejected devices get no attributes
+	if d := radiusserver.Decide(db, radiusserver.Request{Username: "tablet", Password: "wrong", Time: time.Now()}); len(d.Attributes) != 0 {
+		t.Errorf("got attributes %v for a rejected device", decode(d))
+	}
+
+	// The policy's attributes take precedence over every other
+	rules := []database.PolicyRule{{Name: "filter", Action: database.PolicyActionAttributes, Attributes: []database.Attribute{{Name: "Filter-Id", Value: "policy"}}}}
+	if err := db.SetPolicy(rules); err != nil {
+		t.Fatalf("error setting policy: %v", err)
+	}
+
+	got = decode(radiusserver.Decide(db, radiusserver.Request{Username: "tablet", Password: "tablet", Time: time.Now()}))
+	if got["Filter-Id"] != "policy" || got["WISPr-Bandwidth-Max-Down"] != "1000000" {
+		t.Errorf("got attributes %v with a policy, want the policy's Filter-Id", got)
+	}
+}
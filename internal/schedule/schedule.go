@@ -0,0 +1,199 @@
+// Package schedule evaluates the time-based access rules of users and groups.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// Embed the time zone database so schedules work on systems without one.
+	_ "time/tzdata"
+
+	"github.com/maronato/authifi/internal/database"
+)
+
+// clockLayout is the layout of the from and to times of a rule.
+const clockLayout = "15:04"
+
+// searchDays is how many days ahead the next boundary is searched for. A week is enough
+// for every rule to start and end at least once.
+const searchDays = 8
+
+var (
+	// ErrInvalidSchedule is returned when a schedule rule can't be parsed.
+	ErrInvalidSchedule = errors.New("invalid schedule")
+	// ErrInvalidAction is returned when a schedule rule has an unknown action.
+	ErrInvalidAction = errors.New("invalid schedule action")
+)
+
+// rule is a parsed schedule rule.
+type rule struct {
+	// days are the weekdays the rule starts on. All days are allowed if empty.
+	days map[time.Weekday]bool
+	// from and to are the minutes since midnight the rule starts and ends at.
+	from, to int
+	// loc is the time zone of the rule.
+	loc *time.Location
+}
+
+// parseWeekday parses a weekday by its name or its first three letters.
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if len(s) >= 3 && strings.HasPrefix(name, s) {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: unknown day %q", ErrInvalidSchedule, s)
+}
+
+// parseClock parses a HH:MM time as minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse(clockLayout, s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad time %q, use HH:MM", ErrInvalidSchedule, s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil //nolint:gomnd // minutes in an hour
+}
+
+// parse parses and validates a schedule rule.
+func parse(s database.Schedule) (rule, error) {
+	r := rule{days: make(map[time.Weekday]bool, len(s.Days))}
+
+	for _, day := range s.Days {
+		d, err := parseWeekday(day)
+		if err != nil {
+			return rule{}, err
+		}
+
+		r.days[d] = true
+	}
+
+	var err error
+
+	if r.from, err = parseClock(s.From); err != nil {
+		return rule{}, err
+	}
+
+	if r.to, err = parseClock(s.To); err != nil {
+		return rule{}, err
+	}
+
+	if r.from == r.to {
+		return rule{}, fmt.Errorf("%w: from and to are both %s", ErrInvalidSchedule, s.From)
+	}
+
+	r.loc = time.Local
+	if s.Timezone != "" {
+		if r.loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return rule{}, fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, s.Timezone)
+		}
+	}
+
+	return r, nil
+}
+
+// Validate checks that a schedule rule is valid. It doesn't check that its VLAN exists.
+func Validate(s database.Schedule) error {
+	switch s.Action {
+	case database.ScheduleActionReject:
+		if s.VlanID != "" {
+			return fmt.Errorf("%w: reject rules can't have a VLAN", ErrInvalidSchedule)
+		}
+	case database.ScheduleActionVLAN:
+		if s.VlanID == "" {
+			return fmt.Errorf("%w: vlan rules need a VLAN", ErrInvalidSchedule)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidAction, s.Action)
+	}
+
+	_, err := parse(s)
+
+	return err
+}
+
+// at returns the time of a day at the given minutes since midnight. It uses the wall
+// clock, so rules keep their times when daylight saving time starts or ends.
+func at(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, day.Location()) //nolint:gomnd // minutes in an hour
+}
+
+// windows calls fn with the start and end of every window of the rule that starts on
+// the days around t, in order. The end is after midnight for windows that wrap.
+func (r rule) windows(t time.Time, fn func(start, end time.Time)) {
+	local := t.In(r.loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, r.loc)
+
+	// Start from yesterday, its window may wrap into today
+	for i := -1; i < searchDays; i++ {
+		day := today.AddDate(0, 0, i)
+		if len(r.days) > 0 && !r.days[day.Weekday()] {
+			continue
+		}
+
+		start := at(day, r.from)
+
+		end := at(day, r.to)
+		if r.to < r.from {
+			end = at(day.AddDate(0, 0, 1), r.to)
+		}
+
+		fn(start, end)
+	}
+}
+
+// Evaluate returns the first rule that is active at t, if any, and the next time any of
+// the rules starts or ends. next is zero if there are no rules.
+func Evaluate(rules []database.Schedule, t time.Time) (*database.Schedule, time.Time, error) {
+	var (
+		active *database.Schedule
+		next   time.Time
+	)
+
+	for i := range rules {
+		r, err := parse(rules[i])
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		r.windows(t, func(start, end time.Time) {
+			if active == nil && !t.Before(start) && t.Before(end) {
+				active = &rules[i]
+			}
+
+			for _, boundary := range []time.Time{start, end} {
+				if boundary.After(t) && (next.IsZero() || boundary.Before(next)) {
+					next = boundary
+				}
+			}
+		})
+	}
+
+	return active, next, nil
+}
+
+// Describe returns a short human readable description of a rule, like
+// "mon, tue 21:00-07:00 UTC: reject".
+func Describe(s database.Schedule) string {
+	days := "every day"
+	if len(s.Days) > 0 {
+		days = strings.Join(s.Days, ", ")
+	}
+
+	desc := fmt.Sprintf("%s %s-%s", days, s.From, s.To)
+	if s.Timezone != "" {
+		desc += " " + s.Timezone
+	}
+
+	if s.Action == database.ScheduleActionVLAN {
+		return desc + ": VLAN " + s.VlanID
+	}
+
+	return desc + ": " + string(s.Action)
+}
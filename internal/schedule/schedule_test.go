@@ -0,0 +1,123 @@
+package schedule_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/schedule"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rule    database.Schedule
+		wantErr error
+	}{
+		{name: "reject", rule: database.Schedule{Days: []string{"mon", "Tuesday"}, From: "21:00", To: "07:00", Action: database.ScheduleActionReject}},
+		{name: "vlan", rule: database.Schedule{From: "09:00", To: "17:00", Timezone: "Europe/Lisbon", Action: database.ScheduleActionVLAN, VlanID: "20"}},
+		{name: "unknown action", rule: database.Schedule{From: "09:00", To: "17:00", Action: "allow"}, wantErr: schedule.ErrInvalidAction},
+		{name: "vlan without VLAN", rule: database.Schedule{From: "09:00", To: "17:00", Action: database.ScheduleActionVLAN}, wantErr: schedule.ErrInvalidSchedule},
+		{name: "reject with VLAN", rule: database.Schedule{From: "09:00", To: "17:00", Action: database.ScheduleActionReject, VlanID: "20"}, wantErr: schedule.ErrInvalidSchedule},
+		{name: "bad day", rule: database.Schedule{Days: []string{"mo"}, From: "09:00", To: "17:00", Action: database.ScheduleActionReject}, wantErr: schedule.ErrInvalidSchedule},
+		{name: "bad time", rule: database.Schedule{From: "9am", To: "17:00", Action: database.ScheduleActionReject}, wantErr: schedule.ErrInvalidSchedule},
+		{name: "empty window", rule: database.Schedule{From: "09:00", To: "09:00", Action: database.ScheduleActionReject}, wantErr: schedule.ErrInvalidSchedule},
+		{name: "bad timezone", rule: database.Schedule{From: "09:00", To: "17:00", Timezone: "Mars/Olympus", Action: database.ScheduleActionReject}, wantErr: schedule.ErrInvalidSchedule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := schedule.Validate(tt.rule); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatalf("error loading location: %v", err)
+	}
+
+	// School nights: Sunday to Thursday from 21:00 to 07:00 the next day
+	schoolNights := database.Schedule{Days: []string{"sun", "mon", "tue", "wed", "thu"}, From: "21:00", To: "07:00", Timezone: loc.String(), Action: database.ScheduleActionReject}
+	// Homework: every day from 15:00 to 18:00 on the restricted VLAN
+	homework := database.Schedule{From: "15:00", To: "18:00", Timezone: loc.String(), Action: database.ScheduleActionVLAN, VlanID: "20"}
+	rules := []database.Schedule{schoolNights, homework}
+
+	// 2024-01-01 is a Monday
+	date := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantAction database.ScheduleAction
+		wantNext   time.Time
+	}{
+		{name: "monday morning", at: date(1, 10, 0), wantNext: date(1, 15, 0)},
+		{name: "monday homework", at: date(1, 16, 30), wantAction: database.ScheduleActionVLAN, wantNext: date(1, 18, 0)},
+		{name: "monday night", at: date(1, 22, 0), wantAction: database.ScheduleActionReject, wantNext: date(2, 7, 0)},
+		{name: "after midnight", at: date(2, 3, 0), wantAction: database.ScheduleActionReject, wantNext: date(2, 7, 0)},
+		{name: "window start is inclusive", at: date(1, 21, 0), wantAction: database.ScheduleActionReject, wantNext: date(2, 7, 0)},
+		{name: "friday night is free", at: date(5, 22, 0), wantNext: date(6, 15, 0)},
+		{name: "saturday after friday", at: date(6, 3, 0), wantNext: date(6, 15, 0)},
+		{name: "other time zone", at: date(1, 22, 0).UTC(), wantAction: database.ScheduleActionReject, wantNext: date(2, 7, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			active, next, err := schedule.Evaluate(rules, tt.at)
+			if err != nil {
+				t.Fatalf("error evaluating: %v", err)
+			}
+
+			var action database.ScheduleAction
+			if active != nil {
+				action = active.Action
+			}
+
+			if action != tt.wantAction {
+				t.Errorf("got action %q, want %q", action, tt.wantAction)
+			}
+
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("got next boundary %s, want %s", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestEvaluateWithoutRules(t *testing.T) {
+	t.Parallel()
+
+	active, next, err := schedule.Evaluate(nil, time.Now())
+	if active != nil || !next.IsZero() || err != nil {
+		t.Errorf("got %v, %s, %v, want no rule and no boundary", active, next, err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	t.Parallel()
+
+	rule := database.Schedule{Days: []string{"mon", "tue"}, From: "21:00", To: "07:00", Timezone: "UTC", Action: database.ScheduleActionReject}
+	if got, want := schedule.Describe(rule), "mon, tue 21:00-07:00 UTC: reject"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rule = database.Schedule{From: "15:00", To: "18:00", Action: database.ScheduleActionVLAN, VlanID: "20"}
+	if got, want := schedule.Describe(rule), "every day 15:00-18:00: VLAN 20"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
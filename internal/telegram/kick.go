@@ -0,0 +1,56 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/maronato/authifi/internal/coa"
+	"github.com/maronato/authifi/internal/database"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Kicker disconnects devices from their NAS.
+type Kicker interface {
+	// Kick disconnects a device, so it authenticates again.
+	Kick(ctx context.Context, username string) error
+}
+
+// registerKickFlow registers the /kick command.
+func registerKickFlow(ctx context.Context, bot *tele.Bot, db database.Database, kicker Kicker) {
+	bot.Handle("/kick", func(c tele.Context) error {
+		username := c.Message().Payload
+
+		// Handle empty payload
+		if username == "" {
+			if err := c.Send("Please provide a name or username to disconnect. Usage:\n`/kick <device>`", tele.ModeMarkdown); err != nil {
+				return fmt.Errorf("error sending message: %w", err)
+			}
+
+			return nil
+		}
+
+		// Maybe it's the description
+		user, err := db.GetUserByDescription(username)
+		if err == nil {
+			username = user.Username
+		}
+
+		msg := fmt.Sprintf("Disconnected `%s`. It will authenticate again when it reconnects.", escapeMarkdown(username))
+
+		if err := kicker.Kick(ctx, username); err != nil {
+			switch {
+			case errors.Is(err, coa.ErrNoSession):
+				msg = fmt.Sprintf("There's no known session for `%s`. Has it connected since the event log was cleared?", escapeMarkdown(username))
+			default:
+				msg = fmt.Sprintf("Error disconnecting `%s`: %s", escapeMarkdown(username), escapeMarkdown(err.Error()))
+			}
+		}
+
+		if err := c.Send(msg, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleAdmin))
+}
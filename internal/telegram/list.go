@@ -0,0 +1,400 @@
+package telegram
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/eventlog"
+	"github.com/maronato/authifi/internal/lru"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// List inline reply buttons.
+	btnListPageUnique = "list-page"
+	btnListEditUnique = "list-edit"
+
+	// ListPageSize is the number of devices shown per page.
+	ListPageSize = 10
+	// listDataCacheSize is the default size of the search and username caches.
+	listDataCacheSize = 200
+	// listIDLength is the length in bytes of the IDs in the list buttons. They are short
+	// so they fit in Telegram's 64 byte callback data limit.
+	listIDLength = 8
+
+	// Kinds of edit button data. Usernames are sent in the button if they fit, or by ID otherwise.
+	listEditUsername = "u"
+	listEditID       = "i"
+	// listEditUsernameLimit is the length of the longest username sent in an edit button. Telegram
+	// limits the callback data to 64 bytes, including the unique name of the button and the separators.
+	listEditUsernameLimit = 64 - len("\f"+btnListEditUnique+"|"+listEditUsername+"|")
+
+	// Filters of the device list. VLAN and search filters are followed by the VLAN ID or the search ID.
+	listFilterAll     = "a"
+	listFilterBlocked = "b"
+	listFilterVLAN    = "v:"
+	listFilterSearch  = "s:"
+)
+
+// deviceEntry is a device shown in the list.
+type deviceEntry struct {
+	// Username is the username of the device.
+	Username string
+	// Description is the custom assigned name of the device.
+	Description string
+	// VlanID is the ID of the VLAN of the device.
+	VlanID string
+	// Blocked is true if the device is blocked.
+	Blocked bool
+}
+
+// name returns the description of the device, or its username if it has none.
+func (e deviceEntry) name() string {
+	if e.Description != "" {
+		return e.Description
+	}
+
+	return e.Username
+}
+
+// normalizeMAC lowercases s and removes the separators of MAC addresses, so
+// "AA-BB-CC" matches "aa:bb:cc".
+func normalizeMAC(s string) string {
+	return strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(s))
+}
+
+// matches reports whether the device matches a search. Usernames are usually MAC
+// addresses, so they are also compared without separators.
+func (e deviceEntry) matches(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return true
+	}
+
+	if strings.Contains(strings.ToLower(e.Username), query) || strings.Contains(strings.ToLower(e.Description), query) {
+		return true
+	}
+
+	mac := normalizeMAC(query)
+
+	return mac != "" && strings.Contains(normalizeMAC(e.Username), mac)
+}
+
+// listDevices returns all the devices, including blocked ones, sorted by name.
+func listDevices(db database.Database) ([]deviceEntry, error) {
+	users, err := db.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("error getting devices: %w", err)
+	}
+
+	blockedUsers, err := db.GetBlockedUsers()
+	if err != nil {
+		return nil, fmt.Errorf("error getting blocked devices: %w", err)
+	}
+
+	blocked := make(map[string]bool, len(blockedUsers))
+	for _, b := range blockedUsers {
+		blocked[b.Username] = true
+	}
+
+	entries := make([]deviceEntry, 0, len(users)+len(blockedUsers))
+
+	for _, u := range users {
+		// Devices in a group may use the group's VLAN. It's left empty if the group is missing.
+		vlanID, _ := database.UserVLANID(db, u)
+
+		entries = append(entries, deviceEntry{Username: u.Username, Description: u.Description, VlanID: vlanID, Blocked: blocked[u.Username]})
+		delete(blocked, u.Username)
+	}
+
+	// Blocked devices don't always have a user
+	for _, b := range blockedUsers {
+		if blocked[b.Username] {
+			entries = append(entries, deviceEntry{Username: b.Username, Blocked: true})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b deviceEntry) int {
+		return cmp.Or(cmp.Compare(strings.ToLower(a.name()), strings.ToLower(b.name())), cmp.Compare(a.Username, b.Username))
+	})
+
+	return entries, nil
+}
+
+// filterDevices returns the devices that match a filter. search is the query of search filters.
+func filterDevices(entries []deviceEntry, filter, search string) []deviceEntry {
+	return slices.DeleteFunc(slices.Clone(entries), func(e deviceEntry) bool {
+		switch {
+		case filter == listFilterBlocked:
+			return !e.Blocked
+		case strings.HasPrefix(filter, listFilterVLAN):
+			return e.Blocked || e.VlanID != strings.TrimPrefix(filter, listFilterVLAN)
+		case strings.HasPrefix(filter, listFilterSearch):
+			return !e.matches(search)
+		default:
+			return false
+		}
+	})
+}
+
+// newListID creates a short random ID for the list buttons.
+func newListID() (string, error) {
+	b := make([]byte, listIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating ID: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// editButtonData returns the data of the edit button of a device. The username is sent in the
+// button if it fits, so the button keeps working. Otherwise, it's kept in the cache under a new ID.
+func editButtonData(usernames *lru.Cache[string, string], username string) ([]string, error) {
+	if len(username) <= listEditUsernameLimit && !strings.Contains(username, "|") {
+		return []string{listEditUsername, username}, nil
+	}
+
+	id, err := newListID()
+	if err != nil {
+		return nil, err
+	}
+
+	usernames.Set(id, username)
+
+	return []string{listEditID, id}, nil
+}
+
+// editButtonUsername returns the username of the device of an edit button.
+func editButtonUsername(usernames *lru.Cache[string, string], args []string) (string, bool) {
+	if len(args) != 2 { //nolint:gomnd // kind and value
+		return "", false
+	}
+
+	switch args[0] {
+	case listEditUsername:
+		return args[1], true
+	case listEditID:
+		return usernames.Get(args[1])
+	default:
+		return "", false
+	}
+}
+
+// registerListFlow registers the handlers for /list and /find.
+// openEdit builds the edit message of a device.
+func registerListFlow(bot *tele.Bot, db database.Database, events *eventlog.Log, openEdit func(username string) (string, *tele.ReplyMarkup, error)) { //nolint:gocognit // one closure per handler
+	// searchCache maps the IDs in the buttons to search queries
+	searchCache := lru.NewLRUCache[string, string](listDataCacheSize)
+	// usernameCache maps the IDs in the edit buttons to usernames too long to be sent in them
+	usernameCache := lru.NewLRUCache[string, string](listDataCacheSize)
+
+	// buildList builds a page of the device list.
+	buildList := func(c tele.Context, filter string, page int) (string, *tele.ReplyMarkup, error) {
+		entries, err := listDevices(db)
+		if err != nil {
+			return "", nil, err
+		}
+
+		vlans, err := db.GetVLANs()
+		if err != nil {
+			return "", nil, fmt.Errorf("error getting VLANs: %w", err)
+		}
+
+		vlanNames := make(map[string]string, len(vlans))
+		for _, vlan := range vlans {
+			vlanNames[vlan.ID] = vlan.Name
+		}
+
+		// Describe and apply the filter
+		description := "All devices"
+		search := ""
+
+		switch {
+		case filter == listFilterBlocked:
+			description = "Blocked devices"
+		case strings.HasPrefix(filter, listFilterVLAN):
+			description = "Devices in " + escapeMarkdown(vlanNames[strings.TrimPrefix(filter, listFilterVLAN)])
+		case strings.HasPrefix(filter, listFilterSearch):
+			query, ok := searchCache.Get(strings.TrimPrefix(filter, listFilterSearch))
+			if !ok {
+				return "This search has expired. Please run /find again.", nil, nil
+			}
+
+			search = query
+			description = "Devices matching " + markdownFormat.Code(query)
+		default:
+			filter = listFilterAll
+		}
+
+		entries = filterDevices(entries, filter, search)
+
+		// Clamp the page
+		pages := max((len(entries)+ListPageSize-1)/ListPageSize, 1)
+		page = min(max(page, 0), pages-1)
+		start := page * ListPageSize
+		end := min(start+ListPageSize, len(entries))
+
+		msg := fmt.Sprintf("*📋 Device List 📋*\n%s\n\n", description)
+
+		if len(entries) == 0 {
+			msg += "No devices found.\n"
+		}
+
+		m := bot.NewMarkup()
+		canEdit := roleOf(c) >= RoleAdmin
+
+		for i, e := range entries[start:end] {
+			status := vlanNames[e.VlanID]
+			if e.Blocked {
+				status = "🔒 blocked"
+			}
+
+			lastSeen := "never seen"
+			if stats, ok := events.Stats(e.Username); ok {
+				lastSeen = formatLastSeen(stats.LastSeen)
+			}
+
+			// Entities can't be nested or escaped in legacy Markdown, so asterisks are dropped from the bold name
+			msg += fmt.Sprintf("%d. *%s* - %s _(%s)_\n", start+i+1, strings.ReplaceAll(e.name(), "*", ""), escapeMarkdown(status), lastSeen)
+
+			// Jump into the edit flow
+			if canEdit {
+				data, err := editButtonData(usernameCache, e.Username)
+				if err != nil {
+					return "", nil, err
+				}
+
+				btn := m.Data(fmt.Sprintf("%d. %s", start+i+1, e.name()), btnListEditUnique, data...).Inline()
+				m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+			}
+		}
+
+		msg += fmt.Sprintf("\nPage %d of %d, %d devices", page+1, pages, len(entries))
+
+		// Navigation
+		var nav []tele.InlineButton
+		if page > 0 {
+			nav = append(nav, *m.Data("⬅ Previous", btnListPageUnique, filter, strconv.Itoa(page-1)).Inline())
+		}
+
+		if page < pages-1 {
+			nav = append(nav, *m.Data("Next ➡", btnListPageUnique, filter, strconv.Itoa(page+1)).Inline())
+		}
+
+		if len(nav) > 0 {
+			m.InlineKeyboard = append(m.InlineKeyboard, nav)
+		}
+
+		// Filters
+		filters := []tele.InlineButton{
+			*m.Data("All", btnListPageUnique, listFilterAll, "0").Inline(),
+			*m.Data("🔒 Blocked", btnListPageUnique, listFilterBlocked, "0").Inline(),
+		}
+
+		for _, vlan := range vlans {
+			filters = append(filters, *m.Data(vlan.Name, btnListPageUnique, listFilterVLAN+vlan.ID, "0").Inline())
+		}
+
+		// Add up to 3 filters per row
+		for i, btn := range filters {
+			if i%3 == 0 {
+				m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{btn})
+			} else {
+				m.InlineKeyboard[len(m.InlineKeyboard)-1] = append(m.InlineKeyboard[len(m.InlineKeyboard)-1], btn)
+			}
+		}
+
+		return msg, m, nil
+	}
+
+	// sendList sends the first page of a list.
+	sendList := func(c tele.Context, filter string) error {
+		msg, markup, err := buildList(c, filter, 0)
+		if err != nil {
+			return fmt.Errorf("error building list: %w", err)
+		}
+
+		if err := c.Send(msg, markup, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}
+
+	bot.Handle("/list", func(c tele.Context) error {
+		return sendList(c, listFilterAll)
+	}, requireRole(RoleViewer))
+
+	bot.Handle("/find", func(c tele.Context) error {
+		query := strings.TrimSpace(c.Message().Payload)
+		if query == "" {
+			if err := c.Send("Please provide a name, username, or MAC address to search for. Usage:\n`/find <text>`", tele.ModeMarkdown); err != nil {
+				return fmt.Errorf("error sending message: %w", err)
+			}
+
+			return nil
+		}
+
+		// Searches are kept in the cache so they can be paginated
+		id, err := newListID()
+		if err != nil {
+			return err
+		}
+
+		searchCache.Set(id, query)
+
+		return sendList(c, listFilterSearch+id)
+	}, requireRole(RoleViewer))
+
+	// Handle the navigation and filter buttons
+	bot.Handle(&tele.InlineButton{Unique: btnListPageUnique}, func(c tele.Context) error {
+		args := c.Args()
+		if len(args) != 2 { //nolint:gomnd // filter and page
+			return ErrFailedToReadData
+		}
+
+		page, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFailedToReadData, err)
+		}
+
+		msg, markup, err := buildList(c, args[0], page)
+		if err != nil {
+			return fmt.Errorf("error building list: %w", err)
+		}
+
+		// Tapping the current filter again doesn't change the message
+		err = c.Edit(msg, markup, tele.ModeMarkdown)
+		if err != nil && !errors.Is(err, tele.ErrSameMessageContent) && !errors.Is(err, tele.ErrMessageNotModified) {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleViewer))
+
+	// Handle the device buttons by sending the edit message of the device
+	bot.Handle(&tele.InlineButton{Unique: btnListEditUnique}, func(c tele.Context) error {
+		username, ok := editButtonUsername(usernameCache, c.Args())
+		if !ok {
+			return ErrFailedToReadData
+		}
+
+		msg, markup, err := openEdit(username)
+		if err != nil {
+			return fmt.Errorf("error building edit message: %w", err)
+		}
+
+		if err := c.Send(msg, markup, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleAdmin))
+}
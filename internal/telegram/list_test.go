@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/maronato/authifi/internal/lru"
+)
+
+func TestDeviceEntryMatches(t *testing.T) {
+	t.Parallel()
+
+	entry := deviceEntry{Username: "aa:bb:cc:dd:ee:ff", Description: "Living Room TV"}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "", want: true},
+		{query: "living", want: true},
+		{query: "ROOM tv", want: true},
+		{query: "aa:bb", want: true},
+		{query: "AA-BB-CC", want: true},
+		{query: "ccddee", want: true},
+		{query: "kitchen", want: false},
+		{query: "11:22", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			t.Parallel()
+
+			if got := entry.matches(tt.query); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterDevices(t *testing.T) {
+	t.Parallel()
+
+	entries := []deviceEntry{
+		{Username: "phone", VlanID: "10"},
+		{Username: "laptop", Description: "Work laptop", VlanID: "20"},
+		{Username: "tv", VlanID: "10", Blocked: true},
+		{Username: "camera", Blocked: true},
+	}
+
+	tests := []struct {
+		name   string
+		filter string
+		search string
+		want   []string
+	}{
+		{name: "all", filter: listFilterAll, want: []string{"phone", "laptop", "tv", "camera"}},
+		{name: "blocked", filter: listFilterBlocked, want: []string{"tv", "camera"}},
+		{name: "vlan", filter: listFilterVLAN + "10", want: []string{"phone"}},
+		{name: "search", filter: listFilterSearch + "id", search: "work", want: []string{"laptop"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			for _, e := range filterDevices(entries, tt.filter, tt.search) {
+				got = append(got, e.Username)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The original entries are not modified
+	if len(entries) != 4 || entries[3].Username != "camera" {
+		t.Errorf("filterDevices modified the entries: %v", entries)
+	}
+}
+
+func TestEditButtonData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		username string
+		wantKind string
+	}{
+		{name: "MAC address", username: "aa:bb:cc:dd:ee:ff", wantKind: listEditUsername},
+		{name: "longest username", username: strings.Repeat("a", listEditUsernameLimit), wantKind: listEditUsername},
+		{name: "long username", username: strings.Repeat("a", listEditUsernameLimit+1), wantKind: listEditID},
+		{name: "separator", username: "a|b", wantKind: listEditID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			usernames := lru.NewLRUCache[string, string](1)
+
+			data, err := editButtonData(usernames, tt.username)
+			if err != nil {
+				t.Fatalf("error creating button data: %v", err)
+			}
+
+			if data[0] != tt.wantKind {
+				t.Errorf("got kind %q, want %q", data[0], tt.wantKind)
+			}
+
+			if callback := "\f" + btnListEditUnique + "|" + strings.Join(data, "|"); len(callback) > 64 {
+				t.Errorf("got %d bytes of callback data, want at most 64", len(callback))
+			}
+
+			// Telegram sends the data back split by the separator
+			if got, ok := editButtonUsername(usernames, strings.Split(strings.Join(data, "|"), "|")); !ok || got != tt.username {
+				t.Errorf("got username %q, %v, want %q", got, ok, tt.username)
+			}
+		})
+	}
+
+	if _, ok := editButtonUsername(lru.NewLRUCache[string, string](1), []string{listEditID, "expired"}); ok {
+		t.Error("got a username for an unknown ID")
+	}
+}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/maronato/authifi/internal/database"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// Pending device inline reply buttons.
+	btnPendingReviewUnique = "pending-review"
+)
+
+// pendingListText creates the text of the pending device list.
+func pendingListText(devices []database.PendingDevice) string {
+	if len(devices) == 0 {
+		return "*⏳ Pending Devices ⏳*\n\nNo devices are waiting for approval."
+	}
+
+	msg := "*⏳ Pending Devices ⏳*\n\n"
+
+	for i, d := range devices {
+		msg += fmt.Sprintf("%d. `%s`", i+1, d.Username)
+
+		if d.MACAddress != "" && d.MACAddress != d.Username {
+			msg += fmt.Sprintf(" (`%s`)", d.MACAddress)
+		}
+
+		if d.NASAddress != "" {
+			msg += fmt.Sprintf(" via `%s`", d.NASAddress)
+		}
+
+		msg += fmt.Sprintf("\n    first seen %s, last seen %s, %d attempts\n", formatLastSeen(d.FirstSeen), formatLastSeen(d.LastSeen), d.Attempts)
+	}
+
+	msg += "\nSelect a device to review it."
+
+	return msg
+}
+
+// registerPendingFlow registers the handlers for the pending device list.
+func registerPendingFlow(bot *tele.Bot, db database.Database, createNewDeviceMessage func(data *newDeviceData) (string, *tele.ReplyMarkup)) {
+	bot.Handle("/pending", func(c tele.Context) error {
+		devices, err := db.GetPendingDevices()
+		if err != nil {
+			return fmt.Errorf("error getting pending devices: %w", err)
+		}
+
+		// Add a button per device to review it
+		m := bot.NewMarkup()
+
+		for i, d := range devices {
+			btn := m.Data(fmt.Sprintf("%d. %s", i+1, d.Username), btnPendingReviewUnique, d.ID).Inline()
+			m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+		}
+
+		if err := c.Send(pendingListText(devices), m, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleViewer))
+
+	// Handle the selection of a pending device by sending its notification again
+	bot.Handle(&tele.InlineButton{Unique: btnPendingReviewUnique}, func(c tele.Context) error {
+		pending, err := db.GetPendingDevice(c.Data())
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFailedToReadData, err)
+		}
+
+		msg, markup := createNewDeviceMessage(newPendingDeviceData(pending))
+
+		if err := c.Send(msg, markup, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleOperator))
+}
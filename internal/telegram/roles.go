@@ -0,0 +1,179 @@
+package telegram
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/maronato/authifi/internal/config"
+	"github.com/maronato/authifi/internal/database"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Role is the permission level of a chat or user.
+type Role int
+
+const (
+	// RoleNone is used for chats that are not allowed to interact with the bot.
+	RoleNone Role = iota
+	// RoleViewer receives alerts and can list devices.
+	RoleViewer
+	// RoleOperator can also approve and ignore new devices on non-privileged VLANs.
+	RoleOperator
+	// RoleAdmin can do everything.
+	RoleAdmin
+)
+
+// roleKey is the context key the role of an update is stored under.
+const roleKey = "role"
+
+// String returns the name of the role.
+func (r Role) String() string {
+	switch r {
+	case RoleNone:
+		return "none"
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// Roles maps chat and user IDs to their roles.
+type Roles struct {
+	// ids are the roles of each chat or user ID.
+	ids map[int64]Role
+}
+
+// NewRoles creates the roles from the config. If an ID has more than one role, the highest one is used.
+func NewRoles(cfg *config.Config) (*Roles, error) {
+	r := &Roles{ids: make(map[int64]Role)}
+
+	lists := []struct {
+		role Role
+		ids  []string
+	}{
+		{RoleViewer, cfg.TelegramViewerIDs},
+		{RoleOperator, cfg.TelegramOperatorIDs},
+		{RoleAdmin, cfg.TelegramChatIDs},
+	}
+
+	for _, list := range lists {
+		for _, id := range list.ids {
+			intID, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error converting chat ID to int: %w", err)
+			}
+
+			r.ids[intID] = max(r.ids[intID], list.role)
+		}
+	}
+
+	return r, nil
+}
+
+// ChatIDs returns all the IDs with a role, sorted.
+func (r *Roles) ChatIDs() []int64 {
+	ids := make([]int64, 0, len(r.ids))
+	for id := range r.ids {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// Resolve returns the role of a user in a chat. The chat must have a role, and the
+// user gets the highest of the chat's role and their own.
+func (r *Roles) Resolve(chatID, userID int64) Role {
+	chatRole := r.ids[chatID]
+	if chatRole == RoleNone {
+		return RoleNone
+	}
+
+	return max(chatRole, r.ids[userID])
+}
+
+// Middleware resolves the role of every update and drops the ones from chats without a role.
+func (r *Roles) Middleware() tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Chat() == nil {
+				return nil
+			}
+
+			var userID int64
+			if c.Sender() != nil {
+				userID = c.Sender().ID
+			}
+
+			role := r.Resolve(c.Chat().ID, userID)
+			if role == RoleNone {
+				return nil
+			}
+
+			c.Set(roleKey, role)
+
+			return next(c)
+		}
+	}
+}
+
+// roleOf returns the role of the update.
+func roleOf(c tele.Context) Role {
+	role, _ := c.Get(roleKey).(Role)
+
+	return role
+}
+
+// canAssignVLAN reports whether the update's role can assign devices to a VLAN.
+func canAssignVLAN(c tele.Context, vlan database.VLAN) bool {
+	return roleOf(c) >= RoleAdmin || !vlan.Privileged
+}
+
+// deny tells the user they are not allowed to do something, as an alert for buttons or as a message otherwise.
+func deny(c tele.Context, reason string) error {
+	if c.Callback() != nil {
+		if err := c.Respond(&tele.CallbackResponse{Text: "🚫 " + reason, ShowAlert: true}); err != nil {
+			return fmt.Errorf("error answering callback: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.Send("🚫 " + reason); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+
+	return nil
+}
+
+// requireRole only lets updates with at least the given role through.
+func requireRole(minRole Role) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if role := roleOf(c); role < minRole {
+				return deny(c, fmt.Sprintf("This requires the %s role, but you are a %s.", minRole, role))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// onlyRole runs a text handler only for updates with at least the given role.
+// Text handlers are shared by all messages, so other updates are ignored silently.
+func onlyRole(minRole Role, h tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if roleOf(c) < minRole {
+			return nil
+		}
+
+		return h(c)
+	}
+}
@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/maronato/authifi/internal/config"
+)
+
+func TestRoles(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.NewConfig()
+	cfg.TelegramChatIDs = []string{"1"}
+	cfg.TelegramOperatorIDs = []string{"-100", "2", "1"}
+	cfg.TelegramViewerIDs = []string{"-200", "3"}
+
+	roles, err := NewRoles(cfg)
+	if err != nil {
+		t.Fatalf("error creating roles: %v", err)
+	}
+
+	if got, want := roles.ChatIDs(), []int64{-200, -100, 1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("got chat IDs %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		chatID int64
+		userID int64
+		want   Role
+	}{
+		{name: "admin in private chat", chatID: 1, userID: 1, want: RoleAdmin},
+		{name: "highest role wins", chatID: 1, userID: 99, want: RoleAdmin},
+		{name: "operator group", chatID: -100, userID: 99, want: RoleOperator},
+		{name: "viewer group", chatID: -200, userID: 99, want: RoleViewer},
+		{name: "user role elevates the chat role", chatID: -200, userID: 2, want: RoleOperator},
+		{name: "chat role is kept for lower user roles", chatID: -100, userID: 3, want: RoleOperator},
+		{name: "unknown chat", chatID: -300, userID: 1, want: RoleNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := roles.Resolve(tt.chatID, tt.userID); got != tt.want {
+				t.Errorf("got role %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolesInvalidID(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.NewConfig()
+	cfg.TelegramViewerIDs = []string{"not-a-number"}
+
+	if _, err := NewRoles(cfg); err == nil {
+		t.Error("expected an error for an invalid ID")
+	}
+}
@@ -2,15 +2,19 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/maronato/authifi/internal/config"
 	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/eventlog"
 	"github.com/maronato/authifi/internal/logging"
+	"github.com/maronato/authifi/internal/lru"
+	"github.com/maronato/authifi/internal/notify"
 	"golang.org/x/sync/errgroup"
 	tele "gopkg.in/telebot.v3"
 	telemiddleware "gopkg.in/telebot.v3/middleware"
@@ -27,6 +31,8 @@ const (
 
 // BotServer is a Telegram bot server.
 type BotServer struct {
+	// Base logs blocked attempts and administrative changes.
+	notify.Base
 	// bot is the Telegram bot.
 	bot *tele.Bot
 	// chatIDs is a list of chat IDs that the bot is allowed to interact with.
@@ -37,39 +43,70 @@ type BotServer struct {
 	l *slog.Logger
 	// createNewDeviceMessage creates a notification message for a new device.
 	createNewDeviceMessage func(data *newDeviceData) (string, *tele.ReplyMarkup)
+	// notifications are the new device messages sent for each username, so they can be updated.
+	notifications *lru.Cache[string, *sentNotification]
+	// webhook is the webhook poller. Long polling is used if nil.
+	webhook *webhookPoller
+	// webhookListen is the address the webhook server listens on.
+	webhookListen string
+}
+
+// sentNotification is a new device notification sent to the chats.
+type sentNotification struct {
+	// data is the data shared by the messages.
+	data *newDeviceData
+	// messages are the messages sent to each chat.
+	messages []sentMessage
+}
+
+// sentMessage is a message sent to a chat and its inline keyboard.
+type sentMessage struct {
+	msg    *tele.Message
+	markup *tele.ReplyMarkup
 }
 
 // NewBotServer creates a new BotServer.
-func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database) (*BotServer, error) {
+func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database, events *eventlog.Log, kicker Kicker) (*BotServer, error) {
 	l := logging.FromCtx(ctx)
 
 	onTextHandlers := []tele.HandlerFunc{}
 
+	// Receive updates through a webhook if configured, or long polling otherwise
+	var (
+		poller  tele.Poller = &tele.LongPoller{Timeout: PollerTimeout}
+		webhook *webhookPoller
+	)
+
+	if cfg.TelegramWebhookListen != "" {
+		webhook = newWebhook(cfg)
+		poller = webhook
+	}
+
 	// Create the bot
 	bot, err := tele.NewBot(tele.Settings{
 		Token:  cfg.TelegramBotToken,
-		Poller: &tele.LongPoller{Timeout: PollerTimeout},
+		Poller: poller,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error creating bot: %w", err)
 	}
 
+	if webhook != nil {
+		webhook.updates = bot.Updates
+	}
+
 	// Setup error recovery middleware
 	bot.Use(telemiddleware.Recover())
 
-	// Setup chat allowlist
-	chatIDs := make([]int64, len(cfg.TelegramChatIDs))
-
-	for i, id := range cfg.TelegramChatIDs {
-		intID, err := strconv.Atoi(id)
-		if err != nil {
-			return nil, fmt.Errorf("error converting chat ID to int: %w", err)
-		}
-
-		chatIDs[i] = int64(intID)
+	// Setup roles. Updates from chats without a role are dropped
+	roles, err := NewRoles(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating roles: %w", err)
 	}
 
-	bot.Use(telemiddleware.Whitelist(chatIDs...))
+	chatIDs := roles.ChatIDs()
+
+	bot.Use(roles.Middleware())
 
 	// Setup access logs middleware
 	bot.Use(func(hf tele.HandlerFunc) tele.HandlerFunc {
@@ -90,14 +127,32 @@ func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database)
 	})
 
 	bot.Handle("/start", func(c tele.Context) error {
-		err := bot.SetCommands(
-			[]tele.Command{
-				{Text: "/list", Description: "List all the devices"},
-				{Text: "/edit", Description: "Edit a device"},
-				{Text: "/help", Description: "Show help message"},
-			},
-			tele.CommandScope{Type: tele.CommandScopeChat, ChatID: c.Chat().ID},
+		// Only show the commands the chat's role can use
+		commands := []tele.Command{
+			{Text: "/list", Description: "List all the devices"},
+			{Text: "/find", Description: "Search for a device"},
+		}
+		if roleOf(c) >= RoleAdmin {
+			commands = append(commands,
+				tele.Command{Text: "/edit", Description: "Edit a device"},
+				tele.Command{Text: "/kick", Description: "Disconnect a device"},
+			)
+		}
+
+		commands = append(commands, tele.Command{Text: "/vlans", Description: "List all the VLANs"})
+		if roleOf(c) >= RoleAdmin {
+			commands = append(commands,
+				tele.Command{Text: "/addvlan", Description: "Create a VLAN"},
+				tele.Command{Text: "/editvlan", Description: "Edit a VLAN"},
+			)
+		}
+
+		commands = append(commands,
+			tele.Command{Text: "/pending", Description: "List devices waiting for approval"},
+			tele.Command{Text: "/help", Description: "Show help message"},
 		)
+
+		err := bot.SetCommands(commands, tele.CommandScope{Type: tele.CommandScopeChat, ChatID: c.Chat().ID})
 		if err != nil {
 			return fmt.Errorf("error setting commands: %w", err)
 		}
@@ -108,7 +163,7 @@ func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database)
 		}
 
 		return nil
-	})
+	}, requireRole(RoleViewer))
 
 	helpMessage := `*🤖 Authifi Bot Help 🤖*
 
@@ -118,12 +173,20 @@ func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database)
 	
 	*Commands:*
 	- /start - Start interacting with the bot.
-	- /list - List all the devices.
+	- /list - List all the devices. Use the buttons to change pages or filter by network.
+	- /find <text> - Search for a device by name, username, or MAC address.
 	- /edit <device> - Edit a device by its name or username.
+	- /kick <device> - Disconnect a device, so it authenticates again.
+	- /pending - List the devices waiting for approval.
+	- /vlans - List all the VLANs.
+	- /addvlan <id> <name> - Create a VLAN.
+	- /editvlan <vlan> - Edit a VLAN by its ID or name: rename it, make it the default, or change its tunnel attributes.
 	- /help - Show this help message.
 	Other commands *may* be implemented in the future.
 
-	Update the database file directly to manually add, remove, or modify devices.`
+	What you can do depends on your role: viewers receive alerts and can list devices, operators can also add devices to non-privileged networks and ignore requests, and admins can do everything.
+
+	Devices are moved to another VLAN before the VLAN they use is deleted. The default VLAN can't be deleted.`
 
 	bot.Handle("/help", func(c tele.Context) error {
 		if err := c.Send(helpMessage, tele.ModeMarkdown); err != nil {
@@ -131,71 +194,26 @@ func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database)
 		}
 
 		return nil
-	})
-
-	bot.Handle("/list", func(c tele.Context) error {
-		devices, err := db.GetUsers()
-		if err != nil {
-			return fmt.Errorf("error getting devices: %w", err)
-		}
-
-		blockedDevices, err := db.GetBlockedUsers()
-		if err != nil {
-			return fmt.Errorf("error getting blocked devices: %w", err)
-		}
-
-		vlans, err := db.GetVLANs()
-		if err != nil {
-			return fmt.Errorf("error getting VLANs: %w", err)
-		}
-
-		// Create a map of VLANs for easy access
-		vlanMap := make(map[string]string, len(vlans))
-		for _, vlan := range vlans {
-			vlanMap[vlan.ID] = vlan.Name
-		}
-
-		descriptionMap := make(map[string]string, len(devices))
-
-		for _, device := range devices {
-			if device.Description != "" {
-				descriptionMap[device.Username] = device.Description
-			}
-		}
+	}, requireRole(RoleViewer))
 
-		msg := "*📋 Device List 📋*\n\n"
+	// Setup new device handlers
+	notifications := lru.NewLRUCache[string, *sentNotification](newDeviceDataCacheSize)
+	createNewDeviceMessage := registerNewDeviceFlow(bot, db, l, notifications, &onTextHandlers)
 
-		for _, device := range devices {
-			if device.Description == "" {
-				msg += fmt.Sprintf("• *%s* - %s\n", device.Username, vlanMap[device.VlanID])
-			} else {
-				msg += fmt.Sprintf("• *%s* - %s\n", device.Description, vlanMap[device.VlanID])
-			}
-		}
-
-		msg += "\n*🚫 Blocked Devices 🚫*\n\n"
-
-		for _, device := range blockedDevices {
-			description, ok := descriptionMap[device.Username]
-			if !ok {
-				description = device.Username
-			}
-
-			msg += fmt.Sprintf("• *%s*\n", description)
-		}
+	// Setup pending device handlers
+	registerPendingFlow(bot, db, createNewDeviceMessage)
 
-		if err := c.Send(msg, tele.ModeMarkdown); err != nil {
-			return fmt.Errorf("error sending message: %w", err)
-		}
+	// Setup edit device handlers
+	buildEditMessage := registerEditDeviceFlow(bot, db, events, &onTextHandlers)
 
-		return nil
-	})
+	// Setup kick handlers
+	registerKickFlow(ctx, bot, db, kicker)
 
-	// Setup new device handlers and cache
-	createNewDeviceMessage := registerNewDeviceFlow(bot, db, &onTextHandlers)
+	// Setup device list handlers
+	registerListFlow(bot, db, events, buildEditMessage)
 
-	// Setup edit device handlers
-	registerEditDeviceFlow(bot, db, &onTextHandlers)
+	// Setup VLAN handlers
+	registerVLANFlow(bot, db, l, &onTextHandlers)
 
 	// Handle onText events
 	bot.Handle(tele.OnText, func(c tele.Context) error {
@@ -219,9 +237,19 @@ func NewBotServer(ctx context.Context, cfg *config.Config, db database.Database)
 		privacyToken = strings.Repeat("*", len(privacyToken))
 	}
 
-	l.Debug("Bot setup complete", slog.Any("chatIDs", chatIDs), slog.Int("cacheSize", VLANSelectCacheSize), slog.Int("randomIDLength", RandomIDLength), slog.Duration("pollerTimeout", PollerTimeout), slog.String("token", privacyToken))
-
-	return &BotServer{bot: bot, chatIDs: chatIDs, db: db, createNewDeviceMessage: createNewDeviceMessage, l: l}, nil
+	l.Debug("Bot setup complete", slog.Any("chatIDs", chatIDs), slog.Int("cacheSize", VLANSelectCacheSize), slog.Int("randomIDLength", RandomIDLength), slog.Duration("pollerTimeout", PollerTimeout), slog.String("token", privacyToken), slog.String("webhookURL", cfg.TelegramWebhookURL))
+
+	return &BotServer{
+		Base:                   notify.NewBase(l),
+		bot:                    bot,
+		chatIDs:                chatIDs,
+		db:                     db,
+		createNewDeviceMessage: createNewDeviceMessage,
+		notifications:          notifications,
+		webhook:                webhook,
+		webhookListen:          cfg.TelegramWebhookListen,
+		l:                      l,
+	}, nil
 }
 
 // StartBot starts the Telegram bot.
@@ -230,9 +258,26 @@ func (bs *BotServer) StartBot(ctx context.Context) error {
 
 	l := logging.FromCtx(ctx)
 
+	if bs.webhook != nil {
+		// Register the webhook before serving it, so the bot doesn't run without receiving updates
+		if err := bs.bot.SetWebhook(bs.webhook.settings); err != nil {
+			return fmt.Errorf("error setting Telegram webhook: %w", err)
+		}
+	} else if err := bs.bot.RemoveWebhook(); err != nil {
+		// A webhook left from a previous run would prevent long polling from receiving updates
+		l.Warn("Error removing Telegram webhook", slog.Any("error", err))
+	}
+
 	eg.Go(func() error {
 		l.Info("Starting Telegram bot with " + fmt.Sprint(len(bs.chatIDs)) + " allowed chat IDs")
 
+		// Pending devices from previous runs can still be handled with /pending
+		if pending, err := bs.db.GetPendingDevices(); err != nil {
+			l.Error("Error getting pending devices", slog.Any("error", err))
+		} else if len(pending) > 0 {
+			l.Info("Devices waiting for approval", slog.Int("count", len(pending)))
+		}
+
 		bs.bot.Start()
 
 		return nil
@@ -248,6 +293,10 @@ func (bs *BotServer) StartBot(ctx context.Context) error {
 		return nil
 	})
 
+	if bs.webhook != nil {
+		bs.startWebhookServer(egCtx, eg)
+	}
+
 	// Wait for the server to exit and check for errors that
 	// are not caused by the context being canceled.
 	if err := eg.Wait(); err != nil && ctx.Err() == nil {
@@ -257,22 +306,158 @@ func (bs *BotServer) StartBot(ctx context.Context) error {
 	return nil
 }
 
-// NotifyLoginAttempt sends a message to all the chat IDs when a login attempt is detected.
-func (bs *BotServer) NotifyLoginAttempt(username, password, macAddress string) {
-	bs.l.Debug("Sending login attempt notification", slog.String("username", username), slog.String("macAddress", macAddress))
+// startWebhookServer serves the webhook until the context is done.
+func (bs *BotServer) startWebhookServer(ctx context.Context, eg *errgroup.Group) {
+	l := logging.FromCtx(ctx)
+
+	server := &http.Server{
+		Addr:              bs.webhookListen,
+		Handler:           webhookHandler(bs.webhook.settings.SecretToken, bs.webhook),
+		ReadHeaderTimeout: WebhookReadHeaderTimeout,
+	}
+
+	eg.Go(func() error {
+		l.Info("Starting Telegram webhook server on " + bs.webhookListen)
+
+		var err error
+		if tls := bs.webhook.settings.TLS; tls != nil {
+			err = server.ListenAndServeTLS(tls.Cert, tls.Key)
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("error running Telegram webhook server: %w", err)
+		}
+
+		return nil
+	})
+
+	eg.Go(func() error {
+		<-ctx.Done()
+		l.Debug("Shutting down Telegram webhook server")
+
+		// Disable cancel so we can shutdown gracefully
+		noCancelCtx := context.WithoutCancel(ctx)
+		if err := server.Shutdown(noCancelCtx); err != nil {
+			return fmt.Errorf("error shutting down Telegram webhook server: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// NotifyNewDevice sends a message to all the chat IDs when an unknown device tries to connect.
+func (bs *BotServer) NotifyNewDevice(_ context.Context, d notify.Device) {
+	bs.l.Debug("Sending login attempt notification", slog.String("username", d.Username), slog.String("macAddress", d.MACAddress))
+
+	// The buttons reference the device in the pending queue
+	pending, err := bs.pendingDevice(d)
+	if err != nil {
+		bs.l.Error("Error getting pending device", slog.Any("error", err), slog.String("username", d.Username))
 
-	data := &newDeviceData{
-		Username:   username,
-		Password:   password,
-		MacAddress: macAddress,
+		return
 	}
 
+	data := newPendingDeviceData(pending)
+	data.Time = d.Time
+	data.state = &notificationState{}
+
+	sent := &sentNotification{data: data}
+
 	for _, chatID := range bs.chatIDs {
 		recipient := tele.ChatID(chatID)
 
 		msg, markup := bs.createNewDeviceMessage(data)
 
-		if _, err := bs.bot.Send(recipient, msg, markup, tele.ModeMarkdown); err != nil {
+		m, err := bs.bot.Send(recipient, msg, markup, tele.ModeMarkdown)
+		if err != nil {
+			bs.l.Error("Error sending message", slog.Any("error", err), slog.Int64("chatID", chatID), slog.String("message", msg))
+
+			continue
+		}
+
+		sent.messages = append(sent.messages, sentMessage{msg: m, markup: markup})
+	}
+
+	bs.notifications.Set(d.Username, sent)
+}
+
+// pendingDevice returns the pending device of a notification, recording it if it's not in the queue yet.
+func (bs *BotServer) pendingDevice(d notify.Device) (database.PendingDevice, error) {
+	devices, err := bs.db.GetPendingDevices()
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error getting pending devices: %w", err)
+	}
+
+	for _, pending := range devices {
+		if pending.Username == d.Username {
+			return pending, nil
+		}
+	}
+
+	pending, err := bs.db.RecordPendingDevice(database.PendingDevice{
+		Username:   d.Username,
+		Password:   d.Password,
+		MACAddress: d.MACAddress,
+		NASAddress: d.NASAddress,
+		LastSeen:   d.Time,
+	})
+	if err != nil {
+		return database.PendingDevice{}, fmt.Errorf("error recording pending device: %w", err)
+	}
+
+	return pending, nil
+}
+
+// NotifyRepeat updates the new device messages with the number of attempts since they were sent.
+// Messages an admin is interacting with are left untouched.
+func (bs *BotServer) NotifyRepeat(_ context.Context, d notify.Device, count int) {
+	sent, ok := bs.notifications.Get(d.Username)
+	if !ok {
+		return
+	}
+
+	// Hold the lock while editing so the buttons can't change the messages at the same time
+	state := sent.data.state
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.busy {
+		return
+	}
+
+	state.repeats = count
+	text := newDeviceText(sent.data, count)
+
+	for _, m := range sent.messages {
+		if _, err := bs.bot.Edit(m.msg, text, m.markup, tele.ModeMarkdown); err != nil {
+			bs.l.Debug("Error updating message", slog.Any("error", err), slog.Int64("chatID", m.msg.Chat.ID))
+		}
+	}
+}
+
+// NotifyError sends a message to all the chat IDs when a device could not be handled.
+func (bs *BotServer) NotifyError(_ context.Context, d notify.Device, err error) {
+	bs.broadcast(notify.ErrorMessage(d, err).Render(markdownFormat))
+}
+
+// NotifyChange logs administrative changes. Only expirations are sent to the chats since
+// the other changes are mostly made through the bot itself.
+func (bs *BotServer) NotifyChange(ctx context.Context, c notify.Change) {
+	bs.Base.NotifyChange(ctx, c)
+
+	if c.Type == notify.ChangeUserExpired || c.Type == notify.ChangeTempVLANExpired {
+		bs.broadcast(notify.ExpiryMessage(c).Render(markdownFormat))
+	}
+}
+
+// broadcast sends a message to all the chat IDs.
+func (bs *BotServer) broadcast(msg string, opts ...interface{}) {
+	opts = append(opts, tele.ModeMarkdown)
+
+	for _, chatID := range bs.chatIDs {
+		if _, err := bs.bot.Send(tele.ChatID(chatID), msg, opts...); err != nil {
 			bs.l.Error("Error sending message", slog.Any("error", err), slog.Int64("chatID", chatID), slog.String("message", msg))
 		}
 	}
@@ -1,48 +1,171 @@
 package telegram
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/maronato/authifi/internal/approval"
 	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/eventlog"
 	"github.com/maronato/authifi/internal/lru"
+	"github.com/maronato/authifi/internal/notify"
+	"github.com/maronato/authifi/internal/schedule"
 	tele "gopkg.in/telebot.v3"
 )
 
 const (
 	// Inline reply buttons.
-	btnAddUnique        = "add"
-	btnSelectVLANUnique = "select-vlan"
-	btnBackAddUnique    = "back-add"
-	btnIgnoreUnique     = "ignore"
-	btnBlocklistUnique  = "blocklist"
+	btnAddUnique            = "add"
+	btnSelectVLANUnique     = "select-vlan"
+	btnSelectDurationUnique = "add-for"
+	btnBackAddUnique        = "back-add"
+	btnIgnoreUnique         = "ignore"
+	btnBlocklistUnique      = "blocklist"
 
 	// Edit inline reply buttons.
-	btnEditChangeVLANUnique = "edit-change-vlan"
-	btnEditBlockUnique      = "edit-block"
-	btnEditUnblockUnique    = "edit-unblock"
-	btnEditDeleteUnique     = "edit-delete"
-	btnEditBackUnique       = "edit-back"
-	btnEditSelectVLANUnique = "edit-select-vlan"
+	btnEditChangeVLANUnique  = "edit-change-vlan"
+	btnEditBlockUnique       = "edit-block"
+	btnEditUnblockUnique     = "edit-unblock"
+	btnEditDeleteUnique      = "edit-delete"
+	btnEditBackUnique        = "edit-back"
+	btnEditSelectVLANUnique  = "edit-select-vlan"
+	btnEditChangeGroupUnique = "edit-change-group"
+	btnEditSelectGroupUnique = "edit-select-group"
 
 	// newDeviceDataCacheSize is the default size of the new device data cache.
 	newDeviceDataCacheSize = 100
-	// editDeviceDataCacheSize is the default size of the edit device data cache.
-	editDeviceDataCacheSize = 10
+	// editMenuCacheSize is how many of the latest edit menus keep working.
+	editMenuCacheSize = 10
+	// maxInlineButtons is the most buttons Telegram allows in a message.
+	maxInlineButtons = 100
+	// editDeviceDataCacheSize is the size of the edit device data cache. Every button of an edit menu,
+	// like each VLAN or group option, gets its own entry.
+	editDeviceDataCacheSize = editMenuCacheSize * maxInlineButtons
 )
 
+// accessDuration is how long a new device can be added for.
+type accessDuration struct {
+	// Name is the label of the button.
+	Name string
+	// Key identifies the duration in the button data. It's short to fit in the callback data.
+	Key string
+	// Duration is how long the device is added for. Zero adds it forever.
+	Duration time.Duration
+}
+
+// accessDurations are the durations new devices can be added for from the bot.
+var accessDurations = []accessDuration{ //nolint:gochecknoglobals // read-only options
+	{Name: "🕐 1 Day", Key: "1d", Duration: 24 * time.Hour},      //nolint:gomnd // a day
+	{Name: "📅 1 Week", Key: "1w", Duration: 7 * 24 * time.Hour}, //nolint:gomnd // a week
+	{Name: "♾ Forever", Key: "0"},
+}
+
+// accessExpiresAt returns when a device added at now for the duration with the given key expires.
+// It's nil for devices added forever, and ok is false for unknown keys.
+func accessExpiresAt(key string, now time.Time) (expiresAt *time.Time, ok bool) {
+	for _, d := range accessDurations {
+		if d.Key != key {
+			continue
+		}
+
+		if d.Duration == 0 {
+			return nil, true
+		}
+
+		t := now.Add(d.Duration)
+
+		return &t, true
+	}
+
+	return nil, false
+}
+
 type newDeviceData struct {
+	// PendingID is the ID of the device in the pending device queue.
+	PendingID string
 	// Username is the username of the device.
 	Username string
 	// Password is the password of the device.
 	Password string
-	// VlanID is the ID of the VLAN. It's empty if the user didn't select any VLAN.
-	VlanID string
 	// MacAddress is the MAC address of the device.
 	MacAddress string
 	// Description is the custom assigned name of the device. It's empty by default.
 	Description string
+	// NASAddress is the address of the NAS the device tried to connect through.
+	NASAddress string
+	// Time is when the device tried to connect.
+	Time time.Time
+	// state tracks the notification sent for the device. It's nil for data created during the flow.
+	state *notificationState
+}
+
+// notificationState tracks a new device notification so repeated attempts can update it.
+type notificationState struct {
+	mu sync.Mutex
+	// repeats is the number of attempts since the notification was sent.
+	repeats int
+	// busy is true while an admin is interacting with the notification, or after they handled it.
+	busy bool
+}
+
+// repeatCount returns the number of attempts since the notification was sent.
+func (s *notificationState) repeatCount() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.repeats
+}
+
+// setBusy marks whether the notification may be updated with repeated attempts.
+func (s *notificationState) setBusy(busy bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy = busy
+}
+
+// newPendingDeviceData creates the new device data of a pending device.
+func newPendingDeviceData(p database.PendingDevice) *newDeviceData {
+	return &newDeviceData{
+		PendingID:  p.ID,
+		Username:   p.Username,
+		Password:   p.Password,
+		MacAddress: p.MACAddress,
+		NASAddress: p.NASAddress,
+		Time:       p.FirstSeen,
+	}
+}
+
+// newDeviceText creates the text of a new device notification.
+func newDeviceText(data *newDeviceData, repeats int) string {
+	msg := notify.NewDeviceMessage(notify.Device{
+		Username:   data.Username,
+		MACAddress: data.MacAddress,
+		NASAddress: data.NASAddress,
+		Time:       data.Time,
+	})
+
+	if repeats > 0 {
+		msg.Fields = append(msg.Fields, notify.Field{Name: "Seen again", Value: fmt.Sprintf("%d more times", repeats)})
+	}
+
+	msg.Footer = "What would you like to do?"
+
+	return msg.Render(markdownFormat)
 }
 
 func extractUsernameFromNewDeviceMessage(text string) string {
@@ -95,44 +218,110 @@ func buildVLANSelectMenu(bot *tele.Bot, db database.Database, selectVLANUnique s
 }
 
 // registerNewDeviceFlow registers the handlers for the new device flow.
-func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *[]tele.HandlerFunc) func(data *newDeviceData) (string, *tele.ReplyMarkup) { //nolint:maintidx // I want to keep the function signature as is
-	// Create the cache that will persist new user data across the new user flow
-	newDeviceCache := lru.NewLRUCache[string, *newDeviceData](newDeviceDataCacheSize)
+// The buttons reference devices in the pending device queue, so they keep working after a restart.
+func registerNewDeviceFlow(bot *tele.Bot, db database.Database, l *slog.Logger, notifications *lru.Cache[string, *sentNotification], onTextHandlers *[]tele.HandlerFunc) func(data *newDeviceData) (string, *tele.ReplyMarkup) { //nolint:maintidx // I want to keep the function signature as is
+	// deviceData returns the data of a pending device with the state of its notification, if it's still around.
+	deviceData := func(pending database.PendingDevice) *newDeviceData {
+		data := newPendingDeviceData(pending)
+		if sent, ok := notifications.Get(pending.Username); ok {
+			data.state = sent.data.state
+		}
+
+		return data
+	}
+
+	// loadData loads the data of a pending device.
+	loadData := func(pendingID string) (*newDeviceData, error) {
+		pending, err := db.GetPendingDevice(pendingID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFailedToReadData, err)
+		}
+
+		return deviceData(pending), nil
+	}
+
+	// loadFailed answers a button whose pending device could not be loaded.
+	// Devices that are no longer pending were handled by someone else in the meantime.
+	loadFailed := func(c tele.Context, err error) error {
+		if !errors.Is(err, database.ErrPendingDeviceNotFound) {
+			return err
+		}
+
+		if err := c.Respond(&tele.CallbackResponse{Text: "This request was already handled.", ShowAlert: true}); err != nil {
+			return fmt.Errorf("error answering callback: %w", err)
+		}
+
+		return nil
+	}
+
+	// applyOnce applies an approval to a pending device. The device is taken from the queue, so when
+	// two people tap at the same time, or a link is used too, only the first approval is applied.
+	applyOnce := func(c tele.Context, pendingID string, a approval.Approval) (*newDeviceData, error) {
+		a.PendingID = pendingID
+
+		pending, err := approval.Apply(db, a)
+		if err != nil {
+			return nil, fmt.Errorf("error applying %s: %w", a.Action, err)
+		}
+
+		data := deviceData(pending)
+
+		l.Info("Pending device handled", slog.String("action", string(a.Action)), slog.String("username", data.Username), slog.String("vlan", a.VlanID), slog.String("by", actorName(c.Sender())), slog.Int64("chatID", c.Chat().ID))
+
+		return data, nil
+	}
+
+	// finish shows the outcome in the message that was tapped and in the same notification in every other chat.
+	finish := func(c tele.Context, data *newDeviceData, text string) error {
+		// Stop updating the notifications with repeated attempts for good
+		data.state.setBusy(true)
+
+		if err := c.Edit(text, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		sent, ok := notifications.Get(data.Username)
+		if !ok {
+			return nil
+		}
+
+		var errs []error
+
+		for _, m := range sent.messages {
+			if m.msg.Chat.ID == c.Chat().ID && m.msg.ID == c.Message().ID {
+				continue
+			}
+
+			if _, err := bot.Edit(m.msg, text, tele.ModeMarkdown); err != nil {
+				errs = append(errs, fmt.Errorf("error syncing message in chat %d: %w", m.msg.Chat.ID, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
 
 	// createNotifyMessage creates a notification message for a new user.
 	createNotifyMessage := func(data *newDeviceData) (string, *tele.ReplyMarkup) {
 		m := bot.NewMarkup()
 
-		dataID := createRandomID()
-		newDeviceCache.Set(dataID, data)
-
-		btnAdd := m.Data("✅ Add Device", btnAddUnique, dataID).Inline()
-		btnIgnore := m.Data("❌ Ignore Request", btnIgnoreUnique, dataID).Inline()
-		btnBlock := m.Data("🔒 Block Device", btnBlocklistUnique, dataID).Inline()
+		btnAdd := m.Data("✅ Add Device", btnAddUnique, data.PendingID).Inline()
+		btnIgnore := m.Data("❌ Ignore Request", btnIgnoreUnique, data.PendingID).Inline()
+		btnBlock := m.Data("🔒 Block Device", btnBlocklistUnique, data.PendingID).Inline()
 		m.InlineKeyboard = [][]tele.InlineButton{{*btnAdd}, {*btnIgnore}, {*btnBlock}}
 
-		// Markdown message
-		msg := fmt.Sprintf(`*🚨 New Device Detected! 🚨*
-		
-		*Username:* `+"`%s`"+`
-		*Mac Address:* `+"`%s`"+`
-		*Connection time:* `+"`%s`"+`
-		
-		What would you like to do?`,
-			data.Username, data.MacAddress, time.Now().Format(time.RFC1123))
-
-		return msg, m
+		return newDeviceText(data, data.state.repeatCount()), m
 	}
 
 	// Handle the "Add" button
 	bot.Handle(&tele.InlineButton{Unique: btnAddUnique}, func(c tele.Context) error {
-		dataID := c.Data()
-
-		data, ok := newDeviceCache.Get(dataID)
-		if !ok {
-			return ErrFailedToReadData
+		data, err := loadData(c.Data())
+		if err != nil {
+			return loadFailed(c, err)
 		}
 
+		// Stop updating the notification while the menu is open
+		data.state.setBusy(true)
+
 		// Show the VLAN selection menu
 		m := bot.NewMarkup()
 
@@ -142,19 +331,11 @@ func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *
 			return fmt.Errorf("error getting VLANs: %w", err)
 		}
 
-		// Build the inline keyboard with the VLANs
-		for i, vlan := range vlans {
-			selectedData := &newDeviceData{
-				Username:   data.Username,
-				Password:   data.Password,
-				VlanID:     vlan.ID,
-				MacAddress: data.MacAddress,
-			}
-
-			selectedDataID := createRandomID()
-			newDeviceCache.Set(selectedDataID, selectedData)
+		// Build the inline keyboard with the VLANs the user can assign
+		vlans = slices.DeleteFunc(vlans, func(vlan database.VLAN) bool { return !canAssignVLAN(c, vlan) })
 
-			btn := m.Data(vlan.Name, btnSelectVLANUnique, selectedDataID).Inline()
+		for i, vlan := range vlans {
+			btn := m.Data(vlan.Name, btnSelectVLANUnique, data.PendingID, vlan.ID).Inline()
 			// Add up to 3 buttons per row
 			if i%3 == 0 {
 				m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
@@ -164,7 +345,7 @@ func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *
 		}
 
 		// Add a back button, reuse the same data
-		btn := m.Data("⬅ Back", btnBackAddUnique, dataID).Inline()
+		btn := m.Data("⬅ Back", btnBackAddUnique, data.PendingID).Inline()
 		m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
 
 		// Edit the message with the VLAN selection menu
@@ -179,48 +360,103 @@ func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *
 		}
 
 		return nil
-	})
+	}, requireRole(RoleOperator))
 
 	// Handle the selection of a VLAN by the user
 	bot.Handle(&tele.InlineButton{Unique: btnSelectVLANUnique}, func(c tele.Context) error {
-		data, ok := newDeviceCache.Get(c.Data())
+		// The data is the pending device ID and the selected VLAN ID
+		args := c.Args()
+		if len(args) != 2 { //nolint:gomnd // pending ID and VLAN ID
+			return ErrFailedToReadData
+		}
+
+		data, err := loadData(args[0])
+		if err != nil {
+			return loadFailed(c, err)
+		}
+
+		// Get the selected VLAN
+		vlan, err := db.GetVLAN(args[1])
+		if err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		if !canAssignVLAN(c, vlan) {
+			return deny(c, fmt.Sprintf("Only admins can add devices to %s.", vlan.Name))
+		}
+
+		// Show the duration selection menu
+		m := bot.NewMarkup()
+
+		row := make([]tele.InlineButton, 0, len(accessDurations))
+		for _, d := range accessDurations {
+			row = append(row, *m.Data(d.Name, btnSelectDurationUnique, data.PendingID, vlan.ID, d.Key).Inline())
+		}
+
+		// The back button goes back to the VLAN selection menu
+		m.InlineKeyboard = [][]tele.InlineButton{row, {*m.Data("⬅ Back", btnAddUnique, data.PendingID).Inline()}}
+
+		msg := fmt.Sprintf(`*👤 Add `+"`%s`"+` to %s*
+		
+		How long should this device have access for?`,
+			data.Username, strings.ReplaceAll(vlan.Name, "*", ""))
+
+		if err := c.Edit(msg, m, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleOperator))
+
+	// Handle the selection of how long the device is added for
+	bot.Handle(&tele.InlineButton{Unique: btnSelectDurationUnique}, func(c tele.Context) error {
+		// The data is the pending device ID, the selected VLAN ID and the duration key
+		args := c.Args()
+		if len(args) != 3 { //nolint:gomnd // pending ID, VLAN ID and duration
+			return ErrFailedToReadData
+		}
+
+		expiresAt, ok := accessExpiresAt(args[2], time.Now())
 		if !ok {
 			return ErrFailedToReadData
 		}
 
 		// Get the selected VLAN
-		vlan, err := db.GetVLAN(data.VlanID)
+		vlan, err := db.GetVLAN(args[1])
 		if err != nil {
 			return fmt.Errorf("error getting VLAN: %w", err)
 		}
 
+		if !canAssignVLAN(c, vlan) {
+			return deny(c, fmt.Sprintf("Only admins can add devices to %s.", vlan.Name))
+		}
+
 		// Create user
-		if err := db.CreateUser(database.User{
-			Username: data.Username,
-			Password: data.Password,
-			VlanID:   vlan.ID,
-		}); err != nil {
-			return fmt.Errorf("error creating user: %w", err)
+		data, err := applyOnce(c, args[0], approval.Approval{Action: approval.ActionAdd, VlanID: vlan.ID, UserExpiresAt: expiresAt})
+		if err != nil {
+			return loadFailed(c, err)
 		}
 
-		// Edit the message with the success message
+		until := ""
+		if expiresAt != nil {
+			until = " until " + expiresAt.Format(time.RFC1123)
+		}
+
+		// Edit the messages with the success message
 		msg := fmt.Sprintf(`*✅ Success! ✅*
 		
-		`+"`%s`"+` has been added to the *%s* network.
+		`+"`%s`"+` has been added to the *%s* network%s.
+		👤 Approved by %s
 		
 		You may reply to this message with a name to assign to this device.`,
-			data.Username, vlan.Name,
+			data.Username, vlan.Name, until, escapeMarkdown(actorName(c.Sender())),
 		)
 
-		if err := c.Edit(msg, tele.ModeMarkdown); err != nil {
-			return fmt.Errorf("error editing message: %w", err)
-		}
-
-		return nil
-	})
+		return finish(c, data, msg)
+	}, requireRole(RoleOperator))
 
 	// Handle replies to the message with the device name
-	*onTextHandlers = append(*onTextHandlers, func(c tele.Context) error {
+	*onTextHandlers = append(*onTextHandlers, onlyRole(RoleOperator, func(c tele.Context) error {
 		if c.Message().IsReply() {
 			reply := c.Message()
 			original := c.Message().ReplyTo
@@ -258,16 +494,17 @@ func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *
 		}
 
 		return nil
-	})
+	}))
 
 	// Handle the back button from the VLAN selection menu
 	bot.Handle(&tele.InlineButton{Unique: btnBackAddUnique}, func(c tele.Context) error {
-		data, ok := newDeviceCache.Get(c.Data())
-		if !ok {
-			return ErrFailedToReadData
+		data, err := loadData(c.Data())
+		if err != nil {
+			return loadFailed(c, err)
 		}
 
-		// Recreate the notification message
+		// Recreate the notification message and resume updating it
+		data.state.setBusy(false)
 		msg, markup := createNotifyMessage(data)
 
 		// Edit the message with the notification message
@@ -276,52 +513,43 @@ func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *
 		}
 
 		return nil
-	})
+	}, requireRole(RoleOperator))
 
 	// Handle the "Ignore" button
 	bot.Handle(&tele.InlineButton{Unique: btnIgnoreUnique}, func(c tele.Context) error {
-		data, ok := newDeviceCache.Get(c.Data())
-		if !ok {
-			return ErrFailedToReadData
+		// Remove the device from the pending queue
+		data, err := applyOnce(c, c.Data(), approval.Approval{Action: approval.ActionIgnore})
+		if err != nil {
+			return loadFailed(c, err)
 		}
 
-		// Edit the message with the ignore message
+		// Edit the messages with the ignore message
 		msg := fmt.Sprintf(`*🚫 Request Ignored 🚫*
 		
-		No action has been taken for `+"`%s`"+`.`,
-			data.Username)
+		No action has been taken for `+"`%s`"+`.
+		👤 Ignored by %s`,
+			data.Username, escapeMarkdown(actorName(c.Sender())))
 
-		if err := c.Edit(msg, tele.ModeMarkdown); err != nil {
-			return fmt.Errorf("error editing message: %w", err)
-		}
-
-		return nil
-	})
+		return finish(c, data, msg)
+	}, requireRole(RoleOperator))
 
 	// Handle the "Block" button
 	bot.Handle(&tele.InlineButton{Unique: btnBlocklistUnique}, func(c tele.Context) error {
-		data, ok := newDeviceCache.Get(c.Data())
-		if !ok {
-			return ErrFailedToReadData
-		}
-
 		// Block user
-		if err := db.BlockUser(data.Username); err != nil {
-			return fmt.Errorf("error blocking user: %w", err)
+		data, err := applyOnce(c, c.Data(), approval.Approval{Action: approval.ActionBlock})
+		if err != nil {
+			return loadFailed(c, err)
 		}
 
-		// Edit the message with the block message
+		// Edit the messages with the block message
 		msg := fmt.Sprintf(`*🔒 User Blocked 🔒*
 		
-		`+"`%s`"+` has been blocked and further connections will be ignored.`,
-			data.Username)
+		`+"`%s`"+` has been blocked and further connections will be ignored.
+		👤 Blocked by %s`,
+			data.Username, escapeMarkdown(actorName(c.Sender())))
 
-		if err := c.Edit(msg, tele.ModeMarkdown); err != nil {
-			return fmt.Errorf("error editing message: %w", err)
-		}
-
-		return nil
-	})
+		return finish(c, data, msg)
+	}, requireRole(RoleAdmin))
 
 	// Return function to create a message for admin notification
 	return createNotifyMessage
@@ -330,11 +558,16 @@ func registerNewDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *
 type editDeviceData struct {
 	// Username is the username of the device.
 	Username string
-	// VlanID is the ID of the VLAN. It's empty if the user didn't select any VLAN.
+	// VlanID is the ID of the VLAN. It's empty if the user didn't select any VLAN, or
+	// selected the VLAN of the device's group.
 	VlanID string
+	// Group is the name of the selected group. It's empty if the user selected no group.
+	Group string
 }
 
-func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers *[]tele.HandlerFunc) { //nolint:gocyclo,maintidx // big func good
+// registerEditDeviceFlow registers the handlers for the edit device flow. It returns the function
+// that builds the edit message of a device, so other flows can open it.
+func registerEditDeviceFlow(bot *tele.Bot, db database.Database, events *eventlog.Log, onTextHandlers *[]tele.HandlerFunc) func(username string) (string, *tele.ReplyMarkup, error) { //nolint:gocyclo,maintidx // big func good
 	editDeviceCache := lru.NewLRUCache[string, *editDeviceData](editDeviceDataCacheSize)
 
 	buildEditMessage := func(username string) (string, *tele.ReplyMarkup, error) {
@@ -356,22 +589,77 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 			return fmt.Sprintf("*🚫 User Not Found 🚫*\n\n`%s` does not exist.", username), nil, nil
 		}
 
-		vlan, err := db.GetVLAN(user.VlanID)
+		// Users in a group may use the group's VLAN
+		vlanID, err := database.UserVLANID(db, user)
+		if err != nil && !blocked {
+			return "", nil, fmt.Errorf("error getting VLAN of user: %w", err)
+		}
+
+		vlan, err := db.GetVLAN(vlanID)
 		if err != nil {
 			// If the user doesn't have a VLAN, create a temp VLAN with no name
-			if user.VlanID == "" {
+			if vlanID == "" {
 				vlan = database.VLAN{}
 			} else {
 				return "", nil, fmt.Errorf("error getting VLAN: %w", err)
 			}
 		}
 
+		vlanName := vlan.Name
+		if user.VlanID == "" && user.Group != "" {
+			vlanName += " (from group)"
+		}
+
+		group := user.Group
+		if group == "" {
+			group = "none"
+		}
+
 		msg += fmt.Sprintf(`
 		*Name:* %s
 		*Username:* %s
 		*VLAN:* %s
-		
-		You may reply to this message with a new name for this device.`, user.Description, username, vlan.Name)
+		*Group:* %s
+		`, user.Description, username, vlanName, group)
+
+		// Show the schedules of the device and its group
+		if rules, err := database.UserSchedules(db, user); err == nil && len(rules) > 0 {
+			msg += "*Schedules:*\n"
+			for _, rule := range rules {
+				msg += "- " + escapeMarkdown(schedule.Describe(rule)) + "\n"
+			}
+		}
+
+		// Show the VLANs of the device and its group on specific networks
+		if networks, err := database.UserNetworks(db, user); err == nil && len(networks) > 0 {
+			msg += "*Networks:*\n"
+			for _, n := range networks {
+				msg += "- " + escapeMarkdown(n.Network.String()+": VLAN "+n.VlanID) + "\n"
+			}
+		}
+
+		if len(user.Attributes) > 0 {
+			msg += "*Attributes:* " + escapeMarkdown(describeAttributes(user.Attributes)) + "\n"
+		}
+
+		if override := user.ActiveTempVLAN(time.Now()); override != nil {
+			msg += fmt.Sprintf("*Temporary VLAN:* %s until %s\n", escapeMarkdown(override.VlanID), override.ExpiresAt.Format(time.RFC1123))
+		}
+
+		if user.ExpiresAt != nil {
+			msg += fmt.Sprintf("*Expires:* %s\n", user.ExpiresAt.Format(time.RFC1123))
+		}
+
+		if stats, ok := events.Stats(username); ok {
+			msg += fmt.Sprintf(`*First seen:* %s
+		*Last seen:* %s
+		*Attempts:* %d
+		`, stats.FirstSeen.Format(time.RFC1123), formatLastSeen(stats.LastSeen), stats.Attempts)
+		} else {
+			msg += "*Last seen:* never\n"
+		}
+
+		msg += "\nYou may reply to this message with a new name for this device."
 
 		m := bot.NewMarkup()
 
@@ -379,6 +667,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		editDeviceCache.Set(dataID, &editDeviceData{Username: username})
 
 		btnChangeVLAN := m.Data("🔄 Change VLAN", btnEditChangeVLANUnique, dataID).Inline()
+		btnChangeGroup := m.Data("👥 Change Group", btnEditChangeGroupUnique, dataID).Inline()
 		btnBlock := m.Data("🔒 Block", btnEditBlockUnique, dataID).Inline()
 		btnUnblock := m.Data("🔓 Unblock", btnEditUnblockUnique, dataID).Inline()
 		btnDelete := m.Data("🗑 Delete", btnEditDeleteUnique, dataID).Inline()
@@ -386,7 +675,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		if blocked {
 			m.InlineKeyboard = [][]tele.InlineButton{{*btnUnblock}, {*btnDelete}}
 		} else {
-			m.InlineKeyboard = [][]tele.InlineButton{{*btnChangeVLAN}, {*btnBlock}, {*btnDelete}}
+			m.InlineKeyboard = [][]tele.InlineButton{{*btnChangeVLAN}, {*btnChangeGroup}, {*btnBlock}, {*btnDelete}}
 		}
 
 		return msg, m, nil
@@ -421,7 +710,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
 
 	// Handle the change VLAN button
 	bot.Handle(&tele.InlineButton{Unique: btnEditChangeVLANUnique}, func(c tele.Context) error {
@@ -442,6 +731,15 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 			return fmt.Errorf("error building VLAN select menu: %w", err)
 		}
 
+		// Devices in a group can go back to the group's VLAN
+		if user, err := db.GetUser(data.Username); err == nil && user.Group != "" {
+			inheritID := createRandomID()
+			editDeviceCache.Set(inheritID, &editDeviceData{Username: data.Username})
+
+			btn := markup.Data("👥 Use Group VLAN", btnEditSelectVLANUnique, inheritID).Inline()
+			markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{*btn})
+		}
+
 		// Add a back button
 		btn := markup.Data("⬅ Back", btnEditBackUnique, dataID).Inline()
 		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{*btn})
@@ -455,7 +753,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
 
 	// Handle the block button
 	bot.Handle(&tele.InlineButton{Unique: btnEditBlockUnique}, func(c tele.Context) error {
@@ -480,7 +778,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
 
 	// Handle the unblock button
 	bot.Handle(&tele.InlineButton{Unique: btnEditUnblockUnique}, func(c tele.Context) error {
@@ -505,7 +803,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
 
 	// Handle the delete button
 	bot.Handle(&tele.InlineButton{Unique: btnEditDeleteUnique}, func(c tele.Context) error {
@@ -527,10 +825,10 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
 
 	// Handle replies to the message with the device name
-	*onTextHandlers = append(*onTextHandlers, func(c tele.Context) error {
+	*onTextHandlers = append(*onTextHandlers, onlyRole(RoleAdmin, func(c tele.Context) error {
 		if c.Message().IsReply() { //nolint:nestif // nest if good
 			reply := c.Message()
 			original := c.Message().ReplyTo
@@ -585,7 +883,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}))
 
 	// Handle the back button from the VLAN selection menu
 	bot.Handle(&tele.InlineButton{Unique: btnEditBackUnique}, func(c tele.Context) error {
@@ -606,7 +904,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
 
 	// Handle VLAN selection
 	bot.Handle(&tele.InlineButton{Unique: btnEditSelectVLANUnique}, func(c tele.Context) error {
@@ -615,24 +913,121 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 			return ErrFailedToReadData
 		}
 
-		// Get the selected VLAN
-		vlan, err := db.GetVLAN(data.VlanID)
+		// Update the user with the new VLAN. No VLAN means the device uses its group's VLAN.
+		user, err := db.GetUser(data.Username)
 		if err != nil {
-			return fmt.Errorf("error getting VLAN: %w", err)
+			return fmt.Errorf("error getting user: %w", err)
+		}
+
+		if data.VlanID != "" {
+			if _, err := db.GetVLAN(data.VlanID); err != nil {
+				return denyVLANError(c, fmt.Errorf("error getting VLAN: %w", err))
+			}
+		}
+
+		user.VlanID = data.VlanID
+		if err := db.UpdateUser(user); err != nil {
+			return denyVLANError(c, fmt.Errorf("error updating user: %w", err))
+		}
+
+		// Edit the message with the success message
+		msg, markup, err := buildEditMessage(data.Username)
+		if err != nil {
+			return fmt.Errorf("error building edit message: %w", err)
+		}
+
+		if err := c.Edit(msg, markup, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleAdmin))
+
+	// Handle the change group button
+	bot.Handle(&tele.InlineButton{Unique: btnEditChangeGroupUnique}, func(c tele.Context) error {
+		dataID := c.Data()
+
+		data, ok := editDeviceCache.Get(dataID)
+		if !ok {
+			return ErrFailedToReadData
+		}
+
+		groups, err := db.GetGroups()
+		if err != nil {
+			return fmt.Errorf("error getting groups: %w", err)
+		}
+
+		m := bot.NewMarkup()
+
+		// Build the inline keyboard with the groups and an option to leave the group
+		options := make([]string, 0, len(groups)+1)
+		options = append(options, "")
+
+		for _, group := range groups {
+			options = append(options, group.Name)
+		}
+
+		for i, group := range options {
+			selectedID := createRandomID()
+			editDeviceCache.Set(selectedID, &editDeviceData{Username: data.Username, Group: group})
+
+			label := group
+			if group == "" {
+				label = "🚫 No Group"
+			}
+
+			btn := m.Data(label, btnEditSelectGroupUnique, selectedID).Inline()
+			// Add up to 3 buttons per row
+			if i%3 == 0 {
+				m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+			} else {
+				m.InlineKeyboard[len(m.InlineKeyboard)-1] = append(m.InlineKeyboard[len(m.InlineKeyboard)-1], *btn)
+			}
+		}
+
+		// Add a back button
+		btn := m.Data("⬅ Back", btnEditBackUnique, dataID).Inline()
+		m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+
+		msg := fmt.Sprintf(`*📝 Edit Device 📝*
+
+		Please select the new group for *%s*. Devices without a VLAN of their own use the VLAN of their group.`, data.Username)
+
+		if err := c.Edit(msg, m, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleAdmin))
+
+	// Handle group selection
+	bot.Handle(&tele.InlineButton{Unique: btnEditSelectGroupUnique}, func(c tele.Context) error {
+		data, ok := editDeviceCache.Get(c.Data())
+		if !ok {
+			return ErrFailedToReadData
 		}
 
-		// Update the user with the new VLAN
 		user, err := db.GetUser(data.Username)
 		if err != nil {
 			return fmt.Errorf("error getting user: %w", err)
 		}
 
-		user.VlanID = vlan.ID
+		// Devices leaving a group keep the VLAN they were using
+		if data.Group == "" && user.VlanID == "" {
+			if user.VlanID, err = database.UserVLANID(db, user); err != nil {
+				return fmt.Errorf("error getting VLAN of user: %w", err)
+			}
+		}
+
+		user.Group = data.Group
 		if err := db.UpdateUser(user); err != nil {
-			return fmt.Errorf("error updating user: %w", err)
+			if errors.Is(err, database.ErrGroupNotFound) {
+				return deny(c, "This group no longer exists.")
+			}
+
+			return denyVLANError(c, fmt.Errorf("error updating user: %w", err))
 		}
 
-		// Edit the message with the success message
 		msg, markup, err := buildEditMessage(data.Username)
 		if err != nil {
 			return fmt.Errorf("error building edit message: %w", err)
@@ -643,5 +1038,7 @@ func registerEditDeviceFlow(bot *tele.Bot, db database.Database, onTextHandlers
 		}
 
 		return nil
-	})
+	}, requireRole(RoleAdmin))
+
+	return buildEditMessage
 }
@@ -0,0 +1,35 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessExpiresAt(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	if got, ok := accessExpiresAt("1d", now); !ok || got == nil || !got.Equal(now.AddDate(0, 0, 1)) {
+		t.Errorf("got %v, %v, want a day from now", got, ok)
+	}
+
+	if got, ok := accessExpiresAt("1w", now); !ok || got == nil || !got.Equal(now.AddDate(0, 0, 7)) {
+		t.Errorf("got %v, %v, want a week from now", got, ok)
+	}
+
+	if got, ok := accessExpiresAt("0", now); !ok || got != nil {
+		t.Errorf("got %v, %v, want no expiration", got, ok)
+	}
+
+	if _, ok := accessExpiresAt("1y", now); ok {
+		t.Error("got ok for an unknown duration")
+	}
+
+	// The keys must fit in the callback data with the longest pending and VLAN IDs
+	for _, d := range accessDurations {
+		if data := "\f" + btnSelectDurationUnique + "|0123456789abcdef|01234567890123456789012345678901|" + d.Key; len(data) > 64 {
+			t.Errorf("got %d bytes of callback data for %s, want at most 64", len(data), d.Name)
+		}
+	}
+}
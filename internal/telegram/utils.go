@@ -4,6 +4,12 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maronato/authifi/internal/notify"
+	tele "gopkg.in/telebot.v3"
 )
 
 // ErrFailedToReadData is returned when the data from the message could not be read.
@@ -26,3 +32,53 @@ func createRandomID() string {
 
 	return randomID
 }
+
+// actorName returns a readable name of the user who sent an update.
+func actorName(u *tele.User) string {
+	if u == nil {
+		return "unknown"
+	}
+
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+
+	if name := strings.TrimSpace(u.FirstName + " " + u.LastName); name != "" {
+		return name
+	}
+
+	return strconv.FormatInt(u.ID, 10)
+}
+
+// formatLastSeen formats a time as a human readable duration relative to now.
+func formatLastSeen(t time.Time) string {
+	elapsed := time.Since(t)
+
+	switch {
+	case elapsed < time.Minute:
+		return "just now"
+	case elapsed < time.Hour:
+		return fmt.Sprintf("%dm ago", int(elapsed.Minutes()))
+	case elapsed < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(elapsed.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(elapsed.Hours()/24)) //nolint:gomnd // Hours in a day
+	}
+}
+
+// markdownFormat renders notify messages with Telegram's legacy Markdown.
+var markdownFormat = notify.Format{ //nolint:gochecknoglobals // shared by the notifications
+	Bold:      func(s string) string { return "*" + s + "*" },
+	Code:      func(s string) string { return "`" + strings.ReplaceAll(s, "`", "'") + "`" },
+	Link:      func(label, url string) string { return "[" + escapeMarkdown(label) + "](" + url + ")" },
+	Escape:    escapeMarkdown,
+	LineBreak: "\n",
+}
+
+// escapeMarkdown escapes the characters that have a meaning in Telegram's legacy Markdown.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+//nolint:gochecknoglobals // the replacer is safe for concurrent use
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
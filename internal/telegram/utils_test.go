@@ -0,0 +1,33 @@
+package telegram
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestActorName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		user *tele.User
+		want string
+	}{
+		{name: "username", user: &tele.User{ID: 1, Username: "alice", FirstName: "Alice"}, want: "@alice"},
+		{name: "full name", user: &tele.User{ID: 1, FirstName: "Alice", LastName: "Smith"}, want: "Alice Smith"},
+		{name: "first name", user: &tele.User{ID: 1, FirstName: "Alice"}, want: "Alice"},
+		{name: "ID", user: &tele.User{ID: 42}, want: "42"},
+		{name: "no user", want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := actorName(tt.user); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
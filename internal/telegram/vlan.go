@@ -0,0 +1,723 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/maronato/authifi/internal/database"
+	"github.com/maronato/authifi/internal/lru"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// VLAN inline reply buttons.
+	btnVLANEditUnique          = "vlan-edit"
+	btnVLANDefaultUnique       = "vlan-default"
+	btnVLANPrivilegedUnique    = "vlan-privileged"
+	btnVLANQuarantineUnique    = "vlan-quarantine"
+	btnVLANTunnelTypeUnique    = "vlan-tunnel"
+	btnVLANMediumTypeUnique    = "vlan-medium"
+	btnVLANSetTunnelUnique     = "vlan-set-tunnel"
+	btnVLANSetMediumUnique     = "vlan-set-medium"
+	btnVLANDeleteUnique        = "vlan-delete"
+	btnVLANConfirmDeleteUnique = "vlan-confirm-delete"
+	btnVLANBackUnique          = "vlan-back"
+
+	// vlanDeleteCacheSize is the size of the cache of the delete confirmation buttons.
+	vlanDeleteCacheSize = 100
+)
+
+// ErrInvalidVLANID is returned when a VLAN ID can't be used from the bot.
+var ErrInvalidVLANID = errors.New("invalid VLAN ID")
+
+// vlanIDRegex matches the VLAN IDs that can be created from the bot. They are sent in the buttons
+// of the edit message, so they must fit in Telegram's 64 byte callback data limit. Buttons that
+// need two VLAN IDs keep them in a cache instead.
+var vlanIDRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// vlanDeletion is a VLAN to delete and the VLAN its devices and groups are moved to.
+type vlanDeletion struct {
+	// ID is the ID of the VLAN to delete.
+	ID string
+	// ReplacementID is the ID of the VLAN the devices and groups are moved to. It's empty if nothing uses the VLAN.
+	ReplacementID string
+}
+
+// vlanOption is a named value of a VLAN tunnel attribute.
+type vlanOption struct {
+	// Name is the name shown in the buttons.
+	Name string
+	// Value is the value of the attribute. Zero uses the default.
+	Value uint32
+}
+
+// tunnelTypeOptions are the Tunnel-Type values that can be selected from the bot.
+var tunnelTypeOptions = []vlanOption{ //nolint:gochecknoglobals // read-only options
+	{Name: "Default (VLAN)", Value: 0},
+	{Name: "VLAN", Value: 13},    //nolint:gomnd // RFC 3580
+	{Name: "L2TP", Value: 3},     //nolint:gomnd // RFC 2868
+	{Name: "GRE", Value: 10},     //nolint:gomnd // RFC 2868
+	{Name: "PPTP", Value: 1},     //nolint:gomnd // RFC 2868
+	{Name: "IP-in-IP", Value: 7}, //nolint:gomnd // RFC 2868
+}
+
+// mediumTypeOptions are the Tunnel-Medium-Type values that can be selected from the bot.
+var mediumTypeOptions = []vlanOption{ //nolint:gochecknoglobals // read-only options
+	{Name: "Default (IEEE-802)", Value: 0},
+	{Name: "IEEE-802", Value: 6}, //nolint:gomnd // RFC 2868
+	{Name: "IPv4", Value: 1},     //nolint:gomnd // RFC 2868
+	{Name: "IPv6", Value: 2},     //nolint:gomnd // RFC 2868
+}
+
+// optionName returns the name of a value, or the value itself if it's not one of the options.
+func optionName(options []vlanOption, value uint32) string {
+	for _, o := range options {
+		if o.Value == value {
+			return o.Name
+		}
+	}
+
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+// extractVLANIDFromEditVLANMessage returns the VLAN ID of an edit VLAN message.
+func extractVLANIDFromEditVLANMessage(text string) string {
+	re := regexp.MustCompile(`VLAN ID: (.*?)\n`)
+
+	matches := re.FindStringSubmatch(text)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+
+	return ""
+}
+
+// parseAddVLAN parses the payload of /addvlan. The name defaults to the ID.
+func parseAddVLAN(payload string) (database.VLAN, error) {
+	payload = strings.TrimSpace(payload)
+
+	id, name, _ := strings.Cut(payload, " ")
+	if !vlanIDRegex.MatchString(id) {
+		return database.VLAN{}, fmt.Errorf("%w: %q", ErrInvalidVLANID, id)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = id
+	}
+
+	return database.VLAN{ID: id, Name: name}, nil
+}
+
+// findVLAN finds a VLAN by its ID or, if there is none, by its name.
+func findVLAN(db database.Database, query string) (database.VLAN, error) {
+	vlan, err := db.GetVLAN(query)
+	if err == nil {
+		return vlan, nil
+	}
+
+	vlans, err := db.GetVLANs()
+	if err != nil {
+		return database.VLAN{}, fmt.Errorf("error getting VLANs: %w", err)
+	}
+
+	for _, v := range vlans {
+		if strings.EqualFold(v.Name, query) {
+			return v, nil
+		}
+	}
+
+	return database.VLAN{}, fmt.Errorf("error finding VLAN %s: %w", query, database.ErrVLANNotFound)
+}
+
+// vlanErrorReason returns a message for the VLAN integrity errors users can act on.
+func vlanErrorReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, database.ErrVLANInUse):
+		return "Devices, groups, schedules, or policy rules still use this VLAN. Move them to another VLAN first.", true
+	case errors.Is(err, database.ErrDefaultVLANInUse):
+		return "The default VLAN can't be deleted. Make another VLAN the default first.", true
+	case errors.Is(err, database.ErrDefaultVLANAlreadyExists):
+		return "Another VLAN is already the default.", true
+	case errors.Is(err, database.ErrQuarantineVLANAlreadyExists):
+		return "Another VLAN is already the quarantine VLAN.", true
+	case errors.Is(err, database.ErrVLANNotFound):
+		return "This VLAN no longer exists.", true
+	default:
+		return "", false
+	}
+}
+
+// policyInUseReason tells the user which policy rules keep a VLAN from being deleted.
+func policyInUseReason(rules []database.PolicyRule) string {
+	names := make([]string, 0, len(rules))
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+
+	return "Policy rules still use this VLAN: " + strings.Join(names, ", ") + ". Change them in the database file first."
+}
+
+// denyVLANError tells the user why a VLAN change was refused, or returns the error if it's unexpected.
+func denyVLANError(c tele.Context, err error) error {
+	if reason, ok := vlanErrorReason(err); ok {
+		return deny(c, reason)
+	}
+
+	return err
+}
+
+// yesNo formats a flag.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// describeNetworks formats the networks a VLAN is the default of.
+func describeNetworks(networks []database.Network) string {
+	if len(networks) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(networks))
+	for _, n := range networks {
+		names = append(names, n.String())
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// describeAttributes formats reply attributes.
+func describeAttributes(attributes []database.Attribute) string {
+	if len(attributes) == 0 {
+		return "none"
+	}
+
+	pairs := make([]string, 0, len(attributes))
+	for _, a := range attributes {
+		pairs = append(pairs, a.Name+"="+a.Value)
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// registerVLANFlow registers the handlers for /vlans, /addvlan, and /editvlan.
+func registerVLANFlow(bot *tele.Bot, db database.Database, l *slog.Logger, onTextHandlers *[]tele.HandlerFunc) { //nolint:gocognit,maintidx // one closure per handler
+	// deletionCache maps the IDs in the delete confirmation buttons to deletions, since two VLAN IDs
+	// may not fit in a button
+	deletionCache := lru.NewLRUCache[string, vlanDeletion](vlanDeleteCacheSize)
+
+	// deleteButton creates a delete confirmation button.
+	deleteButton := func(m *tele.ReplyMarkup, text string, d vlanDeletion) (*tele.InlineButton, error) {
+		id, err := newListID()
+		if err != nil {
+			return nil, err
+		}
+
+		deletionCache.Set(id, d)
+
+		return m.Data(text, btnVLANConfirmDeleteUnique, id).Inline(), nil
+	}
+
+	// buildVLANMessage builds the edit message of a VLAN.
+	buildVLANMessage := func(id string) (string, *tele.ReplyMarkup, error) {
+		vlan, err := db.GetVLAN(id)
+		if err != nil {
+			if errors.Is(err, database.ErrVLANNotFound) {
+				return fmt.Sprintf("*🚫 VLAN Not Found 🚫*\n\n`%s` does not exist.", id), nil, nil
+			}
+
+			return "", nil, fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		usage, err := database.GetVLANUsage(db, vlan.ID)
+		if err != nil {
+			return "", nil, fmt.Errorf("error getting VLAN usage: %w", err)
+		}
+
+		msg := fmt.Sprintf(`*🌐 Edit VLAN 🌐*
+
+		*VLAN ID:* %s
+		*Name:* %s
+		*Default:* %s
+		*Privileged:* %s
+		*Quarantine:* %s
+		*Default for:* %s
+		*Attributes:* %s
+		*Tunnel type:* %s
+		*Medium type:* %s
+		*Devices:* %d
+		*Groups:* %d
+		*Policy rules:* %d
+
+		You may reply to this message with a new name for this VLAN.`,
+			escapeMarkdown(vlan.ID), escapeMarkdown(vlan.Name), yesNo(vlan.Default), yesNo(vlan.Privileged), yesNo(vlan.Quarantine), escapeMarkdown(describeNetworks(vlan.DefaultFor)),
+			escapeMarkdown(describeAttributes(vlan.Attributes)),
+			escapeMarkdown(optionName(tunnelTypeOptions, vlan.TunnelType)), escapeMarkdown(optionName(mediumTypeOptions, vlan.TunnelMediumType)), len(usage.Users), len(usage.Groups), len(usage.Policy))
+
+		m := bot.NewMarkup()
+
+		if !vlan.Default {
+			m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*m.Data("⭐ Make Default", btnVLANDefaultUnique, vlan.ID).Inline()})
+		}
+
+		privileged := "🔐 Make Privileged"
+		if vlan.Privileged {
+			privileged = "🔓 Make Unprivileged"
+		}
+
+		quarantine := "🚧 Make Quarantine"
+		if vlan.Quarantine {
+			quarantine = "🚧 Remove Quarantine"
+		}
+
+		m.InlineKeyboard = append(m.InlineKeyboard,
+			[]tele.InlineButton{
+				*m.Data(privileged, btnVLANPrivilegedUnique, vlan.ID).Inline(),
+				*m.Data(quarantine, btnVLANQuarantineUnique, vlan.ID).Inline(),
+			},
+			[]tele.InlineButton{
+				*m.Data("🚇 Tunnel Type", btnVLANTunnelTypeUnique, vlan.ID).Inline(),
+				*m.Data("📡 Medium Type", btnVLANMediumTypeUnique, vlan.ID).Inline(),
+			},
+			[]tele.InlineButton{*m.Data("🗑 Delete", btnVLANDeleteUnique, vlan.ID).Inline()},
+		)
+
+		return msg, m, nil
+	}
+
+	// showVLAN edits the message with the edit message of a VLAN.
+	showVLAN := func(c tele.Context, id string) error {
+		msg, markup, err := buildVLANMessage(id)
+		if err != nil {
+			return fmt.Errorf("error building VLAN message: %w", err)
+		}
+
+		if err := c.Edit(msg, markup, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}
+
+	// sendVLAN sends the edit message of a VLAN.
+	sendVLAN := func(c tele.Context, id string) error {
+		msg, markup, err := buildVLANMessage(id)
+		if err != nil {
+			return fmt.Errorf("error building VLAN message: %w", err)
+		}
+
+		if err := c.Send(msg, markup, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}
+
+	// updateVLAN loads a VLAN, changes it, and shows the edit message again.
+	updateVLAN := func(c tele.Context, id string, change func(v *database.VLAN)) error {
+		vlan, err := db.GetVLAN(id)
+		if err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		change(&vlan)
+
+		if err := db.UpdateVLAN(vlan); err != nil {
+			return denyVLANError(c, fmt.Errorf("error updating VLAN: %w", err))
+		}
+
+		l.Info("VLAN updated", slog.String("vlan", vlan.ID), slog.String("by", actorName(c.Sender())))
+
+		return showVLAN(c, id)
+	}
+
+	// optionMenu shows the options of a tunnel attribute.
+	optionMenu := func(c tele.Context, title string, options []vlanOption, setUnique string) error {
+		vlan, err := db.GetVLAN(c.Data())
+		if err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		m := bot.NewMarkup()
+
+		for _, o := range options {
+			btn := m.Data(o.Name, setUnique, vlan.ID, strconv.FormatUint(uint64(o.Value), 10)).Inline()
+			m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+		}
+
+		m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*m.Data("⬅ Back", btnVLANBackUnique, vlan.ID).Inline()})
+
+		msg := fmt.Sprintf("*🌐 Edit VLAN 🌐*\n\nPlease select the %s of *%s*:", title, escapeMarkdown(vlan.Name))
+
+		if err := c.Edit(msg, m, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}
+
+	// setOption sets a tunnel attribute from the selected option.
+	setOption := func(c tele.Context, set func(v *database.VLAN, value uint32)) error {
+		args := c.Args()
+		if len(args) != 2 { //nolint:gomnd // VLAN ID and value
+			return ErrFailedToReadData
+		}
+
+		value, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFailedToReadData, err)
+		}
+
+		return updateVLAN(c, args[0], func(v *database.VLAN) { set(v, uint32(value)) })
+	}
+
+	bot.Handle("/vlans", func(c tele.Context) error {
+		vlans, err := db.GetVLANs()
+		if err != nil {
+			return fmt.Errorf("error getting VLANs: %w", err)
+		}
+
+		msg := "*🌐 VLANs 🌐*\n\n"
+
+		if len(vlans) == 0 {
+			msg += "No VLANs found.\n"
+		}
+
+		m := bot.NewMarkup()
+		canEdit := roleOf(c) >= RoleAdmin
+
+		for i, vlan := range vlans {
+			usage, err := database.GetVLANUsage(db, vlan.ID)
+			if err != nil {
+				return fmt.Errorf("error getting VLAN usage: %w", err)
+			}
+
+			msg += fmt.Sprintf("%d. *%s* (`%s`) - %d devices", i+1, strings.ReplaceAll(vlan.Name, "*", ""), vlan.ID, len(usage.Users))
+
+			if len(usage.Groups) > 0 {
+				msg += fmt.Sprintf(", %d groups", len(usage.Groups))
+			}
+
+			if vlan.Default {
+				msg += " ⭐"
+			}
+
+			if vlan.Privileged {
+				msg += " 🔐"
+			}
+
+			if vlan.Quarantine {
+				msg += " 🚧"
+			}
+
+			msg += "\n"
+
+			if canEdit {
+				btn := m.Data(fmt.Sprintf("%d. %s", i+1, vlan.Name), btnVLANEditUnique, vlan.ID).Inline()
+				m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+			}
+		}
+
+		msg += "\n⭐ default, 🔐 privileged, 🚧 quarantine"
+
+		if canEdit {
+			msg += "\n\nSelect a VLAN to edit it, or use /addvlan to create one."
+		}
+
+		if err := c.Send(msg, m, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleViewer))
+
+	bot.Handle("/addvlan", func(c tele.Context) error {
+		vlan, err := parseAddVLAN(c.Message().Payload)
+		if err != nil {
+			msg := "Please provide an ID of up to 32 letters, numbers, dots, dashes, or underscores, and a name for the VLAN. Usage:\n`/addvlan <id> <name>`"
+			if err := c.Send(msg, tele.ModeMarkdown); err != nil {
+				return fmt.Errorf("error sending message: %w", err)
+			}
+
+			return nil
+		}
+
+		if err := db.CreateVLAN(vlan); err != nil {
+			if !errors.Is(err, database.ErrVLANAlreadyExists) {
+				return fmt.Errorf("error creating VLAN: %w", err)
+			}
+
+			if err := c.Send(fmt.Sprintf("VLAN `%s` already exists. Use `/editvlan %s` to change it.", vlan.ID, vlan.ID), tele.ModeMarkdown); err != nil {
+				return fmt.Errorf("error sending message: %w", err)
+			}
+
+			return nil
+		}
+
+		l.Info("VLAN created", slog.String("vlan", vlan.ID), slog.String("by", actorName(c.Sender())))
+
+		return sendVLAN(c, vlan.ID)
+	}, requireRole(RoleAdmin))
+
+	bot.Handle("/editvlan", func(c tele.Context) error {
+		query := strings.TrimSpace(c.Message().Payload)
+		if query == "" {
+			if err := c.Send("Please provide the ID or name of a VLAN to edit. Usage:\n`/editvlan <vlan>`", tele.ModeMarkdown); err != nil {
+				return fmt.Errorf("error sending message: %w", err)
+			}
+
+			return nil
+		}
+
+		vlan, err := findVLAN(db, query)
+		if err != nil {
+			if !errors.Is(err, database.ErrVLANNotFound) {
+				return err
+			}
+
+			// Show the not found message
+			vlan.ID = query
+		}
+
+		return sendVLAN(c, vlan.ID)
+	}, requireRole(RoleAdmin))
+
+	// Handle the VLAN buttons of /vlans
+	bot.Handle(&tele.InlineButton{Unique: btnVLANEditUnique}, func(c tele.Context) error {
+		return sendVLAN(c, c.Data())
+	}, requireRole(RoleAdmin))
+
+	// Handle the back button from the submenus
+	bot.Handle(&tele.InlineButton{Unique: btnVLANBackUnique}, func(c tele.Context) error {
+		return showVLAN(c, c.Data())
+	}, requireRole(RoleAdmin))
+
+	// Handle the default button. There is only one default VLAN, so the current one is unset first.
+	bot.Handle(&tele.InlineButton{Unique: btnVLANDefaultUnique}, func(c tele.Context) error {
+		if _, err := db.GetVLAN(c.Data()); err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		vlans, err := db.GetVLANs()
+		if err != nil {
+			return fmt.Errorf("error getting VLANs: %w", err)
+		}
+
+		for _, vlan := range vlans {
+			if vlan.Default && vlan.ID != c.Data() {
+				vlan.Default = false
+				if err := db.UpdateVLAN(vlan); err != nil {
+					return denyVLANError(c, fmt.Errorf("error updating VLAN: %w", err))
+				}
+			}
+		}
+
+		return updateVLAN(c, c.Data(), func(v *database.VLAN) { v.Default = true })
+	}, requireRole(RoleAdmin))
+
+	// Handle the privileged button
+	bot.Handle(&tele.InlineButton{Unique: btnVLANPrivilegedUnique}, func(c tele.Context) error {
+		return updateVLAN(c, c.Data(), func(v *database.VLAN) { v.Privileged = !v.Privileged })
+	}, requireRole(RoleAdmin))
+
+	// Handle the quarantine button. There is only one quarantine VLAN, so the current one is unset first.
+	bot.Handle(&tele.InlineButton{Unique: btnVLANQuarantineUnique}, func(c tele.Context) error {
+		vlan, err := db.GetVLAN(c.Data())
+		if err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		if current, err := db.GetQuarantineVLAN(); !vlan.Quarantine && err == nil {
+			current.Quarantine = false
+			if err := db.UpdateVLAN(current); err != nil {
+				return denyVLANError(c, fmt.Errorf("error updating VLAN: %w", err))
+			}
+		}
+
+		return updateVLAN(c, c.Data(), func(v *database.VLAN) { v.Quarantine = !v.Quarantine })
+	}, requireRole(RoleAdmin))
+
+	// Handle the tunnel type buttons
+	bot.Handle(&tele.InlineButton{Unique: btnVLANTunnelTypeUnique}, func(c tele.Context) error {
+		return optionMenu(c, "tunnel type", tunnelTypeOptions, btnVLANSetTunnelUnique)
+	}, requireRole(RoleAdmin))
+
+	bot.Handle(&tele.InlineButton{Unique: btnVLANSetTunnelUnique}, func(c tele.Context) error {
+		return setOption(c, func(v *database.VLAN, value uint32) { v.TunnelType = value })
+	}, requireRole(RoleAdmin))
+
+	// Handle the medium type buttons
+	bot.Handle(&tele.InlineButton{Unique: btnVLANMediumTypeUnique}, func(c tele.Context) error {
+		return optionMenu(c, "tunnel medium type", mediumTypeOptions, btnVLANSetMediumUnique)
+	}, requireRole(RoleAdmin))
+
+	bot.Handle(&tele.InlineButton{Unique: btnVLANSetMediumUnique}, func(c tele.Context) error {
+		return setOption(c, func(v *database.VLAN, value uint32) { v.TunnelMediumType = value })
+	}, requireRole(RoleAdmin))
+
+	// Handle the delete button. VLANs in use can only be deleted after moving their devices to another VLAN.
+	bot.Handle(&tele.InlineButton{Unique: btnVLANDeleteUnique}, func(c tele.Context) error {
+		vlan, err := db.GetVLAN(c.Data())
+		if err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		// New devices could not be added without a default VLAN
+		if vlan.Default {
+			return denyVLANError(c, database.ErrDefaultVLANInUse)
+		}
+
+		usage, err := database.GetVLANUsage(db, vlan.ID)
+		if err != nil {
+			return fmt.Errorf("error getting VLAN usage: %w", err)
+		}
+
+		// Policy rules can only be changed in the database file
+		if len(usage.Policy) > 0 {
+			return deny(c, policyInUseReason(usage.Policy))
+		}
+
+		m := bot.NewMarkup()
+
+		var msg string
+
+		if !usage.InUse() {
+			msg = fmt.Sprintf("*🗑 Delete VLAN 🗑*\n\nAre you sure you want to delete *%s*? No devices or groups use it.", escapeMarkdown(vlan.Name))
+
+			btn, err := deleteButton(m, "🗑 Yes, Delete", vlanDeletion{ID: vlan.ID})
+			if err != nil {
+				return err
+			}
+
+			m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+		} else {
+			vlans, err := db.GetVLANs()
+			if err != nil {
+				return fmt.Errorf("error getting VLANs: %w", err)
+			}
+
+			msg = fmt.Sprintf("*🗑 Delete VLAN 🗑*\n\n%d devices and %d groups use *%s*. Please select the VLAN to move them to before deleting it:", len(usage.Users), len(usage.Groups), escapeMarkdown(vlan.Name))
+
+			for _, target := range vlans {
+				if target.ID == vlan.ID {
+					continue
+				}
+
+				btn, err := deleteButton(m, "Move to "+target.Name, vlanDeletion{ID: vlan.ID, ReplacementID: target.ID})
+				if err != nil {
+					return err
+				}
+
+				m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*btn})
+			}
+		}
+
+		m.InlineKeyboard = append(m.InlineKeyboard, []tele.InlineButton{*m.Data("⬅ Back", btnVLANBackUnique, vlan.ID).Inline()})
+
+		if err := c.Edit(msg, m, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleAdmin))
+
+	// Handle the delete confirmation. The data is the ID of the deletion in the cache.
+	bot.Handle(&tele.InlineButton{Unique: btnVLANConfirmDeleteUnique}, func(c tele.Context) error {
+		deletion, ok := deletionCache.Get(c.Data())
+		if !ok {
+			return deny(c, "This menu has expired. Please open the VLAN again.")
+		}
+
+		vlan, err := db.GetVLAN(deletion.ID)
+		if err != nil {
+			return fmt.Errorf("error getting VLAN: %w", err)
+		}
+
+		if vlan.Default {
+			return denyVLANError(c, database.ErrDefaultVLANInUse)
+		}
+
+		// Reload the devices and groups, some may have been added since the menu was shown
+		usage, err := database.GetVLANUsage(db, vlan.ID)
+		if err != nil {
+			return fmt.Errorf("error getting VLAN usage: %w", err)
+		}
+
+		if len(usage.Policy) > 0 {
+			return deny(c, policyInUseReason(usage.Policy))
+		}
+
+		if !usage.InUse() {
+			if err := db.DeleteVLAN(vlan.ID); err != nil {
+				return denyVLANError(c, fmt.Errorf("error deleting VLAN: %w", err))
+			}
+		} else {
+			if deletion.ReplacementID == "" {
+				return deny(c, "Devices were added to this VLAN in the meantime. Please try again.")
+			}
+
+			// Schedules, networks, and temporary VLANs are moved too, so nothing keeps the VLAN in use.
+			// Nothing is moved if any of them can't be.
+			if usage, err = db.ReplaceVLAN(vlan.ID, deletion.ReplacementID); err != nil {
+				return denyVLANError(c, fmt.Errorf("error replacing VLAN: %w", err))
+			}
+		}
+
+		l.Info("VLAN deleted", slog.String("vlan", vlan.ID), slog.Int("movedDevices", len(usage.Users)), slog.Int("movedGroups", len(usage.Groups)), slog.String("movedTo", deletion.ReplacementID), slog.String("by", actorName(c.Sender())))
+
+		msg := fmt.Sprintf("*🗑 VLAN Deleted 🗑*\n\n*%s* has been deleted.", escapeMarkdown(vlan.Name))
+		if usage.InUse() {
+			msg += fmt.Sprintf(" %d devices and %d groups were moved to `%s`.", len(usage.Users), len(usage.Groups), deletion.ReplacementID)
+		}
+
+		if err := c.Edit(msg, tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error editing message: %w", err)
+		}
+
+		return nil
+	}, requireRole(RoleAdmin))
+
+	// Handle replies to the edit message with the VLAN name
+	*onTextHandlers = append(*onTextHandlers, onlyRole(RoleAdmin, func(c tele.Context) error {
+		if !c.Message().IsReply() {
+			return nil
+		}
+
+		id := extractVLANIDFromEditVLANMessage(c.Message().ReplyTo.Text)
+		if id == "" {
+			return nil
+		}
+
+		vlan, err := db.GetVLAN(id)
+		if err != nil {
+			// Ignore if the VLAN doesn't exist anymore
+			return nil //nolint:nilerr // Fail silently
+		}
+
+		name := strings.TrimSpace(c.Message().Text)
+		if name == "" {
+			return nil
+		}
+
+		vlan.Name = name
+		if err := db.UpdateVLAN(vlan); err != nil {
+			return fmt.Errorf("error updating VLAN: %w", err)
+		}
+
+		l.Info("VLAN renamed", slog.String("vlan", vlan.ID), slog.String("by", actorName(c.Sender())))
+
+		if err := c.Send(fmt.Sprintf("Saved the name *%s* for the VLAN `%s`.", strings.ReplaceAll(vlan.Name, "*", ""), vlan.ID), tele.ModeMarkdown); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+
+		return nil
+	}))
+}
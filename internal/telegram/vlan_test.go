@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/maronato/authifi/internal/database"
+)
+
+func TestParseAddVLAN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		payload string
+		want    database.VLAN
+		wantErr error
+	}{
+		{payload: "10 Home Network", want: database.VLAN{ID: "10", Name: "Home Network"}},
+		{payload: "  20   IoT ", want: database.VLAN{ID: "20", Name: "IoT"}},
+		{payload: "guests", want: database.VLAN{ID: "guests", Name: "guests"}},
+		{payload: "", wantErr: ErrInvalidVLANID},
+		{payload: "a|b Pipes", wantErr: ErrInvalidVLANID},
+		{payload: "0123456789012345678901234567890123 Too long", wantErr: ErrInvalidVLANID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseAddVLAN(tt.payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionName(t *testing.T) {
+	t.Parallel()
+
+	if got := optionName(tunnelTypeOptions, 13); got != "VLAN" {
+		t.Errorf("got %q, want VLAN", got)
+	}
+
+	if got := optionName(mediumTypeOptions, 0); got != "Default (IEEE-802)" {
+		t.Errorf("got %q, want the default", got)
+	}
+
+	if got := optionName(tunnelTypeOptions, 42); got != "42" {
+		t.Errorf("got %q, want the value for unknown options", got)
+	}
+}
+
+func TestExtractVLANIDFromEditVLANMessage(t *testing.T) {
+	t.Parallel()
+
+	if got := extractVLANIDFromEditVLANMessage("🌐 Edit VLAN 🌐\n\n\t\tVLAN ID: 10\n\t\tName: Home\n"); got != "10" {
+		t.Errorf("got %q, want 10", got)
+	}
+
+	if got := extractVLANIDFromEditVLANMessage("📝 Edit Device 📝\n\nUsername: phone\n"); got != "" {
+		t.Errorf("got %q, want no VLAN ID in device messages", got)
+	}
+}
+
+func TestVLANErrorReason(t *testing.T) {
+	t.Parallel()
+
+	for _, err := range []error{database.ErrVLANInUse, database.ErrDefaultVLANInUse, database.ErrDefaultVLANAlreadyExists, database.ErrQuarantineVLANAlreadyExists, database.ErrVLANNotFound} {
+		if _, ok := vlanErrorReason(fmt.Errorf("wrapped: %w", err)); !ok {
+			t.Errorf("got no reason for %v", err)
+		}
+	}
+
+	if _, ok := vlanErrorReason(errors.New("disk full")); ok {
+		t.Error("got a reason for an unexpected error")
+	}
+}
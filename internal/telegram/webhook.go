@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/maronato/authifi/internal/config"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// SecretTokenHeader is the header Telegram sends the webhook secret token in.
+	SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token" //nolint:gosec // not a credential
+	// WebhookReadHeaderTimeout is the maximum time to read the headers of a webhook request.
+	WebhookReadHeaderTimeout = 10 * time.Second
+)
+
+// webhookPoller is a tele.Poller for the updates received by the webhook server. Unlike tele.Webhook,
+// it doesn't register the webhook or listen by itself: StartBot registers the webhook before the
+// server starts, so the bot fails to start if Telegram rejects it.
+type webhookPoller struct {
+	// settings are the webhook settings registered with Telegram.
+	settings *tele.Webhook
+	// updates is the channel the bot reads updates from.
+	updates chan tele.Update
+}
+
+// newWebhook creates the webhook poller. Its updates channel must be set to the bot's before serving it.
+func newWebhook(cfg *config.Config) *webhookPoller {
+	settings := &tele.Webhook{
+		SecretToken: cfg.TelegramWebhookSecret,
+		Endpoint:    &tele.WebhookEndpoint{PublicURL: cfg.TelegramWebhookURL},
+	}
+
+	if cfg.TelegramWebhookTLSCert != "" {
+		settings.TLS = &tele.WebhookTLS{Cert: cfg.TelegramWebhookTLSCert, Key: cfg.TelegramWebhookTLSKey}
+	}
+
+	return &webhookPoller{settings: settings}
+}
+
+// Poll waits for the bot to stop. Updates are sent to the bot by ServeHTTP.
+func (p *webhookPoller) Poll(_ *tele.Bot, _ chan tele.Update, stop chan struct{}) {
+	<-stop
+}
+
+// ServeHTTP sends the update in the request to the bot.
+func (p *webhookPoller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var update tele.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	select {
+	case p.updates <- update:
+	case <-r.Context().Done():
+		// Telegram sends the update again later
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}
+
+// webhookHandler rejects requests that are not updates sent by Telegram. Every request is rejected
+// if the secret is empty, since anyone could send updates otherwise.
+func webhookHandler(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretTokenHeader)), []byte(secret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		method     string
+		secret     string
+		header     string
+		wantStatus int
+	}{
+		{name: "valid secret", method: http.MethodPost, secret: "s3cret", header: "s3cret", wantStatus: http.StatusOK},
+		{name: "wrong secret", method: http.MethodPost, secret: "s3cret", header: "other", wantStatus: http.StatusUnauthorized},
+		{name: "missing secret", method: http.MethodPost, secret: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "no secret configured", method: http.MethodPost, wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodGet, secret: "s3cret", header: "s3cret", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			called := false
+			next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })
+
+			req := httptest.NewRequest(tt.method, "/telegram", strings.NewReader("{}"))
+			if tt.header != "" {
+				req.Header.Set(SecretTokenHeader, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			webhookHandler(tt.secret, next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if wantCalled := tt.wantStatus == http.StatusOK; called != wantCalled {
+				t.Errorf("got update forwarded %t, want %t", called, wantCalled)
+			}
+		})
+	}
+}
+
+func TestWebhookPoller(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		canceled   bool
+		wantStatus int
+		wantUpdate bool
+	}{
+		{name: "update", body: `{"update_id": 42}`, wantStatus: http.StatusOK, wantUpdate: true},
+		{name: "invalid update", body: "{", wantStatus: http.StatusBadRequest},
+		// The bot isn't reading updates, so the request is canceled before the update is sent
+		{name: "canceled request", body: `{"update_id": 42}`, canceled: true, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &webhookPoller{updates: make(chan tele.Update, 1)}
+			if !tt.wantUpdate {
+				p.updates = make(chan tele.Update)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tt.canceled {
+				cancel()
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(tt.body)).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantUpdate {
+				if update := <-p.updates; update.ID != 42 {
+					t.Errorf("got update %d, want 42", update.ID)
+				}
+			}
+		})
+	}
+}